@@ -2,6 +2,7 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -14,6 +15,9 @@ import (
 	"github.com/darmaproject/darmasuite/dvm/core/rawdb"
 	"github.com/darmaproject/darmasuite/dvm/core/state"
 	"github.com/darmaproject/darmasuite/dvm/core/types"
+	"github.com/darmaproject/darmasuite/dvm/core/vm"
+	"github.com/darmaproject/darmasuite/dvm/core/vm/interface"
+	"github.com/darmaproject/darmasuite/dvm/metrics"
 	"github.com/darmaproject/darmasuite/dvm/rlp"
 	"github.com/darmaproject/darmasuite/globals"
 	"github.com/darmaproject/darmasuite/ringct"
@@ -22,6 +26,8 @@ import (
 	"github.com/romana/rlog"
 	"github.com/vmihailenco/msgpack"
 	"math/big"
+	"strconv"
+	"sync"
 )
 
 var (
@@ -53,11 +59,39 @@ var (
 	// than some meaningful limit a user might use. This is not a consensus error
 	// making the transaction invalid, rather a DOS protection.
 	ErrOversizedData = errors.New("oversized data")
+
+	// ErrInvalidDWPayload is returned if a deposit or withdraw transaction does
+	// not carry its amount as an 8 byte big endian payload.
+	ErrInvalidDWPayload = errors.New("invalid deposit/withdraw payload")
+
+	// ErrInvalidDWAmount is returned if a deposit or withdraw amount is zero or
+	// above MAX_CONTRACT_DW_AMOUNT.
+	ErrInvalidDWAmount = errors.New("invalid deposit/withdraw amount")
+
+	// ErrDepositMismatch is returned if the amount of a deposit transaction does
+	// not match the output it pays to the zero address.
+	ErrDepositMismatch = errors.New("deposit amount does not match output")
+
+	// ErrInsufficientBalance is returned if a withdraw transaction takes more
+	// than the balance of the sender's contract account.
+	ErrInsufficientBalance = errors.New("insufficient contract account balance")
+
+	// ErrExecutionTimeout is returned if a contract call runs past the
+	// deadline of its context.
+	ErrExecutionTimeout = errors.New("execution timeout")
+)
+
+var (
+	blockGasHistogram = metrics.NewRegisteredHistogram("chain/contract/block/gas", nil, metrics.NewExpDecaySample(1028, 0.015))
+	txGasHistogram    = metrics.NewRegisteredHistogram("chain/contract/tx/gas", nil, metrics.NewExpDecaySample(1028, 0.015))
+	receiptMeter      = metrics.NewRegisteredMeter("chain/contract/receipts", nil)
+	logMeter          = metrics.NewRegisteredMeter("chain/contract/logs", nil)
 )
 
 type SCTransferE struct {
 	Address string `msgpack:"A,omitempty" json:"A,omitempty"` //  transfer to this blob
 	Amount  uint64 `msgpack:"V,omitempty" json:"V,omitempty"` // Amount in Atomic units
+	Stealth bool   `msgpack:"H,omitempty" json:"H,omitempty"` // Address is a Darma address paid with a hidden amount
 }
 
 type SCStorage struct {
@@ -67,6 +101,10 @@ type SCStorage struct {
 
 const INVALID_CHAIN_HEIGHT = 0x7fffffffffffffff
 
+// MAX_CONTRACT_DW_AMOUNT bounds a single deposit or withdraw to one million
+// coins, which limits what a single faulty tx can move into or out of the VM
+const MAX_CONTRACT_DW_AMOUNT = uint64(1000000 * config.COIN_UNIT)
+
 func (chain *Blockchain) IsCreateContract(tx *transaction.Transaction) bool {
 	if tx.IsContract() == false {
 		return false
@@ -94,12 +132,24 @@ func (chain *Blockchain) VerifyTransactionContract(dbtx storage.DBTX, tx *transa
 		return ErrTooManyVout
 	}
 
-	if tx.IsContractDW() {
-		// TODO: Do not verify DW contract tx ?
-		return nil
+	scData := tx.ExtraMap[transaction.TX_EXTRA_CONTRACT].(*transaction.SCData)
+
+	// the view key is only checked, and used, from the account registry fork
+	// on; earlier parsers took it for trailing bytes and ignored it
+	registry := dvm.GetChainCOnfig().IsAccountRegistry(big.NewInt(chain.LoadTopoHeight(dbtx) + 1))
+	if registry && scData.HasSenderViewKey() && !scData.VerifySenderViewKey() {
+		return ErrInvalidSigner
 	}
 
-	scData := tx.ExtraMap[transaction.TX_EXTRA_CONTRACT].(*transaction.SCData)
+	if tx.IsContractDW() {
+		// check against the latest state, the tx is applied to the next block
+		topoHeight := chain.LoadTopoHeight(dbtx)
+		statedb, err := chain.NewStateDB(dbtx, topoHeight)
+		if err != nil {
+			return err
+		}
+		return chain.verifyContractDW(statedb, tx, topoHeight+1)
+	}
 
 	if createContract && len(scData.Payload) < int(config.MIN_CONTRACT_DATASIZE) {
 		return ErrOversizedData
@@ -139,6 +189,68 @@ func (chain *Blockchain) VerifyTransactionContract(dbtx storage.DBTX, tx *transa
 	return nil
 }
 
+// verifyContractDW checks a deposit or withdraw transaction applied at
+// topoHeight on top of statedb. Before the contract DW fork only the checks
+// that earlier blocks are known to pass are made, so they keep validating.
+func (chain *Blockchain) verifyContractDW(statedb inter.StateDB, tx *transaction.Transaction, topoHeight int64) error {
+	scData := tx.ExtraMap[transaction.TX_EXTRA_CONTRACT].(*transaction.SCData)
+	forked := dvm.GetChainCOnfig().IsContractDW(new(big.Int).SetInt64(topoHeight))
+
+	amount := scData.Amount
+	if scData.Type == transaction.SCDATA_WITHDRAW_TYPE || forked {
+		payloadAmount, err := bytesAmountToUintAmount(scData.Payload, forked)
+		if err != nil {
+			rlog.Warnf("DW tx %s: %s", tx.GetHash(), err)
+			return err
+		}
+		if scData.Type == transaction.SCDATA_DEPOSIT_TYPE && payloadAmount != scData.Amount {
+			rlog.Warnf("deposit tx %s: payload amount %d, tx amount %d", tx.GetHash(), payloadAmount, scData.Amount)
+			return ErrInvalidDWPayload
+		}
+		amount = payloadAmount
+	}
+
+	if forked {
+		if amount == 0 || amount > MAX_CONTRACT_DW_AMOUNT {
+			return ErrInvalidDWAmount
+		}
+		if scData.Type == transaction.SCDATA_WITHDRAW_TYPE && scData.Amount != 0 {
+			// a withdraw moves nothing into the VM
+			return ErrInvalidDWAmount
+		}
+		hash := scData.DWHash(tx)
+		if crypto.VerifySign(hash[:], crypto.Key(scData.Sender), scData.Sig) == false {
+			return ErrInvalidSigner
+		}
+	}
+
+	switch scData.Type {
+	case transaction.SCDATA_DEPOSIT_TYPE:
+		if amount > 0 {
+			if len(tx.Vout) == 0 || len(tx.RctSignature.ECdhInfo) == 0 || len(tx.RctSignature.OutPk) == 0 {
+				return ErrDepositMismatch
+			}
+			outAmount, err := chain.DecodeContractAmount(tx)
+			if err != nil {
+				rlog.Warnf("deposit tx %s: decode amount failed: %s", tx.GetHash(), err)
+				return ErrDepositMismatch
+			}
+			if outAmount != amount {
+				rlog.Warnf("deposit tx %s: amount %d, output amount %d", tx.GetHash(), amount, outAmount)
+				return ErrDepositMismatch
+			}
+		}
+	case transaction.SCDATA_WITHDRAW_TYPE:
+		caller := common.DarmaAddressToContractAddress(scData.Sender)
+		if statedb.GetBalance(caller).Cmp(new(big.Int).SetUint64(amount)) < 0 {
+			rlog.Warnf("withdraw tx %s: balance %s, amount %d", tx.GetHash(), statedb.GetBalance(caller), amount)
+			return ErrInsufficientBalance
+		}
+	}
+
+	return nil
+}
+
 func (chain *Blockchain) DecodeContractAmount(tx *transaction.Transaction) (uint64, error) {
 	spendSecret, viewSecret := crypto.ZeroKeys()
 
@@ -241,30 +353,231 @@ func (chain *Blockchain) ApplyContract(dbtx storage.DBTX,
 
 	rlog.Info("---ApplyContract---")
 
+	env := chain.newContractEnv(dbtx, bl, blid, topoHeight)
+	statedb.Prepare(common.Hash(txHash), common.Hash(blid), 0)
+	res := chain.runContract(env, statedb, tx, txHash, false)
+	if res.applied {
+		blockGas.add(blid, res.gasUsed)
+	}
+	return chain.commitContract(dbtx, statedb, env, tx, txHash, res)
+}
+
+// ContractBlockDone ends the contract txs ApplyContract applied for blid, one at
+// a time, and records the gas they used in blockGasHistogram if the block is
+// committed. The block commit calls it, so a block applied again, as after a
+// reorganisation, is counted from zero. Blocks without contract txs are not
+// recorded.
+func (chain *Blockchain) ContractBlockDone(blid crypto.Hash, committed bool) {
+	blockGas.done(blid, committed)
+}
+
+// blockGasCounter adds up the gas used by the contract txs of the block
+// ApplyContract is applying.
+type blockGasCounter struct {
+	lock sync.Mutex
+	blid crypto.Hash
+	gas  uint64
+}
+
+var blockGas blockGasCounter
+
+func (c *blockGasCounter) add(blid crypto.Hash, gas uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if blid != c.blid {
+		// the block before was not done, so it was not committed
+		c.blid, c.gas = blid, 0
+	}
+	c.gas += gas
+}
+
+func (c *blockGasCounter) done(blid crypto.Hash, committed bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if committed && blid == c.blid && c.gas > 0 {
+		blockGasHistogram.Update(int64(c.gas))
+	}
+	c.blid, c.gas = crypto.Hash{}, 0
+}
+
+// ApplyContracts applies the contract txs of a block in order on statedb, and
+// returns the error ApplyContract gives for each of them. The state and the
+// receipts are those of calling ApplyContract for every tx: the state is not
+// finalised between the txs and they all have index 0. Unless workers is 1,
+// the txs are run at the same time with up to workers goroutines, see
+// dvm.ApplyTransactionsParallel.
+func (chain *Blockchain) ApplyContracts(dbtx storage.DBTX,
+	statedb *state.StateDB,
+	bl *block.Block,
+	txs []*transaction.Transaction,
+	blid crypto.Hash,
+	txHashes []crypto.Hash,
+	topoHeight int64,
+	workers int) []error {
+
+	errs := make([]error, len(txs))
+	var indices []int
+	var hashes []common.Hash
+	for i, tx := range txs {
+		if tx.IsContract() {
+			indices = append(indices, i)
+			hashes = append(hashes, common.Hash(txHashes[i]))
+		}
+	}
+	if len(indices) == 0 {
+		return errs
+	}
+
+	env := chain.newContractEnv(dbtx, bl, blid, topoHeight)
+	results := make([]*contractResult, len(indices))
+	apply := func(db inter.StateDB, i int, speculative bool) error {
+		results[i] = chain.runContract(env, db, txs[indices[i]], txHashes[indices[i]], speculative)
+		return results[i].err
+	}
+	commit := func(i int, _ error) {
+		n := indices[i]
+		errs[n] = chain.commitContract(dbtx, statedb, env, txs[n], txHashes[n], results[i])
+	}
+
+	// the rules of ApplyContract
+	var rules dvm.BlockRules
+	if workers == 1 {
+		dvm.ApplyTransactions(statedb, common.Hash(blid), hashes, rules, apply, commit)
+	} else {
+		dvm.ApplyTransactionsParallel(statedb, common.Hash(blid), hashes, rules, workers, apply, commit)
+	}
+
+	// the txs of the block end here
+	var gas uint64
+	for _, res := range results {
+		if res.applied {
+			gas += res.gasUsed
+		}
+	}
+	if gas > 0 {
+		blockGasHistogram.Update(int64(gas))
+	}
+	return errs
+}
+
+// ApplyBlockContracts applies the contract txs of a block the way the daemon is
+// configured to: with ApplyContracts on --contract-workers goroutines, one per
+// CPU if it is 0, or one tx after another like ApplyContract by default.
+func (chain *Blockchain) ApplyBlockContracts(dbtx storage.DBTX,
+	statedb *state.StateDB,
+	bl *block.Block,
+	txs []*transaction.Transaction,
+	blid crypto.Hash,
+	txHashes []crypto.Hash,
+	topoHeight int64) []error {
+	return chain.ApplyContracts(dbtx, statedb, bl, txs, blid, txHashes, topoHeight, contractWorkers())
+}
+
+// contractWorkers returns the number of goroutines given by --contract-workers.
+func contractWorkers() int {
+	arg, ok := globals.Arguments["--contract-workers"].(string)
+	if !ok || arg == "" {
+		return 1
+	}
+	workers, err := strconv.Atoi(arg)
+	if err != nil || workers < 0 {
+		rlog.Warnf("--contract-workers %q is invalid, applying contract txs one after another", arg)
+		return 1
+	}
+	return workers
+}
+
+// contractEnv is what the contract txs of a block share while they run.
+// Running the txs of a block at the same time reads the chain database from
+// several goroutines, the lock serialises that.
+type contractEnv struct {
+	header   *types.Header
+	gasLimit uint64
+
+	lock    sync.Mutex
+	dbtx    storage.DBTX
+	getHash func(n uint64) common.Hash
+}
+
+func (chain *Blockchain) newContractEnv(dbtx storage.DBTX, bl *block.Block, blid crypto.Hash, topoHeight int64) *contractEnv {
+	blockGaslimit := chain.GetBlockGaslimit()
+	return &contractEnv{
+		header: &types.Header{
+			Number:     new(big.Int).SetInt64(topoHeight),
+			Difficulty: new(big.Int).Set(chain.LoadBlockDifficulty(dbtx, blid)),
+			Time:       bl.BlockHeader.Timestamp,
+			GasLimit:   blockGaslimit,
+		},
+		gasLimit: blockGaslimit,
+		dbtx:     dbtx,
+		getHash:  chain.GetHashFn(dbtx),
+	}
+}
+
+func (env *contractEnv) GetHash(n uint64) common.Hash {
+	env.lock.Lock()
+	defer env.lock.Unlock()
+	return env.getHash(n)
+}
+
+// contractResult is the outcome of running a contract tx, err is the error
+// ApplyContract returns for it.
+type contractResult struct {
+	msg          *transaction.SCMessage
+	ret          []byte
+	gasUsed      uint64
+	contractAddr []byte
+	applied      bool   // ApplyMessage ran, the tx gets a receipt
+	withdrew     bool   // a withdraw succeeded, its transfer is stored even if 0
+	withdrawn    uint64 // amount the withdraw took out of the VM
+	err          error
+}
+
+// errOriginUnknown is returned by speculative runs of txs calling a contract
+// whose origin is not stored yet, it may be created by an earlier tx.
+var errOriginUnknown = errors.New("contract origin unknown")
+
+// runContract runs tx on statedb, the results are stored by commitContract.
+// A speculative run fails rather than depend on anything but statedb that
+// the txs before it could change.
+func (chain *Blockchain) runContract(env *contractEnv, statedb inter.StateDB, tx *transaction.Transaction, txHash crypto.Hash, speculative bool) (res *contractResult) {
+	res = new(contractResult)
 	scdata := tx.ExtraMap[transaction.TX_EXTRA_CONTRACT].(*transaction.SCData)
 
 	msg, err := transaction.AsMessage(scdata)
 	if err != nil {
-		return err
+		res.err = err
+		return
 	}
+	res.msg = msg
 	origin := msg.From()
 	if !msg.ToIsEmpty() {
-		origin, err = chain.loadContractOrigin(dbtx, msg.To().Bytes())
+		env.lock.Lock()
+		origin, err = chain.loadContractOrigin(env.dbtx, msg.To().Bytes())
+		env.lock.Unlock()
+		if err != nil && speculative {
+			res.err = errOriginUnknown
+			return
+		}
 	}
 
-	statedb.Prepare(common.Hash(txHash), common.Hash(blid), 0)
-	blockGaslimit := chain.GetBlockGaslimit()
-	gp := new(dvm.GasPool).AddGas(blockGaslimit)
+	gp := new(dvm.GasPool).AddGas(env.gasLimit)
+	header := env.header
 
-	header := &types.Header{
-		Number:     new(big.Int).SetInt64(topoHeight),
-		Difficulty: new(big.Int).Set(chain.LoadBlockDifficulty(dbtx, blid)),
-		Time:       bl.BlockHeader.Timestamp,
-		GasLimit:   blockGaslimit,
+	// the first tx of an account carrying the sender's view key registers
+	// its Darma address
+	if scdata.HasSenderViewKey() && dvm.GetChainCOnfig().IsAccountRegistry(header.Number) {
+		if !scdata.VerifySenderViewKey() {
+			res.err = ErrInvalidSigner
+			return
+		}
+		if vm.RegisterAccount(statedb, common.Hash(scdata.Sender), common.Hash(scdata.SenderViewKey)) {
+			rlog.Debugf("registered owner of contract account %x", msg.From())
+		}
 	}
 
 	// Create a new context to be used in the VM environment
-	context := dvm.NewVMContext(msg, header, origin, chain.GetHashFn(dbtx), chain.GetAddrStrToBytesFn(), chain.GetBytesToAddrStrFn())
+	context := dvm.NewVMContext(msg, header, origin, env.GetHash, chain.GetAddrStrToBytesFn(), chain.GetBytesToAddrStrFn(statedb, header.Number), chain.GetPayoutAddressFn())
 	// Create a new environment which holds all relevant information
 	// about the transaction and calling mechanisms.
 	/*	var (
@@ -275,82 +588,114 @@ func (chain *Blockchain) ApplyContract(dbtx storage.DBTX,
 
 	vmenv := dvm.GetVM(msg, context, statedb, dvm.GetChainCOnfig(), dvm.GetVMConfig())
 	if vmenv == nil {
-		return fmt.Errorf("failed to call contract!")
+		res.err = fmt.Errorf("failed to call contract!")
+		return
 	}
 
 	if scdata.Type == transaction.SCDATA_DEPOSIT_TYPE || scdata.Type == transaction.SCDATA_WITHDRAW_TYPE { // if tx is type of DEPOSIT or WITHDRAW
+		if err := chain.verifyContractDW(statedb, tx, header.Number.Int64()); err != nil {
+			res.err = err
+			return
+		}
 		caller := msg.From()
 		switch scdata.Type {
 		case transaction.SCDATA_DEPOSIT_TYPE:
-			if scdata.Amount > 0 {
-				amount, err := chain.DecodeContractAmount(tx)
-				if err != nil {
-					return fmt.Errorf("decode amount from tx failed: %s", err.Error())
-				}
-				if scdata.Amount != amount {
-					return fmt.Errorf("amount not matched. scdata.Amount = %d, amount = %d", scdata.Amount, amount)
-				}
-			}
 			amount := msg.Value()
-			rlog.Debugf("address %x deposit %s into VM",caller,amount.String())
-			err = vmenv.Deposit(caller,*amount)
+			rlog.Debugf("address %x deposit %s into VM", caller, amount.String())
+			res.err = vmenv.Deposit(caller, *amount)
 		case transaction.SCDATA_WITHDRAW_TYPE:
-			bytesAmount := msg.Data()
-			uintAmount := bytesAmountToUintAmount(bytesAmount)
+			forked := dvm.GetChainCOnfig().IsContractDW(header.Number)
+			uintAmount, err := bytesAmountToUintAmount(msg.Data(), forked)
+			if err != nil {
+				res.err = err
+				return
+			}
 			amount := new(big.Int).SetUint64(uintAmount)
-			rlog.Debugf("address %x withdraw %s from VM",caller,amount.String())
-			err = vmenv.Withdraw(caller,*amount)
-			if err == nil {
-				//: create new UTXO in blockchain
-				var sctxData SCStorage
-				sctxData.TransferE = append(sctxData.TransferE, SCTransferE{scdata.Sender.String(), uintAmount}) // sender is Darma address format, caller is contract address format
-				chain.storeContractTransfer(dbtx, txHash, &sctxData)
+			rlog.Debugf("address %x withdraw %s from VM", caller, amount.String())
+			res.err = vmenv.Withdraw(caller, *amount)
+			if res.err == nil {
+				res.withdrew, res.withdrawn = true, uintAmount
 			}
 		}
-		return err
+		return
 	}
 
-	ret, gasUsed, contractAddr, err := dvm.ApplyMessage(vmenv, msg, gp)
+	res.ret, res.gasUsed, res.contractAddr, res.err = dvm.ApplyMessage(vmenv, msg, gp)
+	res.applied = true
 
 	if msg.To() != nil {
 		rlog.Infof("statedb.GetBalance(%x): %s", *msg.To(), statedb.GetBalance(*msg.To()))
 	}
 
-	rlog.Infof("ApplyMessage ret: %x", ret)
+	rlog.Infof("ApplyMessage ret: %x", res.ret)
+
+	if res.err != nil {
+		return
+	}
+
+	totalGasSupply := msg.Gas()
+	if totalGasSupply > res.gasUsed {
+		remainingGas := totalGasSupply - res.gasUsed
+		price := msg.GasPrice()
+		totalValue := new(big.Int).Mul(new(big.Int).SetUint64(totalGasSupply), price)
+		remainingValue := new(big.Int).Mul(new(big.Int).SetUint64(remainingGas), msg.GasPrice())
+		statedb.AddBalance(msg.From(), remainingValue)
+		rlog.Debugf("gas total supply %d(value:%s), used %d, remain %d(value:%s), tx= %s", totalGasSupply, totalValue.String(), res.gasUsed, remainingGas, remainingValue.String(), txHash)
+	}
+	return
+}
+
+// commitContract stores the results of tx, run on statedb, in the chain.
+func (chain *Blockchain) commitContract(dbtx storage.DBTX, statedb *state.StateDB, env *contractEnv, tx *transaction.Transaction, txHash crypto.Hash, res *contractResult) error {
+	scdata := tx.ExtraMap[transaction.TX_EXTRA_CONTRACT].(*transaction.SCData)
+
+	if res.withdrew {
+		//: create new UTXO in blockchain
+		var sctxData SCStorage
+		sctxData.TransferE = append(sctxData.TransferE, SCTransferE{Address: scdata.Sender.String(), Amount: res.withdrawn}) // sender is Darma address format, caller is contract address format
+		chain.storeContractTransfer(dbtx, txHash, &sctxData)
+	}
+	if !res.applied {
+		return res.err
+	}
 
+	msg := res.msg
 	// make an receipt for the tx
-	receipt := types.NewReceipt(nil, (err != nil), gasUsed)
+	receipt := types.NewReceipt(nil, (res.err != nil), res.gasUsed)
 	receipt.TxHash = common.Hash(txHash)
-	receipt.GasUsed = gasUsed
+	receipt.GasUsed = res.gasUsed
 	txCreatedAContract := (msg.To() == nil)
 	if txCreatedAContract {
-		copy(receipt.ContractAddress[:],contractAddr[:])
+		copy(receipt.ContractAddress[:], res.contractAddr[:])
 	}
 	receipt.Logs = statedb.GetLogs(common.Hash(txHash))
 	receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
 	receipt.BlockHash = statedb.BlockHash()
-	receipt.BlockNumber = header.Number
+	receipt.BlockNumber = env.header.Number
 	receipt.TransactionIndex = uint(statedb.TxIndex())
-	chain.StoreTxReceipt(dbtx,txHash,receipt)
+	chain.StoreTxReceipt(dbtx, txHash, receipt)
+	txGasHistogram.Update(int64(res.gasUsed))
+	receiptMeter.Mark(1)
+	logMeter.Mark(int64(len(receipt.Logs)))
 
-	if err != nil {
-		return err
+	if res.err != nil {
+		return res.err
 	}
 
-	chain.storeContractTxResult(dbtx, txHash, ret)
-	if tx.IsCreateContract() {
-		chain.StoreContractAddress(dbtx, txHash, contractAddr)
-		chain.storeContractOrigin(dbtx, contractAddr, msg.From())
+	chain.storeContractTxResult(dbtx, txHash, res.ret)
+
+	// payouts to Darma addresses become outputs of the tx, see writeContractTx
+	if payouts := statedb.GetPayouts(common.Hash(txHash)); len(payouts) > 0 {
+		var sctxData SCStorage
+		for _, p := range payouts {
+			sctxData.TransferE = append(sctxData.TransferE, SCTransferE{Address: p.Address, Amount: p.Amount, Stealth: true})
+		}
+		chain.storeContractTransfer(dbtx, txHash, &sctxData)
 	}
 
-	totalGasSupply := msg.Gas()
-	if totalGasSupply > gasUsed {
-		remainingGas := totalGasSupply - gasUsed
-		price := msg.GasPrice()
-		totalValue := new(big.Int).Mul(new(big.Int).SetUint64(totalGasSupply), price)
-		remainingValue := new(big.Int).Mul(new(big.Int).SetUint64(remainingGas), msg.GasPrice())
-		statedb.AddBalance(msg.From(),remainingValue)
-		rlog.Debugf("gas total supply %d(value:%s), used %d, remain %d(value:%s), tx= %s", totalGasSupply, totalValue.String(), gasUsed, remainingGas, remainingValue.String(), txHash)
+	if tx.IsCreateContract() {
+		chain.StoreContractAddress(dbtx, txHash, res.contractAddr)
+		chain.storeContractOrigin(dbtx, res.contractAddr, msg.From())
 	}
 
 	logs := statedb.GetLogs(common.Hash(txHash))
@@ -376,15 +721,29 @@ func (chain *Blockchain) ApplyContract(dbtx storage.DBTX,
 		chain.storeErc20Transfers(dbtx, txHash, transfers)
 	}
 
-	rlog.Debugf("Apply contact success, contract address %x", contractAddr)
+	rlog.Debugf("Apply contact success, contract address %x", res.contractAddr)
 	return nil
 }
 
-func bytesAmountToUintAmount(amount []byte) uint64 {
-	return binary.BigEndian.Uint64(amount)
+// bytesAmountToUintAmount decodes the amount payload of a deposit or
+// withdraw transaction. After the contract DW fork the payload has to be
+// exactly 8 bytes, before it, trailing bytes are ignored like they always
+// were.
+func bytesAmountToUintAmount(amount []byte, forked bool) (uint64, error) {
+	if len(amount) < 8 || (forked && len(amount) != 8) {
+		return 0, ErrInvalidDWPayload
+	}
+	return binary.BigEndian.Uint64(amount), nil
 }
 
 func (chain *Blockchain) CallContact(scdata *transaction.SCData, topoHeight int64) ([]byte, error) {
+	return chain.CallContactContext(context.Background(), scdata, topoHeight)
+}
+
+// CallContactContext runs scdata like CallContact, and aborts the VM once ctx
+// is done. It returns ErrExecutionTimeout if the deadline of ctx passed, and
+// the error of ctx if it was cancelled otherwise.
+func (chain *Blockchain) CallContactContext(ctx context.Context, scdata *transaction.SCData, topoHeight int64) ([]byte, error) {
 	dbtx, err := chain.store.BeginTX(false)
 	defer dbtx.Rollback()
 
@@ -425,17 +784,36 @@ func (chain *Blockchain) CallContact(scdata *transaction.SCData, topoHeight int6
 		return nil, fmt.Errorf("no origin, contract %x, err %s", msg.To(), err)
 	}
 
-	ctx := dvm.NewVMContext(msg, header, origin, chain.GetHashFn(dbtx), chain.GetAddrStrToBytesFn(), chain.GetBytesToAddrStrFn())
-	vmenv := dvm.GetVM(msg, ctx, statedb, dvm.GetChainCOnfig(), dvm.GetVMConfig())
+	vmctx := dvm.NewVMContext(msg, header, origin, chain.GetHashFn(dbtx), chain.GetAddrStrToBytesFn(), chain.GetBytesToAddrStrFn(statedb, header.Number), chain.GetPayoutAddressFn())
+	vmenv := dvm.GetVM(msg, vmctx, statedb, dvm.GetChainCOnfig(), dvm.GetVMConfig())
 	if vmenv == nil {
 		return nil, fmt.Errorf("failed to call contract!")
 	}
 
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			vmenv.Cancel()
+		case <-done:
+		}
+	}()
+
 	res, _, _, err := dvm.ApplyMessage(vmenv, msg, gp)
 	rlog.Infof("ApplyMessage res: %x", res)
+	if vmenv.Cancelled() {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, ErrExecutionTimeout
+		}
+		return nil, ctx.Err()
+	}
 	statedb.Finalise(true)
 	if err != nil {
-		return nil, err
+		if reason, ok := vm.UnpackRevertReason(res); ok {
+			return res, fmt.Errorf("%s: %s", err, reason)
+		}
+		return res, err
 	}
 	return res, nil
 }
@@ -447,16 +825,68 @@ func (chain *Blockchain) GetAddrStrToBytesFn() func(addStr string) []byte {
 	}
 }
 
-func (chain *Blockchain) GetBytesToAddrStrFn() func(bytes []byte) string {
+// GetPayoutAddressFn returns the check for the Darma addresses contracts pay.
+// A payout becomes an output to the address, so it has to be a standard or
+// subaddress of this network, in its canonical form.
+func (chain *Blockchain) GetPayoutAddressFn() func(addrStr string) bool {
+	return func(addrStr string) bool {
+		addr, err := globals.ParseValidateAddress(addrStr)
+		if err != nil || addr.IsIntegratedAddress() || addr.String() != addrStr {
+			return false
+		}
+		return addr.SpendKey.Public_Key_Valid() && addr.ViewKey.Public_Key_Valid()
+	}
+}
+
+// GetBytesToAddrStrFn returns the conversion of contract accounts to Darma
+// addresses for a VM running block num on statedb. From the account registry
+// fork on, the registered address of the account is returned, or "" if it
+// has none.
+func (chain *Blockchain) GetBytesToAddrStrFn(statedb inter.StateDB, num *big.Int) func(bytes []byte) string {
+	registry := dvm.GetChainCOnfig().IsAccountRegistry(num)
 	return func(bytes []byte) string {
+		if registry {
+			return chain.accountOwner(statedb, common.BytesToAddress(bytes))
+		}
 		var addr address.Address
 		addr.Network = globals.GetNetwork()
 		copy(addr.SpendKey[:], bytes)
-		// fixme: view key
 		return addr.String()
 	}
 }
 
+// accountOwner returns the Darma address registered for a contract account
+// in statedb, or "" if the account has none.
+func (chain *Blockchain) accountOwner(statedb inter.StateDB, account common.Address) string {
+	spendKey, viewKey, ok := vm.LookupAccount(statedb, account)
+	if !ok {
+		return ""
+	}
+	return address.NewAddressFromKeys(globals.GetNetwork(), crypto.Key(spendKey), crypto.Key(viewKey)).String()
+}
+
+// LookupContractAccountOwner returns the Darma address registered for a
+// contract account at the top of the chain.
+func (chain *Blockchain) LookupContractAccountOwner(account common.Address) (string, error) {
+	dbtx, err := chain.store.BeginTX(false)
+	if err != nil {
+		return "", err
+	}
+
+	defer dbtx.Rollback()
+
+	statedb, err := chain.NewStateDB(dbtx, chain.LoadTopoHeight(dbtx))
+	if err != nil {
+		return "", err
+	}
+
+	owner := chain.accountOwner(statedb, account)
+	if owner == "" {
+		return "", fmt.Errorf("no address registered for contract account %x", account[12:])
+	}
+	return owner, nil
+}
+
 func (chain *Blockchain) revertContract(dbtx storage.DBTX, bl *block.Block, blid crypto.Hash) error {
 	return chain.RemoveStateRoot(dbtx, blid)
 }
@@ -0,0 +1,237 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/darmaproject/darmasuite/block"
+	"github.com/darmaproject/darmasuite/crypto"
+	"github.com/darmaproject/darmasuite/dvm/common"
+	"github.com/darmaproject/darmasuite/dvm/core/evm"
+	"github.com/darmaproject/darmasuite/dvm/core/rawdb"
+	"github.com/darmaproject/darmasuite/dvm/core/state"
+	"github.com/darmaproject/darmasuite/globals"
+	"github.com/darmaproject/darmasuite/storage"
+	"github.com/darmaproject/darmasuite/transaction"
+)
+
+// memDBTX keeps the objects stored while contract txs are applied in memory.
+// The contract txs use no other method of storage.DBTX.
+type memDBTX struct {
+	storage.DBTX
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemDBTX() *memDBTX {
+	return &memDBTX{objects: make(map[string][]byte)}
+}
+
+func memKey(universe, galaxy, solar, key []byte) string {
+	return hex.EncodeToString(bytes.Join([][]byte{universe, galaxy, solar, key}, []byte("/")))
+}
+
+func (m *memDBTX) StoreObject(universe, galaxy, solar, key, data []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.objects[memKey(universe, galaxy, solar, key)] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *memDBTX) LoadObject(universe, galaxy, solar, key []byte) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	data, ok := m.objects[memKey(universe, galaxy, solar, key)]
+	if !ok {
+		return nil, fmt.Errorf("object not found")
+	}
+	return data, nil
+}
+
+func (m *memDBTX) Delete(universe, galaxy, solar, key []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.objects, memKey(universe, galaxy, solar, key))
+	return nil
+}
+
+// testCounterCode increments the storage slot given by the first word of the
+// call data and logs its new value, or reverts if the second word is not zero.
+var testCounterCode = []byte{
+	byte(evm.PUSH1), 0,
+	byte(evm.CALLDATALOAD),
+	byte(evm.DUP1),
+	byte(evm.SLOAD),
+	byte(evm.PUSH1), 1,
+	byte(evm.ADD),
+	byte(evm.DUP1),
+	byte(evm.PUSH1), 0,
+	byte(evm.MSTORE),
+	byte(evm.DUP2),
+	byte(evm.SSTORE),
+	byte(evm.PUSH1), 32,
+	byte(evm.PUSH1), 0,
+	byte(evm.LOG1),
+	byte(evm.PUSH1), 32,
+	byte(evm.CALLDATALOAD),
+	byte(evm.PUSH1), 26,
+	byte(evm.JUMPI),
+	byte(evm.STOP),
+	byte(evm.JUMPDEST),
+	byte(evm.PUSH1), 0,
+	byte(evm.PUSH1), 0,
+	byte(evm.REVERT),
+}
+
+// testInitCode creates a contract without code that has slot 0 set.
+var testInitCode = []byte{
+	byte(evm.PUSH1), 1,
+	byte(evm.PUSH1), 0,
+	byte(evm.SSTORE),
+	byte(evm.STOP),
+}
+
+var (
+	testSenders   = []common.Address{common.BytesToAddress([]byte("s1")), common.BytesToAddress([]byte("s2")), common.BytesToAddress([]byte("s3"))}
+	testContracts = []common.Address{common.BytesToAddress([]byte("c1")), common.BytesToAddress([]byte("c2"))}
+	testAccounts  = []common.Address{common.BytesToAddress([]byte("a1")), common.BytesToAddress([]byte("a2"))}
+)
+
+var testBlid = crypto.Hash{0xb1}
+
+// newTestContractChain returns the chain, its database and the state the
+// contract txs of the test blocks are applied on.
+func newTestContractChain(t *testing.T) (*Blockchain, *memDBTX, *state.StateDB) {
+	chain := &Blockchain{}
+	dbtx := newMemDBTX()
+	dbtx.StoreObject(BLOCKCHAIN_UNIVERSE, GALAXY_BLOCK, testBlid[:], PLANET_DIFFICULTY, big.NewInt(1000).Bytes())
+
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sender := range testSenders {
+		statedb.AddBalance(common.DarmaAddressToContractAddress(sender), big.NewInt(1e12))
+	}
+	for _, contract := range testContracts {
+		addr := common.DarmaAddressToContractAddress(contract)
+		statedb.SetCode(addr, testCounterCode)
+		statedb.SetNonce(addr, 1)
+		chain.storeContractOrigin(dbtx, addr.Bytes(), common.DarmaAddressToContractAddress(testSenders[0]))
+	}
+	for _, account := range testAccounts[:1] {
+		addr := common.DarmaAddressToContractAddress(account)
+		statedb.AddBalance(addr, big.NewInt(1))
+		chain.storeContractOrigin(dbtx, addr.Bytes(), addr)
+	}
+	statedb.IntermediateRoot(true)
+	return chain, dbtx, statedb
+}
+
+// randomContractTxs returns txs calling the counter contracts on a few shared
+// slots, some of them reverting, paying accounts, one of which has no origin
+// stored, and creating contracts.
+func randomContractTxs(r *rand.Rand, n int) ([]*transaction.Transaction, []crypto.Hash) {
+	txs := make([]*transaction.Transaction, n)
+	hashes := make([]crypto.Hash, n)
+	for i := range txs {
+		scdata := &transaction.SCData{
+			Sender:   testSenders[r.Intn(len(testSenders))],
+			GasLimit: 100000,
+			Price:    1,
+		}
+		switch r.Intn(5) {
+		case 0:
+			scdata.Recipient = testAccounts[r.Intn(len(testAccounts))]
+			scdata.Amount = uint64(r.Intn(3))
+		case 1:
+			scdata.Payload = testInitCode
+		default:
+			scdata.Recipient = testContracts[r.Intn(len(testContracts))]
+			scdata.Payload = make([]byte, 64)
+			scdata.Payload[31] = byte(r.Intn(3))
+			if r.Intn(5) == 0 {
+				scdata.Payload[63] = 1
+			}
+		}
+		txs[i] = &transaction.Transaction{ExtraMap: map[transaction.EXTRA_TAG]interface{}{transaction.TX_EXTRA_CONTRACT: scdata}}
+		hashes[i] = crypto.Hash{0x10, byte(i)}
+	}
+	return txs, hashes
+}
+
+// TestApplyContracts applies random blocks with ApplyBlockContracts on 4
+// goroutines and with ApplyContract one tx after another, and checks the
+// states and what is stored in the chain are the same.
+func TestApplyContracts(t *testing.T) {
+	args := globals.Arguments
+	globals.Arguments = map[string]interface{}{"--contract-workers": "4"}
+	defer func() { globals.Arguments = args }()
+
+	bl := &block.Block{}
+	for seed := int64(0); seed < 10; seed++ {
+		txs, hashes := randomContractTxs(rand.New(rand.NewSource(seed)), 40)
+
+		wantChain, wantDB, wantState := newTestContractChain(t)
+		wantErrs := make([]error, len(txs))
+		for i := range txs {
+			wantErrs[i] = wantChain.ApplyContract(wantDB, wantState, bl, txs[i], testBlid, hashes[i], 1)
+		}
+		gotChain, gotDB, gotState := newTestContractChain(t)
+		gotErrs := gotChain.ApplyBlockContracts(gotDB, gotState, bl, txs, testBlid, hashes, 1)
+
+		if wantRoot, gotRoot := wantState.IntermediateRoot(true), gotState.IntermediateRoot(true); wantRoot != gotRoot {
+			t.Fatalf("seed %d: state root %x in parallel, want %x", seed, gotRoot, wantRoot)
+		}
+		for i := range wantErrs {
+			if fmt.Sprint(wantErrs[i]) != fmt.Sprint(gotErrs[i]) {
+				t.Fatalf("seed %d: tx %d error %v in parallel, want %v", seed, i, gotErrs[i], wantErrs[i])
+			}
+		}
+		if !reflect.DeepEqual(wantDB.objects, gotDB.objects) {
+			t.Fatalf("seed %d: chain stores %d objects in parallel, want %d", seed, len(gotDB.objects), len(wantDB.objects))
+		}
+	}
+}
+
+// TestApplyContractsWithdraw checks a withdraw of 0 stores its transfer, like
+// every withdraw the VM accepts.
+func TestApplyContractsWithdraw(t *testing.T) {
+	chain, dbtx, _ := newTestContractChain(t)
+	res := &contractResult{withdrew: true}
+	scdata := &transaction.SCData{Sender: testSenders[0], Type: transaction.SCDATA_WITHDRAW_TYPE}
+	tx := &transaction.Transaction{ExtraMap: map[transaction.EXTRA_TAG]interface{}{transaction.TX_EXTRA_CONTRACT: scdata}}
+	txHash := crypto.Hash{0x77}
+
+	if err := chain.commitContract(dbtx, nil, nil, tx, txHash, res); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := chain.loadContractTransfer(dbtx, txHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.TransferE) != 1 || stored.TransferE[0].Amount != 0 || stored.TransferE[0].Address != testSenders[0].String() {
+		t.Fatalf("withdraw of 0 stored %+v", stored.TransferE)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package blockchain
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/darmaproject/darmasuite/config"
+	"github.com/darmaproject/darmasuite/crypto"
+	"github.com/darmaproject/darmasuite/globals"
+	"github.com/darmaproject/darmasuite/transaction"
+)
+
+// the priorities, by the blocks their txs should be mined within
+var feePriorities = []struct {
+	name       string
+	blocks     uint64  // the tx should be mined within
+	percentile int     // of the gas prices seen
+	congestion float64 // share of the fullness of the recent blocks added to the minimum fee
+}{
+	{globals.FEE_PRIORITY_SLOW, 10, 25, 0},
+	{globals.FEE_PRIORITY_NORMAL, 3, 50, 0.5},
+	{globals.FEE_PRIORITY_FAST, 1, 75, 1},
+}
+
+// blocks looked back at to estimate fees
+const FEE_ESTIMATE_BLOCKS = 20
+
+type FeeEstimate struct {
+	Priority string
+	Blocks   uint64
+	FeePerKb uint64
+	GasPrice uint64
+}
+
+type FeeEstimates struct {
+	MinFeePerKb uint64
+	PoolTxs     int
+	PoolSize    uint64  // bytes
+	Fullness    float64 // average size of the recent blocks, against the maximum
+	Estimates   []FeeEstimate
+}
+
+// what is learnt from the recent blocks only changes with the top block
+var feeBlocksCache struct {
+	sync.Mutex
+	top       crypto.Hash
+	fullness  float64
+	gasPrices []uint64
+}
+
+// EstimateFee estimates the fee per KB and the gas price for a tx to be mined
+// within the blocks of each priority. The pool txs paying more per KB are mined
+// first, so the fee outbids those which fill the blocks up to the target, and the
+// fuller the recent blocks are, the more is added to the minimum. Gas prices are
+// percentiles of those of the contract txs in the pool and in the recent blocks.
+func (chain *Blockchain) EstimateFee() *FeeEstimates {
+	e := &FeeEstimates{MinFeePerKb: chain.MinimumFeePerKb()}
+	capacity := uint64(config.CRYPTONOTE_MAX_BLOCK_SIZE)
+
+	var gasPrices []uint64
+	e.Fullness, gasPrices = chain.recentBlocksUsage(capacity)
+
+	var pool []feePoolTx
+	version := chain.GetCurrentVersionAtHeight(chain.GetHeight())
+	for _, hash := range chain.Mempool.MempoolListTx() {
+		tx := chain.Mempool.MempoolGetTx(hash)
+		if tx == nil {
+			continue
+		}
+		size := uint64(len(tx.Serialize()))
+		fee := tx.RctSignature.GetTXFee()
+		if gas := tx.GetFeeForContract(version); gas < fee {
+			fee -= gas
+		}
+		if price, ok := contractGasPrice(tx); ok {
+			gasPrices = append(gasPrices, price)
+		}
+
+		pool = append(pool, feePoolTx{feePerKb: fee / ((size + 1023) / 1024), size: size})
+		e.PoolTxs++
+		e.PoolSize += size
+	}
+	e.Estimates = estimateFees(e.MinFeePerKb, e.Fullness, pool, gasPrices, capacity)
+	return e
+}
+
+// feePoolTx is a pool tx as the fee estimates see it
+type feePoolTx struct {
+	feePerKb uint64
+	size     uint64
+}
+
+// estimateFees returns the estimate of each priority for blocks of capacity
+// bytes, the recent ones being fullness full, with the txs of pool waiting and
+// gasPrices paid by the contract txs seen.
+func estimateFees(minFeePerKb uint64, fullness float64, pool []feePoolTx, gasPrices []uint64, capacity uint64) []FeeEstimate {
+	sort.Slice(pool, func(i, j int) bool { return pool[i].feePerKb > pool[j].feePerKb })
+	sort.Slice(gasPrices, func(i, j int) bool { return gasPrices[i] < gasPrices[j] })
+
+	var estimates []FeeEstimate
+	var last FeeEstimate
+	for _, p := range feePriorities {
+		estimate := FeeEstimate{
+			Priority: p.name,
+			Blocks:   p.blocks,
+			FeePerKb: minFeePerKb + uint64(float64(minFeePerKb)*fullness*p.congestion),
+			GasPrice: config.DEFAULT_GASPRICE,
+		}
+
+		// outbid the first pool tx which does not fit in the blocks before the target
+		var filled uint64
+		for _, tx := range pool {
+			filled += tx.size
+			if filled > p.blocks*capacity {
+				if tx.feePerKb+1 > estimate.FeePerKb {
+					estimate.FeePerKb = tx.feePerKb + 1
+				}
+				break
+			}
+		}
+
+		if len(gasPrices) > 0 {
+			estimate.GasPrice = gasPrices[(len(gasPrices)-1)*p.percentile/100]
+			if estimate.GasPrice < config.MIN_GASPRICE {
+				estimate.GasPrice = config.MIN_GASPRICE
+			}
+		}
+
+		// a higher priority never pays less
+		if estimate.FeePerKb < last.FeePerKb {
+			estimate.FeePerKb = last.FeePerKb
+		}
+		if estimate.GasPrice < last.GasPrice {
+			estimate.GasPrice = last.GasPrice
+		}
+		estimates = append(estimates, estimate)
+		last = estimate
+	}
+	return estimates
+}
+
+// recentBlocksUsage returns how full the last FEE_ESTIMATE_BLOCKS heights are on
+// average, and the gas prices of their contract txs.
+func (chain *Blockchain) recentBlocksUsage(capacity uint64) (fullness float64, gasPrices []uint64) {
+	top := chain.GetTopId()
+
+	feeBlocksCache.Lock()
+	defer feeBlocksCache.Unlock()
+	if top == feeBlocksCache.top && feeBlocksCache.gasPrices != nil {
+		return feeBlocksCache.fullness, append([]uint64(nil), feeBlocksCache.gasPrices...)
+	}
+
+	var size, count uint64
+	gasPrices = []uint64{}
+	height := chain.GetHeight()
+	for h := height; h > 0 && h > height-FEE_ESTIMATE_BLOCKS; h-- {
+		for _, blid := range chain.GetBlocksAtHeight(nil, h) {
+			size += chain.Load_Block_Size(nil, blid)
+			count++
+
+			bl, err := chain.LoadBlFromId(nil, blid)
+			if err != nil {
+				continue
+			}
+			for _, txid := range bl.TxHashes {
+				tx, err := chain.LoadTxFromId(nil, txid)
+				if err != nil {
+					continue
+				}
+				if price, ok := contractGasPrice(tx); ok {
+					gasPrices = append(gasPrices, price)
+				}
+			}
+		}
+	}
+	if count > 0 && capacity > 0 {
+		fullness = float64(size) / float64(count) / float64(capacity)
+		if fullness > 1 {
+			fullness = 1
+		}
+	}
+
+	feeBlocksCache.top = top
+	feeBlocksCache.fullness = fullness
+	feeBlocksCache.gasPrices = gasPrices
+	return fullness, append([]uint64(nil), gasPrices...)
+}
+
+func contractGasPrice(tx *transaction.Transaction) (uint64, bool) {
+	if !tx.IsContract() {
+		return 0, false
+	}
+	return tx.ExtraMap[transaction.TX_EXTRA_CONTRACT].(*transaction.SCData).Price, true
+}
@@ -0,0 +1,90 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package blockchain
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/darmaproject/darmasuite/config"
+	"github.com/darmaproject/darmasuite/globals"
+)
+
+// TestEstimateFees estimates the fees of a pool holding 15 blocks of 500 byte
+// txs, the best paying 1000+100*29 per KB, the worst 1000+100*0.
+func TestEstimateFees(t *testing.T) {
+	const capacity = 1000
+	var pool []feePoolTx
+	for i := 0; i < 30; i++ {
+		pool = append(pool, feePoolTx{feePerKb: 1000 + 100*uint64(i), size: 500})
+	}
+	var gasPrices []uint64
+	for i := 0; i < 9; i++ {
+		gasPrices = append(gasPrices, config.MIN_GASPRICE+uint64(i))
+	}
+	r := rand.New(rand.NewSource(1))
+	r.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+	r.Shuffle(len(gasPrices), func(i, j int) { gasPrices[i], gasPrices[j] = gasPrices[j], gasPrices[i] })
+
+	for _, test := range []struct {
+		name      string
+		pool      []feePoolTx
+		gasPrices []uint64
+		want      []FeeEstimate
+	}{
+		{"empty pool", nil, nil, []FeeEstimate{
+			{globals.FEE_PRIORITY_SLOW, 10, 1000, config.DEFAULT_GASPRICE},
+			{globals.FEE_PRIORITY_NORMAL, 3, 1250, config.DEFAULT_GASPRICE},
+			{globals.FEE_PRIORITY_FAST, 1, 1500, config.DEFAULT_GASPRICE},
+		}},
+		// slow outbids the 21st tx, which does not fit in 10 blocks, normal
+		// the 7th and fast the 3rd. The gas prices are the 25th, 50th and
+		// 75th percentiles.
+		{"full pool", pool, gasPrices, []FeeEstimate{
+			{globals.FEE_PRIORITY_SLOW, 10, 1901, config.MIN_GASPRICE + 2},
+			{globals.FEE_PRIORITY_NORMAL, 3, 3301, config.MIN_GASPRICE + 4},
+			{globals.FEE_PRIORITY_FAST, 1, 3701, config.MIN_GASPRICE + 6},
+		}},
+		// the 12 best paying txs fill 6 blocks, which slow waits for, and gas
+		// prices are never below the minimum
+		{"short pool", bestPaying(pool, 12), []uint64{0}, []FeeEstimate{
+			{globals.FEE_PRIORITY_SLOW, 10, 1000, config.MIN_GASPRICE},
+			{globals.FEE_PRIORITY_NORMAL, 3, 3301, config.MIN_GASPRICE},
+			{globals.FEE_PRIORITY_FAST, 1, 3701, config.MIN_GASPRICE},
+		}},
+	} {
+		got := estimateFees(1000, 0.5, append([]feePoolTx(nil), test.pool...), append([]uint64(nil), test.gasPrices...), capacity)
+		if len(got) != len(test.want) {
+			t.Fatalf("%s: %d estimates, want %d", test.name, len(got), len(test.want))
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: estimate %+v, want %+v", test.name, got[i], test.want[i])
+			}
+		}
+	}
+}
+
+// bestPaying returns the n txs of pool paying the most per KB.
+func bestPaying(pool []feePoolTx, n int) []feePoolTx {
+	var best []feePoolTx
+	for _, tx := range pool {
+		if tx.feePerKb >= 1000+100*uint64(30-n) {
+			best = append(best, tx)
+		}
+	}
+	return best
+}
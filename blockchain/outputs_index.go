@@ -21,7 +21,9 @@ package blockchain
 // rewrites them during chain reorganisation
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"github.com/darmaproject/darmasuite/address"
 	"github.com/darmaproject/darmasuite/block"
 	"github.com/darmaproject/darmasuite/config"
 	"github.com/darmaproject/darmasuite/crypto"
@@ -792,29 +794,44 @@ func (chain *Blockchain) writeContractTx(
 
 	for _, v := range sctxData.TransferE {
 		var o globals.TXOutputData
-		addr := common.HexToAddress(v.Address)
-		var pubkey crypto.Key
-		copy(pubkey[:], addr[:])
-		if err != nil || !pubkey.Public_Key_Valid() {
-			logger.Warnf("invalid output address %s, tx %s, err %s", v.Address, txid, err)
-			continue
-		}
 
 		o.BLID = blid // store block id
 		o.TXID = txid
 		o.Height = uint64(height)
 		o.TopoHeight = topoHeight
-		o.SigType = 0
-		o.Unlock_Height = 0
 		o.Block_Time = timestamp
 		o.Index_within_tx = *indexWithinTx
 		o.Key_Images = o.Key_Images[:0]
 
-		// generate one time keys
-		o.Tx_Public_Key, o.InKey.Destination = chain.getContractEphermalKey(txid, *indexWithinTx, pubkey)
-		o.Target = transaction.TxoutToKey{Key: o.InKey.Destination}
-		o.Amount = v.Amount
-		o.InKey.Mask = ringct.ZeroCommitmentFromAmount(v.Amount)
+		if v.Stealth {
+			addr, err := globals.ParseValidateAddress(v.Address)
+			if err != nil {
+				logger.Warnf("invalid payout address %s, tx %s, err %s", v.Address, txid, err)
+				continue
+			}
+
+			// amount is hidden, so it unlocks like the outputs of a normal tx
+			o.SigType = uint64(ringct.RCTTypeCLSAG)
+			o.Unlock_Height = uint64(height) + config.NORMAL_TX_AMOUNT_UNLOCK
+			writeContractPayoutOutput(&o, txid, *indexWithinTx, addr, v.Amount)
+		} else {
+			addr := common.HexToAddress(v.Address)
+			var pubkey crypto.Key
+			copy(pubkey[:], addr[:])
+			if !pubkey.Public_Key_Valid() {
+				logger.Warnf("invalid output address %s, tx %s", v.Address, txid)
+				continue
+			}
+
+			o.SigType = 0
+			o.Unlock_Height = 0
+
+			// generate one time keys
+			o.Tx_Public_Key, o.InKey.Destination = chain.getContractEphermalKey(txid, *indexWithinTx, pubkey)
+			o.Target = transaction.TxoutToKey{Key: o.InKey.Destination}
+			o.Amount = v.Amount
+			o.InKey.Mask = ringct.ZeroCommitmentFromAmount(v.Amount)
+		}
 		o.Index_Global = uint64(*indexGlobal)
 		o.TxType = globals.TX_TYPE_CONTRACT_TX
 
@@ -832,6 +849,62 @@ func (chain *Blockchain) writeContractTx(
 	return true
 }
 
+// writeContractPayoutOutput fills in o as an output paying amount to addr,
+// the way a wallet pays it: the one time key and the tx public key are
+// derived from the view key of addr, and the amount is encoded in the ECDH
+// tuple that ringct.Decode_Amount opens against the commitment.
+//
+// Every node has to derive the same output from the block alone, so the tx
+// secret key can only come from data every node has. Anyone who knows both
+// the txid and addr can therefore recognise the output and open its amount;
+// the amount is public in the contract call that made the payout anyway.
+// The output stays unlinkable to addr for anyone who doesn't know addr.
+func writeContractPayoutOutput(o *globals.TXOutputData, txid crypto.Hash, indexWithinTx uint64, addr *address.Address, amount uint64) {
+	writeContractPayoutToKeys(o, txid, indexWithinTx, addr.SpendKey, addr.ViewKey, addr.IsSubAddress(), amount)
+}
+
+// writeContractPayoutToKeys is writeContractPayoutOutput to the public spend
+// and view keys of an address, sub tells whether it is a sub address.
+func writeContractPayoutToKeys(o *globals.TXOutputData, txid crypto.Hash, indexWithinTx uint64, spendKey, viewKey crypto.Key, sub bool, amount uint64) {
+	var txSecretKey crypto.Key
+	hash := crypto.Keccak256(txid[:], []byte("contractpayout"))
+	copy(txSecretKey[:], hash[:])
+	crypto.ScReduce32(&txSecretKey)
+
+	derivation := crypto.KeyDerivation(&viewKey, &txSecretKey)
+	ephemeralKey := derivation.KeyDerivationToPublicKey(indexWithinTx, spendKey)
+	if sub {
+		// the view key of a sub address is a*D for its spend key D, so
+		// the wallet derives the same a*r*D from the tx public key r*D
+		target := transaction.TxoutToSubAddress{PubKey: *crypto.NewKeyByPoint(&txSecretKey, &spendKey)}
+		target.Key = ephemeralKey
+		o.Target = target
+		o.Tx_Public_Key = target.PubKey
+	} else {
+		o.Target = transaction.TxoutToKey{Key: ephemeralKey}
+		o.Tx_Public_Key = *txSecretKey.PublicKey()
+	}
+	o.InKey.Destination = ephemeralKey
+
+	// this is the encoding ringct.Decode_Amount reverses, with the mask
+	// derived from the shared secret instead of drawn at random
+	scalarKey := derivation.KeyDerivationToScalar(indexWithinTx)
+	mask := crypto.HashToScalar(append([]byte("commitment_mask"), scalarKey[:]...))
+
+	var amountKey crypto.Key
+	binary.LittleEndian.PutUint64(amountKey[:], amount)
+
+	maskSecret := crypto.HashToScalar(scalarKey[:])
+	amountSecret := crypto.HashToScalar(maskSecret[:])
+	crypto.ScAdd(&o.ECDHTuple.Mask, mask, maskSecret)
+	crypto.ScAdd(&o.ECDHTuple.Amount, &amountKey, amountSecret)
+
+	// mask*G + amount*H, the commitment Decode_Amount checks the opening
+	// against
+	crypto.AddKeys2(&o.InKey.Mask, mask, &amountKey, &crypto.H)
+	o.Amount = 0
+}
+
 func (chain *Blockchain) writePoolBonusTx(dbtx storage.DBTX,
 	blid crypto.Hash,
 	timestamp uint64,
@@ -0,0 +1,143 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package blockchain
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/darmaproject/darmasuite/address"
+	"github.com/darmaproject/darmasuite/crypto"
+	"github.com/darmaproject/darmasuite/globals"
+	"github.com/darmaproject/darmasuite/ringct"
+	"github.com/darmaproject/darmasuite/transaction"
+)
+
+// TestContractPayoutOutput opens a contract payout the way the wallet of the
+// payee does and checks it can be spent.
+func TestContractPayoutOutput(t *testing.T) {
+	spendSecret, spendPublic := crypto.NewKeyPair()
+	viewSecret, viewPublic := crypto.NewKeyPair()
+	addr := &address.Address{SpendKey: *spendPublic, ViewKey: *viewPublic}
+
+	txid := crypto.Keccak256([]byte("contract call"))
+	for _, amount := range []uint64{0, 1, 123456789, 1 << 60} {
+		for index := uint64(0); index < 3; index++ {
+			var o globals.TXOutputData
+			writeContractPayoutOutput(&o, txid, index, addr, amount)
+
+			if o.Amount != 0 {
+				t.Fatalf("amount %d is in the clear", amount)
+			}
+
+			derivation := crypto.KeyDerivation(&o.Tx_Public_Key, viewSecret)
+			if key := derivation.KeyDerivationToPublicKey(index, *spendPublic); key != o.InKey.Destination {
+				t.Fatalf("output %d of amount %d is not detected by the payee", index, amount)
+			}
+
+			scalarKey := derivation.KeyDerivationToScalar(index)
+			decoded, mask, ok := ringct.Decode_Amount(o.ECDHTuple, *scalarKey, o.InKey.Mask)
+			if !ok {
+				t.Fatalf("output %d of amount %d can't be decoded", index, amount)
+			}
+			if decoded != amount {
+				t.Fatalf("output %d decoded amount %d, want %d", index, decoded, amount)
+			}
+
+			// the opening matches the commitment
+			var amountKey, commitment crypto.Key
+			binary.LittleEndian.PutUint64(amountKey[:], amount)
+			crypto.AddKeys2(&commitment, &mask, &amountKey, &crypto.H)
+			if commitment != o.InKey.Mask {
+				t.Fatalf("output %d of amount %d: opening doesn't match the commitment", index, amount)
+			}
+
+			// and the payee holds the one time secret key
+			var secret crypto.Key
+			crypto.ScAdd(&secret, scalarKey, spendSecret)
+			if *secret.PublicKey() != o.InKey.Destination {
+				t.Fatalf("output %d of amount %d can't be spent by the payee", index, amount)
+			}
+		}
+	}
+}
+
+// TestContractPayoutOutputForOthers checks that another wallet doesn't
+// detect the payout.
+func TestContractPayoutOutputForOthers(t *testing.T) {
+	_, spendPublic := crypto.NewKeyPair()
+	_, viewPublic := crypto.NewKeyPair()
+	addr := &address.Address{SpendKey: *spendPublic, ViewKey: *viewPublic}
+
+	_, otherSpend := crypto.NewKeyPair()
+	otherView, _ := crypto.NewKeyPair()
+
+	var o globals.TXOutputData
+	writeContractPayoutOutput(&o, crypto.Hash{1}, 0, addr, 1000)
+
+	derivation := crypto.KeyDerivation(&o.Tx_Public_Key, otherView)
+	if derivation.KeyDerivationToPublicKey(0, *otherSpend) == o.InKey.Destination {
+		t.Fatal("payout detected by another wallet")
+	}
+}
+
+// TestContractPayoutOutputToSubAddress opens a contract payout to a sub
+// address of the payee, whose wallet has the view secret of its main address
+// and finds the sub address from the one time key.
+func TestContractPayoutOutputToSubAddress(t *testing.T) {
+	spendSecret, spendPublic := crypto.NewKeyPair()
+	viewSecret, _ := crypto.NewKeyPair()
+
+	// D = B + m*G and C = a*D, the keys of the sub address
+	subSecret, subPublic := crypto.NewKeyPair()
+	var subSpend crypto.Key
+	crypto.AddKeys(&subSpend, spendPublic, subPublic)
+	subView := *crypto.NewKeyByPoint(viewSecret, &subSpend)
+
+	txid := crypto.Keccak256([]byte("contract call"))
+	for _, amount := range []uint64{1, 123456789} {
+		for index := uint64(0); index < 3; index++ {
+			var o globals.TXOutputData
+			writeContractPayoutToKeys(&o, txid, index, subSpend, subView, true, amount)
+
+			target, ok := o.Target.(transaction.TxoutToSubAddress)
+			if !ok || target.PubKey != o.Tx_Public_Key || target.Key != o.InKey.Destination {
+				t.Fatalf("output %d of amount %d has target %+v", index, amount, o.Target)
+			}
+
+			derivation := crypto.KeyDerivation(&o.Tx_Public_Key, viewSecret)
+			if derivation.KeyDerivationToPublicKey(index, *spendPublic) == o.InKey.Destination {
+				t.Fatalf("output %d of amount %d detected on the main address", index, amount)
+			}
+			if key := derivation.KeyDerivationToPublicKey(index, subSpend); key != o.InKey.Destination {
+				t.Fatalf("output %d of amount %d is not detected by the payee", index, amount)
+			}
+
+			scalarKey := derivation.KeyDerivationToScalar(index)
+			decoded, _, ok := ringct.Decode_Amount(o.ECDHTuple, *scalarKey, o.InKey.Mask)
+			if !ok || decoded != amount {
+				t.Fatalf("output %d decoded amount %d, want %d", index, decoded, amount)
+			}
+
+			var secret crypto.Key
+			crypto.ScAdd(&secret, scalarKey, spendSecret)
+			crypto.ScAdd(&secret, &secret, subSecret)
+			if *secret.PublicKey() != o.InKey.Destination {
+				t.Fatalf("output %d of amount %d can't be spent by the payee", index, amount)
+			}
+		}
+	}
+}
@@ -48,12 +48,13 @@ func (chain *Blockchain) CalculateTXFee(hardForkVersion int64, tx *transaction.T
 		sizeInKb++
 	}
 
-	var neededFee uint64
+	return sizeInKb * chain.MinimumFeePerKb()
+}
+
+// MinimumFeePerKb returns the fee per KB the protocol asks for at the current height
+func (chain *Blockchain) MinimumFeePerKb() uint64 {
 	if chain.GetHeight() < globals.GetVotingStartHeight() {
-		neededFee = sizeInKb * config.BEFORE_DPOS_FEE_PER_KB
-	} else {
-		neededFee = sizeInKb * config.FEE_PER_KB
+		return config.BEFORE_DPOS_FEE_PER_KB
 	}
-
-	return neededFee
+	return config.FEE_PER_KB
 }
@@ -0,0 +1,177 @@
+// Copyright 2019 The darmasuite Authors
+// This file is part of the darmasuite library.
+//
+// The darmasuite library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The darmasuite library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the darmasuite library. If not, see <http://www.gnu.org/licenses/>.
+
+package abi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+
+	"github.com/darmaproject/darmasuite/dvm/common"
+)
+
+// Value is a decoded argument, in a form that marshals to JSON without loss:
+// integers beyond 2^53 become decimal strings and bytes 0x prefixed hex.
+type Value struct {
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// DecodedLog is a log decoded with the event of the ABI it matches.
+type DecodedLog struct {
+	Event  string  `json:"event"`
+	Values []Value `json:"values"`
+}
+
+// PackJSONArgs packs the call of method name, the constructor if name is
+// empty, with args given as a JSON array. Strings, numbers and booleans are
+// packed as PackStrArgs packs their text, so big integers can be given as
+// strings or as numbers.
+func (abi ABI) PackJSONArgs(name string, args []byte) ([]byte, error) {
+	var raw []json.RawMessage
+	if len(bytes.TrimSpace(args)) > 0 {
+		if err := json.Unmarshal(args, &raw); err != nil {
+			return nil, fmt.Errorf("abi: args must be a JSON array: %s", err)
+		}
+	}
+
+	strArgs := make([]string, len(raw))
+	for i, arg := range raw {
+		dec := json.NewDecoder(bytes.NewReader(arg))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		switch v := v.(type) {
+		case string:
+			strArgs[i] = v
+		case json.Number:
+			strArgs[i] = v.String()
+		case bool:
+			strArgs[i] = fmt.Sprintf("%t", v)
+		default:
+			return nil, fmt.Errorf("abi: arg %d must be a string, a number or a boolean", i)
+		}
+	}
+	return abi.PackStrArgs(name, strArgs...)
+}
+
+// DecodeOutputs decodes the return data of method or call name.
+func (abi ABI) DecodeOutputs(name string, output []byte) ([]Value, error) {
+	method, ok := abi.Methods[name]
+	if !ok {
+		if method, ok = abi.Calls[name]; !ok {
+			return nil, fmt.Errorf("abi: method or call '%s' not found", name)
+		}
+	}
+	if len(method.Outputs) == 0 {
+		return []Value{}, nil
+	}
+	values, err := method.Outputs.UnpackValues(output)
+	if err != nil {
+		return nil, err
+	}
+	return namedValues(method.Outputs, values), nil
+}
+
+// DecodeLog decodes a log with the event whose id is its first topic.
+// Indexed arguments of dynamic types are given as the hash in their topic.
+func (abi ABI) DecodeLog(topics []common.Hash, data []byte) (*DecodedLog, error) {
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("abi: anonymous logs cannot be decoded")
+	}
+	for _, event := range abi.Events {
+		if event.Anonymous || event.Id() != topics[0] {
+			continue
+		}
+
+		nonIndexed, err := event.Inputs.UnpackValues(data)
+		if err != nil {
+			return nil, err
+		}
+		log := &DecodedLog{Event: event.Name, Values: make([]Value, 0, len(event.Inputs))}
+		topic := 1
+		for _, input := range event.Inputs {
+			var v interface{}
+			if input.Indexed {
+				if topic >= len(topics) {
+					return nil, fmt.Errorf("abi: event %s has more indexed arguments than topics", event.Name)
+				}
+				if input.Type.requiresLengthPrefix() || input.Type.T == ArrayTy {
+					v = topics[topic]
+				} else if v, err = toGoType(0, input.Type, topics[topic][:]); err != nil {
+					return nil, err
+				}
+				topic++
+			} else {
+				v, nonIndexed = nonIndexed[0], nonIndexed[1:]
+			}
+			log.Values = append(log.Values, Value{Name: input.Name, Type: input.Type.String(), Value: jsonValue(input.Type, v)})
+		}
+		return log, nil
+	}
+	return nil, fmt.Errorf("abi: no event with id %x", topics[0])
+}
+
+func namedValues(args Arguments, values []interface{}) []Value {
+	named := make([]Value, len(values))
+	for i, v := range values {
+		named[i] = Value{Name: args[i].Name, Type: args[i].Type.String(), Value: jsonValue(args[i].Type, v)}
+	}
+	return named
+}
+
+// jsonValue converts v of type t, as UnpackValues returns it, to what
+// marshals to JSON without loss.
+func jsonValue(t Type, v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	switch t.T {
+	case BytesTy, FixedBytesTy:
+		b := make([]byte, rv.Len())
+		for i := range b {
+			b[i] = byte(rv.Index(i).Uint())
+		}
+		return fmt.Sprintf("0x%x", b)
+	case SliceTy, ArrayTy:
+		list := make([]interface{}, rv.Len())
+		for i := range list {
+			list[i] = jsonValue(*t.Elem, rv.Index(i).Interface())
+		}
+		return list
+	}
+
+	// integers beyond 2^53 lose precision in javascript
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if b, ok := v.(*big.Int); ok {
+			return b.String()
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i := rv.Int(); i > 1<<53 || i < -(1<<53) {
+			return fmt.Sprintf("%d", i)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() > 1<<53 {
+			return fmt.Sprintf("%d", rv.Uint())
+		}
+	}
+	return v
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 The darmasuite Authors
+// This file is part of the darmasuite library.
+//
+// The darmasuite library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The darmasuite library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the darmasuite library. If not, see <http://www.gnu.org/licenses/>.
+
+package abi
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/darmaproject/darmasuite/dvm/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const jsonToken = `
+[
+	{ "type" : "function", "name" : "transfer", "inputs" : [ { "name" : "to", "type" : "address" }, { "name" : "value", "type" : "uint256" } ], "outputs" : [ { "name" : "ok", "type" : "bool" } ] },
+	{ "type" : "function", "name" : "info", "constant" : true, "outputs" : [ { "name" : "name", "type" : "string" }, { "name" : "supply", "type" : "uint256" }, { "name" : "id", "type" : "bytes4" }, { "name" : "big", "type" : "uint64" } ] },
+	{ "type" : "event", "name" : "Transfer", "inputs" : [ { "indexed" : true, "name" : "from", "type" : "address" }, { "indexed" : true, "name" : "memo", "type" : "string" }, { "indexed" : false, "name" : "value", "type" : "uint256" } ] }
+]`
+
+func TestPackJSONArgs(t *testing.T) {
+	abi, err := JSON(strings.NewReader(jsonToken))
+	require.NoError(t, err)
+
+	to := "0x00000000000000000000000000000000000000000000000000000000000000aa"
+	want, err := abi.PackStrArgs("transfer", to, "100000000000000000000")
+	require.NoError(t, err)
+
+	for _, args := range []string{
+		`["` + to + `", "100000000000000000000"]`,
+		`["` + to + `", 100000000000000000000]`,
+	} {
+		got, err := abi.PackJSONArgs("transfer", []byte(args))
+		require.NoError(t, err, args)
+		assert.Equal(t, want, got, args)
+	}
+
+	_, err = abi.PackJSONArgs("transfer", []byte(`["`+to+`", {"value": 1}]`))
+	assert.Error(t, err)
+	_, err = abi.PackJSONArgs("transfer", []byte(`{}`))
+	assert.Error(t, err)
+}
+
+func TestDecodeOutputs(t *testing.T) {
+	abi, err := JSON(strings.NewReader(jsonToken))
+	require.NoError(t, err)
+
+	supply, _ := new(big.Int).SetString("100000000000000000000", 10)
+	output, err := abi.Methods["info"].Outputs.Pack("Token", supply, [4]byte{1, 2, 3, 4}, uint64(1)<<60)
+	require.NoError(t, err)
+
+	values, err := abi.DecodeOutputs("info", output)
+	require.NoError(t, err)
+	data, err := json.Marshal(values)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"name": "name", "type": "string", "value": "Token"},
+		{"name": "supply", "type": "uint256", "value": "100000000000000000000"},
+		{"name": "id", "type": "bytes4", "value": "0x01020304"},
+		{"name": "big", "type": "uint64", "value": "1152921504606846976"}
+	]`, string(data))
+
+	_, err = abi.DecodeOutputs("missing", output)
+	assert.Error(t, err)
+}
+
+func TestDecodeLog(t *testing.T) {
+	abi, err := JSON(strings.NewReader(jsonToken))
+	require.NoError(t, err)
+
+	from := common.HexToAddress("0xbb")
+	memo := common.HexToHash("0x1234")
+	data, err := abi.Events["Transfer"].Inputs.NonIndexed().Pack(big.NewInt(7))
+	require.NoError(t, err)
+
+	log, err := abi.DecodeLog([]common.Hash{abi.Events["Transfer"].Id(), from.Hash(), memo}, data)
+	require.NoError(t, err)
+	assert.Equal(t, "Transfer", log.Event)
+	require.Len(t, log.Values, 3)
+	assert.Equal(t, from, log.Values[0].Value)
+	assert.Equal(t, memo, log.Values[1].Value)
+	assert.Equal(t, "7", log.Values[2].Value)
+
+	_, err = abi.DecodeLog([]common.Hash{common.HexToHash("0x01")}, data)
+	assert.Error(t, err)
+	_, err = abi.DecodeLog([]common.Hash{abi.Events["Transfer"].Id()}, data)
+	assert.Error(t, err)
+}
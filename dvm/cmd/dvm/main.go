@@ -0,0 +1,65 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+
+// dvm executes EVM bytecode or WAVM contracts against an in-memory state,
+// without a running daemon. It is the Darma counterpart of go-ethereum's evm tool.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/docopt/docopt-go"
+
+	"github.com/darmaproject/darmasuite/config"
+)
+
+const commandLine = `dvm
+Darma virtual machine: run EVM bytecode or WAVM contracts outside the daemon
+
+Usage:
+  dvm run [--wasm] [--create] [--code=<hex> | --codefile=<file>] [--input=<hex> | --inputfile=<file>] [--sender=<address>] [--receiver=<address>] [--value=<amount>] [--gas=<gas>] [--price=<price>] [--prestate=<file>] [--chainconfig=<file>] [--number=<height>] [--timestamp=<unix>] [--json] [--nomemory] [--nostack] [--nodump]
+  dvm -h | --help
+  dvm -v | --version
+
+Options:
+  -h --help             Show usage.
+  -v --version          Show version.
+  --wasm                Run the code on WAVM instead of EVM, the code must be a compressed WASM bundle
+  --create              Deploy the code and run its constructor (input is appended as constructor arguments)
+  --code=<hex>          Hex encoded code to run
+  --codefile=<file>     File containing hex encoded EVM code or a binary compressed WASM bundle
+  --input=<hex>         Hex encoded call data
+  --inputfile=<file>    File containing hex encoded call data
+  --sender=<address>    Caller address [default: 0x73656e646572]
+  --receiver=<address>  Address of the called contract [default: 0x7265636569766572]
+  --value=<amount>      Value transferred with the call [default: 0]
+  --gas=<gas>           Gas limit for the execution, the deploy and the call of WASM code without --create share it [default: 10000000000]
+  --price=<price>       Gas price [default: 0]
+  --prestate=<file>     JSON file with the prestate, in the "pre" format of the dvm/core/wavm/tests fixtures
+  --chainconfig=<file>  JSON file with the chain config, the forks to run under, instead of the one of the daemon
+  --number=<height>     Block number seen by the contract
+  --timestamp=<unix>    Block timestamp seen by the contract
+  --json                Print a JSON execution trace to stderr
+  --nomemory            Exclude memory from the EVM JSON trace
+  --nostack             Exclude the stack from the EVM JSON trace
+  --nodump              Do not print the post-execution state dump
+`
+
+func main() {
+	arguments, err := docopt.Parse(commandLine, nil, true, config.Version.String(), false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while parsing options err: %s\n", err)
+		os.Exit(1)
+	}
+
+	cfg, err := parseRunConfig(arguments)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	if err = run(cfg, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,412 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/darmaproject/darmasuite/dvm/common"
+	"github.com/darmaproject/darmasuite/dvm/core"
+	"github.com/darmaproject/darmasuite/dvm/core/evm"
+	"github.com/darmaproject/darmasuite/dvm/core/evm/runtime"
+	"github.com/darmaproject/darmasuite/dvm/core/rawdb"
+	"github.com/darmaproject/darmasuite/dvm/core/state"
+	"github.com/darmaproject/darmasuite/dvm/core/types"
+	"github.com/darmaproject/darmasuite/dvm/core/vm"
+	"github.com/darmaproject/darmasuite/dvm/core/wavm"
+	wavmruntime "github.com/darmaproject/darmasuite/dvm/core/wavm/runtime"
+	"github.com/darmaproject/darmasuite/dvm/crypto"
+	"github.com/darmaproject/darmasuite/dvm/params"
+)
+
+// runConfig holds the parsed command line of a single dvm run.
+type runConfig struct {
+	wasm   bool
+	create bool
+
+	code  []byte
+	input []byte
+
+	sender   common.Address
+	receiver common.Address
+	value    *big.Int
+	gas      uint64
+	price    *big.Int
+
+	number    *big.Int
+	timestamp *big.Int
+
+	pre         *prestate
+	chainConfig *params.ChainConfig
+
+	jsonTrace bool
+	noMemory  bool
+	noStack   bool
+	noDump    bool
+}
+
+// prestate is the subset of the dvm/core/wavm/tests fixture format that the
+// runner understands: the block environment and the "pre" allocation.
+type prestate struct {
+	Env stEnv            `json:"env"`
+	Pre dvm.GenesisAlloc `json:"pre"`
+}
+
+type stEnv struct {
+	Coinbase   common.Address `json:"currentCoinbase"`
+	Difficulty *big.Int       `json:"currentDifficulty"`
+	GasLimit   uint64         `json:"currentGasLimit"`
+	Number     uint64         `json:"currentNumber"`
+	Timestamp  uint64         `json:"currentTimestamp"`
+}
+
+// execResult is printed as JSON once the execution finished.
+type execResult struct {
+	Output        string          `json:"output"`
+	GasUsed       uint64          `json:"gasUsed"`                 // deploy and call included
+	DeployGasUsed uint64          `json:"deployGasUsed,omitempty"` // when code is deployed before it is called
+	Address       *common.Address `json:"address,omitempty"`
+	Error         string          `json:"error,omitempty"`
+	Time          string          `json:"time"`
+	Logs          []*types.Log    `json:"logs"`
+	State         *state.Dump     `json:"state,omitempty"`
+}
+
+func parseRunConfig(arguments map[string]interface{}) (*runConfig, error) {
+	var err error
+	cfg := &runConfig{
+		wasm:      arguments["--wasm"].(bool),
+		create:    arguments["--create"].(bool),
+		jsonTrace: arguments["--json"].(bool),
+		noMemory:  arguments["--nomemory"].(bool),
+		noStack:   arguments["--nostack"].(bool),
+		noDump:    arguments["--nodump"].(bool),
+	}
+
+	if arguments["--code"] != nil {
+		if cfg.code, err = decodeHex(arguments["--code"].(string)); err != nil {
+			return nil, fmt.Errorf("invalid code: %s", err)
+		}
+	} else if arguments["--codefile"] != nil {
+		if cfg.code, err = readCodeFile(arguments["--codefile"].(string), cfg.wasm); err != nil {
+			return nil, err
+		}
+	}
+
+	if arguments["--input"] != nil {
+		if cfg.input, err = decodeHex(arguments["--input"].(string)); err != nil {
+			return nil, fmt.Errorf("invalid input: %s", err)
+		}
+	} else if arguments["--inputfile"] != nil {
+		if cfg.input, err = readCodeFile(arguments["--inputfile"].(string), false); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg.sender = common.HexToAddress(arguments["--sender"].(string))
+	cfg.receiver = common.HexToAddress(arguments["--receiver"].(string))
+
+	if cfg.value, err = parseBig(arguments["--value"].(string)); err != nil {
+		return nil, fmt.Errorf("invalid value: %s", err)
+	}
+	if cfg.price, err = parseBig(arguments["--price"].(string)); err != nil {
+		return nil, fmt.Errorf("invalid price: %s", err)
+	}
+	if cfg.gas, err = strconv.ParseUint(arguments["--gas"].(string), 0, 64); err != nil {
+		return nil, fmt.Errorf("invalid gas: %s", err)
+	}
+
+	if arguments["--number"] != nil {
+		if cfg.number, err = parseBig(arguments["--number"].(string)); err != nil {
+			return nil, fmt.Errorf("invalid number: %s", err)
+		}
+	}
+	if arguments["--timestamp"] != nil {
+		if cfg.timestamp, err = parseBig(arguments["--timestamp"].(string)); err != nil {
+			return nil, fmt.Errorf("invalid timestamp: %s", err)
+		}
+	}
+
+	if arguments["--prestate"] != nil {
+		if cfg.pre, err = loadPrestate(arguments["--prestate"].(string)); err != nil {
+			return nil, err
+		}
+	}
+
+	// without a config the forks are those of the daemon, not the runtime
+	// defaults which enable every WAVM fork
+	cfg.chainConfig = dvm.GetChainCOnfig()
+	if arguments["--chainconfig"] != nil {
+		if cfg.chainConfig, err = loadChainConfig(arguments["--chainconfig"].(string)); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(cfg.code) == 0 && cfg.create {
+		return nil, fmt.Errorf("--create requires --code or --codefile")
+	}
+	return cfg, nil
+}
+
+func decodeHex(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	return hex.DecodeString(s)
+}
+
+func parseBig(s string) (*big.Int, error) {
+	v, ok := new(big.Int).SetString(s, 0)
+	if !ok {
+		return nil, fmt.Errorf("cannot parse %q as integer", s)
+	}
+	return v, nil
+}
+
+// readCodeFile reads EVM code as hex text. WASM bundles are accepted either
+// as hex text or in the raw binary form written by the contract compiler.
+func readCodeFile(path string, binary bool) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %s", path, err)
+	}
+	if code, err := decodeHex(string(data)); err == nil {
+		return code, nil
+	}
+	if binary {
+		return data, nil
+	}
+	return nil, fmt.Errorf("%s does not contain hex data", path)
+}
+
+// loadPrestate accepts either a complete test fixture with "env" and "pre"
+// sections or a bare allocation map.
+func loadPrestate(path string) (*prestate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read prestate %s: %s", path, err)
+	}
+	pre := new(prestate)
+	if err = json.Unmarshal(data, pre); err != nil {
+		return nil, fmt.Errorf("invalid prestate %s: %s", path, err)
+	}
+	if pre.Pre == nil {
+		if err = json.Unmarshal(data, &pre.Pre); err != nil {
+			return nil, fmt.Errorf("invalid prestate %s: %s", path, err)
+		}
+	}
+	return pre, nil
+}
+
+func loadChainConfig(path string) (*params.ChainConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read chain config %s: %s", path, err)
+	}
+	config := new(params.ChainConfig)
+	if err = json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid chain config %s: %s", path, err)
+	}
+	return config, nil
+}
+
+// makeState builds an in-memory state from the allocation and re-opens it at
+// the committed root, so that execution starts from a clean journal.
+func makeState(alloc dvm.GenesisAlloc) *state.StateDB {
+	sdb := state.NewDatabase(rawdb.NewMemoryDatabase())
+	statedb, _ := state.New(common.Hash{}, sdb, nil)
+	for addr, a := range alloc {
+		statedb.SetCode(addr, a.Code)
+		statedb.SetNonce(addr, a.Nonce)
+		if a.Balance != nil {
+			statedb.SetBalance(addr, a.Balance)
+		}
+		for k, v := range a.Storage {
+			statedb.SetState(addr, k, v)
+		}
+	}
+	root, _ := statedb.Commit(false)
+	statedb, _ = state.New(root, sdb, nil)
+	return statedb
+}
+
+// blockContext returns the block fields seen by the contract, preferring the
+// command line over the prestate environment.
+func (cfg *runConfig) blockContext() (coinbase common.Address, number, timestamp, difficulty *big.Int) {
+	number, timestamp, difficulty = new(big.Int), big.NewInt(time.Now().Unix()), new(big.Int)
+	if cfg.pre != nil {
+		coinbase = cfg.pre.Env.Coinbase
+		number.SetUint64(cfg.pre.Env.Number)
+		if cfg.pre.Env.Timestamp != 0 {
+			timestamp.SetUint64(cfg.pre.Env.Timestamp)
+		}
+		if cfg.pre.Env.Difficulty != nil {
+			difficulty.Set(cfg.pre.Env.Difficulty)
+		}
+	}
+	if cfg.number != nil {
+		number.Set(cfg.number)
+	}
+	if cfg.timestamp != nil {
+		timestamp.Set(cfg.timestamp)
+	}
+	return
+}
+
+func vmTestBlockHash(n uint64) common.Hash {
+	return common.BytesToHash(crypto.Keccak256([]byte(new(big.Int).SetUint64(n).String())))
+}
+
+func run(cfg *runConfig, out, traceOut io.Writer) error {
+	var alloc dvm.GenesisAlloc
+	if cfg.pre != nil {
+		alloc = cfg.pre.Pre
+	}
+	statedb := makeState(alloc)
+	statedb.Prepare(common.Hash{}, common.Hash{}, 0)
+
+	var (
+		ret           []byte
+		address       *common.Address
+		leftOverGas   uint64
+		deployGasUsed uint64
+		err           error
+	)
+	start := time.Now()
+	if cfg.wasm {
+		ret, address, leftOverGas, deployGasUsed, err = runWAVM(cfg, statedb, traceOut)
+	} else {
+		ret, address, leftOverGas, err = runEVM(cfg, statedb, traceOut)
+	}
+	elapsed := time.Since(start)
+
+	result := execResult{
+		Output:        fmt.Sprintf("0x%x", ret),
+		GasUsed:       cfg.gas - leftOverGas,
+		DeployGasUsed: deployGasUsed,
+		Address:       address,
+		Time:          elapsed.String(),
+		Logs:          statedb.Logs(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if !cfg.noDump {
+		statedb.Commit(false)
+		dump := statedb.RawDump(false, false, false)
+		result.State = &dump
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
+
+func runEVM(cfg *runConfig, statedb *state.StateDB, traceOut io.Writer) ([]byte, *common.Address, uint64, error) {
+	coinbase, number, timestamp, difficulty := cfg.blockContext()
+	runtimeConfig := &runtime.Config{
+		Origin:      cfg.sender,
+		State:       statedb,
+		GasLimit:    cfg.gas,
+		GasPrice:    cfg.price,
+		Value:       cfg.value,
+		Coinbase:    coinbase,
+		Difficulty:  difficulty,
+		Time:        timestamp,
+		BlockNumber: number,
+		GetHashFn:   vmTestBlockHash,
+		ChainConfig: cfg.chainConfig,
+	}
+	if cfg.jsonTrace {
+		runtimeConfig.EVMConfig = evm.Config{
+			Debug: true,
+			Tracer: evm.NewJSONLogger(&evm.LogConfig{
+				DisableMemory: cfg.noMemory,
+				DisableStack:  cfg.noStack,
+			}, traceOut),
+		}
+	}
+
+	if cfg.create {
+		input := append(common.CopyBytes(cfg.code), cfg.input...)
+		ret, address, leftOverGas, err := runtime.Create(input, runtimeConfig)
+		return ret, &address, leftOverGas, err
+	}
+	if len(cfg.code) > 0 {
+		statedb.SetCode(cfg.receiver, cfg.code)
+	}
+	ret, leftOverGas, err := runtime.Call(cfg.receiver, cfg.input, runtimeConfig)
+	return ret, nil, leftOverGas, err
+}
+
+// runWAVM deploys the bundle when asked to, or when code was given without
+// --create, deploys it first and then calls the fresh contract with the input
+// and the gas the deploy left. deployGasUsed is the gas of that deploy.
+func runWAVM(cfg *runConfig, statedb *state.StateDB, traceOut io.Writer) (ret []byte, address *common.Address, leftOverGas, deployGasUsed uint64, err error) {
+	coinbase, number, timestamp, difficulty := cfg.blockContext()
+	runtimeConfig := &wavmruntime.Config{
+		Origin:      cfg.sender,
+		State:       statedb,
+		GasLimit:    cfg.gas,
+		GasPrice:    cfg.price,
+		Value:       cfg.value,
+		Coinbase:    coinbase,
+		Difficulty:  difficulty,
+		Time:        timestamp,
+		BlockNumber: number,
+		GetHashFn:   vmTestBlockHash,
+		ChainConfig: cfg.chainConfig,
+	}
+	if cfg.jsonTrace {
+		logger := wavm.NewWasmLogger(&vm.LogConfig{Debug: true})
+		runtimeConfig.WAVMConfig = vm.Config{Debug: true, Tracer: logger}
+		defer writeWasmTrace(traceOut, logger)
+	}
+
+	receiver := cfg.receiver
+	if len(cfg.code) > 0 {
+		code := cfg.code
+		if cfg.create {
+			code = append(common.CopyBytes(cfg.code), cfg.input...)
+		}
+		var contractAddr common.Address
+		ret, contractAddr, leftOverGas, err = wavmruntime.Create(code, runtimeConfig)
+		if cfg.create || err != nil {
+			return ret, &contractAddr, leftOverGas, 0, err
+		}
+		receiver = contractAddr
+		address = &contractAddr
+		deployGasUsed = cfg.gas - leftOverGas
+		if leftOverGas == 0 {
+			// the runtime would take a gas limit of 0 as no limit
+			return nil, address, 0, deployGasUsed, vm.ErrOutOfGas
+		}
+		runtimeConfig.GasLimit = leftOverGas
+	}
+	ret, leftOverGas, err = wavmruntime.Call(receiver, cfg.input, runtimeConfig)
+	return ret, address, leftOverGas, deployGasUsed, err
+}
+
+func writeWasmTrace(w io.Writer, logger *wavm.WasmLogger) {
+	encoder := json.NewEncoder(w)
+	for _, log := range logger.StructLogs() {
+		encoder.Encode(struct {
+			Pc      uint64 `json:"pc"`
+			Op      string `json:"op"`
+			Gas     uint64 `json:"gas"`
+			GasCost uint64 `json:"gasCost"`
+			Depth   int    `json:"depth"`
+			Error   string `json:"error,omitempty"`
+		}{log.Pc, log.OpName(), log.Gas, log.GasCost, log.Depth, log.ErrorString()})
+	}
+	for _, log := range logger.DebugLogs() {
+		encoder.Encode(log)
+	}
+}
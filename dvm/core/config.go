@@ -12,5 +12,10 @@ func GetVMConfig() vm.Config {
 }
 
 func GetChainCOnfig() *params.ChainConfig {
-	return &params.ChainConfig{}
+	// WAVMLimitsBlock, WAVMGasV2Block, ContractPayoutBlock, ContractDWBlock
+	// and AccountRegistryBlock are left unset until they are scheduled for a
+	// hard fork, enforcing them on earlier blocks would change their results.
+	return &params.ChainConfig{
+		WAVMLimits: &params.DefaultWAVMLimits,
+	}
 }
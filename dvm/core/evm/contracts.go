@@ -25,6 +25,7 @@ import (
 
 	"github.com/darmaproject/darmasuite/dvm/common"
 	"github.com/darmaproject/darmasuite/dvm/common/math"
+	"github.com/darmaproject/darmasuite/dvm/core/vm"
 	"github.com/darmaproject/darmasuite/dvm/crypto"
 	"github.com/darmaproject/darmasuite/dvm/crypto/blake2b"
 	"github.com/darmaproject/darmasuite/dvm/crypto/bls12381"
@@ -960,3 +961,61 @@ func (c *bls12381MapG2) Run(input []byte) ([]byte, error) {
 	// Encode the G2 point to 256 bytes
 	return g.EncodePoint(r), nil
 }
+
+// ContractPayoutAddress is the address of the contractPayout precompile.
+var ContractPayoutAddress = common.BytesToAddress([]byte{1, 0})
+
+var errContractPayoutCall = errors.New("payouts must be made with a value transferring call")
+
+// contractPayout implemented as a native contract. A contract pays the value
+// of a call to it to the Darma address given as input, the payout leaves the
+// VM as an output of the transaction. It is available from the contract
+// payout fork on.
+type contractPayout struct{}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *contractPayout) RequiredGas(input []byte) uint64 {
+	return params.ContractPayoutGas
+}
+
+// Run is only reached by calls that do not transfer value to the precompile,
+// Call pays out through pay.
+func (c *contractPayout) Run(input []byte) ([]byte, error) {
+	return nil, errContractPayoutCall
+}
+
+func (c *contractPayout) pay(evm *EVM, caller common.Address, input []byte, suppliedGas uint64, value *big.Int) (ret []byte, remainingGas uint64, err error) {
+	gasCost := c.RequiredGas(input)
+	if suppliedGas < gasCost {
+		return nil, 0, ErrOutOfGas
+	}
+	suppliedGas -= gasCost
+
+	// Call has moved the value to the precompile, hand it back so that the
+	// payout is taken from the paying contract.
+	evm.Context.Transfer(evm.StateDB, ContractPayoutAddress, caller, value, false)
+	if err := evm.Context.TransferEx(evm.StateDB, caller, string(input), value); err != nil {
+		return nil, suppliedGas, err
+	}
+	return nil, suppliedGas, nil
+}
+
+// accountOwner implemented as a native contract. It returns the Darma address
+// registered for the contract account given as a 32 byte word, or nothing if
+// the account has none. It is available from the account registry fork on.
+type accountOwner struct {
+	addressToString vm.AddressToString
+}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *accountOwner) RequiredGas(input []byte) uint64 {
+	return params.AccountOwnerGas
+}
+
+func (c *accountOwner) Run(input []byte) ([]byte, error) {
+	if c.addressToString == nil {
+		return nil, nil
+	}
+	account := common.BytesToAddress(getData(input, 0, 32))
+	return []byte(c.addressToString(account[:])), nil
+}
@@ -58,6 +58,12 @@ func (evm *EVM) precompile(addr common.Address) (PrecompiledContract, bool) {
 		precompiles = PrecompiledContractsHomestead
 	}
 	p, ok := precompiles[addr]
+	if !ok && evm.chainRules.IsContractPayout && addr == ContractPayoutAddress {
+		return &contractPayout{}, true
+	}
+	if !ok && evm.chainRules.IsAccountRegistry && addr == vm.AccountRegistryAddress {
+		return &accountOwner{evm.Context.AddressToString}, true
+	}
 	return p, ok
 }
 
@@ -232,7 +238,9 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 		}(gas, time.Now())
 	}
 
-	if isPrecompile {
+	if payout, ok := p.(*contractPayout); ok {
+		ret, gas, err = payout.pay(evm, caller.Address(), input, gas, value)
+	} else if isPrecompile {
 		ret, gas, err = RunPrecompiledContract(p, input, gas)
 	} else {
 		// Initialise a new contract and set the code that is to be used by the EVM.
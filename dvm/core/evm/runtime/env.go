@@ -18,22 +18,26 @@ package runtime
 
 import (
 	"github.com/darmaproject/darmasuite/dvm/core"
+	"github.com/darmaproject/darmasuite/dvm/core/evm"
 	"github.com/darmaproject/darmasuite/dvm/core/vm"
 )
 
-func NewEnv(cfg *Config) *vm.EVM {
+func NewEnv(cfg *Config) *evm.EVM {
 	context := vm.Context{
-		CanTransfer: core.CanTransfer,
-		Transfer:    core.Transfer,
-		GetHash:     cfg.GetHashFn,
-		Origin:      cfg.Origin,
-		Coinbase:    cfg.Coinbase,
-		BlockNumber: cfg.BlockNumber,
-		Time:        cfg.Time,
-		Difficulty:  cfg.Difficulty,
-		GasLimit:    cfg.GasLimit,
-		GasPrice:    cfg.GasPrice,
+		CanTransferFunc: dvm.CanTransfer,
+		TransferFunc:    dvm.Transfer,
+		TransferExFunc:  dvm.TransferEx,
+		PayoutAddress:   cfg.PayoutAddressFn,
+		GetHash:         cfg.GetHashFn,
+		AddressToString: cfg.AddressToStringFn,
+		Origin:          cfg.Origin,
+		Coinbase:        cfg.Coinbase,
+		BlockNumber:     cfg.BlockNumber,
+		Time:            cfg.Time,
+		Difficulty:      cfg.Difficulty,
+		GasLimit:        cfg.GasLimit,
+		GasPrice:        cfg.GasPrice,
 	}
 
-	return vm.NewEVM(context, cfg.State, cfg.ChainConfig, cfg.EVMConfig)
+	return evm.NewEVM(context, cfg.State, cfg.ChainConfig, cfg.EVMConfig)
 }
@@ -0,0 +1,113 @@
+// Copyright 2019 The darmasuite Authors
+// This file is part of the darmasuite library.
+//
+// The darmasuite library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The darmasuite library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the darmasuite library. If not, see <http://www.gnu.org/licenses/>.
+
+package runtime
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/darmaproject/darmasuite/dvm/common"
+	"github.com/darmaproject/darmasuite/dvm/core/evm"
+	"github.com/darmaproject/darmasuite/dvm/core/rawdb"
+	"github.com/darmaproject/darmasuite/dvm/core/state"
+	"github.com/darmaproject/darmasuite/dvm/params"
+)
+
+const payoutRecipient = "dERoXHjNHFBabzBCQbBDSqbkLURQyzmPRCLfeFtzRQA3NgVfU4HDbRpZQUKBzq59QU2QLcoAviYQ59FG4bu8T9pZ1woERqciSL"
+
+// payoutCode calls the payout precompile with 700 atomic units and the call
+// data as recipient, and returns whether the call succeeded.
+var payoutCode = []byte{
+	byte(evm.CALLDATASIZE),
+	byte(evm.PUSH1), 0,
+	byte(evm.PUSH1), 0,
+	byte(evm.CALLDATACOPY),
+	byte(evm.PUSH1), 0, // retLength
+	byte(evm.PUSH1), 0, // retOffset
+	byte(evm.CALLDATASIZE), // argsLength
+	byte(evm.PUSH1), 0,     // argsOffset
+	byte(evm.PUSH2), 0x02, 0xbc, // value
+	byte(evm.PUSH2), 0x01, 0x00, // address
+	byte(evm.GAS),
+	byte(evm.CALL),
+	byte(evm.PUSH1), 0,
+	byte(evm.MSTORE),
+	byte(evm.PUSH1), 32,
+	byte(evm.PUSH1), 0,
+	byte(evm.RETURN),
+}
+
+func executePayout(t *testing.T, chainConfig *params.ChainConfig, recipient string) (bool, *state.StateDB) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.AddBalance(common.BytesToAddress([]byte("contract")), big.NewInt(1000))
+	cfg := &Config{
+		ChainConfig: chainConfig,
+		State:       statedb,
+		PayoutAddressFn: func(addr string) bool {
+			return addr == payoutRecipient
+		},
+	}
+	ret, _, err := Execute(payoutCode, []byte(recipient), cfg)
+	if err != nil {
+		t.Fatal("didn't expect error", err)
+	}
+	return new(big.Int).SetBytes(ret).Sign() != 0, statedb
+}
+
+func TestContractPayout(t *testing.T) {
+	contract := common.BytesToAddress([]byte("contract"))
+	forked := &params.ChainConfig{ChainID: big.NewInt(1), ContractPayoutBlock: new(big.Int)}
+
+	ok, statedb := executePayout(t, forked, payoutRecipient)
+	if !ok {
+		t.Fatal("expected payout to succeed")
+	}
+	payouts := statedb.GetPayouts(common.Hash{})
+	if len(payouts) != 1 {
+		t.Fatalf("expected 1 payout, got %d", len(payouts))
+	}
+	if p := payouts[0]; p.Contract != contract || p.Address != payoutRecipient || p.Amount != 700 {
+		t.Errorf("unexpected payout %+v", p)
+	}
+	if balance := statedb.GetBalance(contract); balance.Cmp(big.NewInt(300)) != 0 {
+		t.Errorf("expected contract balance 300, got %v", balance)
+	}
+	if balance := statedb.GetBalance(evm.ContractPayoutAddress); balance.Sign() != 0 {
+		t.Errorf("expected precompile to keep no funds, got %v", balance)
+	}
+
+	// a failed payout is reverted with the call
+	ok, statedb = executePayout(t, forked, "not an address")
+	if ok {
+		t.Fatal("expected payout to an invalid address to fail")
+	}
+	if payouts := statedb.GetPayouts(common.Hash{}); len(payouts) != 0 {
+		t.Errorf("expected no payouts, got %d", len(payouts))
+	}
+	if balance := statedb.GetBalance(contract); balance.Cmp(big.NewInt(1000)) != 0 {
+		t.Errorf("expected contract balance 1000, got %v", balance)
+	}
+
+	// before the fork the call is a plain value transfer
+	ok, statedb = executePayout(t, &params.ChainConfig{ChainID: big.NewInt(1)}, payoutRecipient)
+	if !ok {
+		t.Fatal("expected value transfer to succeed")
+	}
+	if payouts := statedb.GetPayouts(common.Hash{}); len(payouts) != 0 {
+		t.Errorf("expected no payouts before the fork, got %d", len(payouts))
+	}
+}
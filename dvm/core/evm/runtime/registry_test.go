@@ -0,0 +1,79 @@
+// Copyright 2019 The darmasuite Authors
+// This file is part of the darmasuite library.
+//
+// The darmasuite library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The darmasuite library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the darmasuite library. If not, see <http://www.gnu.org/licenses/>.
+
+package runtime
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/darmaproject/darmasuite/dvm/common"
+	"github.com/darmaproject/darmasuite/dvm/core/rawdb"
+	"github.com/darmaproject/darmasuite/dvm/core/state"
+	"github.com/darmaproject/darmasuite/dvm/core/vm"
+	"github.com/darmaproject/darmasuite/dvm/params"
+)
+
+func TestAccountRegistry(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	spendKey := common.HexToHash("0x1111111111111111111111111111111111111111111111111111111111111111")
+	viewKey := common.HexToHash("0x2222222222222222222222222222222222222222222222222222222222222222")
+	account := common.DarmaAddressToContractAddress(common.Address(spendKey))
+
+	if !vm.RegisterAccount(statedb, spendKey, viewKey) {
+		t.Fatal("expected first registration to be recorded")
+	}
+	if vm.RegisterAccount(statedb, spendKey, common.HexToHash("0x33")) {
+		t.Fatal("expected second registration to be ignored")
+	}
+	// the registry must survive the removal of empty accounts
+	statedb.Finalise(true)
+	if s, v, ok := vm.LookupAccount(statedb, account); !ok || s != spendKey || v != viewKey {
+		t.Fatalf("unexpected lookup %x %x %v", s, v, ok)
+	}
+	if _, _, ok := vm.LookupAccount(statedb, common.BytesToAddress([]byte("unknown"))); ok {
+		t.Fatal("expected unknown account to have no owner")
+	}
+
+	addressToString := func(b []byte) string {
+		s, v, ok := vm.LookupAccount(statedb, common.BytesToAddress(b))
+		if !ok {
+			return ""
+		}
+		return fmt.Sprintf("%x:%x", s, v)
+	}
+	call := func(chainConfig *params.ChainConfig) string {
+		cfg := &Config{
+			ChainConfig:       chainConfig,
+			State:             statedb,
+			AddressToStringFn: addressToString,
+		}
+		ret, _, err := Call(vm.AccountRegistryAddress, account[:], cfg)
+		if err != nil {
+			t.Fatal("didn't expect error", err)
+		}
+		return string(ret)
+	}
+
+	forked := &params.ChainConfig{ChainID: big.NewInt(1), AccountRegistryBlock: new(big.Int)}
+	if ret, want := call(forked), fmt.Sprintf("%x:%x", spendKey, viewKey); ret != want {
+		t.Errorf("expected owner %s, got %s", want, ret)
+	}
+	if ret := call(&params.ChainConfig{ChainID: big.NewInt(1)}); ret != "" {
+		t.Errorf("expected no precompile before the fork, got %s", ret)
+	}
+}
@@ -22,9 +22,9 @@ import (
 	"time"
 
 	"github.com/darmaproject/darmasuite/dvm/common"
+	"github.com/darmaproject/darmasuite/dvm/core/evm"
 	"github.com/darmaproject/darmasuite/dvm/core/rawdb"
 	"github.com/darmaproject/darmasuite/dvm/core/state"
-	"github.com/darmaproject/darmasuite/dvm/core/vm"
 	"github.com/darmaproject/darmasuite/dvm/crypto"
 	"github.com/darmaproject/darmasuite/dvm/params"
 )
@@ -42,30 +42,19 @@ type Config struct {
 	GasPrice    *big.Int
 	Value       *big.Int
 	Debug       bool
-	EVMConfig   vm.Config
+	EVMConfig   evm.Config
 
-	State     *state.StateDB
-	GetHashFn func(n uint64) common.Hash
+	State             *state.StateDB
+	GetHashFn         func(n uint64) common.Hash
+	PayoutAddressFn   func(string) bool
+	AddressToStringFn func([]byte) string
 }
 
 // sets defaults on the config
 func setDefaults(cfg *Config) {
 	if cfg.ChainConfig == nil {
 		cfg.ChainConfig = &params.ChainConfig{
-			ChainID:             big.NewInt(1),
-			HomesteadBlock:      new(big.Int),
-			DAOForkBlock:        new(big.Int),
-			DAOForkSupport:      false,
-			EIP150Block:         new(big.Int),
-			EIP150Hash:          common.Hash{},
-			EIP155Block:         new(big.Int),
-			EIP158Block:         new(big.Int),
-			ByzantiumBlock:      new(big.Int),
-			ConstantinopleBlock: new(big.Int),
-			PetersburgBlock:     new(big.Int),
-			IstanbulBlock:       new(big.Int),
-			MuirGlacierBlock:    new(big.Int),
-			YoloV1Block:         nil,
+			ChainID: big.NewInt(1),
 		}
 	}
 
@@ -111,7 +100,7 @@ func Execute(code, input []byte, cfg *Config) ([]byte, *state.StateDB, error) {
 	var (
 		address = common.BytesToAddress([]byte("contract"))
 		vmenv   = NewEnv(cfg)
-		sender  = vm.AccountRef(cfg.Origin)
+		sender  = evm.AccountRef(cfg.Origin)
 	)
 	cfg.State.CreateAccount(address)
 	// set the receiver's (the executing contract) code for execution.
@@ -140,7 +129,7 @@ func Create(input []byte, cfg *Config) ([]byte, common.Address, uint64, error) {
 	}
 	var (
 		vmenv  = NewEnv(cfg)
-		sender = vm.AccountRef(cfg.Origin)
+		sender = evm.AccountRef(cfg.Origin)
 	)
 
 	// Call the code with the given configuration.
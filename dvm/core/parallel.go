@@ -0,0 +1,150 @@
+// Copyright 2019 The darmasuite Authors
+// This file is part of the darmasuite library.
+//
+// The darmasuite library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The darmasuite library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the darmasuite library. If not, see <http://www.gnu.org/licenses/>.
+
+package dvm
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/darmaproject/darmasuite/dvm/common"
+	"github.com/darmaproject/darmasuite/dvm/core/state"
+	"github.com/darmaproject/darmasuite/dvm/core/vm/interface"
+)
+
+// ApplyTxFunc runs transaction i of a block on db. The error is the result
+// of the transaction, it does not stop the block.
+//
+// When run in parallel, ApplyTxFunc is called from several goroutines and
+// possibly more than once for a transaction. It may only change db. A
+// speculative run has to return an error if it depends on anything else that
+// the transactions before it could change, so that it is run again.
+type ApplyTxFunc func(db inter.StateDB, i int, speculative bool) error
+
+// CommitTxFunc is called in block order once transaction i is part of the
+// state, with the error its ApplyTxFunc returned.
+type CommitTxFunc func(i int, err error)
+
+// BlockRules are how the transactions of a block are separated from each
+// other.
+type BlockRules struct {
+	// Finalise finalises the state before the block and after every
+	// transaction: the refund counter is cleared, and the accounts destroyed
+	// or left empty are deleted. Without it they carry over to the
+	// transactions after it, like in blockchain.ApplyContract.
+	Finalise bool
+
+	// TxIndex gives every transaction its index in the block, otherwise
+	// they all have index 0, like in blockchain.ApplyContract.
+	TxIndex bool
+}
+
+func (rules BlockRules) txIndex(i int) int {
+	if rules.TxIndex {
+		return i
+	}
+	return 0
+}
+
+// ApplyTransactions runs the transactions of a block one after another on
+// statedb.
+func ApplyTransactions(statedb *state.StateDB, blockHash common.Hash, txHashes []common.Hash, rules BlockRules, apply ApplyTxFunc, commit CommitTxFunc) {
+	if rules.Finalise {
+		statedb.Finalise(true)
+	}
+	for i := range txHashes {
+		statedb.Prepare(txHashes[i], blockHash, rules.txIndex(i))
+		err := apply(statedb, i, false)
+		if rules.Finalise {
+			statedb.Finalise(true)
+		}
+		commit(i, err)
+	}
+}
+
+// speculation is a transaction run on a copy of the state at the start of
+// the block.
+type speculation struct {
+	db  *state.TrackingStateDB
+	err error
+}
+
+// ApplyTransactionsParallel runs the transactions of a block on copies of
+// statedb at the same time, using up to workers goroutines, or one per CPU if
+// workers is 0. The transactions are then committed to statedb in block
+// order. A transaction that read anything written by the transactions before
+// it, or that failed, is run again on statedb. The result is the same as
+// that of ApplyTransactions with the same rules.
+func ApplyTransactionsParallel(statedb *state.StateDB, blockHash common.Hash, txHashes []common.Hash, rules BlockRules, workers int, apply ApplyTxFunc, commit CommitTxFunc) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(txHashes) {
+		workers = len(txHashes)
+	}
+	if rules.Finalise {
+		statedb.Finalise(true)
+	}
+
+	// every worker has a copy of the state at the start of the block, made
+	// before anything is committed to statedb, and reverts it after each
+	// transaction. The changes are kept by the TrackingStateDB.
+	copies := make([]*state.StateDB, workers)
+	for w := range copies {
+		copies[w] = statedb.Copy()
+	}
+
+	specs := make([]speculation, len(txHashes))
+	var wg sync.WaitGroup
+	next := make(chan int)
+	for _, db := range copies {
+		wg.Add(1)
+		go func(db *state.StateDB) {
+			defer wg.Done()
+			for i := range next {
+				revid := db.Snapshot()
+				db.Prepare(txHashes[i], blockHash, rules.txIndex(i))
+				specs[i].db = state.NewTrackingStateDB(db)
+				specs[i].err = apply(specs[i].db, i, true)
+				db.RevertToSnapshot(revid)
+			}
+		}(db)
+	}
+	for i := range specs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	written := state.NewAccessSet()
+	for i := range specs {
+		statedb.Prepare(txHashes[i], blockHash, rules.txIndex(i))
+		db, err := specs[i].db, specs[i].err
+		if err != nil || db.Conflicts(written) {
+			db = state.NewTrackingStateDB(statedb)
+			err = apply(db, i, false)
+		} else {
+			db.Replay(statedb)
+		}
+		written.Merge(db.Writes())
+		if rules.Finalise {
+			statedb.Finalise(true)
+			// the next transaction starts with a cleared refund counter
+			written.ClearRefund()
+		}
+		commit(i, err)
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2019 The darmasuite Authors
+// This file is part of the darmasuite library.
+//
+// The darmasuite library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The darmasuite library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the darmasuite library. If not, see <http://www.gnu.org/licenses/>.
+
+package dvm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/darmaproject/darmasuite/dvm/accounts/abi"
+	"github.com/darmaproject/darmasuite/dvm/common"
+	"github.com/darmaproject/darmasuite/dvm/core/evm"
+	"github.com/darmaproject/darmasuite/dvm/core/rawdb"
+	"github.com/darmaproject/darmasuite/dvm/core/state"
+	"github.com/darmaproject/darmasuite/dvm/core/types"
+	"github.com/darmaproject/darmasuite/dvm/core/vm"
+	"github.com/darmaproject/darmasuite/dvm/core/vm/interface"
+	"github.com/darmaproject/darmasuite/dvm/core/wavm"
+	"github.com/darmaproject/darmasuite/dvm/params"
+)
+
+type testMessage struct {
+	from  common.Address
+	to    *common.Address
+	value *big.Int
+	data  []byte
+	wavm  bool // run by the WAVM instead of the EVM
+}
+
+func (m testMessage) From() common.Address { return m.from }
+func (m testMessage) To() *common.Address  { return m.to }
+func (m testMessage) GasPrice() *big.Int   { return big.NewInt(1) }
+func (m testMessage) Gas() uint64          { return 1000000 }
+func (m testMessage) Value() *big.Int      { return m.value }
+func (m testMessage) Nonce() uint64        { return 0 }
+func (m testMessage) CheckNonce() bool     { return false }
+func (m testMessage) Data() []byte         { return m.data }
+func (m testMessage) ToIsEmpty() bool      { return m.to == nil }
+
+// counterCode increments the storage slot given by the first word of the
+// call data, logs its new value with the slot as topic, and reverts if the
+// second word is not zero.
+var counterCode = []byte{
+	byte(evm.PUSH1), 0,
+	byte(evm.CALLDATALOAD),
+	byte(evm.DUP1),
+	byte(evm.SLOAD),
+	byte(evm.PUSH1), 1,
+	byte(evm.ADD),
+	byte(evm.DUP1),
+	byte(evm.PUSH1), 0,
+	byte(evm.MSTORE),
+	byte(evm.DUP2),
+	byte(evm.SSTORE),
+	byte(evm.PUSH1), 32,
+	byte(evm.PUSH1), 0,
+	byte(evm.LOG1),
+	byte(evm.PUSH1), 32,
+	byte(evm.CALLDATALOAD),
+	byte(evm.PUSH1), 26,
+	byte(evm.JUMPI),
+	byte(evm.STOP),
+	byte(evm.JUMPDEST),
+	byte(evm.PUSH1), 0,
+	byte(evm.PUSH1), 0,
+	byte(evm.REVERT),
+}
+
+// initCode creates a contract without code that has slot 0 set.
+var initCode = []byte{
+	byte(evm.PUSH1), 1,
+	byte(evm.PUSH1), 0,
+	byte(evm.SSTORE),
+	byte(evm.STOP),
+}
+
+var (
+	testSenders   = []common.Address{common.BytesToAddress([]byte("s1")), common.BytesToAddress([]byte("s2")), common.BytesToAddress([]byte("s3")), common.BytesToAddress([]byte("s4"))}
+	testContracts = []common.Address{common.BytesToAddress([]byte("c1")), common.BytesToAddress([]byte("c2")), common.BytesToAddress([]byte("c3"))}
+	testAccounts  = []common.Address{common.BytesToAddress([]byte("a1")), common.BytesToAddress([]byte("a2")), common.BytesToAddress([]byte("a3"))}
+)
+
+var (
+	testChainConfig = &params.ChainConfig{ChainID: big.NewInt(1)}
+	testHeader      = &types.Header{Number: big.NewInt(1), Difficulty: new(big.Int), GasLimit: 10000000}
+)
+
+// newTestVM returns the VM that runs msg on db.
+func newTestVM(msg testMessage, db inter.StateDB) vm.VM {
+	context := NewVMContext(msg, testHeader, msg.from, func(uint64) common.Hash { return common.Hash{} },
+		func(s string) []byte { return common.HexToAddress(s).Bytes() },
+		func(b []byte) string { return common.BytesToAddress(b).Hex() },
+		nil)
+	if msg.wavm {
+		return wavm.NewWAVM(context, db, testChainConfig, vm.Config{})
+	}
+	return GetVM(msg, context, db, testChainConfig, GetVMConfig())
+}
+
+func loadTestErc20(t testing.TB) ([]byte, abi.ABI) {
+	code, err := ioutil.ReadFile(filepath.Join("wavm", "tests", "erc20", "TokenERC20.compress"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	abiJSON, err := ioutil.ReadFile(filepath.Join("wavm", "tests", "erc20", "abi.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	abiobj, err := wavm.GetAbi(abiJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code, abiobj
+}
+
+// newTestState returns the state the test blocks run on, and the address of
+// a WAVM ERC20 token whose supply is held by the first sender.
+func newTestState(t testing.TB) (*state.StateDB, common.Address) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	for _, addr := range testSenders {
+		statedb.AddBalance(addr, big.NewInt(1e9))
+	}
+	for _, addr := range testContracts {
+		statedb.SetCode(addr, counterCode)
+		statedb.SetNonce(addr, 1)
+	}
+	statedb.AddBalance(testAccounts[0], big.NewInt(1))
+
+	code, abiobj := loadTestErc20(t)
+	ctor, err := abiobj.Pack("", big.NewInt(1000000), "token", "TKN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := testMessage{from: testSenders[0], value: new(big.Int), wavm: true}
+	_, token, _, err := newTestVM(create, statedb).Create(vm.AccountRef(create.from), append(code, ctor...), 10000000, create.value)
+	if err != nil {
+		t.Fatal("didn't expect error", err)
+	}
+	statedb.IntermediateRoot(true)
+	return statedb, token
+}
+
+// randomBlock generates txs that call the counter contracts on a few shared
+// slots, pay accounts, some of which do not exist yet, create contracts, and
+// transfer tokens, some of which the sender doesn't have.
+func randomBlock(t testing.TB, r *rand.Rand, n int, token common.Address) []testMessage {
+	_, abiobj := loadTestErc20(t)
+	msgs := make([]testMessage, n)
+	for i := range msgs {
+		msg := testMessage{from: testSenders[r.Intn(len(testSenders))], value: new(big.Int)}
+		switch r.Intn(7) {
+		case 0:
+			to := testAccounts[r.Intn(len(testAccounts))]
+			msg.to, msg.value = &to, big.NewInt(r.Int63n(3))
+		case 1:
+			msg.data = initCode
+		case 2:
+			if r.Intn(2) == 0 {
+				msg.from = testSenders[0]
+			}
+			input, err := abiobj.Pack("transfer", testSenders[r.Intn(len(testSenders))], big.NewInt(r.Int63n(10)+1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg.to, msg.data, msg.wavm = &token, input, true
+		default:
+			to := testContracts[r.Intn(len(testContracts))]
+			msg.to = &to
+			msg.data = make([]byte, 64)
+			msg.data[31] = byte(r.Intn(4))
+			if r.Intn(5) == 0 {
+				msg.data[63] = 1
+			}
+			if r.Intn(3) == 0 {
+				msg.value = big.NewInt(r.Int63n(5))
+			}
+		}
+		msgs[i] = msg
+	}
+	return msgs
+}
+
+type testReceipt struct {
+	Failed  bool
+	GasUsed uint64
+	TxIndex int
+	Logs    []types.Log
+}
+
+// executeBlock runs msgs on statedb under rules and returns the receipts. If
+// workers is 0 the txs are run the way blockchain.ApplyContract runs them,
+// if it is 1 by ApplyTransactions, and else by ApplyTransactionsParallel.
+func executeBlock(statedb *state.StateDB, msgs []testMessage, rules BlockRules, workers int) []testReceipt {
+	blockHash := common.HexToHash("0xb1")
+	txHashes := make([]common.Hash, len(msgs))
+	for i := range txHashes {
+		txHashes[i] = common.BytesToHash([]byte(fmt.Sprintf("tx%d", i)))
+	}
+
+	results := make([]testReceipt, len(msgs))
+	apply := func(db inter.StateDB, i int, speculative bool) error {
+		msg := msgs[i]
+		_, gasUsed, _, err := ApplyMessage(newTestVM(msg, db), msg, new(GasPool).AddGas(testHeader.GasLimit))
+		if err == nil {
+			remaining := new(big.Int).SetUint64(msg.Gas() - gasUsed)
+			db.AddBalance(msg.from, remaining.Mul(remaining, msg.GasPrice()))
+		}
+		results[i] = testReceipt{Failed: err != nil, GasUsed: gasUsed}
+		return err
+	}
+	commit := func(i int, err error) {
+		results[i].TxIndex = statedb.TxIndex()
+		for _, log := range statedb.GetLogs(txHashes[i]) {
+			results[i].Logs = append(results[i].Logs, *log)
+		}
+	}
+
+	switch workers {
+	case 0:
+		for i := range msgs {
+			statedb.Prepare(txHashes[i], blockHash, 0)
+			commit(i, apply(statedb, i, false))
+		}
+	case 1:
+		ApplyTransactions(statedb, blockHash, txHashes, rules, apply, commit)
+	default:
+		ApplyTransactionsParallel(statedb, blockHash, txHashes, rules, workers, apply, commit)
+	}
+	return results
+}
+
+// compareBlocks runs the blocks of 20 seeds with both workers and fails if
+// the states or the receipts differ.
+func compareBlocks(t *testing.T, rules BlockRules, wantWorkers, gotWorkers int) {
+	for seed := int64(0); seed < 20; seed++ {
+		wantState, token := newTestState(t)
+		msgs := randomBlock(t, rand.New(rand.NewSource(seed)), 50, token)
+		want := executeBlock(wantState, msgs, rules, wantWorkers)
+		gotState, _ := newTestState(t)
+		got := executeBlock(gotState, msgs, rules, gotWorkers)
+
+		if wantRoot, gotRoot := wantState.IntermediateRoot(true), gotState.IntermediateRoot(true); wantRoot != gotRoot {
+			t.Fatalf("seed %d: state root mismatch: %d workers %x, %d workers %x", seed, wantWorkers, wantRoot, gotWorkers, gotRoot)
+		}
+		for i := range want {
+			if !reflect.DeepEqual(want[i], got[i]) {
+				t.Fatalf("seed %d: receipt %d mismatch:\n%d workers %+v\n%d workers %+v", seed, i, wantWorkers, want[i], gotWorkers, got[i])
+			}
+		}
+	}
+}
+
+func TestApplyTransactionsParallel(t *testing.T) {
+	compareBlocks(t, BlockRules{Finalise: true, TxIndex: true}, 1, 4)
+}
+
+// The rules of blockchain.ApplyContract have to give the same state and
+// receipts as it does, whether the txs run one after another or in parallel.
+// The parallel run shares the WAVM module cache between the workers, run it
+// with -race.
+func TestApplyTransactionsLikeApplyContract(t *testing.T) {
+	var rules BlockRules
+	compareBlocks(t, rules, 0, 1)
+	compareBlocks(t, rules, 0, 4)
+}
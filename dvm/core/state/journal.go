@@ -124,6 +124,9 @@ type (
 	addLogChange struct {
 		txhash common.Hash
 	}
+	addPayoutChange struct {
+		txhash common.Hash
+	}
 	addPreimageChange struct {
 		hash common.Hash
 	}
@@ -227,6 +230,19 @@ func (ch addLogChange) dirtied() *common.Address {
 	return nil
 }
 
+func (ch addPayoutChange) revert(s *StateDB) {
+	payouts := s.payouts[ch.txhash]
+	if len(payouts) == 1 {
+		delete(s.payouts, ch.txhash)
+	} else {
+		s.payouts[ch.txhash] = payouts[:len(payouts)-1]
+	}
+}
+
+func (ch addPayoutChange) dirtied() *common.Address {
+	return nil
+}
+
 func (ch addPreimageChange) revert(s *StateDB) {
 	delete(s.preimages, ch.hash)
 }
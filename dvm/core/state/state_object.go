@@ -195,6 +195,8 @@ func (s *stateObject) GetCommittedState(db Database, key common.Hash) common.Has
 	if value, cached := s.originStorage[key]; cached {
 		return value
 	}
+	storageReadMeter.Mark(1)
+
 	// If no live objects are available, attempt to use snapshots
 	var (
 		enc []byte
@@ -321,6 +323,7 @@ func (s *stateObject) updateTrie(db Database) Trie {
 			continue
 		}
 		s.originStorage[key] = value
+		storageWriteMeter.Mark(1)
 
 		var v []byte
 		if (value == common.Hash{}) {
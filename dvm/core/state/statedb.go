@@ -45,6 +45,14 @@ var (
 	emptyRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
 )
 
+var (
+	accountReadMeter  = metrics.NewRegisteredMeter("state/account/reads", nil)
+	accountWriteMeter = metrics.NewRegisteredMeter("state/account/writes", nil)
+	storageReadMeter  = metrics.NewRegisteredMeter("state/storage/reads", nil)
+	storageWriteMeter = metrics.NewRegisteredMeter("state/storage/writes", nil)
+	commitTimer       = metrics.NewRegisteredTimer("state/commit", nil)
+)
+
 type proofList [][]byte
 
 func (n *proofList) Put(key []byte, value []byte) error {
@@ -90,6 +98,7 @@ type StateDB struct {
 	txIndex      int
 	logs         map[common.Hash][]*types.Log
 	logSize      uint
+	payouts      map[common.Hash][]*types.Payout
 
 	preimages map[common.Hash][]byte
 
@@ -127,6 +136,7 @@ func New(root common.Hash, db Database, snaps *snapshot.Tree) (*StateDB, error)
 		stateObjectsPending: make(map[common.Address]struct{}),
 		stateObjectsDirty:   make(map[common.Address]struct{}),
 		logs:                make(map[common.Hash][]*types.Log),
+		payouts:             make(map[common.Hash][]*types.Payout),
 		preimages:           make(map[common.Hash][]byte),
 		journal:             newJournal(),
 	}
@@ -167,6 +177,7 @@ func (s *StateDB) Reset(root common.Hash) error {
 	s.txIndex = 0
 	s.logs = make(map[common.Hash][]*types.Log)
 	s.logSize = 0
+	s.payouts = make(map[common.Hash][]*types.Payout)
 	s.preimages = make(map[common.Hash][]byte)
 	s.clearJournalAndRefund()
 
@@ -204,6 +215,20 @@ func (s *StateDB) Logs() []*types.Log {
 	return logs
 }
 
+// AddPayout queues a payout of the current transaction.
+func (s *StateDB) AddPayout(payout *types.Payout) {
+	s.journal.append(addPayoutChange{txhash: s.thash})
+
+	payout.TxHash = s.thash
+	payout.Index = uint(len(s.payouts[s.thash]))
+	s.payouts[s.thash] = append(s.payouts[s.thash], payout)
+}
+
+// GetPayouts returns the payouts queued by the transaction with the given hash.
+func (s *StateDB) GetPayouts(hash common.Hash) []*types.Payout {
+	return s.payouts[hash]
+}
+
 // AddPreimage records a SHA3 preimage seen by the VM.
 func (s *StateDB) AddPreimage(hash common.Hash, preimage []byte) {
 	if _, ok := s.preimages[hash]; !ok {
@@ -449,6 +474,8 @@ func (s *StateDB) updateStateObject(obj *stateObject) {
 	if metrics.EnabledExpensive {
 		defer func(start time.Time) { s.AccountUpdates += time.Since(start) }(time.Now())
 	}
+	accountWriteMeter.Mark(1)
+
 	// Encode the account and update the account trie
 	addr := obj.Address()
 
@@ -475,6 +502,8 @@ func (s *StateDB) deleteStateObject(obj *stateObject) {
 	if metrics.EnabledExpensive {
 		defer func(start time.Time) { s.AccountUpdates += time.Since(start) }(time.Now())
 	}
+	accountWriteMeter.Mark(1)
+
 	// Delete the account from the trie
 	addr := obj.Address()
 	if err := s.trie.TryDelete(addr[:]); err != nil {
@@ -501,6 +530,8 @@ func (s *StateDB) getDeletedStateObject(addr common.Address) *stateObject {
 	if obj := s.stateObjects[addr]; obj != nil {
 		return obj
 	}
+	accountReadMeter.Mark(1)
+
 	// If no live objects are available, attempt to use snapshots
 	var (
 		data *Account
@@ -652,6 +683,7 @@ func (s *StateDB) Copy() *StateDB {
 		refund:              s.refund,
 		logs:                make(map[common.Hash][]*types.Log, len(s.logs)),
 		logSize:             s.logSize,
+		payouts:             make(map[common.Hash][]*types.Payout, len(s.payouts)),
 		preimages:           make(map[common.Hash][]byte, len(s.preimages)),
 		journal:             newJournal(),
 	}
@@ -694,6 +726,14 @@ func (s *StateDB) Copy() *StateDB {
 		}
 		state.logs[hash] = cpy
 	}
+	for hash, payouts := range s.payouts {
+		cpy := make([]*types.Payout, len(payouts))
+		for i, p := range payouts {
+			cpy[i] = new(types.Payout)
+			*cpy[i] = *p
+		}
+		state.payouts[hash] = cpy
+	}
 	for hash, preimage := range s.preimages {
 		state.preimages[hash] = preimage
 	}
@@ -813,6 +853,8 @@ func (s *StateDB) Commit(deleteEmptyObjects bool) (common.Hash, error) {
 	if s.dbErr != nil {
 		return common.Hash{}, fmt.Errorf("commit aborted due to earlier error: %v", s.dbErr)
 	}
+	defer commitTimer.UpdateSince(time.Now())
+
 	// Finalize any pending changes and merge everything into the tries
 	s.IntermediateRoot(deleteEmptyObjects)
 
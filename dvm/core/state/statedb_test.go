@@ -319,6 +319,13 @@ func newTestAction(addr common.Address, r *rand.Rand) testAction {
 			},
 			args: make([]int64, 1),
 		},
+		{
+			name: "AddPayout",
+			fn: func(a testAction, s *StateDB) {
+				s.AddPayout(&types.Payout{Contract: addr, Address: "payee", Amount: uint64(a.args[0])})
+			},
+			args: make([]int64, 1),
+		},
 		{
 			name: "AddPreimage",
 			fn: func(a testAction, s *StateDB) {
@@ -453,6 +460,10 @@ func (test *snapshotTest) checkEqual(state, checkstate *StateDB) error {
 		return fmt.Errorf("got GetLogs(common.Hash{}) == %v, want GetLogs(common.Hash{}) == %v",
 			state.GetLogs(common.Hash{}), checkstate.GetLogs(common.Hash{}))
 	}
+	if !reflect.DeepEqual(state.GetPayouts(common.Hash{}), checkstate.GetPayouts(common.Hash{})) {
+		return fmt.Errorf("got GetPayouts(common.Hash{}) == %v, want GetPayouts(common.Hash{}) == %v",
+			state.GetPayouts(common.Hash{}), checkstate.GetPayouts(common.Hash{}))
+	}
 	return nil
 }
 
@@ -0,0 +1,289 @@
+// Copyright 2019 The darmasuite Authors
+// This file is part of the darmasuite library.
+//
+// The darmasuite library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The darmasuite library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the darmasuite library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"math/big"
+
+	"github.com/darmaproject/darmasuite/dvm/common"
+	"github.com/darmaproject/darmasuite/dvm/core/types"
+)
+
+type accessKind uint8
+
+const (
+	accessAccount    accessKind = iota // balance, nonce, code, existence or suicide flag
+	accessStorage                      // a single storage slot
+	accessAnyStorage                   // any storage slot of the account
+	accessReset                        // the storage of the account is cleared
+	accessRefund                       // the refund counter
+)
+
+type access struct {
+	kind accessKind
+	addr common.Address
+	key  common.Hash
+}
+
+// AccessSet is a set of state locations.
+type AccessSet map[access]struct{}
+
+// NewAccessSet returns an empty AccessSet.
+func NewAccessSet() AccessSet {
+	return make(AccessSet)
+}
+
+func (set AccessSet) add(kind accessKind, addr common.Address, key common.Hash) {
+	set[access{kind, addr, key}] = struct{}{}
+}
+
+func (set AccessSet) has(kind accessKind, addr common.Address, key common.Hash) bool {
+	_, ok := set[access{kind, addr, key}]
+	return ok
+}
+
+// ClearRefund removes the refund counter from set, for a state whose
+// refund counter was cleared since.
+func (set AccessSet) ClearRefund() {
+	delete(set, access{kind: accessRefund})
+}
+
+// Merge adds the locations of other to set.
+func (set AccessSet) Merge(other AccessSet) {
+	for a := range other {
+		set[a] = struct{}{}
+	}
+}
+
+// stateOp is a change made through a TrackingStateDB. revisions maps the
+// snapshot ids of the tracked database to those of the one replayed on.
+type stateOp func(db *StateDB, revisions map[int]int)
+
+// TrackingStateDB runs a transaction on a StateDB and records the locations
+// it reads and writes, together with its changes in order. A transaction run
+// speculatively on a copy of the state can then be checked against what the
+// transactions before it wrote, and its changes replayed on the real state.
+// Replaying gives the same result as running the transaction there, as long
+// as none of the locations it read have changed.
+type TrackingStateDB struct {
+	db     *StateDB
+	reads  AccessSet
+	writes AccessSet
+	ops    []stateOp
+}
+
+// NewTrackingStateDB tracks the accesses made to db.
+func NewTrackingStateDB(db *StateDB) *TrackingStateDB {
+	return &TrackingStateDB{
+		db:     db,
+		reads:  NewAccessSet(),
+		writes: NewAccessSet(),
+	}
+}
+
+// Reads returns the locations read so far.
+func (t *TrackingStateDB) Reads() AccessSet { return t.reads }
+
+// Writes returns the locations written so far.
+func (t *TrackingStateDB) Writes() AccessSet { return t.writes }
+
+// Conflicts reports whether any location read so far is in written.
+func (t *TrackingStateDB) Conflicts(written AccessSet) bool {
+	for a := range t.reads {
+		switch a.kind {
+		case accessAccount:
+			if written.has(accessAccount, a.addr, common.Hash{}) {
+				return true
+			}
+		case accessStorage:
+			if written.has(accessStorage, a.addr, a.key) || written.has(accessReset, a.addr, common.Hash{}) {
+				return true
+			}
+		case accessAnyStorage:
+			if written.has(accessAnyStorage, a.addr, common.Hash{}) || written.has(accessReset, a.addr, common.Hash{}) {
+				return true
+			}
+		case accessRefund:
+			if written.has(accessRefund, common.Address{}, common.Hash{}) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Replay applies the changes made so far to db.
+func (t *TrackingStateDB) Replay(db *StateDB) {
+	revisions := make(map[int]int)
+	for _, op := range t.ops {
+		op(db, revisions)
+	}
+}
+
+func (t *TrackingStateDB) readAccount(addr common.Address) {
+	t.reads.add(accessAccount, addr, common.Hash{})
+}
+
+func (t *TrackingStateDB) writeAccount(addr common.Address, op stateOp) {
+	t.writes.add(accessAccount, addr, common.Hash{})
+	t.ops = append(t.ops, op)
+}
+
+func (t *TrackingStateDB) CreateAccount(addr common.Address) {
+	t.db.CreateAccount(addr)
+	t.writes.add(accessReset, addr, common.Hash{})
+	t.writeAccount(addr, func(db *StateDB, _ map[int]int) { db.CreateAccount(addr) })
+}
+
+func (t *TrackingStateDB) SubBalance(addr common.Address, amount *big.Int) {
+	t.db.SubBalance(addr, amount)
+	amount = new(big.Int).Set(amount)
+	t.writeAccount(addr, func(db *StateDB, _ map[int]int) { db.SubBalance(addr, amount) })
+}
+
+func (t *TrackingStateDB) AddBalance(addr common.Address, amount *big.Int) {
+	t.db.AddBalance(addr, amount)
+	amount = new(big.Int).Set(amount)
+	t.writeAccount(addr, func(db *StateDB, _ map[int]int) { db.AddBalance(addr, amount) })
+}
+
+func (t *TrackingStateDB) GetBalance(addr common.Address) *big.Int {
+	t.readAccount(addr)
+	return t.db.GetBalance(addr)
+}
+
+func (t *TrackingStateDB) GetNonce(addr common.Address) uint64 {
+	t.readAccount(addr)
+	return t.db.GetNonce(addr)
+}
+
+func (t *TrackingStateDB) SetNonce(addr common.Address, nonce uint64) {
+	t.db.SetNonce(addr, nonce)
+	t.writeAccount(addr, func(db *StateDB, _ map[int]int) { db.SetNonce(addr, nonce) })
+}
+
+func (t *TrackingStateDB) GetCodeHash(addr common.Address) common.Hash {
+	t.readAccount(addr)
+	return t.db.GetCodeHash(addr)
+}
+
+func (t *TrackingStateDB) GetCode(addr common.Address) []byte {
+	t.readAccount(addr)
+	return t.db.GetCode(addr)
+}
+
+func (t *TrackingStateDB) SetCode(addr common.Address, code []byte) {
+	t.db.SetCode(addr, code)
+	code = common.CopyBytes(code)
+	t.writeAccount(addr, func(db *StateDB, _ map[int]int) { db.SetCode(addr, code) })
+}
+
+func (t *TrackingStateDB) GetCodeSize(addr common.Address) int {
+	t.readAccount(addr)
+	return t.db.GetCodeSize(addr)
+}
+
+// The refund counter is a location, unless the state is finalised between
+// transactions, which clears it.
+
+func (t *TrackingStateDB) AddRefund(gas uint64) {
+	t.db.AddRefund(gas)
+	t.writes.add(accessRefund, common.Address{}, common.Hash{})
+	t.ops = append(t.ops, func(db *StateDB, _ map[int]int) { db.AddRefund(gas) })
+}
+
+func (t *TrackingStateDB) SubRefund(gas uint64) {
+	t.db.SubRefund(gas)
+	t.writes.add(accessRefund, common.Address{}, common.Hash{})
+	t.ops = append(t.ops, func(db *StateDB, _ map[int]int) { db.SubRefund(gas) })
+}
+
+func (t *TrackingStateDB) GetRefund() uint64 {
+	t.reads.add(accessRefund, common.Address{}, common.Hash{})
+	return t.db.GetRefund()
+}
+
+func (t *TrackingStateDB) GetCommittedState(addr common.Address, key common.Hash) common.Hash {
+	t.reads.add(accessStorage, addr, key)
+	return t.db.GetCommittedState(addr, key)
+}
+
+func (t *TrackingStateDB) GetState(addr common.Address, key common.Hash) common.Hash {
+	t.reads.add(accessStorage, addr, key)
+	return t.db.GetState(addr, key)
+}
+
+func (t *TrackingStateDB) SetState(addr common.Address, key, value common.Hash) {
+	t.db.SetState(addr, key, value)
+	t.writes.add(accessStorage, addr, key)
+	t.writes.add(accessAnyStorage, addr, common.Hash{})
+	t.ops = append(t.ops, func(db *StateDB, _ map[int]int) { db.SetState(addr, key, value) })
+}
+
+func (t *TrackingStateDB) Suicide(addr common.Address) bool {
+	suicided := t.db.Suicide(addr)
+	t.readAccount(addr)
+	t.writes.add(accessReset, addr, common.Hash{})
+	t.writeAccount(addr, func(db *StateDB, _ map[int]int) { db.Suicide(addr) })
+	return suicided
+}
+
+func (t *TrackingStateDB) HasSuicided(addr common.Address) bool {
+	t.readAccount(addr)
+	return t.db.HasSuicided(addr)
+}
+
+func (t *TrackingStateDB) Exist(addr common.Address) bool {
+	t.readAccount(addr)
+	return t.db.Exist(addr)
+}
+
+func (t *TrackingStateDB) Empty(addr common.Address) bool {
+	t.readAccount(addr)
+	return t.db.Empty(addr)
+}
+
+func (t *TrackingStateDB) RevertToSnapshot(revid int) {
+	t.db.RevertToSnapshot(revid)
+	t.ops = append(t.ops, func(db *StateDB, revisions map[int]int) { db.RevertToSnapshot(revisions[revid]) })
+}
+
+func (t *TrackingStateDB) Snapshot() int {
+	revid := t.db.Snapshot()
+	t.ops = append(t.ops, func(db *StateDB, revisions map[int]int) { revisions[revid] = db.Snapshot() })
+	return revid
+}
+
+func (t *TrackingStateDB) AddLog(log *types.Log) {
+	t.db.AddLog(log)
+	t.ops = append(t.ops, func(db *StateDB, _ map[int]int) { db.AddLog(log) })
+}
+
+func (t *TrackingStateDB) AddPayout(payout *types.Payout) {
+	t.db.AddPayout(payout)
+	t.ops = append(t.ops, func(db *StateDB, _ map[int]int) { db.AddPayout(payout) })
+}
+
+func (t *TrackingStateDB) AddPreimage(hash common.Hash, preimage []byte) {
+	t.db.AddPreimage(hash, preimage)
+	t.ops = append(t.ops, func(db *StateDB, _ map[int]int) { db.AddPreimage(hash, preimage) })
+}
+
+func (t *TrackingStateDB) ForEachStorage(addr common.Address, cb func(key, value common.Hash) bool) error {
+	t.reads.add(accessAnyStorage, addr, common.Hash{})
+	return t.db.ForEachStorage(addr, cb)
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/romana/rlog"
 	"math"
 	"math/big"
+	"time"
 )
 
 var (
@@ -118,6 +119,7 @@ func NewStateTransition(vm vm.VM, msg Message, gp *GasPool) *StateTransition {
 // state and would never be accepted within a block.
 func ApplyMessage(vm vm.VM, msg Message, gp *GasPool) ([]byte, uint64, []byte, error) {
 	rlog.Info("---ApplyMessage---")
+	defer execTimer(vm).UpdateSince(time.Now())
 	return NewStateTransition(vm, msg, gp).TransitionDb()
 }
 
@@ -218,7 +220,8 @@ func (st *StateTransition) TransitionDb() (ret []byte, usedGas uint64, contactAd
 		// sufficient balance to make the transfer happen. The first
 		// balance transfer may never fail.
 		// fixme: check if err is vm.ErrInsufficientBalance ???
-		return nil, 0, nil, vmerr
+		// ret holds the revert data, if any.
+		return ret, 0, nil, vmerr
 	}
 
 	st.refundGas()
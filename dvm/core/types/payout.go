@@ -0,0 +1,39 @@
+// Copyright 2019 The darmasuite Authors
+// This file is part of the darmasuite library.
+//
+// The darmasuite library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The darmasuite library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the darmasuite library. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import "github.com/darmaproject/darmasuite/dvm/common"
+
+// Payout is a transfer of funds from a contract account to a Darma address
+// outside the VM. The amount has already been taken from the contract's
+// balance when the payout is queued; the chain turns the payouts of a
+// successful contract transaction into outputs of that transaction.
+type Payout struct {
+	// Consensus fields:
+	// contract account the funds were taken from
+	Contract common.Address
+	// Darma address being paid
+	Address string
+	// amount in atomic units
+	Amount uint64
+
+	// Derived fields. These fields are filled in by the state database.
+	// hash of the transaction
+	TxHash common.Hash
+	// index of the payout in the transaction
+	Index uint
+}
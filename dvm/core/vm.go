@@ -20,6 +20,7 @@ import (
 	"github.com/darmaproject/darmasuite/dvm/core/evm"
 	"github.com/darmaproject/darmasuite/dvm/core/types"
 	"github.com/darmaproject/darmasuite/dvm/core/wavm"
+	"github.com/darmaproject/darmasuite/dvm/metrics"
 	"github.com/darmaproject/darmasuite/dvm/params"
 	"math/big"
 
@@ -28,14 +29,29 @@ import (
 	"github.com/darmaproject/darmasuite/dvm/core/vm/interface"
 )
 
+var (
+	evmExecTimer  = metrics.NewRegisteredTimer("dvm/evm/exec", nil)
+	wavmExecTimer = metrics.NewRegisteredTimer("dvm/wavm/exec", nil)
+)
+
+// execTimer returns the timer measuring the messages run by the engine of vm.
+func execTimer(vm vm.VM) metrics.Timer {
+	if _, ok := vm.(*wavm.WAVM); ok {
+		return wavmExecTimer
+	}
+	return evmExecTimer
+}
+
 // NewVMContext creates a new context for use in the VM.
-func NewVMContext(msg Message, header *types.Header, origin common.Address, hashfunc vm.GetHashFunc, strToAddrFunc vm.StringToAddress, addrToStrFunc vm.AddressToString) vm.Context {
+func NewVMContext(msg Message, header *types.Header, origin common.Address, hashfunc vm.GetHashFunc, strToAddrFunc vm.StringToAddress, addrToStrFunc vm.AddressToString, payoutAddrFunc vm.PayoutAddressFunc) vm.Context {
 	// Can't get miner's address
 	beneficiary := msg.From()
 
 	return vm.Context{
 		CanTransferFunc: CanTransfer,
 		TransferFunc:    Transfer,
+		TransferExFunc:  TransferEx,
+		PayoutAddress:   payoutAddrFunc,
 		GetHash:         hashfunc,
 		StringToAddress: strToAddrFunc,
 		AddressToString: addrToStrFunc,
@@ -61,6 +77,13 @@ func Transfer(db inter.StateDB, sender, recipient common.Address, amount *big.In
 	db.AddBalance(recipient, amount)
 }
 
+// TransferEx subtracts amount from sender and queues a payout of it to the
+// Darma address recipient.
+func TransferEx(db inter.StateDB, sender common.Address, recipient string, amount *big.Int) {
+	db.SubBalance(sender, amount)
+	db.AddPayout(&types.Payout{Contract: sender, Address: recipient, Amount: amount.Uint64()})
+}
+
 func GetVM(msg Message, ctx vm.Context, statedb inter.StateDB, chainConfig *params.ChainConfig, vmConfig vm.Config) vm.VM {
 	if chainConfig.IsEVM(ctx.BlockNumber){
 		//Fixme:
@@ -30,4 +30,7 @@ var (
 	ErrExecutionAssert          = errors.New("wavm: execution assert")
 	ErrMagicNumberMismatch      = errors.New("magic number mismatch")
 	ErrMainnetActive            = errors.New("only support election transaction in main net startup")
+	ErrInvalidPayoutAddress     = errors.New("invalid payout address")
+	ErrInvalidPayoutAmount      = errors.New("invalid payout amount")
+	ErrExecutionAborted         = errors.New("execution aborted")
 )
@@ -0,0 +1,69 @@
+// Copyright 2019 The darmasuite Authors
+// This file is part of the darmasuite library.
+//
+// The darmasuite library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The darmasuite library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the darmasuite library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"github.com/darmaproject/darmasuite/dvm/common"
+	"github.com/darmaproject/darmasuite/dvm/core/vm/interface"
+)
+
+// AccountRegistryAddress holds the registry of the Darma addresses behind
+// contract accounts in its storage. A contract account only keeps the last 20
+// bytes of the owner's public spend key, the registry keeps both public keys
+// so the full address can be given back. The EVM serves lookups from a
+// precompile at this address.
+var AccountRegistryAddress = common.BytesToAddress([]byte{1, 1})
+
+// registrySlots returns the storage slots of the public spend and view key
+// registered for account. Accounts have their first 12 bytes zeroed, which
+// leaves room to tell the two slots apart.
+func registrySlots(account common.Address) (spendSlot, viewSlot common.Hash) {
+	spendSlot = common.Hash(account)
+	viewSlot = common.Hash(account)
+	viewSlot[0] = 1
+	return
+}
+
+// RegisterAccount records spendKey and viewKey as the owner of the contract
+// account of spendKey. The first registration of an account is kept, it
+// returns whether this one was recorded.
+func RegisterAccount(db inter.StateDB, spendKey, viewKey common.Hash) bool {
+	account := common.DarmaAddressToContractAddress(common.Address(spendKey))
+	spendSlot, viewSlot := registrySlots(account)
+	if db.GetState(AccountRegistryAddress, spendSlot) != (common.Hash{}) {
+		return false
+	}
+
+	// a nonce keeps the registry from being removed as an empty account
+	if db.GetNonce(AccountRegistryAddress) == 0 {
+		db.SetNonce(AccountRegistryAddress, 1)
+	}
+	db.SetState(AccountRegistryAddress, spendSlot, spendKey)
+	db.SetState(AccountRegistryAddress, viewSlot, viewKey)
+	return true
+}
+
+// LookupAccount returns the public spend and view key registered for account.
+func LookupAccount(db inter.StateDB, account common.Address) (spendKey, viewKey common.Hash, ok bool) {
+	account = common.DarmaAddressToContractAddress(account)
+	spendSlot, viewSlot := registrySlots(account)
+	spendKey = db.GetState(AccountRegistryAddress, spendSlot)
+	if spendKey == (common.Hash{}) {
+		return common.Hash{}, common.Hash{}, false
+	}
+	return spendKey, db.GetState(AccountRegistryAddress, viewSlot), true
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 The darmasuite Authors
+// This file is part of the darmasuite library.
+//
+// The darmasuite library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The darmasuite library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the darmasuite library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+)
+
+// revertSelector is the selector of the Solidity Error(string) revert payload,
+// the first 4 bytes of keccak256("Error(string)").
+var revertSelector = []byte{0x08, 0xc3, 0x79, 0xa0}
+
+// EncodeRevertReason packs reason the same way Solidity's revert(string) does,
+// so that callers can decode WAVM and EVM revert data alike.
+func EncodeRevertReason(reason []byte) []byte {
+	data := make([]byte, 4+64+(len(reason)+31)/32*32)
+	copy(data, revertSelector)
+	binary.BigEndian.PutUint64(data[4+24:], 32)
+	binary.BigEndian.PutUint64(data[4+56:], uint64(len(reason)))
+	copy(data[4+64:], reason)
+	return data
+}
+
+// UnpackRevertReason returns the reason carried by an Error(string) revert
+// payload. It returns false if data is not such a payload.
+func UnpackRevertReason(data []byte) (string, bool) {
+	if len(data) < 4+64 || !bytes.Equal(data[:4], revertSelector) {
+		return "", false
+	}
+	data = data[4:]
+	offset := new(big.Int).SetBytes(data[:32])
+	if !offset.IsUint64() || offset.Uint64() > uint64(len(data)-32) {
+		return "", false
+	}
+	start := offset.Uint64() + 32
+	size := new(big.Int).SetBytes(data[start-32 : start])
+	if !size.IsUint64() || size.Uint64() > uint64(len(data))-start {
+		return "", false
+	}
+	return string(data[start : start+size.Uint64()]), true
+}
@@ -39,7 +39,10 @@ var emptyCodeHash = crypto.Keccak256Hash(nil)
 type (
 	CanTransferFunc func(inter.StateDB, common.Address, *big.Int) bool
 	TransferFunc    func(inter.StateDB, common.Address, common.Address, *big.Int)
-	TransferExFunc  func(string, *big.Int)
+	// TransferExFunc pays from a contract account to a Darma address outside the VM
+	TransferExFunc func(inter.StateDB, common.Address, string, *big.Int)
+	// PayoutAddressFunc reports whether a Darma address can be paid by contracts
+	PayoutAddressFunc func(string) bool
 	// GetHashFunc returns the nth block hash in the blockchain
 	GetHashFunc     func(uint64) common.Hash
 	StringToAddress func(string) []byte
@@ -52,6 +55,11 @@ type Context struct {
 	CanTransferFunc CanTransferFunc
 	// Transfer transfers ether from one account to the other
 	TransferFunc TransferFunc
+	// TransferEx pays from a contract account to a Darma address
+	TransferExFunc TransferExFunc
+	// PayoutAddress reports whether TransferEx may pay an address, contracts
+	// can't pay out if it is nil
+	PayoutAddress PayoutAddressFunc
 	// GetHash returns the hash corresponding to n
 	GetHash         GetHashFunc
 	StringToAddress StringToAddress
@@ -81,8 +89,28 @@ func (ctx *Context) Transfer(db inter.StateDB, sender, recipient common.Address,
 	return true
 }
 
+// TransferEx pays amount from the sender contract account to recipient, a
+// Darma address outside the VM. The amount leaves the VM when the chain
+// turns the payout into an output of the transaction.
+func (ctx *Context) TransferEx(db inter.StateDB, sender common.Address, recipient string, amount *big.Int) error {
+	if ctx.PayoutAddress == nil || ctx.TransferExFunc == nil || !ctx.PayoutAddress(recipient) {
+		return ErrInvalidPayoutAddress
+	}
+	if amount.Sign() <= 0 || !amount.IsUint64() {
+		return ErrInvalidPayoutAmount
+	}
+	if !ctx.CanTransfer(db, sender, amount) {
+		return ErrInsufficientBalance
+	}
+	rlog.Infof("vm.Context.TransferEx %d from %x to %s", amount, sender, recipient)
+
+	ctx.TransferExFunc(db, sender, recipient, amount)
+	return nil
+}
+
 type VM interface {
 	Cancel()
+	Cancelled() bool
 	Create(caller ContractRef, code []byte, gas uint64, value *big.Int) (ret []byte, contractAddr common.Address, leftOverGas uint64, err error)
 	Call(caller ContractRef, addr common.Address, input []byte, gas uint64, value *big.Int) (ret []byte, leftOverGas uint64, err error)
 	CallCode(caller ContractRef, addr common.Address, input []byte, gas uint64, value *big.Int) (ret []byte, leftOverGas uint64, err error)
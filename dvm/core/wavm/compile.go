@@ -41,6 +41,9 @@ package wavm
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
 
 	"github.com/darmaproject/darma-wasm/darma"
 	"github.com/darmaproject/darma-wasm/disasm"
@@ -49,6 +52,7 @@ import (
 	"github.com/darmaproject/darmasuite/dvm/core/wavm/gas"
 	"github.com/darmaproject/darmasuite/dvm/core/wavm/utils"
 	"github.com/darmaproject/darmasuite/dvm/log"
+	"github.com/darmaproject/darmasuite/dvm/params"
 )
 
 // A small note on the usage of discard instructions:
@@ -188,7 +192,185 @@ func (cb *CodeBlock) buildCode(blockDepth int, n int) *Code {
 	return code
 }
 
+// growCharge is the gas charge compiled in front of every memory.grow. It
+// takes the number of pages to grow from the top of the stack, leaves it
+// there and calls AddGas with
+//
+//     delta*linear + (new*new-cur*cur)*quad
+//
+// where cur and new are the memory size in pages before and after growing.
+// Growing past maxPages charges math.MaxUint64, which aborts the execution
+// before the memory is allocated.
+type growCharge struct {
+	gasIndex int    // function index of the AddGas import
+	scratch  uint32 // index of the first of the two locals the charge uses
+	maxPages uint64
+	linear   uint64 // params.WAVMGasSchedule.GrowMem
+	quad     uint64 // params.WAVMGasSchedule.GrowMemQuad
+}
+
+// growChargeDepth is the operand stack height growCharge needs on top of
+// the page count.
+const growChargeDepth = 4
+
+func (g *growCharge) instrs() []disasm.Instr {
+	delta, pages := g.scratch, g.scratch+1
+	return []disasm.Instr{
+		growOp(ops.TeeLocal, delta),
+		growOp(ops.GetLocal, delta),
+		growOp(ops.I64ExtendUI32),
+		growOp(ops.CurrentMemory, uint8(0)),
+		growOp(ops.I64ExtendUI32),
+		growOp(ops.I64Add),
+		growOp(ops.SetLocal, pages),
+		// quadratic part
+		growOp(ops.GetLocal, pages),
+		growOp(ops.GetLocal, pages),
+		growOp(ops.I64Mul),
+		growOp(ops.CurrentMemory, uint8(0)),
+		growOp(ops.I64ExtendUI32),
+		growOp(ops.CurrentMemory, uint8(0)),
+		growOp(ops.I64ExtendUI32),
+		growOp(ops.I64Mul),
+		growOp(ops.I64Sub),
+		growOp(ops.I64Const, int64(g.quad)),
+		growOp(ops.I64Mul),
+		// linear part
+		growOp(ops.GetLocal, delta),
+		growOp(ops.I64ExtendUI32),
+		growOp(ops.I64Const, int64(g.linear)),
+		growOp(ops.I64Mul),
+		growOp(ops.I64Add),
+		// select(cost, MaxUint64, pages <= maxPages)
+		growOp(ops.I64Const, int64(-1)),
+		growOp(ops.GetLocal, pages),
+		growOp(ops.I64Const, int64(g.maxPages)),
+		growOp(ops.I64LeU),
+		growOp(ops.Select),
+		growOp(ops.Call, uint32(g.gasIndex)),
+	}
+}
+
+// callDepthCharge is compiled around every call of a function of the module,
+// as the interpreter doesn't bound how deep the functions of a contract call
+// each other. It counts the nesting in a global added to the module and calls
+// AddGas with math.MaxUint64, which aborts the execution, when it goes past
+// maxDepth.
+type callDepthCharge struct {
+	gasIndex int    // function index of the AddGas import
+	global   uint32 // index of the global from addCallDepthGlobal
+	maxDepth uint32
+}
+
+// callDepthChargeDepth is the operand stack height callDepthCharge needs on
+// top of the arguments or the results of a call.
+const callDepthChargeDepth = 4
+
+func (c *callDepthCharge) enter() []disasm.Instr {
+	return []disasm.Instr{
+		growOp(ops.GetGlobal, c.global),
+		growOp(ops.I32Const, int32(1)),
+		growOp(ops.I32Add),
+		growOp(ops.SetGlobal, c.global),
+		// select(MaxUint64, 0, depth > maxDepth)
+		growOp(ops.I64Const, int64(-1)),
+		growOp(ops.I64Const, int64(0)),
+		growOp(ops.GetGlobal, c.global),
+		growOp(ops.I32Const, int32(c.maxDepth)),
+		growOp(ops.I32GtU),
+		growOp(ops.Select),
+		growOp(ops.Call, uint32(c.gasIndex)),
+	}
+}
+
+func (c *callDepthCharge) leave() []disasm.Instr {
+	return []disasm.Instr{
+		growOp(ops.GetGlobal, c.global),
+		growOp(ops.I32Const, int32(1)),
+		growOp(ops.I32Sub),
+		growOp(ops.SetGlobal, c.global),
+	}
+}
+
+// counts reports whether instr is a call callDepthCharge counts, host
+// functions return without calling back into the module.
+func (c *callDepthCharge) counts(instr disasm.Instr, module *wasm.Module) bool {
+	switch instr.Op.Code {
+	case ops.CallIndirect:
+		return true
+	case ops.Call:
+		index := int(instr.Immediates[0].(uint32))
+		return index >= len(module.FunctionIndexSpace) || !module.FunctionIndexSpace[index].IsHost()
+	}
+	return false
+}
+
+// addCallDepthGlobal appends the global callDepthCharge counts in to m and
+// returns its index. The global isn't part of the contract code, so it is
+// added again whenever a deployed contract is instantiated.
+func addCallDepthGlobal(m *wasm.Module) uint32 {
+	m.GlobalIndexSpace = append(m.GlobalIndexSpace, wasm.GlobalEntry{
+		Type: wasm.GlobalVar{Type: wasm.ValueTypeI32, Mutable: true},
+		Init: []byte{ops.I32Const, 0x00, ops.End},
+	})
+	return uint32(len(m.GlobalIndexSpace) - 1)
+}
+
+// writeInstrs writes the code of instrs to buffer.
+func writeInstrs(buffer *bytes.Buffer, instrs []disasm.Instr) {
+	for _, instr := range instrs {
+		buffer.WriteByte(instr.Op.Code)
+		for _, imm := range instr.Immediates {
+			err := binary.Write(buffer, binary.LittleEndian, imm)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+}
+
+func growOp(code byte, immediates ...interface{}) disasm.Instr {
+	op, err := ops.New(code)
+	if err != nil {
+		panic(err)
+	}
+	return disasm.Instr{Op: op, Immediates: immediates}
+}
+
+// growsMemory reports whether the disassembled function contains memory.grow.
+func growsMemory(disassembly []disasm.Instr) bool {
+	for _, instr := range disassembly {
+		if instr.Op.Code == ops.GrowMemory && !instr.Unreachable {
+			return true
+		}
+	}
+	return false
+}
+
 func CompileModule(module *wasm.Module, chainctx ChainContext, mutable Mutable) ([]darma.Compiled, error) {
+	var cfg Config
+	if chainctx.Wavm != nil {
+		cfg = chainctx.Wavm.wavmConfig
+	}
+	schedule := chainctx.GasCounter.Schedule
+	if schedule == nil {
+		schedule = &params.WAVMGasScheduleV1
+	}
+	return compileModule(module, chainctx.GasRule, mutable, cfg, schedule)
+}
+
+// compileModule compiles module with the instruction costs of gasRule and
+// the memory.grow costs of schedule, under the limits of cfg.
+func compileModule(module *wasm.Module, gasRule gas.Gas, mutable Mutable, cfg Config, schedule *params.WAVMGasSchedule) ([]darma.Compiled, error) {
+	_, _, gasIndex := utils.GetIndex(module)
+	var depth *callDepthCharge
+	if cfg.MaxFunctionDepth > 0 && gasIndex != -1 {
+		depth = &callDepthCharge{
+			gasIndex: gasIndex,
+			global:   addCallDepthGlobal(module),
+			maxDepth: uint32(cfg.MaxFunctionDepth),
+		}
+	}
 	Compiled := make([]darma.Compiled, len(module.FunctionIndexSpace))
 	for i, fn := range module.FunctionIndexSpace {
 		// Skip native methods as they need not be
@@ -217,8 +399,26 @@ func CompileModule(module *wasm.Module, chainctx ChainContext, mutable Mutable)
 		for _, entry := range fn.Body.Locals {
 			totalLocalVars += int(entry.Count)
 		}
-		disassembly.Code = gas.InjectCounter(disassembly.Code, module, chainctx.GasRule)
-		code, table = Compile(disassembly.Code, module, mutable)
+		disassembly.Code = gas.InjectCounter(disassembly.Code, module, gasRule)
+		var grow *growCharge
+		if cfg.MaxMemoryPages > 0 && gasIndex != -1 && growsMemory(disassembly.Code) {
+			grow = &growCharge{
+				gasIndex: gasIndex,
+				scratch:  uint32(totalLocalVars),
+				maxPages: uint64(cfg.MaxMemoryPages),
+				linear:   schedule.GrowMem,
+				quad:     schedule.GrowMemQuad,
+			}
+			totalLocalVars += 2
+			maxDepth += growChargeDepth
+		}
+		if depth != nil {
+			maxDepth += callDepthChargeDepth
+		}
+		if cfg.MaxValueSlots > 0 && totalLocalVars+maxDepth > cfg.MaxValueSlots {
+			return nil, fmt.Errorf("function %d needs %d value slots, the limit is %d", i, totalLocalVars+maxDepth, cfg.MaxValueSlots)
+		}
+		code, table = compile(disassembly.Code, module, mutable, grow, depth)
 		Compiled[i] = darma.Compiled{
 			Code:           code,
 			Table:          table,
@@ -229,6 +429,80 @@ func CompileModule(module *wasm.Module, chainctx ChainContext, mutable Mutable)
 	return Compiled, nil
 }
 
+// deploySchedules returns the gas schedules contracts called at block num can
+// have been deployed under, the schedule of num first: code deployed before
+// the V2 fork was compiled under V1, code deployed after it under V2.
+func deploySchedules(c *params.ChainConfig, num *big.Int) []*params.WAVMGasSchedule {
+	if c.IsWAVMGasV2(num) {
+		return []*params.WAVMGasSchedule{&params.WAVMGasScheduleV2, &params.WAVMGasScheduleV1}
+	}
+	return []*params.WAVMGasSchedule{&params.WAVMGasScheduleV1}
+}
+
+// limitsActive reports whether cfg limits memory.grow or the call depth,
+// which code only obeys if CompileModule compiled it under the limits.
+func limitsActive(cfg Config) bool {
+	return cfg.MaxMemoryPages > 0 || cfg.MaxFunctionDepth > 0
+}
+
+// limitCompiled returns the compiled code of a deployed contract with the
+// memory.grow and call depth charges of cfg, so contracts deployed before
+// the limits obey them too. m is the module InstantiateModule returns for
+// deployed code. The contract keeps the instruction costs it was deployed
+// with: its code is compiled again under each of schedules, with and
+// without the limits, until the result matches the stored code. Code that
+// matches none of them can't be given the limits, running it would leave
+// them unenforced, so it is an error.
+func limitCompiled(m *wasm.Module, compiled []darma.Compiled, mutable Mutable, cfg Config, schedules []*params.WAVMGasSchedule) ([]darma.Compiled, error) {
+	if m.Import == nil {
+		return nil, errors.New("module doesn't import AddGas, its resources can't be limited")
+	}
+	if _, _, gasIndex := utils.GetIndex(m); gasIndex == -1 {
+		return nil, errors.New("module doesn't import AddGas, its resources can't be limited")
+	}
+	unlimited := cfg
+	unlimited.MaxMemoryPages, unlimited.MaxFunctionDepth, unlimited.MaxValueSlots = 0, 0, 0
+
+	for _, schedule := range schedules {
+		gasRule := gas.NewGas(schedule, cfg.DisableFloatingPoint)
+		limited, err := compileModule(deployedModule(m), gasRule, mutable, cfg, schedule)
+		if err != nil {
+			return nil, err
+		}
+		if sameCompiled(limited, compiled) {
+			return compiled, nil
+		}
+		plain, err := compileModule(deployedModule(m), gasRule, mutable, unlimited, schedule)
+		if err != nil {
+			return nil, err
+		}
+		if sameCompiled(plain, compiled) {
+			return limited, nil
+		}
+	}
+	return nil, errors.New("deployed code matches no gas schedule, its resources can't be limited")
+}
+
+// deployedModule returns a copy of m without the global InstantiateModule
+// adds to deployed code, as CompileModule saw the module at deployment.
+func deployedModule(m *wasm.Module) *wasm.Module {
+	c := copyModule(m)
+	c.GlobalIndexSpace = c.GlobalIndexSpace[:len(c.GlobalIndexSpace)-1]
+	return c
+}
+
+func sameCompiled(a, b []darma.Compiled) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i].Code, b[i].Code) || a[i].TotalLocalVars != b[i].TotalLocalVars || a[i].MaxDepth != b[i].MaxDepth {
+			return false
+		}
+	}
+	return true
+}
+
 // func (cb *CodeBlock) addChild() {
 // 	cb.code.Children = append([]code)
 // }
@@ -237,6 +511,10 @@ func CompileModule(module *wasm.Module, chainctx ChainContext, mutable Mutable)
 // TODO(vibhavp): Add options for optimizing code. Operators like i32.reinterpret/f32
 // are no-ops, and can be safely removed.
 func Compile(disassembly []disasm.Instr, module *wasm.Module, mutable Mutable) ([]byte, []*darma.BranchTable) {
+	return compile(disassembly, module, mutable, nil, nil)
+}
+
+func compile(disassembly []disasm.Instr, module *wasm.Module, mutable Mutable, grow *growCharge, depth *callDepthCharge) ([]byte, []*darma.BranchTable) {
 	buffer := new(bytes.Buffer)
 	branchTables := []*darma.BranchTable{}
 
@@ -683,6 +961,25 @@ func Compile(disassembly []disasm.Instr, module *wasm.Module, mutable Mutable) (
 				log.Warn("Compile warning", "Msg", "Can't find ReadWithPointer env function!!")
 			}
 		}
+		if instr.Op.Code == ops.GrowMemory && grow != nil {
+			growInstr := grow.instrs()
+			for _, instr := range growInstr {
+				buffer.WriteByte(instr.Op.Code)
+				for _, imm := range instr.Immediates {
+					err := binary.Write(buffer, binary.LittleEndian, imm)
+					if err != nil {
+						panic(err)
+					}
+				}
+			}
+			newInstr = append(newInstr, growInstr...)
+		}
+		counted := depth != nil && depth.counts(instr, module)
+		if counted {
+			enterInstr := depth.enter()
+			writeInstrs(buffer, enterInstr)
+			newInstr = append(newInstr, enterInstr...)
+		}
 		buffer.WriteByte(instr.Op.Code)
 		for _, imm := range instr.Immediates {
 			err := binary.Write(buffer, binary.LittleEndian, imm)
@@ -691,6 +988,11 @@ func Compile(disassembly []disasm.Instr, module *wasm.Module, mutable Mutable) (
 			}
 		}
 		newInstr = append(newInstr, instr)
+		if counted {
+			leaveInstr := depth.leave()
+			writeInstrs(buffer, leaveInstr)
+			newInstr = append(newInstr, leaveInstr...)
+		}
 		if len(writeInstr) != 0 {
 			if writeIndex != -1 {
 				for _, instr := range writeInstr {
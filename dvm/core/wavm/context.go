@@ -21,7 +21,6 @@ import (
 
 	"github.com/darmaproject/darmasuite/dvm/accounts/abi"
 	"github.com/darmaproject/darmasuite/dvm/common"
-	"github.com/darmaproject/darmasuite/dvm/core/state"
 	inter "github.com/darmaproject/darmasuite/dvm/core/vm/interface"
 	"github.com/darmaproject/darmasuite/dvm/core/wavm/contract"
 	"github.com/darmaproject/darmasuite/dvm/core/wavm/gas"
@@ -35,8 +34,8 @@ type ChainContext struct {
 	CanTransfer func(inter.StateDB, common.Address, *big.Int) bool
 	// Transfer transfers darma from one account to the other
 	Transfer func(inter.StateDB, common.Address, common.Address, *big.Int, bool) bool
-	//TransferEx transfers to any external address from the contract account
-	//TransferEx func(string, *big.Int)
+	// TransferEx pays from the contract account to a Darma address outside the VM
+	TransferEx func(inter.StateDB, common.Address, string, *big.Int) error
 	// GetHash returns the hash corresponding to n
 	GetHash         func(uint64) common.Hash
 	StringToAddress func(string) []byte
@@ -52,7 +51,7 @@ type ChainContext struct {
 	BlockNumber    *big.Int       // Provides information for NUMBER
 	Time           *big.Int       // Provides information for TIME
 	Difficulty     *big.Int       // Provides information for DIFFICULTY
-	StateDB        *state.StateDB
+	StateDB        inter.StateDB
 	Contract       *contract.WASMContract
 	Code           []byte  //Wasm contract code
 	Abi            abi.ABI //Wasm contract abi
@@ -58,6 +58,10 @@ var endianess = binary.LittleEndian
 type EnvFunctions struct {
 	ctx       *ChainContext
 	funcTable map[string]wasm.Function
+
+	// outcome of the last contract call, see GetCallStatus
+	callFailed     bool
+	callRevertData []byte
 }
 
 //InitFuncTable init event and contract_call function
@@ -313,22 +317,40 @@ func (ef *EnvFunctions) Assert(proc *exec.WavmProcess, condition uint64, msgIdx
 func (ef *EnvFunctions) SendFromContract(proc *exec.WavmProcess, addrIdx uint64, amountIdx uint64) {
 	ef.forbiddenMutable(proc)
 	ef.ctx.GasCounter.GasSendFromContract()
+	if recipient, ok := ef.payoutRecipient(proc, addrIdx); ok {
+		amount := utils.GetU256(proc.ReadAt(amountIdx))
+		if err := ef.ctx.TransferEx(ef.ctx.StateDB, ef.ctx.Contract.Address(), recipient, amount); err != nil {
+			panic(newRevertError(fmt.Sprintf("SendFromContract: payout of %s failed: %s", amount, err)))
+		}
+		return
+	}
 	addr := common.BytesToAddress(proc.ReadAt(addrIdx))
 	amount := utils.GetU256(proc.ReadAt(amountIdx))
 	if ef.ctx.CanTransfer(ef.ctx.StateDB, ef.ctx.Contract.Address(), amount) {
-		_, _, err := ef.ctx.Wavm.Call(ef.ctx.Contract, addr, nil, params.CallStipend, amount)
+		ret, _, err := ef.ctx.Wavm.Call(ef.ctx.Contract, addr, nil, params.CallStipend, amount)
 		// ef.ctx.GasCounter.Charge(returnGas)
 		if err != nil {
-			panic(errormsg.ErrExecutionReverted)
+			// a reverting recipient keeps its own reason
+			if _, ok := errormsg.UnpackRevertReason(ret); ok {
+				panic(&revertError{data: ret})
+			}
+			panic(newRevertError(fmt.Sprintf("SendFromContract: sending %s to %x failed: %s", amount, addr, err)))
 		}
 	} else {
-		panic(errormsg.ErrExecutionReverted)
+		panic(newRevertError(fmt.Sprintf("SendFromContract: insufficient balance to send %s", amount)))
 	}
 }
 
 func (ef *EnvFunctions) TransferFromContract(proc *exec.WavmProcess, addrIdx uint64, amountIdx uint64) uint64 {
 	ef.forbiddenMutable(proc)
 	ef.ctx.GasCounter.GasSendFromContract()
+	if recipient, ok := ef.payoutRecipient(proc, addrIdx); ok {
+		amount := utils.GetU256(proc.ReadAt(amountIdx))
+		if err := ef.ctx.TransferEx(ef.ctx.StateDB, ef.ctx.Contract.Address(), recipient, amount); err != nil {
+			return 0
+		}
+		return 1
+	}
 	addr := common.BytesToAddress(proc.ReadAt(addrIdx))
 	amount := utils.GetU256(proc.ReadAt(amountIdx))
 	if ef.ctx.CanTransfer(ef.ctx.StateDB, ef.ctx.Contract.Address(), amount) {
@@ -342,6 +364,19 @@ func (ef *EnvFunctions) TransferFromContract(proc *exec.WavmProcess, addrIdx uin
 	return 0
 }
 
+// payoutRecipient returns the Darma address a contract sends to. From the
+// contract payout fork on, SendFromContract and TransferFromContract pay a
+// Darma address given as a string instead of a contract account, and the
+// payout is charged on top of the send.
+func (ef *EnvFunctions) payoutRecipient(proc *exec.WavmProcess, addrIdx uint64) (string, bool) {
+	recipient := proc.ReadAt(addrIdx)
+	if len(recipient) == common.AddressLength || !ef.ctx.Wavm.ChainConfig().IsContractPayout(ef.ctx.BlockNumber) {
+		return "", false
+	}
+	ef.ctx.GasCounter.GasContractPayout()
+	return string(recipient), true
+}
+
 func (ef *EnvFunctions) fromI64(proc *exec.WavmProcess, value uint64) uint64 {
 	ef.ctx.GasCounter.GasFromI64()
 	amount := int(value)
@@ -499,6 +534,8 @@ func (ef *EnvFunctions) getEvent(funcName string) interface{} {
 }
 
 //todo If a unmutable method calls a mutable method across contracts, return error.
+//A failed call rolls back the callee only. Contracts that import GetCallStatus get
+//a zero result and can inspect the failure, the others are aborted as well.
 func (ef *EnvFunctions) getContractCall(funcName string) interface{} {
 	Abi := ef.ctx.Abi
 
@@ -590,12 +627,33 @@ func (ef *EnvFunctions) getContractCall(funcName string) interface{} {
 		if amount.Sign() != 0 {
 			gas += params.CallStipend
 		}
+		// Contracts importing GetCallStatus handle failed calls themselves,
+		// the others keep aborting with the callee.
+		caller := ef.ctx.Wavm.Wavm
+		catch := caller.importsFunction(OpNameGetCallStatus)
 		ret, returnGas, err := ef.ctx.Wavm.Call(ef.ctx.Contract, toAddr, res, gas, amount)
+		ef.ctx.Wavm.Wavm = caller
 		failError := errors.New(errContractCallResult)
 		if err != nil {
-			e := fmt.Errorf("%s Reason : %s", failError, err)
-			panic(e)
+			if !catch {
+				e := fmt.Errorf("%s Reason : %s", failError, err)
+				panic(e)
+			}
+			// The callee state is already rolled back, only the gas left
+			// over by a revert is given back to the caller.
+			ef.ctx.Contract.Gas += returnGas
+			ef.callFailed = true
+			ef.callRevertData = nil
+			if err.Error() == errormsg.ErrExecutionReverted.Error() {
+				ef.callRevertData = ret
+			}
+			if len(dc.Outputs) == 0 {
+				return nil
+			}
+			return failedCallResult(proc, dc.Outputs[0].Type)
 		} else {
+			ef.callFailed = false
+			ef.callRevertData = nil
 			ef.ctx.Contract.Gas += returnGas
 			if len(dc.Outputs) == 0 {
 				return nil
@@ -734,6 +792,30 @@ func (ef *EnvFunctions) getContractCall(funcName string) interface{} {
 	//return makeFunc(fnDef)
 }
 
+// failedCallResult returns the zero value of a contract call result of type t.
+func failedCallResult(proc *exec.WavmProcess, t abi.Type) interface{} {
+	switch t.T {
+	case abi.StringTy:
+		return uint32(proc.SetBytes([]byte{}))
+	case abi.AddressTy:
+		return uint32(proc.SetBytes(common.Address{}.Bytes()))
+	case abi.UintTy:
+		if t.Size == 32 {
+			return uint32(0)
+		} else if t.Size == 64 {
+			return uint64(0)
+		}
+		return uint32(proc.SetBytes([]byte(new(big.Int).String())))
+	case abi.IntTy:
+		if t.Size == 64 {
+			return int64(0)
+		}
+		return int32(0)
+	default:
+		return int32(0)
+	}
+}
+
 // End the line
 func (ef *EnvFunctions) printLine(msg string) error {
 	funcName := ef.ctx.Wavm.Wavm.GetFuncName()
@@ -1258,17 +1340,54 @@ func (ef *EnvFunctions) Pow(proc *exec.WavmProcess, base, exponent uint64) uint6
 }
 
 func (ef *EnvFunctions) AddGas(proc *exec.WavmProcess, cost uint64) {
+	// the metering code CompileModule injects runs in every block of
+	// instructions, so a cancelled execution stops here
+	if ef.ctx.Wavm.Cancelled() {
+		panic(errormsg.ErrExecutionAborted)
+	}
 	ef.ctx.GasCounter.AdjustedCharge(cost)
 }
 
-//todo Considering the complete implementation of revert, revert is needed in contractcall
+// revertError unwinds the interpreter from Revert and carries the encoded
+// revert reason back to Apply.
+type revertError struct {
+	data []byte
+}
+
+func newRevertError(reason string) *revertError {
+	return &revertError{data: errormsg.EncodeRevertReason([]byte(reason))}
+}
+
+func (e *revertError) Error() string {
+	return errormsg.ErrExecutionReverted.Error()
+}
+
+//Revert stop the execution, roll back the state changes of this call and return the message to the caller
 func (ef *EnvFunctions) Revert(proc *exec.WavmProcess, msgIdx uint64) {
 	ctx := ef.ctx
 	ctx.GasCounter.GasRevert()
 	msg := proc.ReadAt(msgIdx)
 	ctx.GasCounter.GasMemoryCost(uint64(len(msg)))
 	log.Info("Contract Revert >>>>", "message", string(msg))
-	panic(errormsg.ErrExecutionReverted)
+	panic(&revertError{data: errormsg.EncodeRevertReason(msg)})
+}
+
+//GetCallStatus get the status of the last contract call, 1 on success and 0 on failure
+func (ef *EnvFunctions) GetCallStatus(proc *exec.WavmProcess) uint64 {
+	ef.ctx.GasCounter.GasQuickStep()
+	if ef.callFailed {
+		return 0
+	}
+	return 1
+}
+
+//GetCallRevertData get the revert message of the last failed contract call
+func (ef *EnvFunctions) GetCallRevertData(proc *exec.WavmProcess) uint64 {
+	data := ef.callRevertData
+	if reason, ok := errormsg.UnpackRevertReason(data); ok {
+		data = []byte(reason)
+	}
+	return ef.returnPointer(proc, data)
 }
 
 func (ef *EnvFunctions) returnPointer(proc *exec.WavmProcess, input []byte) uint64 {
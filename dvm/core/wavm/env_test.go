@@ -30,11 +30,11 @@ import (
 	"github.com/darmaproject/darma-wasm/wasm"
 	"github.com/darmaproject/darmasuite/dvm/accounts/abi"
 	"github.com/darmaproject/darmasuite/dvm/common"
+	"github.com/darmaproject/darmasuite/dvm/core/rawdb"
 	"github.com/darmaproject/darmasuite/dvm/core/state"
 	"github.com/darmaproject/darmasuite/dvm/core/vm"
 	"github.com/darmaproject/darmasuite/dvm/core/wavm/contract"
 	g "github.com/darmaproject/darmasuite/dvm/core/wavm/gas"
-	"github.com/darmaproject/darmasuite/dvm/dmdb"
 	"github.com/darmaproject/darmasuite/dvm/log"
 	"github.com/darmaproject/darmasuite/dvm/params"
 	"github.com/darmaproject/darmasuite/dvm/trie"
@@ -137,12 +137,12 @@ func getVM(codeFile string, abiPath string) (*exec.Interpreter, EnvFunctions) {
 	abi := readAbi(abiPath)
 	addr := common.BytesToAddress([]byte("0xd2be7e0d40c1a73ec1709f00b11cb5e24c784077"))
 
-	chainconfig := &params.ChainConfig{HubbleBlock: big.NewInt(0)}
-	gasRule := g.NewGas(false)
-	gasTable := chainconfig.GasTable(new(big.Int).SetInt64(10000))
+	chainconfig := &params.ChainConfig{ChainID: big.NewInt(1)}
+	gasSchedule := chainconfig.WAVMGasSchedule(new(big.Int).SetInt64(10000))
+	gasRule := g.NewGas(gasSchedule, false)
 	contract := contract.NewWASMContract(vm.AccountRef(addr),
 		vm.AccountRef(addr), big.NewInt(100), 200000)
-	gasCounter := g.NewGasCounter(contract, gasTable)
+	gasCounter := g.NewGasCounter(contract, gasSchedule)
 	cc := ChainContext{
 		BlockNumber: big.NewInt(1),
 		Contract:    contract,
@@ -150,14 +150,22 @@ func getVM(codeFile string, abiPath string) (*exec.Interpreter, EnvFunctions) {
 		GasRule:     gasRule,
 		GasCounter:  gasCounter,
 		GasLimit:    10000000,
+		StringToAddress: func(s string) []byte {
+			return common.HexToAddress(s).Bytes()
+		},
+		AddressToString: func(b []byte) string {
+			return common.BytesToAddress(b).Hex()
+		},
 		Wavm: &WAVM{
-			wavmConfig: Config{Debug: true, Tracer: NewWasmLogger(nil)},
-			Wavm:       &Wavm{},
+			chainConfig: chainconfig,
+			wavmConfig:  Config{Debug: true, Tracer: NewWasmLogger(nil)},
+			Wavm:        &Wavm{},
 		},
 	}
 
-	cc.StateDB = prepareState()
-	cc.StateDB.GetOrNewStateObject(cc.Contract.Address())
+	statedb := prepareState()
+	statedb.GetOrNewStateObject(cc.Contract.Address())
+	cc.StateDB = statedb
 
 	envModule := EnvModule{}
 	envModule.InitModule(&cc)
@@ -480,10 +488,10 @@ func prepareState() *state.StateDB {
 	//bHash := common.BytesToHash(value)
 	//stateDB.Prepare(tHash, bHash, 1)
 
-	db := dmdb.NewMemDatabase()
+	db := rawdb.NewMemoryDatabase()
 	value := make([]byte, 1)
 	value[0] = 0x01
-	state, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	state, _ := state.New(common.Hash{}, state.NewDatabase(db), nil)
 	tHash = common.BytesToHash(value)
 	bHash = common.BytesToHash(value)
 	state.Prepare(tHash, bHash, 1)
@@ -584,3 +592,23 @@ func TestVM_AddressTooLong(t *testing.T) {
 	assert.Equal(t, common.HexToAddress(dest).String(), common.BytesToAddress(strBytes).String())
 
 }
+
+func TestVM_AddGasCancelled(t *testing.T) {
+	interp, ef := getVM(debugCodePath, debugAbiPath)
+
+	var mutable = true
+	proc := exec.NewWavmProcess(interp.VM, interp.Memory, &mutable)
+
+	gas := ef.ctx.Contract.Gas
+	ef.AddGas(proc, 10)
+	assert.True(t, ef.ctx.Contract.Gas < gas)
+
+	ef.ctx.Wavm.Cancel()
+	gas = ef.ctx.Contract.Gas
+	defer func() {
+		assert.Equal(t, vm.ErrExecutionAborted, recover())
+		assert.Equal(t, gas, ef.ctx.Contract.Gas)
+	}()
+	ef.AddGas(proc, 10)
+	t.Error("cancelled execution was not aborted")
+}
@@ -97,6 +97,10 @@ const (
 
 	OpNameRevert = "Revert"
 
+	//contract call result
+	OpNameGetCallStatus     = "GetCallStatus"
+	OpNameGetCallRevertData = "GetCallRevertData"
+
 	//qlang
 	OpNameSender = "Sender"
 	OpNameLoad   = "Load"
@@ -684,6 +688,26 @@ func (ef *EnvFunctions) getFuncTable() map[string]wasm.Function {
 				Code: []byte{},
 			},
 		},
+		OpNameGetCallStatus: {
+			Host: reflect.ValueOf(ef.GetCallStatus),
+			Sig: &wasm.FunctionSig{
+				ParamTypes:  []wasm.ValueType{},
+				ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32},
+			},
+			Body: &wasm.FunctionBody{
+				Code: []byte{},
+			},
+		},
+		OpNameGetCallRevertData: {
+			Host: reflect.ValueOf(ef.GetCallRevertData),
+			Sig: &wasm.FunctionSig{
+				ParamTypes:  []wasm.ValueType{},
+				ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32},
+			},
+			Body: &wasm.FunctionBody{
+				Code: []byte{},
+			},
+		},
 		OpNameSender: {
 			Host: reflect.ValueOf(ef.Sender),
 			Sig: &wasm.FunctionSig{
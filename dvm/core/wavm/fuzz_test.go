@@ -0,0 +1,288 @@
+// Copyright 2019 The darmasuite Authors
+// This file is part of the darmasuite library.
+//
+// The darmasuite library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The darmasuite library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the darmasuite library. If not, see <http://www.gnu.org/licenses/>.
+
+package wavm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/darmaproject/darma-wasm/exec"
+	"github.com/darmaproject/darma-wasm/validate"
+	"github.com/darmaproject/darma-wasm/wasm"
+	"github.com/darmaproject/darmasuite/dvm/accounts/abi"
+	"github.com/darmaproject/darmasuite/dvm/common"
+	"github.com/darmaproject/darmasuite/dvm/core/vm"
+	inter "github.com/darmaproject/darmasuite/dvm/core/vm/interface"
+	"github.com/darmaproject/darmasuite/dvm/core/wavm/contract"
+	g "github.com/darmaproject/darmasuite/dvm/core/wavm/gas"
+	"github.com/darmaproject/darmasuite/dvm/core/wavm/storage"
+	"github.com/darmaproject/darmasuite/dvm/core/wavm/utils"
+	"github.com/darmaproject/darmasuite/dvm/params"
+)
+
+// fuzzContract is a contract from the test fixtures used to seed the corpus.
+type fuzzContract struct {
+	name string
+	code []byte
+	abi  abi.ABI
+}
+
+// loadFuzzContracts decodes every compressed contract under tests/. The
+// result is sorted by file name, so an index into it is stable across runs.
+func loadFuzzContracts(f *testing.F) []fuzzContract {
+	files, err := filepath.Glob(filepath.Join("tests", "*", "*.compress"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	var contracts []fuzzContract
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		code, _, err := utils.DecodeContractCode(data)
+		if err != nil {
+			f.Fatalf("%s: %v", file, err)
+		}
+		abiobj, err := GetAbi(code.Abi)
+		if err != nil {
+			f.Fatalf("%s: %v", file, err)
+		}
+		contracts = append(contracts, fuzzContract{name: file, code: code.Code, abi: abiobj})
+	}
+	if len(contracts) == 0 {
+		f.Fatal("no seed contracts found")
+	}
+	return contracts
+}
+
+// newFuzzContext returns a chain context good enough to resolve the env
+// module and to run env functions outside of a real transaction.
+func newFuzzContext(abiobj abi.ABI, isCreated bool) *ChainContext {
+	addr := common.HexToAddress("0xd2be7e0d40c1a73ec1709f00b11cb5e24c784077")
+	chainconfig := &params.ChainConfig{ChainID: big.NewInt(1)}
+	gasSchedule := chainconfig.WAVMGasSchedule(big.NewInt(1))
+	wasmContract := contract.NewWASMContract(vm.AccountRef(addr), vm.AccountRef(addr), big.NewInt(0), 10000000)
+	ctx := &ChainContext{
+		BlockNumber: big.NewInt(1),
+		Time:        big.NewInt(0),
+		Difficulty:  big.NewInt(0),
+		GasPrice:    big.NewInt(0),
+		GasLimit:    10000000,
+		// Value transfers need a complete WAVM, so contracts never have funds.
+		CanTransfer: func(inter.StateDB, common.Address, *big.Int) bool {
+			return false
+		},
+		GetHash: func(n uint64) common.Hash {
+			return common.Hash{}
+		},
+		StringToAddress: func(s string) []byte {
+			return common.HexToAddress(s).Bytes()
+		},
+		AddressToString: func(b []byte) string {
+			return common.BytesToAddress(b).Hex()
+		},
+		Contract:       wasmContract,
+		Abi:            abiobj,
+		IsCreated:      isCreated,
+		GasRule:        g.NewGas(gasSchedule, false),
+		GasCounter:     g.NewGasCounter(wasmContract, gasSchedule),
+		GasTable:       gasSchedule.Table,
+		StorageMapping: make(map[uint64]storage.StorageMapping),
+		Wavm: &WAVM{
+			chainConfig: chainconfig,
+			wavmConfig:  Config{Debug: true, Tracer: NewWasmLogger(nil)},
+			mutable:     -1,
+			Wavm:        &Wavm{},
+		},
+	}
+	statedb := prepareState()
+	statedb.GetOrNewStateObject(addr)
+	ctx.StateDB = statedb
+	return ctx
+}
+
+// tooLarge reports whether instantiating m would allocate more linear memory
+// than a contract may ever use. Those modules only make the fuzzer itself run
+// out of memory.
+func tooLarge(m *wasm.Module) bool {
+	if m.Memory == nil || len(m.Memory.Entries) == 0 {
+		return false
+	}
+	return uint64(m.Memory.Entries[0].Limits.Initial)*wasm_page_size > maximum_linear_memory
+}
+
+// FuzzInstantiateModule reads, verifies and instantiates arbitrary modules
+// against the env module of one of the fixture ABIs. Instantiation runs
+// instantiateMemory over the data segments.
+func FuzzInstantiateModule(f *testing.F) {
+	contracts := loadFuzzContracts(f)
+	for i, c := range contracts {
+		f.Add(c.code, uint8(i))
+	}
+
+	f.Fuzz(func(t *testing.T, code []byte, abiIdx uint8) {
+		ctx := newFuzzContext(contracts[int(abiIdx)%len(contracts)].abi, true)
+		w := NewWavm(*ctx, Config{}, true)
+		if err := w.InstantiateModule(code, []uint8{}); err != nil {
+			return
+		}
+		if tooLarge(w.Module) {
+			return
+		}
+		interpreter, err := exec.NewInterpreter(w.Module, nil, instantiateMemory, w.captureOp, w.captureEnvFunctionStart, w.captureEnvFunctionEnd, false)
+		if err != nil {
+			return
+		}
+		if interpreter.Module() != w.Module {
+			t.Fatal("interpreter runs a different module than the one instantiated")
+		}
+	})
+}
+
+// FuzzCompileModule checks that every module passing verification can be
+// compiled with gas metering injected.
+func FuzzCompileModule(f *testing.F) {
+	contracts := loadFuzzContracts(f)
+	for i, c := range contracts {
+		f.Add(c.code, uint8(i))
+	}
+
+	f.Fuzz(func(t *testing.T, code []byte, abiIdx uint8) {
+		abiobj := contracts[int(abiIdx)%len(contracts)].abi
+		ctx := newFuzzContext(abiobj, true)
+		w := NewWavm(*ctx, Config{}, true)
+		m, err := wasm.ReadModule(bytes.NewReader(code), w.ResolveImports)
+		if err != nil {
+			return
+		}
+		if err := validate.VerifyModule(m); err != nil {
+			return
+		}
+		compiled, err := CompileModule(m, *ctx, MutableFunction(abiobj, m))
+		if err != nil {
+			return
+		}
+		if len(compiled) != len(m.FunctionIndexSpace) {
+			t.Fatalf("compiled %d functions, module has %d", len(compiled), len(m.FunctionIndexSpace))
+		}
+	})
+}
+
+// envMemoryReaders calls each env function that dereferences guest pointers.
+// a and b are pointers into linear memory.
+var envMemoryReaders = []struct {
+	name string
+	call func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64)
+}{
+	{"GetBalanceFromAddress", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.GetBalanceFromAddress(proc, a) }},
+	{"SHA3", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.SHA3(proc, a) }},
+	{"Ecrecover", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.Ecrecover(proc, a, b, a, b) }},
+	{"Assert", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.Assert(proc, 1, a) }},
+	{"SendFromContract", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.SendFromContract(proc, a, b) }},
+	{"TransferFromContract", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.TransferFromContract(proc, a, b) }},
+	{"toI64", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.toI64(proc, a) }},
+	{"toU64", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.toU64(proc, a) }},
+	{"Concat", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.Concat(proc, a, b) }},
+	{"Equal", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.Equal(proc, a, b) }},
+	{"PrintAddress", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.PrintAddress(proc, a, b) }},
+	{"PrintStr", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.PrintStr(proc, a, b) }},
+	{"PrintQStr", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.PrintQStr(proc, a, b) }},
+	{"PrintUint256T", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.PrintUint256T(proc, a, b) }},
+	{"AddressFrom", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.AddressFrom(proc, a) }},
+	{"AddressToString", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.AddressToString(proc, a) }},
+	{"U256From", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.U256From(proc, a) }},
+	{"U256ToString", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.U256ToString(proc, a) }},
+	{"U256Add", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.U256Add(proc, a, b) }},
+	{"U256Sub", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.U256Sub(proc, a, b) }},
+	{"U256Mul", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.U256Mul(proc, a, b) }},
+	{"U256Div", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.U256Div(proc, a, b) }},
+	{"U256Mod", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.U256Mod(proc, a, b) }},
+	{"U256Pow", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.U256Pow(proc, a, b) }},
+	{"U256Cmp", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.U256Cmp(proc, a, b) }},
+	{"U256Shl", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.U256Shl(proc, a, b) }},
+	{"U256Shr", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.U256Shr(proc, a, b) }},
+	{"U256And", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.U256And(proc, a, b) }},
+	{"U256Or", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.U256Or(proc, a, b) }},
+	{"U256Xor", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.U256Xor(proc, a, b) }},
+	{"Revert", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.Revert(proc, a) }},
+	{"Sender", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.Sender(proc, a) }},
+	{"Load", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.Load(proc, a, b) }},
+	{"Store", func(ef *EnvFunctions, proc *exec.WavmProcess, a, b uint64) { ef.Store(proc, a, b) }},
+}
+
+// callEnvFunction runs fn the way Apply would and fails on runtime errors
+// other than out of range memory accesses. Apply turns every panic into an
+// error, so guest traps are fine, but a nil dereference or a division by zero
+// in an env function is a host bug.
+func callEnvFunction(t *testing.T, name string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if err, ok := r.(runtime.Error); ok && !strings.Contains(err.Error(), "out of range") {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+	}()
+	fn()
+}
+
+// FuzzEnvFunctionMemory writes data into linear memory and passes pointers
+// relative to it to every env function that reads guest memory.
+func FuzzEnvFunctionMemory(f *testing.F) {
+	code, err := ioutil.ReadFile(debugCodePath)
+	if err != nil {
+		f.Fatal(err)
+	}
+	abiobj := readAbi(debugAbiPath)
+
+	f.Add([]byte("0x0523029b179009a28a7fae478cd0c2e5ba2adc38"), uint64(0), uint64(0))
+	f.Add([]byte("115792089237316195423570985008687907853269984665640564039457584007913129639935"), uint64(0), uint64(0))
+	f.Add([]byte("darma Token"), uint64(0), uint64(4))
+	f.Add([]byte{0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, uint64(0), uint64(1))
+	f.Add([]byte{}, uint64(1<<32), uint64(1<<63))
+
+	f.Fuzz(func(t *testing.T, data []byte, a, b uint64) {
+		ctx := newFuzzContext(abiobj, false)
+		envModule := EnvModule{}
+		envModule.InitModule(ctx)
+		m, err := wasm.ReadModule(bytes.NewReader(code), func(name string) (*wasm.Module, error) {
+			return envModule.GetModule(), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := ctx.Wavm.Wavm
+		interpreter, err := exec.NewInterpreter(m, nil, instantiateMemory, w.captureOp, w.captureEnvFunctionStart, w.captureEnvFunctionEnd, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		interpreter.ResetContext()
+		ef := envModule.GetEnvFunctions()
+
+		for _, reader := range envMemoryReaders {
+			mutable := true
+			proc := exec.NewWavmProcess(interpreter.VM, interpreter.Memory, &mutable)
+			base := uint64(interpreter.Memory.SetBytes(data))
+			callEnvFunction(t, reader.name, func() { reader.call(&ef, proc, base+a, base+b) })
+		}
+	})
+}
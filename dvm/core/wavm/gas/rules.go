@@ -23,30 +23,12 @@ import (
 	ops "github.com/darmaproject/darma-wasm/wasm/operators"
 	"github.com/darmaproject/darmasuite/dvm/common"
 	"github.com/darmaproject/darmasuite/dvm/common/math"
-	"github.com/darmaproject/darmasuite/dvm/core/state"
 	"github.com/darmaproject/darmasuite/dvm/core/vm"
+	inter "github.com/darmaproject/darmasuite/dvm/core/vm/interface"
 	"github.com/darmaproject/darmasuite/dvm/core/wavm/contract"
 	"github.com/darmaproject/darmasuite/dvm/params"
 )
 
-const (
-	WasmCostsRegular       = 1
-	WasmCostsDiv           = 16
-	WasmCostsMul           = 4
-	WasmCostsMem           = 2
-	WasmCostsStaticU256    = 64
-	WasmCostsStaticHash    = 64
-	WasmCostsStaticAddress = 40
-	/// Memory stipend. Amount of free memory (in 64kb pages) each contract can use for stack.
-	WasmCostsInitialMem = 4096
-	/// Grow memory cost, per page (64kb)
-	WasmCostsGrowMem        = 8192
-	WasmCostsMemcpy         = 1
-	WasmCostsMaxStackHeight = 64 * 1024
-	WasmCostsOpcodesMul     = 3
-	WasmCostsOpcodesDiv     = 8
-)
-
 const ErrorGasLimit = "Invocation resulted in gas limit violated"
 const ErrorInitialMemLimit = "Initial memory limit"
 const ErrorDisableFloatingPoint = "Wasm contract error: disabled floating point"
@@ -59,16 +41,23 @@ type GasValue struct {
 }
 
 type Gas struct {
-	Ops   map[byte]InstructionType
-	Rules map[InstructionType]GasValue
+	Ops     map[byte]InstructionType
+	Rules   map[InstructionType]GasValue
+	Regular uint64
 }
 
 type GasCounter struct {
 	Contract *contract.WASMContract
 	GasTable params.GasTable
+	Schedule *params.WAVMGasSchedule
 }
 
-func NewGas(disableFloatingPoint bool) Gas {
+// NewGas returns the instruction costs of schedule, which is
+// params.WAVMGasScheduleV1 if nil.
+func NewGas(schedule *params.WAVMGasSchedule, disableFloatingPoint bool) Gas {
+	if schedule == nil {
+		schedule = &params.WAVMGasScheduleV1
+	}
 	rules := Gas{
 		Ops: map[byte]InstructionType{
 			ops.Unreachable:  InstructionTypeUnreachable,
@@ -261,11 +250,12 @@ func NewGas(disableFloatingPoint bool) Gas {
 			ops.F64ReinterpretI64: InstructionTypeReinterpretation,
 		},
 		Rules: map[InstructionType]GasValue{
-			InstructionTypeLoad:  GasValue{Metering: MeteringFixed, Value: WasmCostsMem},
-			InstructionTypeStore: GasValue{Metering: MeteringFixed, Value: WasmCostsMem},
-			InstructionTypeDiv:   GasValue{Metering: MeteringFixed, Value: WasmCostsDiv},
-			InstructionTypeMul:   GasValue{Metering: MeteringFixed, Value: WasmCostsMul},
+			InstructionTypeLoad:  GasValue{Metering: MeteringFixed, Value: schedule.Mem},
+			InstructionTypeStore: GasValue{Metering: MeteringFixed, Value: schedule.Mem},
+			InstructionTypeDiv:   GasValue{Metering: MeteringFixed, Value: schedule.Div},
+			InstructionTypeMul:   GasValue{Metering: MeteringFixed, Value: schedule.Mul},
 		},
+		Regular: schedule.Regular,
 	}
 	if disableFloatingPoint {
 		rules.Rules[InstructionTypeFloat] = GasValue{
@@ -292,7 +282,7 @@ func (gas Gas) GasCost(op byte) uint64 {
 	case MeteringFixed:
 		return metering.Value
 	default:
-		return WasmCostsRegular
+		return gas.Regular
 	}
 }
 
@@ -300,10 +290,16 @@ func constGasFunc(gas uint64) uint64 {
 	return gas
 }
 
-func NewGasCounter(contract *contract.WASMContract, gasTable params.GasTable) GasCounter {
+// NewGasCounter returns a counter charging contract the host function costs
+// of schedule, which is params.WAVMGasScheduleV1 if nil.
+func NewGasCounter(contract *contract.WASMContract, schedule *params.WAVMGasSchedule) GasCounter {
+	if schedule == nil {
+		schedule = &params.WAVMGasScheduleV1
+	}
 	return GasCounter{
 		Contract: contract,
-		GasTable: gasTable,
+		GasTable: schedule.Table,
+		Schedule: schedule,
 	}
 
 }
@@ -319,60 +315,59 @@ func (gas GasCounter) ChargeGas(amount uint64) bool {
 }
 
 func (gas GasCounter) AdjustedCharge(amount uint64) {
-	// gas.Charge(amount * WasmCostsOpcodesMul / WasmCostsOpcodesDiv)
 	gas.Charge(amount)
 }
 
 func (gas GasCounter) GasQuickStep() {
-	gas.Charge(constGasFunc(vm.GasQuickStep))
+	gas.Charge(constGasFunc(gas.Schedule.QuickStep))
 }
 
 func (gas GasCounter) GasFastestStep() {
-	gas.Charge(constGasFunc(vm.GasFastestStep))
+	gas.Charge(constGasFunc(gas.Schedule.FastestStep))
 }
 
 func (gas GasCounter) GasGetBlockNumber() {
-	gas.Charge(constGasFunc(vm.GasQuickStep))
+	gas.Charge(constGasFunc(gas.Schedule.QuickStep))
 }
 
 func (gas GasCounter) GasGetBalanceFromAddress() {
-	gas.Charge(constGasFunc(vm.GasQuickStep))
+	gas.Charge(constGasFunc(gas.Schedule.QuickStep))
 }
 
 func (gas GasCounter) GasMemoryCost(size uint64) {
-	gas.Charge(constGasFunc(vm.GasQuickStep * size))
+	gas.Charge(constGasFunc(gas.Schedule.MemoryByte * size))
 }
 
 func (gas GasCounter) GasGetGas() {
-	gas.Charge(constGasFunc(vm.GasQuickStep))
+	gas.Charge(constGasFunc(gas.Schedule.QuickStep))
 }
 
 func (gas GasCounter) GasGetBlockHash() {
-	gas.Charge(constGasFunc(vm.GasExtStep))
+	gas.Charge(constGasFunc(gas.Schedule.ExtStep))
 }
 
 func (gas GasCounter) GasGetBlockProduser() {
-	gas.Charge(constGasFunc(vm.GasQuickStep))
+	gas.Charge(constGasFunc(gas.Schedule.QuickStep))
 }
 
 func (gas GasCounter) GasGetTimestamp() {
-	gas.Charge(constGasFunc(vm.GasQuickStep))
+	gas.Charge(constGasFunc(gas.Schedule.QuickStep))
 }
 
 func (gas GasCounter) GasGetOrigin() {
-	gas.Charge(constGasFunc(vm.GasQuickStep))
+	gas.Charge(constGasFunc(gas.Schedule.QuickStep))
 }
 
 func (gas GasCounter) GasGetSender() {
-	gas.Charge(constGasFunc(vm.GasQuickStep))
+	gas.Charge(constGasFunc(gas.Schedule.QuickStep))
 }
 
 func (gas GasCounter) GasGetGasLimit() {
-	gas.Charge(constGasFunc(vm.GasQuickStep))
+	gas.Charge(constGasFunc(gas.Schedule.QuickStep))
 }
 
 func (gas GasCounter) GasGetCoinUnit() {
-	gas.Charge(constGasFunc(vm.GasQuickStep))
+	gas.Charge(constGasFunc(gas.Schedule.QuickStep))
 }
 
 // func (vm *VM) GasGenerateKey() error {
@@ -384,29 +379,38 @@ func (gas GasCounter) GasGetCoinUnit() {
 // }
 
 func (gas GasCounter) GasGetValue() {
-	gas.Charge(constGasFunc(vm.GasQuickStep))
+	gas.Charge(constGasFunc(gas.Schedule.QuickStep))
 }
 
-//todo Verify sha3 gas cost，stay the same with evm
+// GasSHA3 charges for hashing size bytes. From WAVMGasScheduleV2 on it
+// costs the same as the EVM SHA3 instruction.
 func (gas GasCounter) GasSHA3(size uint64) {
-	gas.Charge(params.Sha3Gas)
-	gas.Charge(params.Sha3WordGas * size)
+	words := size / gas.Schedule.Sha3WordSize
+	if size%gas.Schedule.Sha3WordSize != 0 {
+		words++
+	}
+	gas.Charge(gas.Schedule.Sha3)
+	gas.Charge(gas.Schedule.Sha3Word * words)
 }
 
 func (gas GasCounter) GasGetContractAddress() {
-	gas.Charge(constGasFunc(vm.GasQuickStep))
+	gas.Charge(constGasFunc(gas.Schedule.QuickStep))
 }
 
 func (gas GasCounter) GasAssert() {
-	gas.Charge(constGasFunc(vm.GasQuickStep))
+	gas.Charge(constGasFunc(gas.Schedule.QuickStep))
 }
 
 func (gas GasCounter) GasRevert() {
-	gas.Charge(constGasFunc(vm.GasQuickStep))
+	gas.Charge(constGasFunc(gas.Schedule.QuickStep))
 }
 
 func (gas GasCounter) GasSendFromContract() {
-	gas.Charge(constGasFunc(params.CallStipend))
+	gas.Charge(constGasFunc(gas.Schedule.SendContract))
+}
+
+func (gas GasCounter) GasContractPayout() {
+	gas.Charge(constGasFunc(gas.Schedule.ContractPayout))
 }
 
 // func (gas GasCounter) GasGetContractValue() {
@@ -414,27 +418,27 @@ func (gas GasCounter) GasSendFromContract() {
 // }
 
 func (gas GasCounter) GasFromI64() {
-	gas.Charge(constGasFunc(vm.GasQuickStep))
+	gas.Charge(constGasFunc(gas.Schedule.QuickStep))
 }
 
 func (gas GasCounter) GasFromU64() {
-	gas.Charge(constGasFunc(vm.GasQuickStep))
+	gas.Charge(constGasFunc(gas.Schedule.QuickStep))
 }
 
 func (gas GasCounter) GasToI64() {
-	gas.Charge(constGasFunc(vm.GasQuickStep))
+	gas.Charge(constGasFunc(gas.Schedule.QuickStep))
 }
 
 func (gas GasCounter) GasToU64() {
-	gas.Charge(constGasFunc(vm.GasQuickStep))
+	gas.Charge(constGasFunc(gas.Schedule.QuickStep))
 }
 
 func (gas GasCounter) GasConcat(size uint64) {
-	gas.Charge(constGasFunc(WasmCostsMem * size))
+	gas.Charge(constGasFunc(gas.Schedule.ConcatByte * size))
 }
 
 func (gas GasCounter) GasEqual() {
-	gas.Charge(constGasFunc(vm.GasQuickStep))
+	gas.Charge(constGasFunc(gas.Schedule.QuickStep))
 }
 
 func (gas GasCounter) GasLog(size uint64, topics uint64) {
@@ -443,14 +447,14 @@ func (gas GasCounter) GasLog(size uint64, topics uint64) {
 		panic(errGasUintOverflow)
 	}
 	costgas := uint64(0)
-	if costgas, overflow = math.SafeAdd(costgas, params.LogGas); overflow {
+	if costgas, overflow = math.SafeAdd(costgas, gas.Schedule.Log); overflow {
 		panic(errGasUintOverflow)
 	}
-	if costgas, overflow = math.SafeAdd(costgas, topics*params.LogTopicGas); overflow {
+	if costgas, overflow = math.SafeAdd(costgas, topics*gas.Schedule.LogTopic); overflow {
 		panic(errGasUintOverflow)
 	}
 	var memorySizeGas uint64
-	if memorySizeGas, overflow = math.SafeMul(requestedSize, params.LogDataGas); overflow {
+	if memorySizeGas, overflow = math.SafeMul(requestedSize, gas.Schedule.LogData); overflow {
 		panic(errGasUintOverflow)
 	}
 	if costgas, overflow = math.SafeAdd(costgas, memorySizeGas); overflow {
@@ -459,16 +463,16 @@ func (gas GasCounter) GasLog(size uint64, topics uint64) {
 	gas.Charge(costgas)
 }
 
-func (gas GasCounter) GasCall(address common.Address, value, gasLimit, blockNumber *big.Int, chainConfig *params.ChainConfig, statedb *state.StateDB) uint64 {
+func (gas GasCounter) GasCall(address common.Address, value, gasLimit, blockNumber *big.Int, chainConfig *params.ChainConfig, statedb inter.StateDB) uint64 {
 	var (
 		callgas        = gas.GasTable.Calls
 		transfersValue = value.Sign() != 0
 	)
 	if transfersValue && statedb.Empty(address) {
-		callgas += params.CallNewAccountGas
+		callgas += gas.Schedule.CallNewAccount
 	}
 	if transfersValue {
-		callgas += params.CallValueTransferGas
+		callgas += gas.Schedule.CallValue
 	}
 	tempgas, err := gas.callGas(gas.GasTable, gas.Contract.Gas, callgas, gasLimit)
 	if err != nil {
@@ -500,7 +504,7 @@ func (gas GasCounter) callGas(gasTable params.GasTable, availableGas, base uint6
 	return callCost.Uint64(), nil
 }
 
-func (gas GasCounter) GasStore(stateDb *state.StateDB, contractAddr common.Address, loc common.Hash, value common.Hash) {
+func (gas GasCounter) GasStore(stateDb inter.StateDB, contractAddr common.Address, loc common.Hash, value common.Hash) {
 	var (
 		y, x = value, loc
 		val  = stateDb.GetState(contractAddr, x)
@@ -511,15 +515,15 @@ func (gas GasCounter) GasStore(stateDb *state.StateDB, contractAddr common.Addre
 	// 3. From a non-zero to a non-zero                         (CHANGE)
 	if (val == common.Hash{} && y != common.Hash{}) {
 		// 0 => non 0
-		gas.Charge(constGasFunc(params.SstoreSetGas))
+		gas.Charge(constGasFunc(gas.Schedule.SstoreSet))
 		// return params.SstoreSetGas, nil
 	} else if (val != common.Hash{} && y == common.Hash{}) {
-		stateDb.AddRefund(params.SstoreRefundGas)
-		gas.Charge(constGasFunc(params.SstoreClearGas))
+		stateDb.AddRefund(gas.Schedule.SstoreRefund)
+		gas.Charge(constGasFunc(gas.Schedule.SstoreClear))
 		// return params.SstoreClearGas, nil
 	} else {
 		// non 0 => non 0 (or 0 => 0)
-		gas.Charge(constGasFunc(params.SstoreResetGas))
+		gas.Charge(constGasFunc(gas.Schedule.SstoreReset))
 		// return params.SstoreResetGas, nil
 	}
 }
@@ -529,7 +533,7 @@ func (gas GasCounter) GasLoad() {
 }
 
 func (gas GasCounter) GasEcrecover() {
-	gas.Charge(constGasFunc(params.EcrecoverGas))
+	gas.Charge(constGasFunc(gas.Schedule.Ecrecover))
 }
 
 func (gas GasCounter) GasPow(exponent *big.Int) {
@@ -538,7 +542,7 @@ func (gas GasCounter) GasPow(exponent *big.Int) {
 		costgas  = expByteLen * gas.GasTable.ExpByte // no overflow check required. Max is 256 * ExpByte gas
 		overflow bool
 	)
-	if costgas, overflow = math.SafeAdd(costgas, vm.GasQuickStep); overflow {
+	if costgas, overflow = math.SafeAdd(costgas, gas.Schedule.QuickStep); overflow {
 		panic(errGasUintOverflow)
 	}
 	gas.Charge(constGasFunc(costgas))
@@ -549,23 +553,23 @@ func (gas GasCounter) GasCostZero() {
 }
 
 func (gas GasCounter) GasReturnAddress() {
-	gas.AdjustedCharge(constGasFunc(WasmCostsStaticAddress))
+	gas.AdjustedCharge(constGasFunc(gas.Schedule.ReturnAddress))
 }
 
 func (gas GasCounter) GasReturnU256() {
-	gas.AdjustedCharge(constGasFunc(WasmCostsStaticU256))
+	gas.AdjustedCharge(constGasFunc(gas.Schedule.ReturnU256))
 }
 
 func (gas GasCounter) GasReturnHash() {
-	gas.AdjustedCharge(constGasFunc(WasmCostsStaticHash))
+	gas.AdjustedCharge(constGasFunc(gas.Schedule.ReturnHash))
 }
 
 func (gas GasCounter) GasReturnPointer(size uint64) {
-	gas.AdjustedCharge(constGasFunc(size))
+	gas.AdjustedCharge(constGasFunc(gas.Schedule.ReturnByte * size))
 }
 
 func (gas GasCounter) GasInitialMemory(initial uint64) {
-	amount := initial * WasmCostsInitialMem
+	amount := initial * gas.Schedule.InitialMem
 	if !gas.ChargeGas(amount) {
 		panic(ErrorInitialMemLimit)
 	}
@@ -0,0 +1,214 @@
+// Copyright 2019 The darmasuite Authors
+// This file is part of the darmasuite library.
+//
+// The darmasuite library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The darmasuite library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the darmasuite library. If not, see <http://www.gnu.org/licenses/>.
+
+package wavm
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/darmaproject/darma-wasm/darma"
+	"github.com/darmaproject/darma-wasm/wasm"
+	"github.com/darmaproject/darmasuite/dvm/accounts/abi"
+	"github.com/darmaproject/darmasuite/dvm/common"
+	wasmcontract "github.com/darmaproject/darmasuite/dvm/core/wavm/contract"
+	"github.com/darmaproject/darmasuite/dvm/crypto"
+	"github.com/hashicorp/golang-lru/simplelru"
+)
+
+// moduleCacheLimit is the number of bytes of decoded contract code kept in
+// the module cache.
+const moduleCacheLimit = 64 * 1024 * 1024
+
+// modules caches the decoded form of deployed contracts for every WAVM in the
+// process, so that eth_call and block processing share it.
+var modules = newModuleCache(moduleCacheLimit)
+
+// cachedModule is everything runWavm derives from the stored contract code
+// before execution starts. It is a pure function of the code and of the WAVM
+// limits, so entries are keyed by moduleKey and never go stale on their own.
+// Entries are shared by concurrent executions and must not be modified,
+// executions get a copy from bind.
+type cachedModule struct {
+	code     wasmcontract.WasmCode
+	abi      abi.ABI
+	module   *wasm.Module
+	mutable  Mutable
+	compiled []darma.Compiled
+	size     int
+}
+
+func newCachedModule(code wasmcontract.WasmCode, abi abi.ABI, module *wasm.Module, mutable Mutable, compiled []darma.Compiled) *cachedModule {
+	return &cachedModule{
+		code:     code,
+		abi:      abi,
+		module:   module,
+		mutable:  mutable,
+		compiled: compiled,
+		size:     len(code.Code) + len(code.Abi) + len(code.Compiled),
+	}
+}
+
+// bind returns a copy of the cached module and of its compiled code for a
+// single execution, with the imported functions resolved against env instead
+// of the env module of the execution that filled the cache. Host functions
+// close over their ChainContext, so a module must never be shared between
+// executions without rebinding.
+func (c *cachedModule) bind(env *wasm.Module) (*wasm.Module, []darma.Compiled, error) {
+	bound := copyModule(c.module)
+	if c.module.Import != nil {
+		// imported functions come first in the function index space, in the
+		// order they are declared in the import section.
+		index := 0
+		for _, entry := range c.module.Import.Entries {
+			if entry.Type.Kind() != wasm.ExternalFunction {
+				continue
+			}
+			export, ok := env.Export.Entries[entry.FieldName]
+			if !ok {
+				return nil, nil, fmt.Errorf("module cache: env function %s not found", entry.FieldName)
+			}
+			bound.FunctionIndexSpace[index] = env.FunctionIndexSpace[export.Index]
+			index++
+		}
+	}
+	return bound, copyCompiled(c.compiled), nil
+}
+
+// copyModule copies the parts of m an execution may modify: the index spaces
+// the interpreter is instantiated from, and the memory and table sections
+// that memory.grow and the limits work on. The other sections are only read.
+func copyModule(m *wasm.Module) *wasm.Module {
+	c := *m
+	c.FunctionIndexSpace = append([]wasm.Function(nil), m.FunctionIndexSpace...)
+	c.GlobalIndexSpace = append([]wasm.GlobalEntry(nil), m.GlobalIndexSpace...)
+	c.TableIndexSpace = make([][]uint32, len(m.TableIndexSpace))
+	for i, table := range m.TableIndexSpace {
+		c.TableIndexSpace[i] = append([]uint32(nil), table...)
+	}
+	c.LinearMemoryIndexSpace = make([][]byte, len(m.LinearMemoryIndexSpace))
+	for i, memory := range m.LinearMemoryIndexSpace {
+		c.LinearMemoryIndexSpace[i] = append([]byte(nil), memory...)
+	}
+	if m.Memory != nil {
+		memory := *m.Memory
+		memory.Entries = append([]wasm.Memory(nil), m.Memory.Entries...)
+		c.Memory = &memory
+	}
+	if m.Table != nil {
+		table := *m.Table
+		table.Entries = append([]wasm.Table(nil), m.Table.Entries...)
+		c.Table = &table
+	}
+	return &c
+}
+
+// copyCompiled copies the code and branch tables of compiled, which the
+// interpreter of an execution takes over and patches.
+func copyCompiled(compiled []darma.Compiled) []darma.Compiled {
+	c := make([]darma.Compiled, len(compiled))
+	for i, fn := range compiled {
+		c[i] = fn
+		c[i].Code = append([]byte(nil), fn.Code...)
+		c[i].Table = make([]*darma.BranchTable, len(fn.Table))
+		for j, table := range fn.Table {
+			if table == nil {
+				continue
+			}
+			t := *table
+			t.Targets = append([]darma.Target(nil), table.Targets...)
+			t.PatchedAddrs = append([]int64(nil), table.PatchedAddrs...)
+			c[i].Table[j] = &t
+		}
+	}
+	return c
+}
+
+// moduleCache is an LRU cache of decoded contracts bounded by the total size
+// of their code. It is safe for concurrent use.
+type moduleCache struct {
+	lock  sync.Mutex
+	lru   *simplelru.LRU
+	size  int
+	limit int
+}
+
+func newModuleCache(limit int) *moduleCache {
+	c := &moduleCache{limit: limit}
+	// entries are bounded by size, not by count
+	c.lru, _ = simplelru.NewLRU(math.MaxInt32, func(key, value interface{}) {
+		c.size -= value.(*cachedModule).size
+	})
+	return c
+}
+
+// moduleKey is the key of code run under cfg in the module cache. Deployed
+// code is compiled again under the limits, which are part of the key then.
+func moduleKey(codeHash common.Hash, cfg Config) common.Hash {
+	if !limitsActive(cfg) {
+		return codeHash
+	}
+	limits := fmt.Sprintf("%d/%d/%d", cfg.MaxMemoryPages, cfg.MaxFunctionDepth, cfg.MaxValueSlots)
+	return crypto.Keccak256Hash(codeHash[:], []byte(limits))
+}
+
+func (c *moduleCache) get(codeHash common.Hash) (*cachedModule, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if value, ok := c.lru.Get(codeHash); ok {
+		return value.(*cachedModule), true
+	}
+	return nil, false
+}
+
+func (c *moduleCache) add(codeHash common.Hash, m *cachedModule) {
+	if m.size > c.limit {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.lru.Contains(codeHash) {
+		return
+	}
+	c.lru.Add(codeHash, m)
+	c.size += m.size
+	for c.size > c.limit {
+		c.lru.RemoveOldest()
+	}
+}
+
+func (c *moduleCache) len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.lru.Len()
+}
+
+func (c *moduleCache) purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.lru.Purge()
+	c.size = 0
+}
+
+// PurgeModuleCache drops every decoded contract from the module cache.
+func PurgeModuleCache() {
+	modules.purge()
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 The darmasuite Authors
+// This file is part of the darmasuite library.
+//
+// The darmasuite library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The darmasuite library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the darmasuite library. If not, see <http://www.gnu.org/licenses/>.
+
+package wavm
+
+import (
+	"testing"
+
+	"github.com/darmaproject/darma-wasm/darma"
+	"github.com/darmaproject/darma-wasm/wasm"
+	"github.com/darmaproject/darmasuite/dvm/common"
+	wasmcontract "github.com/darmaproject/darmasuite/dvm/core/wavm/contract"
+)
+
+func testCachedModule(size int) *cachedModule {
+	return newCachedModule(wasmcontract.WasmCode{Code: make([]byte, size)}, readAbi(debugAbiPath), nil, nil, nil)
+}
+
+func TestModuleCacheSizeLimit(t *testing.T) {
+	cache := newModuleCache(100)
+	cache.add(common.Hash{1}, testCachedModule(40))
+	cache.add(common.Hash{2}, testCachedModule(40))
+	// touch the first entry so the second one is the least recently used
+	if _, ok := cache.get(common.Hash{1}); !ok {
+		t.Fatal("expected entry 1 to be cached")
+	}
+	cache.add(common.Hash{3}, testCachedModule(40))
+
+	if _, ok := cache.get(common.Hash{2}); ok {
+		t.Error("expected least recently used entry to be evicted")
+	}
+	if _, ok := cache.get(common.Hash{1}); !ok {
+		t.Error("expected entry 1 to survive eviction")
+	}
+	if cache.size != 80 {
+		t.Errorf("cache size %d, want 80", cache.size)
+	}
+
+	cache.add(common.Hash{4}, testCachedModule(101))
+	if _, ok := cache.get(common.Hash{4}); ok {
+		t.Error("didn't expect an entry larger than the cache to be added")
+	}
+
+	cache.purge()
+	if cache.len() != 0 || cache.size != 0 {
+		t.Errorf("expected empty cache after purge, have %d entries of %d bytes", cache.len(), cache.size)
+	}
+}
+
+func TestBindCopiesInstanceState(t *testing.T) {
+	module := &wasm.Module{
+		Memory:                 &wasm.SectionMemories{Entries: []wasm.Memory{{Limits: wasm.ResizableLimits{Initial: 1}}}},
+		Table:                  &wasm.SectionTables{Entries: []wasm.Table{{Limits: wasm.ResizableLimits{Initial: 2}}}},
+		GlobalIndexSpace:       []wasm.GlobalEntry{{Init: []byte{0x41, 0x00, 0x0b}}},
+		TableIndexSpace:        [][]uint32{{0, 1}},
+		LinearMemoryIndexSpace: [][]byte{make([]byte, 4)},
+	}
+	compiled := []darma.Compiled{{
+		Code:  []byte{0x01, 0x02},
+		Table: []*darma.BranchTable{{Targets: []darma.Target{{Addr: 1}}}},
+	}}
+	cached := newCachedModule(wasmcontract.WasmCode{}, readAbi(debugAbiPath), module, nil, compiled)
+
+	bound, boundCompiled, err := cached.bind(&wasm.Module{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bound.Memory.Entries[0].Limits.Initial = 2
+	bound.Table.Entries[0].Limits.Initial = 4
+	bound.TableIndexSpace[0][0] = 1
+	bound.LinearMemoryIndexSpace[0][0] = 1
+	boundCompiled[0].Code[0] = 0xff
+	boundCompiled[0].Table[0].Targets[0].Addr = 2
+
+	if module.Memory.Entries[0].Limits.Initial != 1 || module.Table.Entries[0].Limits.Initial != 2 {
+		t.Error("expected the cached memory and table sections to be left alone")
+	}
+	if module.TableIndexSpace[0][0] != 0 || module.LinearMemoryIndexSpace[0][0] != 0 {
+		t.Error("expected the cached index spaces to be left alone")
+	}
+	if compiled[0].Code[0] != 0x01 || compiled[0].Table[0].Targets[0].Addr != 1 {
+		t.Error("expected the cached compiled code to be left alone")
+	}
+}
@@ -219,16 +219,75 @@ func (wavm *Wavm) InstantiateModule(code []byte, memory []uint8) error {
 			log.Error("could not verify module", "err", err)
 			return err
 		}
+		err = verifyLimits(m, wavm.WavmConfig)
+		if err != nil {
+			log.Error("module exceeds the wavm limits", "err", err)
+			return err
+		}
 	}
 	if m.Export == nil {
 		log.Error("module has no export section", "export", "nil")
 		return errors.New("module has no export section")
 	}
+	if !wavm.IsCreated {
+		// deployed code may count its call depth, CompileModule adds the
+		// global for the code it compiles
+		addCallDepthGlobal(m)
+	}
 	wavm.Module = m
 	// m.PrintDetails()
 	return nil
 }
 
+// verifyLimits checks the memory and table a module declares against the
+// limits in cfg.
+func verifyLimits(m *wasm.Module, cfg Config) error {
+	if cfg.MaxMemoryPages > 0 && m.Memory != nil && len(m.Memory.Entries) != 0 {
+		limits := m.Memory.Entries[0].Limits
+		if uint64(limits.Initial) > uint64(cfg.MaxMemoryPages) {
+			return fmt.Errorf("initial memory of %d pages exceeds the limit of %d", limits.Initial, cfg.MaxMemoryPages)
+		}
+		if limits.Flags&0x1 != 0 && uint64(limits.Maximum) > uint64(cfg.MaxMemoryPages) {
+			return fmt.Errorf("maximum memory of %d pages exceeds the limit of %d", limits.Maximum, cfg.MaxMemoryPages)
+		}
+	}
+	if cfg.MaxTableSize > 0 && m.Table != nil && len(m.Table.Entries) != 0 {
+		limits := m.Table.Entries[0].Limits
+		if uint64(limits.Initial) > uint64(cfg.MaxTableSize) {
+			return fmt.Errorf("table size %d exceeds the limit of %d", limits.Initial, cfg.MaxTableSize)
+		}
+		if limits.Flags&0x1 != 0 && uint64(limits.Maximum) > uint64(cfg.MaxTableSize) {
+			return fmt.Errorf("maximum table size %d exceeds the limit of %d", limits.Maximum, cfg.MaxTableSize)
+		}
+	}
+	// memory.grow and the call depth are limited by the gas charges
+	// CompileModule adds, which need the AddGas import
+	if cfg.MaxMemoryPages > 0 || cfg.MaxFunctionDepth > 0 {
+		if m.Import == nil {
+			return errors.New("module doesn't import AddGas, its resources can't be limited")
+		}
+		if _, _, gasIndex := utils.GetIndex(m); gasIndex == -1 {
+			return errors.New("module doesn't import AddGas, its resources can't be limited")
+		}
+	}
+	return nil
+}
+
+// verifyValueSlots checks the compiled functions against the value slot
+// limit of cfg before every execution, contracts compiled before the limit
+// was enforced included.
+func verifyValueSlots(compiled []darma.Compiled, cfg Config) error {
+	if cfg.MaxValueSlots <= 0 {
+		return nil
+	}
+	for i, fn := range compiled {
+		if slots := fn.TotalLocalVars + fn.MaxDepth; slots > cfg.MaxValueSlots {
+			return fmt.Errorf("function %d needs %d value slots, the limit is %d", i, slots, cfg.MaxValueSlots)
+		}
+	}
+	return nil
+}
+
 func (wavm *Wavm) Apply(input []byte, compiled []darma.Compiled, mutable Mutable) (res []byte, err error) {
 	rlog.Info("---wavm.Apply---")
 	// Catch all the panic and transform it into an error
@@ -236,8 +295,20 @@ func (wavm *Wavm) Apply(input []byte, compiled []darma.Compiled, mutable Mutable
 		if r := recover(); r != nil {
 			rlog.Error("Got error during wasm execution.", "err", r)
 			rlog.Debugf("stack: %s", debug.Stack())
-			res = nil
-			err = fmt.Errorf("%s", r)
+			if rev, ok := r.(*revertError); ok {
+				// Revert keeps its reason as the return data of the call
+				res = rev.data
+				err = vm.ErrExecutionReverted
+			} else if r == gas.ErrorGasLimit && wavm.WavmConfig.ReturnOnGasLimitExceeded {
+				res = nil
+				err = vm.ErrOutOfGas
+			} else if r == vm.ErrExecutionAborted {
+				res = nil
+				err = vm.ErrExecutionAborted
+			} else {
+				res = nil
+				err = fmt.Errorf("%s", r)
+			}
 			if wavm.WavmConfig.Debug == true {
 				if wavm.VM == nil {
 					wavm.captrueFault(uint64(0), err)
@@ -250,15 +321,22 @@ func (wavm *Wavm) Apply(input []byte, compiled []darma.Compiled, mutable Mutable
 	wavm.MutableList = mutable
 
 	//initialize the gas cost for initial memory when create contract before create Interpreter
-	//todo memory grow
+	//memory grow is charged by the code CompileModule injects in front of it
 	if wavm.ChainContext.IsCreated == true {
 		memSize := uint64(1)
+		if wavm.WavmConfig.DefaultMemoryPages > 0 {
+			memSize = uint64(wavm.WavmConfig.DefaultMemoryPages)
+		}
 		if len(wavm.Module.Memory.Entries) != 0 {
 			memSize = uint64(wavm.Module.Memory.Entries[0].Limits.Initial)
 		}
 		wavm.ChainContext.GasCounter.GasInitialMemory(memSize)
 	}
 
+	if err = verifyValueSlots(compiled, wavm.WavmConfig); err != nil {
+		return nil, err
+	}
+
 	var vm *exec.Interpreter
 	vm, err = exec.NewInterpreter(wavm.Module, compiled, instantiateMemory, wavm.captureOp, wavm.captureEnvFunctionStart, wavm.captureEnvFunctionEnd, wavm.WavmConfig.Debug)
 	if err != nil {
@@ -267,13 +345,6 @@ func (wavm *Wavm) Apply(input []byte, compiled []darma.Compiled, mutable Mutable
 	}
 
 	wavm.VM = vm
-	// gas := wavm.ChainContext.Contract.Gas
-	// adjustedGas := uint64(gas * exec.WasmCostsOpcodesDiv / exec.WasmCostsOpcodesMul)
-	// if adjustedGas > math.MaxUint64 {
-	// 	return nil, fmt.Errorf("Wasm interpreter cannot run contracts with gas (wasm adjusted) >= 2^64")
-	// }
-	//
-	// vm.Contract.Gas = adjustedGas
 
 	res, err = wavm.ExecCodeWithFuncName(input)
 	if err != nil {
@@ -495,6 +566,19 @@ func (wavm *Wavm) GetFuncName() string {
 	return wavm.currentFuncName
 }
 
+// importsFunction reports whether the module imports the env function name.
+func (wavm *Wavm) importsFunction(name string) bool {
+	if wavm.Module == nil || wavm.Module.Import == nil {
+		return false
+	}
+	for _, entry := range wavm.Module.Import.Entries {
+		if entry.FieldName == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (wavm *Wavm) SetFuncName(name string) {
 	wavm.currentFuncName = name
 }
@@ -0,0 +1,455 @@
+// Copyright 2019 The darmasuite Authors
+// This file is part of the darmasuite library.
+//
+// The darmasuite library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The darmasuite library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the darmasuite library. If not, see <http://www.gnu.org/licenses/>.
+
+package runtime
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	ops "github.com/darmaproject/darma-wasm/wasm/operators"
+	"github.com/darmaproject/darmasuite/dvm/common"
+	"github.com/darmaproject/darmasuite/dvm/core/rawdb"
+	"github.com/darmaproject/darmasuite/dvm/core/state"
+	"github.com/darmaproject/darmasuite/dvm/core/wavm"
+	"github.com/darmaproject/darmasuite/dvm/core/wavm/utils"
+	"github.com/darmaproject/darmasuite/dvm/params"
+)
+
+// The gas calibration suite runs a loop over one instruction class or host
+// function per case and reports the time spent per unit of gas it was
+// charged. A balanced gas schedule gives every case about the same ns/gas;
+// a case well above the others is underpriced. The loop case measures the
+// loop alone and is the baseline the other cases are compared to.
+//
+//     go test -run NONE -bench GasCalibration ./dvm/core/wavm/runtime
+//
+// The instruction and host step costs of WAVMGasScheduleV2 are to be derived
+// from the time each case takes on the reference hardware. Measure it with
+//
+//     go test -run GasScheduleV2 -calibrate ./dvm/core/wavm/runtime
+//
+// which writes the data to testdata/gas_calibration.json. Commit the data
+// along with the schedule; TestGasScheduleV2 fails when the schedule no
+// longer matches it. Until the data is committed the V2 prices are
+// placeholders, and the test only checks V2 stays off in the defaults.
+
+const (
+	calibrationIterations = 100
+	calibrationUnroll     = 16
+)
+
+var calibrate = flag.Bool("calibrate", false, "measure the gas calibration cases and write "+calibrationFile)
+
+var calibrationFile = filepath.Join("testdata", "gas_calibration.json")
+
+const (
+	valueI32 = 0x7f
+	valueI64 = 0x7e
+)
+
+// calibrationSigs are the signatures of the env functions the cases import.
+var calibrationSigs = map[string][2][]byte{
+	"AddGas":         {{valueI64}, {}},
+	"GetBlockNumber": {{}, {valueI64}},
+	"GetBlockHash":   {{valueI64}, {valueI32}},
+	"GetSender":      {{}, {valueI32}},
+	"SHA3":           {{valueI32}, {valueI32}},
+	"Concat":         {{valueI32, valueI32}, {valueI32}},
+	"U256FromU64":    {{valueI64}, {valueI32}},
+	"U256_Add":       {{valueI32, valueI32}, {valueI32}},
+}
+
+const calibrationAbi = `[
+	{"name": "init", "constant": false, "inputs": [], "outputs": [], "type": "constructor"},
+	{"name": "run", "constant": false, "inputs": [], "outputs": [], "type": "function"}
+]`
+
+type calibrationCase struct {
+	name    string
+	imports []string // env functions besides AddGas, called by index from 1 on
+	body    []byte   // loop body, leaves the operand stack as it found it
+}
+
+// The run function has an i32 loop counter in local 0 and an i64
+// accumulator in local 1.
+var calibrationCases = []calibrationCase{
+	{name: "loop"},
+	{name: "add", body: []byte{ops.GetLocal, 1, ops.I64Const, 3, ops.I64Add, ops.SetLocal, 1}},
+	{name: "mul", body: []byte{ops.GetLocal, 1, ops.I64Const, 3, ops.I64Mul, ops.SetLocal, 1}},
+	{name: "div", body: []byte{ops.GetLocal, 1, ops.I64Const, 3, ops.I64DivU, ops.SetLocal, 1}},
+	{name: "load", body: []byte{ops.I32Const, 8, ops.I64Load, 3, 0, ops.GetLocal, 1, ops.I64Add, ops.SetLocal, 1}},
+	{name: "store", body: []byte{ops.I32Const, 8, ops.GetLocal, 1, ops.I64Store, 3, 0}},
+	{
+		name:    "GetBlockNumber",
+		imports: []string{"GetBlockNumber"},
+		body:    []byte{ops.Call, 1, ops.Drop},
+	},
+	{
+		name:    "GetBlockHash",
+		imports: []string{"GetBlockHash"},
+		body:    []byte{ops.I64Const, 0, ops.Call, 1, ops.Drop},
+	},
+	{
+		name:    "GetSender",
+		imports: []string{"GetSender"},
+		body:    []byte{ops.Call, 1, ops.Drop},
+	},
+	{
+		name:    "SHA3",
+		imports: []string{"GetSender", "SHA3"},
+		body:    []byte{ops.Call, 1, ops.Call, 2, ops.Drop},
+	},
+	{
+		name:    "Concat",
+		imports: []string{"GetSender", "Concat"},
+		body:    []byte{ops.Call, 1, ops.Call, 1, ops.Call, 2, ops.Drop},
+	},
+	{
+		name:    "U256_Add",
+		imports: []string{"U256FromU64", "U256_Add"},
+		body:    []byte{ops.I64Const, 7, ops.Call, 1, ops.I64Const, 9, ops.Call, 1, ops.Call, 2, ops.Drop},
+	},
+}
+
+// module assembles the contract of the case: the env imports, 16 pages of
+// memory for the values host functions return, an empty init constructor
+// and the run function.
+func (c calibrationCase) module() []byte {
+	imports := append([]string{"AddGas"}, c.imports...)
+
+	types := [][]byte{funcType(nil, nil)}
+	var importEntries [][]byte
+	for i, name := range imports {
+		sig := calibrationSigs[name]
+		types = append(types, funcType(sig[0], sig[1]))
+		entry := append(wasmName("env"), wasmName(name)...)
+		importEntries = append(importEntries, append(entry, 0x00, byte(i+1)))
+	}
+
+	run := []byte{ops.I32Const}
+	run = append(run, sleb128(calibrationIterations)...)
+	run = append(run, ops.SetLocal, 0, ops.Loop, 0x40)
+	for i := 0; i < calibrationUnroll; i++ {
+		run = append(run, c.body...)
+	}
+	run = append(run,
+		ops.GetLocal, 0, ops.I32Const, 1, ops.I32Sub, ops.TeeLocal, 0, ops.BrIf, 0,
+		ops.End, ops.End)
+
+	first := byte(len(imports))
+	var m []byte
+	m = append(m, 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00)
+	m = append(m, wasmSection(1, types...)...)
+	m = append(m, wasmSection(2, importEntries...)...)
+	m = append(m, wasmSection(3, []byte{0}, []byte{0})...)
+	m = append(m, wasmSection(5, []byte{0x00, 0x10})...)
+	m = append(m, wasmSection(7,
+		append(wasmName("init"), 0x00, first),
+		append(wasmName("run"), 0x00, first+1))...)
+	m = append(m, wasmSection(10,
+		funcBody(nil, []byte{ops.End}),
+		funcBody([]byte{0x02, 0x01, valueI32, 0x01, valueI64}, run))...)
+	return m
+}
+
+func funcType(params, results []byte) []byte {
+	t := []byte{0x60}
+	t = append(t, uleb128(uint64(len(params)))...)
+	t = append(t, params...)
+	t = append(t, uleb128(uint64(len(results)))...)
+	return append(t, results...)
+}
+
+func funcBody(locals, code []byte) []byte {
+	if locals == nil {
+		locals = []byte{0x00}
+	}
+	body := append(locals, code...)
+	return append(uleb128(uint64(len(body))), body...)
+}
+
+func wasmName(name string) []byte {
+	return append(uleb128(uint64(len(name))), name...)
+}
+
+func wasmSection(id byte, entries ...[]byte) []byte {
+	content := uleb128(uint64(len(entries)))
+	for _, e := range entries {
+		content = append(content, e...)
+	}
+	section := append([]byte{id}, uleb128(uint64(len(content)))...)
+	return append(section, content...)
+}
+
+func uleb128(v uint64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if v == 0 {
+			return out
+		}
+	}
+}
+
+func sleb128(v int64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+// gasScheduleConfigs returns chain configs running under each WAVM gas
+// schedule.
+func gasScheduleConfigs() map[string]*params.ChainConfig {
+	return map[string]*params.ChainConfig{
+		"v1": {ChainID: big.NewInt(1), WAVMLimitsBlock: new(big.Int)},
+		"v2": {ChainID: big.NewInt(1), WAVMLimitsBlock: new(big.Int), WAVMGasV2Block: new(big.Int)},
+	}
+}
+
+// deployCalibration creates the contract of c under chainConfig and returns
+// a config to call it with and the input of its run function.
+func deployCalibration(t testing.TB, c calibrationCase, chainConfig *params.ChainConfig) (*Config, common.Address, []byte) {
+	abiobj, err := wavm.GetAbi([]byte(calibrationAbi))
+	if err != nil {
+		t.Fatal(err)
+	}
+	input, err := abiobj.Pack("run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	cfg := &Config{
+		State:       statedb,
+		Origin:      common.HexToAddress("0xaaaa"),
+		GasLimit:    100000000,
+		ChainConfig: chainConfig,
+	}
+	code := utils.CompressWasmAndAbi([]byte(calibrationAbi), c.module(), nil)
+	_, address, _, err := Create(code, cfg)
+	if err != nil {
+		t.Fatalf("%s: didn't expect error %v", c.name, err)
+	}
+	return cfg, address, input
+}
+
+func callGasUsed(t testing.TB, cfg *Config, address common.Address, input []byte) uint64 {
+	_, leftOverGas, err := Call(address, input, cfg)
+	if err != nil {
+		t.Fatal("didn't expect error", err)
+	}
+	return cfg.GasLimit - leftOverGas
+}
+
+func calibrationCaseNamed(name string) calibrationCase {
+	for _, c := range calibrationCases {
+		if c.name == name {
+			return c
+		}
+	}
+	panic("unknown calibration case " + name)
+}
+
+func TestGasScheduleVersions(t *testing.T) {
+	configs := gasScheduleConfigs()
+	div := calibrationCaseNamed("div")
+
+	cfg, address, input := deployCalibration(t, div, configs["v1"])
+	v1 := callGasUsed(t, cfg, address, input)
+
+	// a contract keeps the instruction costs it was created with
+	cfg.ChainConfig = configs["v2"]
+	if used := callGasUsed(t, cfg, address, input); used != v1 {
+		t.Errorf("expected v1 contract to use %d gas under v2, used %d", v1, used)
+	}
+
+	cfg, address, input = deployCalibration(t, div, configs["v2"])
+	if used := callGasUsed(t, cfg, address, input); used >= v1 {
+		t.Errorf("expected v2 division to be cheaper than %d gas, used %d", v1, used)
+	}
+
+	// host functions follow the schedule of the executing block
+	sha3 := calibrationCaseNamed("SHA3")
+	cfg, address, input = deployCalibration(t, sha3, configs["v1"])
+	v1 = callGasUsed(t, cfg, address, input)
+	cfg.ChainConfig = configs["v2"]
+	if used := callGasUsed(t, cfg, address, input); used == v1 {
+		t.Errorf("expected v2 to reprice SHA3 calls of a v1 contract, used %d gas", used)
+	}
+}
+
+func BenchmarkGasCalibration(b *testing.B) {
+	configs := gasScheduleConfigs()
+	for _, version := range []string{"v1", "v2"} {
+		for _, c := range calibrationCases {
+			chainConfig, c := configs[version], c
+			b.Run(version+"/"+c.name, func(b *testing.B) {
+				cfg, address, input := deployCalibration(b, c, chainConfig)
+
+				var used uint64
+				b.ResetTimer()
+				start := time.Now()
+				for i := 0; i < b.N; i++ {
+					used += callGasUsed(b, cfg, address, input)
+				}
+				elapsed := time.Since(start)
+				b.StopTimer()
+
+				b.ReportMetric(float64(used)/float64(b.N), "gas/op")
+				b.ReportMetric(float64(elapsed.Nanoseconds())/float64(used), "ns/gas")
+			})
+		}
+	}
+}
+
+// calibrationData is the time the calibration cases take on the machine
+// that measured them.
+type calibrationData struct {
+	GOOS       string             `json:"goos"`
+	GOARCH     string             `json:"goarch"`
+	NumCPU     int                `json:"numcpu"`
+	GoVersion  string             `json:"go"`
+	Iterations int                `json:"iterations"`
+	Unroll     int                `json:"unroll"`
+	NsPerOp    map[string]float64 `json:"nsPerOp"` // per call of the run function
+}
+
+// measureCalibration times the run function of every case. The schedule a
+// case is created under only changes the amounts AddGas is called with, not
+// the number of calls, so the timings hold for any schedule.
+func measureCalibration(t *testing.T) *calibrationData {
+	data := &calibrationData{
+		GOOS:       runtime.GOOS,
+		GOARCH:     runtime.GOARCH,
+		NumCPU:     runtime.NumCPU(),
+		GoVersion:  runtime.Version(),
+		Iterations: calibrationIterations,
+		Unroll:     calibrationUnroll,
+		NsPerOp:    make(map[string]float64),
+	}
+	chainConfig := gasScheduleConfigs()["v1"]
+	for _, c := range calibrationCases {
+		cfg, address, input := deployCalibration(t, c, chainConfig)
+		result := testing.Benchmark(func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := Call(address, input, cfg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		data.NsPerOp[c.name] = float64(result.NsPerOp())
+	}
+	return data
+}
+
+// perBody returns the time one loop body of the case takes, without the
+// loop around it.
+func (data *calibrationData) perBody(name string) float64 {
+	bodies := float64(data.Iterations * data.Unroll)
+	return (data.NsPerOp[name] - data.NsPerOp["loop"]) / bodies
+}
+
+// deriveGasSchedule prices the instruction classes and host steps of base
+// from the calibration data. A regular instruction costs 1 gas, which sets
+// the time per unit of gas from the add case; every other case is priced to
+// take that same time per unit of gas. Costs the calibration cases don't
+// isolate, such as the return costs, are kept from base.
+func deriveGasSchedule(base params.WAVMGasSchedule, data *calibrationData) params.WAVMGasSchedule {
+	nsPerGas := data.perBody("add") / 4
+	gas := func(name string) float64 {
+		return data.perBody(name) / nsPerGas
+	}
+	price := func(g float64) uint64 {
+		if g < 1 {
+			return 1
+		}
+		return uint64(math.Round(g))
+	}
+
+	derived := base
+	derived.Regular = 1
+	// get_local, i64.const, the instruction and set_local
+	derived.Mul = price(gas("mul") - 3)
+	derived.Div = price(gas("div") - 3)
+	// the load next to i32.const, get_local, i64.add and set_local; the
+	// store next to i32.const and get_local
+	derived.Mem = price((gas("load") - 4 + gas("store") - 2) / 2)
+	// call and drop
+	derived.QuickStep = price(gas("GetBlockNumber") - 2)
+	// i64.const, call and drop, returning a hash
+	derived.ExtStep = price(gas("GetBlockHash") - 3 - float64(base.ReturnHash))
+	// two U256FromU64 calls and U256_Add, each a FastestStep returning a
+	// U256, next to two i64.const, three calls and drop
+	derived.FastestStep = price((gas("U256_Add") - 6 - 3*float64(base.ReturnU256)) / 3)
+	return derived
+}
+
+func TestGasScheduleV2(t *testing.T) {
+	if *calibrate {
+		data := measureCalibration(t)
+		blob, err := json.MarshalIndent(data, "", "\t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(calibrationFile), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(calibrationFile, append(blob, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blob, err := ioutil.ReadFile(calibrationFile)
+	if os.IsNotExist(err) {
+		cfg := new(Config)
+		setDefaults(cfg)
+		if cfg.ChainConfig.WAVMGasV2Block != nil {
+			t.Fatal("WAVMGasScheduleV2 is on in the defaults without calibration data")
+		}
+		t.Skip("no calibration data, measure it with -calibrate on the reference hardware")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := new(calibrationData)
+	if err := json.Unmarshal(blob, data); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("calibration data of %s/%s, %d CPUs, %s", data.GOOS, data.GOARCH, data.NumCPU, data.GoVersion)
+
+	derived := deriveGasSchedule(params.WAVMGasScheduleV2, data)
+	if derived != params.WAVMGasScheduleV2 {
+		t.Errorf("WAVMGasScheduleV2 doesn't match the calibration data\nhave %+v\nwant %+v", params.WAVMGasScheduleV2, derived)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2019 The darmasuite Authors
+// This file is part of the darmasuite library.
+//
+// The darmasuite library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The darmasuite library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the darmasuite library. If not, see <http://www.gnu.org/licenses/>.
+
+package runtime
+
+import (
+	"github.com/darmaproject/darmasuite/dvm/core"
+	"github.com/darmaproject/darmasuite/dvm/core/vm"
+	"github.com/darmaproject/darmasuite/dvm/core/wavm"
+)
+
+func NewEnv(cfg *Config) *wavm.WAVM {
+	context := vm.Context{
+		CanTransferFunc: dvm.CanTransfer,
+		TransferFunc:    dvm.Transfer,
+		TransferExFunc:  dvm.TransferEx,
+		PayoutAddress:   cfg.PayoutAddressFn,
+		GetHash:         cfg.GetHashFn,
+		StringToAddress: cfg.StringToAddressFn,
+		AddressToString: cfg.AddressToStringFn,
+		Origin:          cfg.Origin,
+		Coinbase:        cfg.Coinbase,
+		BlockNumber:     cfg.BlockNumber,
+		Time:            cfg.Time,
+		Difficulty:      cfg.Difficulty,
+		GasLimit:        cfg.GasLimit,
+		GasPrice:        cfg.GasPrice,
+	}
+
+	return wavm.NewWAVM(context, cfg.State, cfg.ChainConfig, cfg.WAVMConfig)
+}
@@ -0,0 +1,107 @@
+// Copyright 2019 The darmasuite Authors
+// This file is part of the darmasuite library.
+//
+// The darmasuite library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The darmasuite library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the darmasuite library. If not, see <http://www.gnu.org/licenses/>.
+
+package runtime
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/darmaproject/darmasuite/dvm/common"
+	"github.com/darmaproject/darmasuite/dvm/core/rawdb"
+	"github.com/darmaproject/darmasuite/dvm/core/state"
+)
+
+// erc20Cases is the subset of tests/erc20.json needed to build call data.
+type erc20Cases struct {
+	TestCase []struct {
+		Tests []struct {
+			Function string `json:"function"`
+			Input    []struct {
+				Data string `json:"data"`
+			} `json:"input"`
+		} `json:"tests"`
+	} `json:"testcase"`
+}
+
+// FuzzCall calls a deployed erc20 contract with arbitrary call data, which
+// exercises the ABI dispatch and argument decoding in ExecCodeWithFuncName.
+// Successful calls to constant methods must leave the state untouched.
+func FuzzCall(f *testing.F) {
+	code, abiobj := loadErc20(f)
+	origin := common.HexToAddress("0xaaaa")
+
+	base, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	base.SetBalance(origin, new(big.Int).Lsh(big.NewInt(1), 100))
+	ctor, err := abiobj.Pack("", big.NewInt(1000000), "bitcoin", "BTC")
+	if err != nil {
+		f.Fatal(err)
+	}
+	_, address, _, err := Create(append(code, ctor...), &Config{State: base, Origin: origin, GasLimit: 10000000})
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	blob, err := ioutil.ReadFile(filepath.Join("..", "tests", "erc20.json"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	var cases erc20Cases
+	if err := json.Unmarshal(blob, &cases); err != nil {
+		f.Fatal(err)
+	}
+	for _, tc := range cases.TestCase {
+		for _, test := range tc.Tests {
+			args := make([]string, len(test.Input))
+			for i, input := range test.Input {
+				args[i] = input.Data
+			}
+			input, err := abiobj.PackStrArgs(test.Function, args...)
+			if err != nil {
+				f.Fatalf("%s: %v", test.Function, err)
+			}
+			f.Add(input, uint64(0))
+		}
+	}
+	f.Add([]byte{}, uint64(0))
+	f.Add([]byte{0x01, 0x02, 0x03}, uint64(1))
+
+	f.Fuzz(func(t *testing.T, input []byte, value uint64) {
+		statedb := base.Copy()
+		cfg := &Config{
+			State:    statedb,
+			Origin:   origin,
+			GasLimit: 10000000,
+			Value:    new(big.Int).SetUint64(value),
+		}
+		before := statedb.IntermediateRoot(false)
+		_, leftOverGas, err := Call(address, input, cfg)
+		if leftOverGas > cfg.GasLimit {
+			t.Fatalf("call returned more gas than it was given: %d > %d", leftOverGas, cfg.GasLimit)
+		}
+		if err != nil || value != 0 || len(input) < 4 {
+			return
+		}
+		if method, err := abiobj.MethodById(input[:4]); err == nil && method.Const {
+			if after := statedb.IntermediateRoot(false); after != before {
+				t.Fatalf("constant method %s modified the state", method.Name)
+			}
+		}
+	})
+}
@@ -0,0 +1,183 @@
+// Copyright 2019 The darmasuite Authors
+// This file is part of the darmasuite library.
+//
+// The darmasuite library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The darmasuite library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the darmasuite library. If not, see <http://www.gnu.org/licenses/>.
+
+package runtime
+
+import (
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/darmaproject/darmasuite/dvm/common"
+	"github.com/darmaproject/darmasuite/dvm/core/rawdb"
+	"github.com/darmaproject/darmasuite/dvm/core/state"
+	"github.com/darmaproject/darmasuite/dvm/core/vm"
+	"github.com/darmaproject/darmasuite/dvm/core/wavm"
+	"github.com/darmaproject/darmasuite/dvm/crypto"
+	"github.com/darmaproject/darmasuite/dvm/params"
+)
+
+// Config is a basic type specifying certain configuration flags for running
+// the WAVM.
+type Config struct {
+	ChainConfig *params.ChainConfig
+	Difficulty  *big.Int
+	Origin      common.Address
+	Coinbase    common.Address
+	BlockNumber *big.Int
+	Time        *big.Int
+	GasLimit    uint64
+	GasPrice    *big.Int
+	Value       *big.Int
+	Debug       bool
+	WAVMConfig  vm.Config
+
+	State             *state.StateDB
+	GetHashFn         func(n uint64) common.Hash
+	StringToAddressFn func(string) []byte
+	AddressToStringFn func([]byte) string
+	PayoutAddressFn   func(string) bool
+}
+
+// sets defaults on the config
+func setDefaults(cfg *Config) {
+	if cfg.ChainConfig == nil {
+		// every fork but WAVMGasV2Block, as WAVMGasScheduleV2 is not
+		// calibrated yet
+		cfg.ChainConfig = &params.ChainConfig{
+			ChainID:              big.NewInt(1),
+			WAVMLimitsBlock:      new(big.Int),
+			ContractPayoutBlock:  new(big.Int),
+			AccountRegistryBlock: new(big.Int),
+		}
+	}
+
+	if cfg.Difficulty == nil {
+		cfg.Difficulty = new(big.Int)
+	}
+	if cfg.Time == nil {
+		cfg.Time = big.NewInt(time.Now().Unix())
+	}
+	if cfg.GasLimit == 0 {
+		cfg.GasLimit = math.MaxUint64
+	}
+	if cfg.GasPrice == nil {
+		cfg.GasPrice = new(big.Int)
+	}
+	if cfg.Value == nil {
+		cfg.Value = new(big.Int)
+	}
+	if cfg.BlockNumber == nil {
+		cfg.BlockNumber = new(big.Int)
+	}
+	if cfg.GetHashFn == nil {
+		cfg.GetHashFn = func(n uint64) common.Hash {
+			return common.BytesToHash(crypto.Keccak256([]byte(new(big.Int).SetUint64(n).String())))
+		}
+	}
+	if cfg.StringToAddressFn == nil {
+		cfg.StringToAddressFn = func(s string) []byte {
+			return common.HexToAddress(s).Bytes()
+		}
+	}
+	if cfg.AddressToStringFn == nil {
+		cfg.AddressToStringFn = func(b []byte) string {
+			return common.BytesToAddress(b).Hex()
+		}
+	}
+	// the interpreter reports every instruction to the tracer in debug mode,
+	// so debugging without an explicit tracer falls back to the wasm logger.
+	if cfg.Debug {
+		cfg.WAVMConfig.Debug = true
+	}
+	if cfg.WAVMConfig.Debug && cfg.WAVMConfig.Tracer == nil {
+		cfg.WAVMConfig.Tracer = wavm.NewWasmLogger(nil)
+	}
+}
+
+// Execute deploys the compressed WASM bundle and calls the new contract using
+// the input as call data. It returns the WAVM's return value, the new state
+// and an error if it failed.
+//
+// Execute sets up an in-memory, temporary, environment for the execution of
+// the given code. Constructor arguments, if any, must already be appended to
+// the bundle.
+func Execute(code, input []byte, cfg *Config) ([]byte, *state.StateDB, error) {
+	if cfg == nil {
+		cfg = new(Config)
+	}
+	setDefaults(cfg)
+
+	if cfg.State == nil {
+		cfg.State, _ = state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	}
+	_, address, _, err := Create(code, cfg)
+	if err != nil {
+		return nil, cfg.State, err
+	}
+	ret, _, err := Call(address, input, cfg)
+
+	return ret, cfg.State, err
+}
+
+// Create deploys the compressed WASM bundle, followed by the constructor
+// arguments, and returns the stored contract code, its address and the gas left.
+func Create(input []byte, cfg *Config) ([]byte, common.Address, uint64, error) {
+	if cfg == nil {
+		cfg = new(Config)
+	}
+	setDefaults(cfg)
+
+	if cfg.State == nil {
+		cfg.State, _ = state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	}
+	var (
+		vmenv  = NewEnv(cfg)
+		sender = vm.AccountRef(cfg.Origin)
+	)
+
+	// Call the code with the given configuration.
+	code, address, leftOverGas, err := vmenv.Create(
+		sender,
+		input,
+		cfg.GasLimit,
+		cfg.Value,
+	)
+	return code, address, leftOverGas, err
+}
+
+// Call executes the contract deployed at address. It will return the WAVM's
+// return value or an error if it failed.
+//
+// Call, unlike Execute, requires a config and also requires the State field to
+// be set.
+func Call(address common.Address, input []byte, cfg *Config) ([]byte, uint64, error) {
+	setDefaults(cfg)
+
+	vmenv := NewEnv(cfg)
+
+	sender := vm.AccountRef(cfg.Origin)
+	// Call the code with the given configuration.
+	ret, leftOverGas, err := vmenv.Call(
+		sender,
+		address,
+		input,
+		cfg.GasLimit,
+		cfg.Value,
+	)
+
+	return ret, leftOverGas, err
+}
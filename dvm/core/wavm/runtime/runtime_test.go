@@ -0,0 +1,522 @@
+// Copyright 2019 The darmasuite Authors
+// This file is part of the darmasuite library.
+//
+// The darmasuite library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The darmasuite library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the darmasuite library. If not, see <http://www.gnu.org/licenses/>.
+
+package runtime
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/darmaproject/darmasuite/dvm/accounts/abi"
+	"github.com/darmaproject/darmasuite/dvm/common"
+	"github.com/darmaproject/darmasuite/dvm/core/rawdb"
+	"github.com/darmaproject/darmasuite/dvm/core/state"
+	"github.com/darmaproject/darmasuite/dvm/core/vm"
+	"github.com/darmaproject/darmasuite/dvm/core/wavm"
+	"github.com/darmaproject/darmasuite/dvm/core/wavm/utils"
+	"github.com/darmaproject/darmasuite/dvm/params"
+)
+
+var (
+	erc20Code = filepath.Join("..", "tests", "erc20", "TokenERC20.compress")
+	erc20Abi  = filepath.Join("..", "tests", "erc20", "abi.json")
+)
+
+func loadErc20(t testing.TB) ([]byte, abi.ABI) {
+	code, err := ioutil.ReadFile(erc20Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	abiJSON, err := ioutil.ReadFile(erc20Abi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	abiobj, err := wavm.GetAbi(abiJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code, abiobj
+}
+
+func TestDefaults(t *testing.T) {
+	cfg := new(Config)
+	setDefaults(cfg)
+
+	if cfg.ChainConfig == nil {
+		t.Error("expected chain config to be non nil")
+	}
+	if cfg.Difficulty == nil {
+		t.Error("expected difficulty to be non nil")
+	}
+	if cfg.Time == nil {
+		t.Error("expected time to be non nil")
+	}
+	if cfg.GasLimit == 0 {
+		t.Error("didn't expect gaslimit to be zero")
+	}
+	if cfg.GasPrice == nil {
+		t.Error("expected gas price to be non nil")
+	}
+	if cfg.Value == nil {
+		t.Error("expected value to be non nil")
+	}
+	if cfg.GetHashFn == nil {
+		t.Error("expected get hash func to be non nil")
+	}
+	if cfg.StringToAddressFn == nil || cfg.AddressToStringFn == nil {
+		t.Error("expected address conversion funcs to be non nil")
+	}
+	if cfg.BlockNumber == nil {
+		t.Error("expected block number to be non nil")
+	}
+	if cfg.WAVMConfig.Tracer != nil {
+		t.Error("didn't expect a tracer without debug")
+	}
+
+	cfg = &Config{Debug: true}
+	setDefaults(cfg)
+	if !cfg.WAVMConfig.Debug || cfg.WAVMConfig.Tracer == nil {
+		t.Error("expected debug to install a tracer")
+	}
+}
+
+func TestCreateAndCall(t *testing.T) {
+	code, abiobj := loadErc20(t)
+	supply := big.NewInt(1000000)
+	ctor, err := abiobj.Pack("", supply, "bitcoin", "BTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	cfg := &Config{
+		State:       statedb,
+		Origin:      common.HexToAddress("0xaaaa"),
+		GasLimit:    10000000,
+		BlockNumber: big.NewInt(10),
+	}
+	_, address, _, err := Create(append(code, ctor...), cfg)
+	if err != nil {
+		t.Fatal("didn't expect error", err)
+	}
+	if len(statedb.GetCode(address)) == 0 {
+		t.Fatal("expected contract code to be stored")
+	}
+
+	input, _ := abiobj.Pack("GetTokenName")
+	ret, _, err := Call(address, input, cfg)
+	if err != nil {
+		t.Fatal("didn't expect error", err)
+	}
+	var name string
+	if err := abiobj.Unpack(&name, "GetTokenName", ret); err != nil {
+		t.Fatal(err)
+	}
+	if name != "bitcoin" {
+		t.Errorf("expected token name bitcoin, got %s", name)
+	}
+
+	input, _ = abiobj.Pack("GetAmount", cfg.Origin)
+	ret, _, err = Call(address, input, cfg)
+	if err != nil {
+		t.Fatal("didn't expect error", err)
+	}
+	amount := new(big.Int)
+	if err := abiobj.Unpack(&amount, "GetAmount", ret); err != nil {
+		t.Fatal(err)
+	}
+	if amount.Cmp(supply) != 0 {
+		t.Errorf("expected balance %v, got %v", supply, amount)
+	}
+}
+
+func TestExecute(t *testing.T) {
+	code, abiobj := loadErc20(t)
+	ctor, _ := abiobj.Pack("", big.NewInt(100), "bitcoin", "BTC")
+	input, _ := abiobj.Pack("GetSymbol")
+
+	ret, statedb, err := Execute(append(code, ctor...), input, &Config{GasLimit: 10000000})
+	if err != nil {
+		t.Fatal("didn't expect error", err)
+	}
+	if statedb == nil {
+		t.Fatal("expected state to be returned")
+	}
+	var symbol string
+	if err := abiobj.Unpack(&symbol, "GetSymbol", ret); err != nil {
+		t.Fatal(err)
+	}
+	if symbol != "BTC" {
+		t.Errorf("expected symbol BTC, got %s", symbol)
+	}
+}
+
+// deployErc20 deploys the erc20 test contract and returns a config whose
+// state holds it.
+func deployErc20(t testing.TB) (*Config, common.Address, abi.ABI) {
+	code, abiobj := loadErc20(t)
+	ctor, _ := abiobj.Pack("", big.NewInt(1000000), "bitcoin", "BTC")
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	cfg := &Config{
+		State:    statedb,
+		Origin:   common.HexToAddress("0xaaaa"),
+		GasLimit: 10000000,
+	}
+	_, address, _, err := Create(append(code, ctor...), cfg)
+	if err != nil {
+		t.Fatal("didn't expect error", err)
+	}
+	return cfg, address, abiobj
+}
+
+func TestConcurrentCalls(t *testing.T) {
+	cfg, address, abiobj := deployErc20(t)
+	input, _ := abiobj.Pack("GetTokenName")
+	wavm.PurgeModuleCache()
+
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, 16)
+		rets = make(chan []byte, 16)
+	)
+	for i := 0; i < 16; i++ {
+		callCfg := *cfg
+		callCfg.State = cfg.State.Copy()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ret, _, err := Call(address, input, &callCfg)
+			if err != nil {
+				errs <- err
+				return
+			}
+			rets <- ret
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	close(rets)
+
+	for err := range errs {
+		t.Error("didn't expect error", err)
+	}
+	for ret := range rets {
+		var name string
+		if err := abiobj.Unpack(&name, "GetTokenName", ret); err != nil {
+			t.Fatal(err)
+		}
+		if name != "bitcoin" {
+			t.Errorf("expected token name bitcoin, got %s", name)
+		}
+	}
+}
+
+// TestConcurrentCachedTransfers runs state changing calls of a cached
+// contract concurrently, run it with -race.
+func TestConcurrentCachedTransfers(t *testing.T) {
+	cfg, address, abiobj := deployErc20(t)
+	// the first call fills the module cache
+	input, _ := abiobj.Pack("transfer", common.HexToAddress("0xbbbb"), big.NewInt(1))
+	warm := *cfg
+	warm.State = cfg.State.Copy()
+	if _, _, err := Call(address, input, &warm); err != nil {
+		t.Fatal("didn't expect error", err)
+	}
+	getAmount, _ := abiobj.Pack("GetAmount", cfg.Origin)
+	ret, _, err := Call(address, getAmount, &warm)
+	if err != nil {
+		t.Fatal("didn't expect error", err)
+	}
+	var balance *big.Int
+	if err := abiobj.Unpack(&balance, "GetAmount", ret); err != nil {
+		t.Fatal(err)
+	}
+	// the transfers below start from the state before the first one
+	balance.Add(balance, big.NewInt(1))
+
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, 16)
+	)
+	for i := 0; i < 16; i++ {
+		callCfg := *cfg
+		callCfg.State = cfg.State.Copy()
+		input, _ := abiobj.Pack("transfer", common.BigToAddress(big.NewInt(int64(0xcccc+i))), big.NewInt(int64(i+1)))
+		want := new(big.Int).Sub(balance, big.NewInt(int64(i+1)))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := Call(address, input, &callCfg); err != nil {
+				errs <- err
+				return
+			}
+			ret, _, err := Call(address, getAmount, &callCfg)
+			if err != nil {
+				errs <- err
+				return
+			}
+			var amount *big.Int
+			if err := abiobj.Unpack(&amount, "GetAmount", ret); err != nil {
+				errs <- err
+				return
+			}
+			if amount.Cmp(want) != 0 {
+				errs <- fmt.Errorf("expected amount %v, got %v", want, amount)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestRevertReturnsReason(t *testing.T) {
+	code, err := ioutil.ReadFile(filepath.Join("..", "tests", "env", "testEnv.compress"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	abiJSON, err := ioutil.ReadFile(filepath.Join("..", "tests", "env", "abi.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	abiobj, err := wavm.GetAbi(abiJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	cfg := &Config{
+		State:    statedb,
+		Origin:   common.HexToAddress("0xaaaa"),
+		GasLimit: 10000000,
+	}
+	_, address, _, err := Create(code, cfg)
+	if err != nil {
+		t.Fatal("didn't expect error", err)
+	}
+
+	input, _ := abiobj.Pack("testRevert")
+	root := statedb.IntermediateRoot(false)
+	ret, leftOverGas, err := Call(address, input, cfg)
+	if err != vm.ErrExecutionReverted {
+		t.Fatalf("expected %v, got %v", vm.ErrExecutionReverted, err)
+	}
+	if _, ok := vm.UnpackRevertReason(ret); !ok {
+		t.Errorf("expected revert reason in return data, got %x", ret)
+	}
+	if leftOverGas == 0 {
+		t.Error("expected revert to leave unused gas")
+	}
+	if statedb.IntermediateRoot(false) != root {
+		t.Error("expected reverted call to leave the state untouched")
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	// a module with nothing but 1000 pages of initial memory
+	module := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x05, 0x04, 0x01, 0x00, 0xe8, 0x07}
+	code := utils.CompressWasmAndAbi([]byte("[]"), module, nil)
+
+	_, _, _, err := Create(code, &Config{GasLimit: 10000000})
+	if err == nil || !strings.Contains(err.Error(), "exceeds the limit") {
+		t.Errorf("expected memory limit error, got %v", err)
+	}
+
+	// before the fork the module gets past validation
+	cfg := &Config{GasLimit: 10000000, ChainConfig: &params.ChainConfig{ChainID: big.NewInt(1)}}
+	_, _, _, err = Create(code, cfg)
+	if err != nil && strings.Contains(err.Error(), "exceeds the limit") {
+		t.Errorf("didn't expect limits before the fork, got %v", err)
+	}
+}
+
+// limitsModule imports AddGas, has a memory of 1 page and exports
+//
+//	init()             the constructor
+//	recurse(n uint32)  calls itself n times
+//	grow(n uint32)     grows the memory by n pages
+var limitsModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x01, 0x0c, 0x03, 0x60, 0x01, 0x7e, 0x00, 0x60,
+	0x00, 0x00, 0x60, 0x01, 0x7f, 0x00, 0x02, 0x0e, 0x01, 0x03, 0x65, 0x6e, 0x76, 0x06, 0x41, 0x64,
+	0x64, 0x47, 0x61, 0x73, 0x00, 0x00, 0x03, 0x04, 0x03, 0x01, 0x02, 0x02, 0x05, 0x03, 0x01, 0x00,
+	0x01, 0x07, 0x19, 0x03, 0x04, 0x69, 0x6e, 0x69, 0x74, 0x00, 0x01, 0x07, 0x72, 0x65, 0x63, 0x75,
+	0x72, 0x73, 0x65, 0x00, 0x02, 0x04, 0x67, 0x72, 0x6f, 0x77, 0x00, 0x03, 0x0a, 0x1b, 0x03, 0x02,
+	0x00, 0x0b, 0x0e, 0x00, 0x20, 0x00, 0x04, 0x40, 0x20, 0x00, 0x41, 0x01, 0x6b, 0x10, 0x02, 0x0b,
+	0x0b, 0x07, 0x00, 0x20, 0x00, 0x40, 0x00, 0x1a, 0x0b,
+}
+
+const limitsAbi = `[{"name":"init","constant":false,"inputs":[],"outputs":[],"type":"constructor"},` +
+	`{"name":"recurse","constant":false,"inputs":[{"name":"n","type":"uint32","indexed":false}],"outputs":[],"type":"function"},` +
+	`{"name":"grow","constant":false,"inputs":[{"name":"n","type":"uint32","indexed":false}],"outputs":[],"type":"function"}]`
+
+func deployLimits(t *testing.T, limits params.WAVMLimits) (*Config, common.Address, abi.ABI) {
+	abiobj, err := wavm.GetAbi([]byte(limitsAbi))
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	cfg := &Config{
+		State:       statedb,
+		Origin:      common.HexToAddress("0xaaaa"),
+		GasLimit:    10000000,
+		ChainConfig: &params.ChainConfig{ChainID: big.NewInt(1), WAVMLimitsBlock: new(big.Int), WAVMLimits: &limits},
+	}
+	_, address, _, err := Create(utils.CompressWasmAndAbi([]byte(limitsAbi), limitsModule, nil), cfg)
+	if err != nil {
+		t.Fatal("didn't expect error", err)
+	}
+	return cfg, address, abiobj
+}
+
+func TestFunctionDepthLimit(t *testing.T) {
+	limits := params.DefaultWAVMLimits
+	limits.MaxFunctionDepth = 16
+	cfg, address, abiobj := deployLimits(t, limits)
+
+	input, _ := abiobj.Pack("recurse", uint32(16))
+	if _, _, err := Call(address, input, cfg); err != nil {
+		t.Errorf("didn't expect error at the depth limit, got %v", err)
+	}
+	input, _ = abiobj.Pack("recurse", uint32(17))
+	if _, _, err := Call(address, input, cfg); err != vm.ErrOutOfGas {
+		t.Errorf("expected %v past the depth limit, got %v", vm.ErrOutOfGas, err)
+	}
+}
+
+func TestMemoryGrowLimit(t *testing.T) {
+	limits := params.DefaultWAVMLimits
+	limits.MaxMemoryPages = 4
+	cfg, address, abiobj := deployLimits(t, limits)
+
+	input, _ := abiobj.Pack("grow", uint32(3))
+	if _, _, err := Call(address, input, cfg); err != nil {
+		t.Errorf("didn't expect error growing to the memory limit, got %v", err)
+	}
+	input, _ = abiobj.Pack("grow", uint32(4))
+	if _, _, err := Call(address, input, cfg); err != vm.ErrOutOfGas {
+		t.Errorf("expected %v growing past the memory limit, got %v", vm.ErrOutOfGas, err)
+	}
+}
+
+// TestLimitsOfDeployedCode deploys limitsModule before the limits and checks
+// it obeys them once they are enforced.
+func TestLimitsOfDeployedCode(t *testing.T) {
+	limits := params.DefaultWAVMLimits
+	limits.MaxFunctionDepth = 16
+	limits.MaxMemoryPages = 4
+	abiobj, err := wavm.GetAbi([]byte(limitsAbi))
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	cfg := &Config{
+		State:       statedb,
+		Origin:      common.HexToAddress("0xaaaa"),
+		GasLimit:    10000000,
+		BlockNumber: big.NewInt(9),
+		ChainConfig: &params.ChainConfig{ChainID: big.NewInt(1), WAVMLimitsBlock: big.NewInt(10), WAVMLimits: &limits},
+	}
+	_, address, _, err := Create(utils.CompressWasmAndAbi([]byte(limitsAbi), limitsModule, nil), cfg)
+	if err != nil {
+		t.Fatal("didn't expect error", err)
+	}
+
+	deep, _ := abiobj.Pack("recurse", uint32(17))
+	grow, _ := abiobj.Pack("grow", uint32(4))
+	for _, input := range [][]byte{deep, grow} {
+		if _, _, err := Call(address, input, cfg); err != nil {
+			t.Errorf("didn't expect error before the limits, got %v", err)
+		}
+	}
+
+	cfg.BlockNumber = big.NewInt(10)
+	for _, input := range [][]byte{deep, grow} {
+		if _, _, err := Call(address, input, cfg); err != vm.ErrOutOfGas {
+			t.Errorf("expected %v past the limits, got %v", vm.ErrOutOfGas, err)
+		}
+	}
+	input, _ := abiobj.Pack("recurse", uint32(16))
+	if _, _, err := Call(address, input, cfg); err != nil {
+		t.Errorf("didn't expect error at the depth limit, got %v", err)
+	}
+	input, _ = abiobj.Pack("grow", uint32(3))
+	if _, _, err := Call(address, input, cfg); err != nil {
+		t.Errorf("didn't expect error growing to the memory limit, got %v", err)
+	}
+}
+
+// TestLimitsOfUnknownCode checks code compiled under other limits than those
+// enforced isn't run without them.
+func TestLimitsOfUnknownCode(t *testing.T) {
+	limits := params.DefaultWAVMLimits
+	limits.MaxFunctionDepth = 8
+	cfg, address, abiobj := deployLimits(t, limits)
+
+	enforced := limits
+	enforced.MaxFunctionDepth = 16
+	cfg.ChainConfig.WAVMLimits = &enforced
+	input, _ := abiobj.Pack("recurse", uint32(12))
+	if _, _, err := Call(address, input, cfg); err == nil || !strings.Contains(err.Error(), "matches no gas schedule") {
+		t.Errorf("expected code compiled under other limits to fail, got %v", err)
+	}
+}
+
+func TestDeclaredMaximumLimits(t *testing.T) {
+	// limitsModule with a memory of 1 page that may grow to 1000
+	module := append([]byte{}, limitsModule[:44]...)
+	module = append(module, 0x05, 0x05, 0x01, 0x01, 0x01, 0xe8, 0x07)
+	module = append(module, limitsModule[49:]...)
+	code := utils.CompressWasmAndAbi([]byte(limitsAbi), module, nil)
+
+	_, _, _, err := Create(code, &Config{GasLimit: 10000000})
+	if err == nil || !strings.Contains(err.Error(), "maximum memory of 1000 pages exceeds the limit") {
+		t.Errorf("expected maximum memory limit error, got %v", err)
+	}
+
+	// a module with 1 page of memory that doesn't import AddGas
+	unmetered := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x05, 0x03, 0x01, 0x00, 0x01}
+	code = utils.CompressWasmAndAbi([]byte("[]"), unmetered, nil)
+	_, _, _, err = Create(code, &Config{GasLimit: 10000000})
+	if err == nil || !strings.Contains(err.Error(), "AddGas") {
+		t.Errorf("expected error for a module that can't be metered, got %v", err)
+	}
+}
+
+func benchmarkErc20Call(b *testing.B, cached bool) {
+	cfg, address, abiobj := deployErc20(b)
+	input, _ := abiobj.Pack("GetAmount", cfg.Origin)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !cached {
+			wavm.PurgeModuleCache()
+		}
+		if _, _, err := Call(address, input, cfg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkErc20CallCached(b *testing.B)   { benchmarkErc20Call(b, true) }
+func BenchmarkErc20CallUncached(b *testing.B) { benchmarkErc20Call(b, false) }
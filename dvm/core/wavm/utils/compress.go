@@ -122,7 +122,10 @@ func deZlib(src []byte) (dst []byte, err error) {
 
 func deGzip(src []byte) (dst []byte, err error) {
 	b := bytes.NewReader(src)
-	r, _ := gzip.NewReader(b)
+	r, err := gzip.NewReader(b)
+	if err != nil {
+		return nil, err
+	}
 	defer r.Close()
 
 	dst, err = ioutil.ReadAll(r)
@@ -0,0 +1,59 @@
+// Copyright 2019 The darmasuite Authors
+// This file is part of the darmasuite library.
+//
+// The darmasuite library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The darmasuite library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the darmasuite library. If not, see <http://www.gnu.org/licenses/>.
+
+package utils_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/darmaproject/darmasuite/dvm/core/wavm/utils"
+)
+
+// FuzzDecodeContractCode feeds arbitrary contract code to the decoder used
+// by runWavm on every call. Successfully decoded code must survive a round
+// trip through CompressWasmAndAbi.
+func FuzzDecodeContractCode(f *testing.F) {
+	seeds, _ := filepath.Glob(filepath.Join("..", "tests", "*", "*.compress"))
+	for _, seed := range seeds {
+		code, err := ioutil.ReadFile(seed)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(code)
+	}
+	f.Add([]byte{0x01, 0x61, 0x73, 0x6d})
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		dec, _, err := utils.DecodeContractCode(input)
+		if err != nil {
+			return
+		}
+		enc := utils.CompressWasmAndAbi(dec.Abi, dec.Code, dec.Compiled)
+		redec, rest, err := utils.DecodeContractCode(enc)
+		if err != nil {
+			t.Fatalf("failed to decode re-encoded contract: %v", err)
+		}
+		if len(rest) != 0 {
+			t.Errorf("unexpected trailing input after re-encoding: %x", rest)
+		}
+		if !bytes.Equal(dec.Code, redec.Code) || !bytes.Equal(dec.Abi, redec.Abi) || !bytes.Equal(dec.Compiled, redec.Compiled) {
+			t.Errorf("contract code changed after round trip")
+		}
+	})
+}
@@ -27,8 +27,8 @@ import (
 	"time"
 
 	"github.com/darmaproject/darma-wasm/darma"
+	"github.com/darmaproject/darmasuite/dvm/accounts/abi"
 	"github.com/darmaproject/darmasuite/dvm/common"
-	"github.com/darmaproject/darmasuite/dvm/core/state"
 	"github.com/darmaproject/darmasuite/dvm/core/vm"
 	errorsmsg "github.com/darmaproject/darmasuite/dvm/core/vm"
 	"github.com/darmaproject/darmasuite/dvm/core/vm/interface"
@@ -96,27 +96,45 @@ func runWavm(wavm *WAVM, contract *wasmcontract.WASMContract, input []byte, isCr
 	if len(contract.Code) == 0 {
 		return nil, nil
 	}
-	var code wasmcontract.WasmCode
-	decode, vmInput, err := utils.DecodeContractCode(contract.Code)
-	if err != nil {
-		return nil, err
-	}
-	code = decode
-	if isCreate == true {
-		input = vmInput
+	// decoding a deployed contract doesn't charge gas, so calls can reuse
+	// the result of an earlier call to the same code.
+	var cached *cachedModule
+	if isCreate == false {
+		cached, _ = modules.get(moduleKey(contract.CodeHash, wavm.wavmConfig))
 	}
+	var (
+		code wasmcontract.WasmCode
+		abi  abi.ABI
+		err  error
+	)
+	if cached != nil {
+		code = cached.code
+		abi = cached.abi
+	} else {
+		var vmInput []byte
+		code, vmInput, err = utils.DecodeContractCode(contract.Code)
+		if err != nil {
+			return nil, err
+		}
+		if isCreate == true {
+			input = vmInput
+		}
 
-	abi, err := GetAbi(code.Abi)
-	if err != nil {
-		return nil, err
+		abi, err = GetAbi(code.Abi)
+		if err != nil {
+			return nil, err
+		}
 	}
-	gasRule := gas.NewGas(wavm.wavmConfig.DisableFloatingPoint)
-	gasTable := wavm.ChainConfig().GasTable(wavm.Context.BlockNumber)
-	gasCounter := gas.NewGasCounter(contract, gasTable)
+	// instruction costs only matter when the code is compiled at creation,
+	// deployed contracts keep the costs of the block that created them.
+	gasSchedule := wavm.ChainConfig().WAVMGasSchedule(wavm.Context.BlockNumber)
+	gasRule := gas.NewGas(gasSchedule, wavm.wavmConfig.DisableFloatingPoint)
+	gasTable := gasSchedule.Table
+	gasCounter := gas.NewGasCounter(contract, gasSchedule)
 	crx := ChainContext{
 		CanTransfer: wavm.Context.CanTransfer,
 		Transfer:    wavm.Context.Transfer,
-		//TransferEx:  wavm.Context.TransferEx,
+		TransferEx:  wavm.Context.TransferEx,
 		GetHash:         wavm.Context.GetHash,
 		StringToAddress: wavm.Context.StringToAddress,
 		AddressToString: wavm.Context.AddressToString,
@@ -132,7 +150,7 @@ func runWavm(wavm *WAVM, contract *wasmcontract.WASMContract, input []byte, isCr
 		Time:           wavm.Context.Time,
 		Difficulty:     wavm.Context.Difficulty,
 		Contract:       contract,
-		StateDB:        wavm.StateDB.(*state.StateDB),
+		StateDB:        wavm.StateDB,
 		Code:           code.Code,
 		Abi:            abi,
 		Wavm:           wavm,
@@ -144,6 +162,18 @@ func runWavm(wavm *WAVM, contract *wasmcontract.WASMContract, input []byte, isCr
 	}
 	newwawm := NewWavm(crx, wavm.wavmConfig, isCreate)
 	wavm.Wavm = newwawm
+	if cached != nil {
+		env, err := newwawm.ResolveImports("env")
+		if err != nil {
+			return nil, err
+		}
+		var compiled []darma.Compiled
+		newwawm.Module, compiled, err = cached.bind(env)
+		if err != nil {
+			return nil, err
+		}
+		return newwawm.Apply(input, compiled, cached.mutable)
+	}
 	err = newwawm.InstantiateModule(code.Code, []uint8{})
 	if err != nil {
 		return nil, err
@@ -158,7 +188,7 @@ func runWavm(wavm *WAVM, contract *wasmcontract.WASMContract, input []byte, isCr
 		}
 		res, err = newwawm.Apply(input, compiled, mutable)
 		if err != nil {
-			return nil, err
+			return res, err
 		}
 		compileres, err := json.Marshal(compiled)
 		if err != nil {
@@ -172,9 +202,17 @@ func runWavm(wavm *WAVM, contract *wasmcontract.WASMContract, input []byte, isCr
 		if err != nil {
 			return nil, err
 		}
+		if limitsActive(wavm.wavmConfig) {
+			compiled, err = limitCompiled(newwawm.Module, compiled, mutable, wavm.wavmConfig, deploySchedules(wavm.ChainConfig(), wavm.Context.BlockNumber))
+			if err != nil {
+				return nil, err
+			}
+		}
+		// the cache keeps copies, this execution goes on with the originals
+		modules.add(moduleKey(contract.CodeHash, wavm.wavmConfig), newCachedModule(code, abi, copyModule(newwawm.Module), mutable, copyCompiled(compiled)))
 		res, err = newwawm.Apply(input, compiled, mutable)
 		if err != nil {
-			return nil, err
+			return res, err
 		}
 	}
 	return res, err
@@ -186,6 +224,15 @@ func NewWAVM(ctx vm.Context, statedb inter.StateDB, chainConfig *params.ChainCon
 		Tracer:      vmConfig.Tracer,
 		NoRecursion: vmConfig.NoRecursion,
 	}
+	if limits := chainConfig.WAVMResourceLimits(ctx.BlockNumber); limits != nil {
+		wavmConfig.MaxMemoryPages = limits.MaxMemoryPages
+		wavmConfig.MaxTableSize = limits.MaxTableSize
+		wavmConfig.MaxValueSlots = limits.MaxValueSlots
+		wavmConfig.MaxCallStackDepth = limits.MaxCallStackDepth
+		wavmConfig.MaxFunctionDepth = limits.MaxFunctionDepth
+		wavmConfig.DefaultMemoryPages = limits.DefaultMemoryPages
+		wavmConfig.ReturnOnGasLimitExceeded = true
+	}
 	wavm := &WAVM{
 		Context:     ctx,
 		StateDB:     statedb,
@@ -197,14 +244,29 @@ func NewWAVM(ctx vm.Context, statedb inter.StateDB, chainConfig *params.ChainCon
 	return wavm
 }
 
+// maxCallDepth returns the deepest nesting of contract calls allowed.
+func (wavm *WAVM) maxCallDepth() int {
+	if wavm.wavmConfig.MaxCallStackDepth > 0 {
+		return wavm.wavmConfig.MaxCallStackDepth
+	}
+	return int(params.CallCreateDepth)
+}
+
+// Cancel aborts the running contracts at their next gas charge. It may be
+// called concurrently and more than once.
 func (wavm *WAVM) Cancel() {
 	atomic.StoreInt32(&wavm.abort, 1)
 }
 
+// Cancelled returns true if Cancel has been called
+func (wavm *WAVM) Cancelled() bool {
+	return atomic.LoadInt32(&wavm.abort) == 1
+}
+
 func (wavm *WAVM) Create(caller vm.ContractRef, code []byte, gas uint64, value *big.Int) (ret []byte, contractAddr common.Address, leftOverGas uint64, err error) {
 	// Depth check execution. Fail if we're trying to execute above the
 	// limit.
-	if wavm.depth > int(params.CallCreateDepth) {
+	if wavm.depth > wavm.maxCallDepth() {
 		return nil, common.Address{}, gas, errorsmsg.ErrDepth
 	}
 	if !wavm.CanTransfer(wavm.StateDB, caller.Address(), value) {
@@ -285,7 +347,7 @@ func (wavm *WAVM) Call(caller vm.ContractRef, addr common.Address, input []byte,
 		return nil, gas, nil
 	}
 	// Fail if we're trying to execute above the call depth limit
-	if wavm.depth > int(params.CallCreateDepth) {
+	if wavm.depth > wavm.maxCallDepth() {
 		return nil, gas, errorsmsg.ErrDepth
 	}
 	// Fail if we're trying to transfer more than the available balance
@@ -356,7 +418,7 @@ func (wavm *WAVM) CallCode(caller vm.ContractRef, addr common.Address, input []b
 	}
 
 	// Fail if we're trying to execute above the call depth limit
-	if wavm.depth > int(params.CallCreateDepth) {
+	if wavm.depth > wavm.maxCallDepth() {
 		return nil, gas, errorsmsg.ErrDepth
 	}
 	// Fail if we're trying to transfer more than the available balance
@@ -391,7 +453,7 @@ func (wavm *WAVM) DelegateCall(caller vm.ContractRef, addr common.Address, input
 		return nil, gas, nil
 	}
 	// Fail if we're trying to execute above the call depth limit
-	if wavm.depth > int(params.CallCreateDepth) {
+	if wavm.depth > wavm.maxCallDepth() {
 		return nil, gas, errorsmsg.ErrDepth
 	}
 
@@ -13,6 +13,7 @@ type Config struct {
 	MaxTableSize             int
 	MaxValueSlots            int
 	MaxCallStackDepth        int
+	MaxFunctionDepth         int
 	DefaultMemoryPages       int
 	DefaultTableSize         int
 	GasLimit                 uint64
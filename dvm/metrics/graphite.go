@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"log"
 	"net"
@@ -46,6 +47,23 @@ func GraphiteWithConfig(c GraphiteConfig) {
 	}
 }
 
+// GraphiteWithContext is like GraphiteWithConfig, but returns once ctx is
+// done.
+func GraphiteWithContext(ctx context.Context, c GraphiteConfig) {
+	ticker := time.NewTicker(c.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := graphite(&c); nil != err {
+				log.Println(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 // GraphiteOnce performs a single submission to Graphite, returning a
 // non-nil error on failed connections. This can be used in a loop
 // similar to GraphiteWithConfig for custom error handling.
@@ -1,6 +1,7 @@
 package influxdb
 
 import (
+	"context"
 	"fmt"
 	uurl "net/url"
 	"time"
@@ -33,6 +34,11 @@ func InfluxDB(r metrics.Registry, d time.Duration, url, database, username, pass
 
 // InfluxDBWithTags starts a InfluxDB reporter which will post the from the given metrics.Registry at each d interval with the specified tags
 func InfluxDBWithTags(r metrics.Registry, d time.Duration, url, database, username, password, namespace string, tags map[string]string) {
+	InfluxDBWithTagsContext(context.Background(), r, d, url, database, username, password, namespace, tags)
+}
+
+// InfluxDBWithTagsContext is like InfluxDBWithTags, but returns once ctx is done
+func InfluxDBWithTagsContext(ctx context.Context, r metrics.Registry, d time.Duration, url, database, username, password, namespace string, tags map[string]string) {
 	u, err := uurl.Parse(url)
 	if err != nil {
 		log.Warn("Unable to parse InfluxDB", "url", url, "err", err)
@@ -55,7 +61,7 @@ func InfluxDBWithTags(r metrics.Registry, d time.Duration, url, database, userna
 		return
 	}
 
-	rep.run()
+	rep.run(ctx)
 }
 
 // InfluxDBWithTagsOnce runs once an InfluxDB reporter and post the given metrics.Registry with the specified tags
@@ -97,17 +103,21 @@ func (r *reporter) makeClient() (err error) {
 	return
 }
 
-func (r *reporter) run() {
-	intervalTicker := time.Tick(r.interval)
-	pingTicker := time.Tick(time.Second * 5)
+func (r *reporter) run(ctx context.Context) {
+	intervalTicker := time.NewTicker(r.interval)
+	defer intervalTicker.Stop()
+	pingTicker := time.NewTicker(time.Second * 5)
+	defer pingTicker.Stop()
 
 	for {
 		select {
-		case <-intervalTicker:
+		case <-ctx.Done():
+			return
+		case <-intervalTicker.C:
 			if err := r.send(); err != nil {
 				log.Warn("Unable to send to InfluxDB", "err", err)
 			}
-		case <-pingTicker:
+		case <-pingTicker.C:
 			_, _, err := r.client.Ping()
 			if err != nil {
 				log.Warn("Got error while sending a ping to InfluxDB, trying to recreate client", "err", err)
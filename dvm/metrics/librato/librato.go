@@ -1,6 +1,7 @@
 package librato
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"math"
@@ -41,11 +42,22 @@ func Librato(r metrics.Registry, d time.Duration, e string, t string, s string,
 }
 
 func (rep *Reporter) Run() {
+	rep.RunContext(context.Background())
+}
+
+// RunContext is like Run, but returns once ctx is done.
+func (rep *Reporter) RunContext(ctx context.Context) {
 	log.Printf("WARNING: This client has been DEPRECATED! It has been moved to https://github.com/mihasya/go-metrics-librato and will be removed from rcrowley/go-metrics on August 5th 2015")
 	ticker := time.NewTicker(rep.Interval)
 	defer ticker.Stop()
 	metricsApi := &LibratoClient{rep.Email, rep.Token}
-	for now := range ticker.C {
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
 		var metrics Batch
 		var err error
 		if metrics, err = rep.BuildRequest(now, rep.Registry); err != nil {
@@ -27,7 +27,9 @@ var Enabled = false
 var EnabledExpensive = false
 
 // enablerFlags is the CLI flag names to use to enable metrics collections.
-var enablerFlags = []string{"metrics"}
+// The daemon serves or pushes the metrics when given the --metrics-* flags,
+// so they enable them too.
+var enablerFlags = []string{"metrics", "metrics-bind", "metrics-config", "metrics-push"}
 
 // expensiveEnablerFlags is the CLI flag names to use to enable metrics collections.
 var expensiveEnablerFlags = []string{"metrics.expensive"}
@@ -38,6 +40,9 @@ var expensiveEnablerFlags = []string{"metrics.expensive"}
 func init() {
 	for _, arg := range os.Args {
 		flag := strings.TrimLeft(arg, "-")
+		if i := strings.Index(flag, "="); i >= 0 {
+			flag = flag[:i]
+		}
 
 		for _, enabler := range enablerFlags {
 			if !Enabled && flag == enabler {
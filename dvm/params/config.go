@@ -28,6 +28,52 @@ import (
 // set of configuration options.
 type ChainConfig struct {
 	ChainID *big.Int `json:"chainId"` // chainId identifies the current chain and is used for replay protection
+
+	// The Ethereum forks. Darma has run the EIP150 and YoloV1 rules since
+	// genesis and none of the others, so EIP150Block and YoloV1Block can only
+	// delay those two rules, while the other forks are off when nil.
+	HomesteadBlock      *big.Int `json:"homesteadBlock,omitempty"`      // Homestead switch block (nil = no fork)
+	EIP150Block         *big.Int `json:"eip150Block,omitempty"`         // EIP150 switch block (nil = genesis)
+	EIP155Block         *big.Int `json:"eip155Block,omitempty"`         // EIP155 switch block (nil = no fork)
+	EIP158Block         *big.Int `json:"eip158Block,omitempty"`         // EIP158 switch block (nil = no fork)
+	ByzantiumBlock      *big.Int `json:"byzantiumBlock,omitempty"`      // Byzantium switch block (nil = no fork)
+	ConstantinopleBlock *big.Int `json:"constantinopleBlock,omitempty"` // Constantinople switch block (nil = no fork)
+	PetersburgBlock     *big.Int `json:"petersburgBlock,omitempty"`     // Petersburg switch block (nil = no fork)
+	IstanbulBlock       *big.Int `json:"istanbulBlock,omitempty"`       // Istanbul switch block (nil = no fork)
+	YoloV1Block         *big.Int `json:"yoloV1Block,omitempty"`         // YoloV1 switch block (nil = genesis)
+
+	WAVMLimitsBlock *big.Int    `json:"wavmLimitsBlock,omitempty"` // WAVM resource limits switch block (nil = no fork)
+	WAVMLimits      *WAVMLimits `json:"wavmLimits,omitempty"`      // Limits enforced from WAVMLimitsBlock, nil means DefaultWAVMLimits
+
+	WAVMGasV2Block *big.Int `json:"wavmGasV2Block,omitempty"` // WAVMGasScheduleV2 switch block (nil = no fork)
+
+	ContractPayoutBlock *big.Int `json:"contractPayoutBlock,omitempty"` // Contract payouts to Darma addresses switch block (nil = no fork)
+
+	ContractDWBlock *big.Int `json:"contractDWBlock,omitempty"` // Signed and strictly checked deposit/withdraw transactions switch block (nil = no fork)
+
+	AccountRegistryBlock *big.Int `json:"accountRegistryBlock,omitempty"` // Contract account to Darma address registry switch block (nil = no fork)
+}
+
+// WAVMLimits bounds the resources a WASM contract may use. Zero fields are
+// not enforced.
+type WAVMLimits struct {
+	MaxMemoryPages     int `json:"maxMemoryPages"`     // Linear memory a contract may grow to, in 64KB pages
+	MaxTableSize       int `json:"maxTableSize"`       // Initial size of the function table
+	MaxValueSlots      int `json:"maxValueSlots"`      // Locals plus operand stack height of a single function
+	MaxCallStackDepth  int `json:"maxCallStackDepth"`  // Nesting of contract calls
+	MaxFunctionDepth   int `json:"maxFunctionDepth"`   // Nesting of function calls within a contract
+	DefaultMemoryPages int `json:"defaultMemoryPages"` // Memory charged for modules without a memory section
+}
+
+// DefaultWAVMLimits are the WAVM limits used when the chain config enables
+// them without overriding any.
+var DefaultWAVMLimits = WAVMLimits{
+	MaxMemoryPages:     512,
+	MaxTableSize:       1024,
+	MaxValueSlots:      16384,
+	MaxCallStackDepth:  int(CallCreateDepth),
+	MaxFunctionDepth:   1024,
+	DefaultMemoryPages: 1,
 }
 
 // IsHubble returns whether num is either equal to the hubble block or greater.
@@ -37,12 +83,17 @@ func (c *ChainConfig) IsHubble(num *big.Int) bool {
 
 // IsHomestead returns whether num is either equal to the homestead block or greater.
 func (c *ChainConfig) IsHomestead(num *big.Int) bool {
-	return false
+	return isForked(c.HomesteadBlock, num)
+}
+
+// IsEIP150 returns whether num is either equal to the EIP150 fork block or greater.
+func (c *ChainConfig) IsEIP150(num *big.Int) bool {
+	return isForkedSinceGenesis(c.EIP150Block, num)
 }
 
 // IsEIP155 returns whether num is either equal to the EIP155 fork block or greater.
 func (c *ChainConfig) IsEIP155(num *big.Int) bool {
-	return false
+	return isForked(c.EIP155Block, num)
 }
 
 // IsEWASM returns whether num represents a block number after the EWASM fork
@@ -52,7 +103,70 @@ func (c *ChainConfig) IsEWASM(num *big.Int) bool {
 
 // IsEIP158 returns whether num is either equal to the EIP158 fork block or greater.
 func (c *ChainConfig) IsEIP158(num *big.Int) bool {
-	return false
+	return isForked(c.EIP158Block, num)
+}
+
+// IsByzantium returns whether num is either equal to the Byzantium fork block or greater.
+func (c *ChainConfig) IsByzantium(num *big.Int) bool {
+	return isForked(c.ByzantiumBlock, num)
+}
+
+// IsConstantinople returns whether num is either equal to the Constantinople fork block or greater.
+func (c *ChainConfig) IsConstantinople(num *big.Int) bool {
+	return isForked(c.ConstantinopleBlock, num)
+}
+
+// IsPetersburg returns whether num is either equal to the Petersburg fork block or greater.
+func (c *ChainConfig) IsPetersburg(num *big.Int) bool {
+	return isForked(c.PetersburgBlock, num)
+}
+
+// IsIstanbul returns whether num is either equal to the Istanbul fork block or greater.
+func (c *ChainConfig) IsIstanbul(num *big.Int) bool {
+	return isForked(c.IstanbulBlock, num)
+}
+
+// IsYoloV1 returns whether num is either equal to the YoloV1 fork block or greater.
+func (c *ChainConfig) IsYoloV1(num *big.Int) bool {
+	return isForkedSinceGenesis(c.YoloV1Block, num)
+}
+
+// IsWAVMLimits returns whether num is either equal to the WAVM limits block or greater.
+func (c *ChainConfig) IsWAVMLimits(num *big.Int) bool {
+	return isForked(c.WAVMLimitsBlock, num)
+}
+
+// IsWAVMGasV2 returns whether num is either equal to the WAVM gas V2 block or greater.
+func (c *ChainConfig) IsWAVMGasV2(num *big.Int) bool {
+	return isForked(c.WAVMGasV2Block, num)
+}
+
+// IsContractPayout returns whether num is either equal to the contract payout block or greater.
+func (c *ChainConfig) IsContractPayout(num *big.Int) bool {
+	return isForked(c.ContractPayoutBlock, num)
+}
+
+// IsContractDW returns whether num is either equal to the contract deposit/withdraw block or greater.
+func (c *ChainConfig) IsContractDW(num *big.Int) bool {
+	return isForked(c.ContractDWBlock, num)
+}
+
+// IsAccountRegistry returns whether num is either equal to the account registry block or greater.
+func (c *ChainConfig) IsAccountRegistry(num *big.Int) bool {
+	return isForked(c.AccountRegistryBlock, num)
+}
+
+// WAVMResourceLimits returns the WAVM limits in effect at block num, or nil
+// if they are not enforced yet.
+func (c *ChainConfig) WAVMResourceLimits(num *big.Int) *WAVMLimits {
+	if !c.IsWAVMLimits(num) {
+		return nil
+	}
+	if c.WAVMLimits != nil {
+		return c.WAVMLimits
+	}
+	limits := DefaultWAVMLimits
+	return &limits
 }
 
 func (c *ChainConfig) IsEVM(num *big.Int) bool {
@@ -64,12 +178,18 @@ func (c *ChainConfig) IsEVM(num *big.Int) bool {
 //
 // The returned GasTable's fields shouldn't, under any circumstances, be changed.
 func (c *ChainConfig) GasTable(num *big.Int) GasTable {
-	if num == nil {
-		return GasTableHubble
-	}
+	return c.WAVMGasSchedule(num).Table
+}
+
+// WAVMGasSchedule returns the WAVM gas schedule in effect at block num.
+//
+// The returned schedule's fields shouldn't, under any circumstances, be changed.
+func (c *ChainConfig) WAVMGasSchedule(num *big.Int) *WAVMGasSchedule {
 	switch {
+	case c.IsWAVMGasV2(num):
+		return &WAVMGasScheduleV2
 	default:
-		return GasTableHubble
+		return &WAVMGasScheduleV1
 	}
 }
 
@@ -92,9 +212,78 @@ func (c *ChainConfig) CheckCompatible(newcfg *ChainConfig, height uint64) *Confi
 }
 
 func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int) *ConfigCompatError {
+	if isForkIncompatible(c.HomesteadBlock, newcfg.HomesteadBlock, head) {
+		return newCompatError("Homestead fork block", c.HomesteadBlock, newcfg.HomesteadBlock)
+	}
+	if isForkIncompatible(c.EIP155Block, newcfg.EIP155Block, head) {
+		return newCompatError("EIP155 fork block", c.EIP155Block, newcfg.EIP155Block)
+	}
+	if isForkIncompatible(c.EIP158Block, newcfg.EIP158Block, head) {
+		return newCompatError("EIP158 fork block", c.EIP158Block, newcfg.EIP158Block)
+	}
+	if isForkIncompatible(c.ByzantiumBlock, newcfg.ByzantiumBlock, head) {
+		return newCompatError("Byzantium fork block", c.ByzantiumBlock, newcfg.ByzantiumBlock)
+	}
+	if isForkIncompatible(c.ConstantinopleBlock, newcfg.ConstantinopleBlock, head) {
+		return newCompatError("Constantinople fork block", c.ConstantinopleBlock, newcfg.ConstantinopleBlock)
+	}
+	if isForkIncompatible(c.PetersburgBlock, newcfg.PetersburgBlock, head) {
+		return newCompatError("Petersburg fork block", c.PetersburgBlock, newcfg.PetersburgBlock)
+	}
+	if isForkIncompatible(c.IstanbulBlock, newcfg.IstanbulBlock, head) {
+		return newCompatError("Istanbul fork block", c.IstanbulBlock, newcfg.IstanbulBlock)
+	}
+	if isForkIncompatible(c.WAVMLimitsBlock, newcfg.WAVMLimitsBlock, head) {
+		return newCompatError("WAVM limits fork block", c.WAVMLimitsBlock, newcfg.WAVMLimitsBlock)
+	}
+	if isForkIncompatible(c.WAVMGasV2Block, newcfg.WAVMGasV2Block, head) {
+		return newCompatError("WAVM gas V2 fork block", c.WAVMGasV2Block, newcfg.WAVMGasV2Block)
+	}
+	if isForkIncompatible(c.ContractPayoutBlock, newcfg.ContractPayoutBlock, head) {
+		return newCompatError("contract payout fork block", c.ContractPayoutBlock, newcfg.ContractPayoutBlock)
+	}
+	if isForkIncompatible(c.ContractDWBlock, newcfg.ContractDWBlock, head) {
+		return newCompatError("contract deposit/withdraw fork block", c.ContractDWBlock, newcfg.ContractDWBlock)
+	}
+	if isForkIncompatible(c.AccountRegistryBlock, newcfg.AccountRegistryBlock, head) {
+		return newCompatError("account registry fork block", c.AccountRegistryBlock, newcfg.AccountRegistryBlock)
+	}
 	return nil
 }
 
+// isForkIncompatible returns true if a fork scheduled at s1 cannot be rescheduled to
+// block s2 because head is already past the fork.
+func isForkIncompatible(s1, s2, head *big.Int) bool {
+	return (isForked(s1, head) || isForked(s2, head)) && !configNumEqual(s1, s2)
+}
+
+// isForked returns whether a fork scheduled at block s is active at the given head block.
+func isForked(s, head *big.Int) bool {
+	if s == nil || head == nil {
+		return false
+	}
+	return s.Cmp(head) <= 0
+}
+
+// isForkedSinceGenesis is isForked for the rules Darma has always had, which
+// are active at every block when s is nil.
+func isForkedSinceGenesis(s, head *big.Int) bool {
+	if s == nil {
+		return true
+	}
+	return isForked(s, head)
+}
+
+func configNumEqual(x, y *big.Int) bool {
+	if x == nil {
+		return y == nil
+	}
+	if y == nil {
+		return x == nil
+	}
+	return x.Cmp(y) == 0
+}
+
 // ConfigCompatError is raised if the locally-stored blockchain is initialised with a
 // ChainConfig that would alter the past.
 type ConfigCompatError struct {
@@ -137,6 +326,10 @@ type Rules struct {
 	IsByzantium, IsConstantinople, IsPetersburg, IsIstanbul bool
 	IsYoloV1                                                bool
 	IsHubble bool
+	IsWAVMLimits bool
+	IsWAVMGasV2 bool
+	IsContractPayout bool
+	IsAccountRegistry bool
 }
 
 // Rules ensures c's ChainID is not nil.
@@ -147,8 +340,18 @@ func (c *ChainConfig) Rules(num *big.Int) Rules {
 	}
 	return Rules{
 		ChainID: new(big.Int).Set(chainID),
-		IsEIP150: true, // [Gas cost changes for IO-heavy operations](https://github.com/ethereum/EIPs/blob/master/EIPS/eip-150.md)
-		IsYoloV1: true,
+		IsHomestead: c.IsHomestead(num),
+		IsEIP150: c.IsEIP150(num), // [Gas cost changes for IO-heavy operations](https://github.com/ethereum/EIPs/blob/master/EIPS/eip-150.md)
+		IsEIP158: c.IsEIP158(num),
+		IsByzantium: c.IsByzantium(num),
+		IsConstantinople: c.IsConstantinople(num),
+		IsPetersburg: c.IsPetersburg(num),
+		IsIstanbul: c.IsIstanbul(num),
+		IsYoloV1: c.IsYoloV1(num),
+		IsWAVMLimits: c.IsWAVMLimits(num),
+		IsWAVMGasV2: c.IsWAVMGasV2(num),
+		IsContractPayout: c.IsContractPayout(num),
+		IsAccountRegistry: c.IsAccountRegistry(num),
 		// other field is default value: false
 	}
 }
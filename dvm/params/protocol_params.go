@@ -119,6 +119,8 @@ const (
 	IdentityBaseGas         uint64 = 15     // Base price for a data copy operation
 	IdentityPerWordGas      uint64 = 3      // Per-work price for a data copy operation
 	ModExpQuadCoeffDiv      uint64 = 20     // Divisor for the quadratic particle of the big int modular exponentiation
+	ContractPayoutGas       uint64 = 25000  // Paying contract funds out to a Darma address, which creates an output
+	AccountOwnerGas         uint64 = 1600   // Looking up the Darma address registered for a contract account
 
 	Bn256AddGasByzantium             uint64 = 500    // Byzantium gas needed for an elliptic curve addition
 	Bn256AddGasIstanbul              uint64 = 150    // Gas needed for an elliptic curve addition
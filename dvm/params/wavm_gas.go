@@ -0,0 +1,160 @@
+// Copyright 2019 The darmasuite Authors
+// This file is part of the darmasuite library.
+//
+// The darmasuite library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The darmasuite library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the darmasuite library. If not, see <http://www.gnu.org/licenses/>.
+
+package params
+
+// WAVMGasSchedule organizes the gas prices of WASM contracts.
+//
+// Instruction costs are injected into a contract's code when it is created,
+// so a contract keeps the instruction costs of the schedule it was created
+// under. Host function costs are charged while the contract runs and follow
+// the schedule of the block being executed.
+type WAVMGasSchedule struct {
+	Version uint64
+
+	// Instruction costs, per instruction
+	Regular uint64 // Any instruction without a cost of its own
+	Mul     uint64 // Integer multiplication
+	Div     uint64 // Integer division and remainder
+	Mem     uint64 // Loads and stores
+
+	// Memory costs, per 64KB page
+	InitialMem  uint64 // Initial memory of a contract
+	GrowMem     uint64 // Pages added by memory.grow
+	GrowMemQuad uint64 // Pages squared added by memory.grow
+
+	// Host function costs
+	QuickStep      uint64 // Environment getters, conversions and checks
+	FastestStep    uint64 // U256 arithmetic, comparisons and string helpers
+	ExtStep        uint64 // Block hash lookups
+	Sha3           uint64 // Once per SHA3 call
+	Sha3Word       uint64 // Per word of the SHA3 input
+	Sha3WordSize   uint64 // Bytes per SHA3 word
+	MemoryByte     uint64 // Per byte of a message passed to Revert
+	ConcatByte     uint64 // Per byte of a string built by Concat
+	ReturnByte     uint64 // Per byte of a returned pointer
+	ReturnU256     uint64
+	ReturnHash     uint64
+	ReturnAddress  uint64
+	Ecrecover      uint64
+	SendContract   uint64 // SendFromContract and TransferFromContract
+	ContractPayout uint64 // Paying a Darma address from SendFromContract and TransferFromContract
+	Log            uint64 // Once per event
+	LogTopic       uint64 // Per event topic
+	LogData        uint64 // Per byte of event data
+	SstoreSet      uint64 // Storing a non-zero value to an empty slot
+	SstoreReset    uint64 // Changing a non-zero slot
+	SstoreClear    uint64 // Clearing a non-zero slot
+	SstoreRefund   uint64 // Refunded for clearing a slot
+	CallNewAccount uint64 // Contract call sending value to an empty account
+	CallValue      uint64 // Contract call sending value
+
+	Table GasTable // Loads, calls and pow
+}
+
+// Variables containing the WAVM gas schedules. A schedule must never change
+// once a chain has executed blocks with it; reprice by adding a new version.
+var (
+	// WAVMGasScheduleV1 contains the gas prices WAVM launched with.
+	WAVMGasScheduleV1 = WAVMGasSchedule{
+		Version: 1,
+
+		Regular: 1,
+		Mul:     4,
+		Div:     16,
+		Mem:     2,
+
+		InitialMem:  4096,
+		GrowMem:     8192,
+		GrowMemQuad: 16,
+
+		QuickStep:      2,
+		FastestStep:    3,
+		ExtStep:        20,
+		Sha3:           Sha3Gas,
+		Sha3Word:       Sha3WordGas,
+		Sha3WordSize:   1, // every byte was charged as a word
+		MemoryByte:     2,
+		ConcatByte:     2,
+		ReturnByte:     1,
+		ReturnU256:     64,
+		ReturnHash:     64,
+		ReturnAddress:  40,
+		Ecrecover:      EcrecoverGas,
+		SendContract:   CallStipend,
+		ContractPayout: ContractPayoutGas,
+		Log:            LogGas,
+		LogTopic:       LogTopicGas,
+		LogData:        LogDataGas,
+		SstoreSet:      SstoreSetGas,
+		SstoreReset:    SstoreResetGas,
+		SstoreClear:    SstoreClearGas,
+		SstoreRefund:   SstoreRefundGas,
+		CallNewAccount: CallNewAccountGas,
+		CallValue:      CallValueTransferGas,
+
+		Table: GasTableHubble,
+	}
+
+	// WAVMGasScheduleV2 reprices WAVMGasScheduleV1. Instruction prices follow
+	// the cost of interpreting them rather than that of the native
+	// instruction, host functions pay for the reflective call into the host,
+	// and SHA3 is charged per 32 byte word like in the EVM. The Mul, Div,
+	// Mem, QuickStep, FastestStep and ExtStep costs are NOT calibrated yet:
+	// they are placeholders until the calibration data is measured on the
+	// reference hardware and committed, see TestGasScheduleV2 in
+	// dvm/core/wavm/runtime. WAVMGasV2Block must stay nil, on every chain and
+	// in the runtime defaults, until then.
+	WAVMGasScheduleV2 = WAVMGasSchedule{
+		Version: 2,
+
+		Regular: 1,
+		Mul:     2,
+		Div:     4,
+		Mem:     3,
+
+		InitialMem:  4096,
+		GrowMem:     8192,
+		GrowMemQuad: 16,
+
+		QuickStep:      40,
+		FastestStep:    60,
+		ExtStep:        100,
+		Sha3:           Sha3Gas,
+		Sha3Word:       Sha3WordGas,
+		Sha3WordSize:   32,
+		MemoryByte:     2,
+		ConcatByte:     2,
+		ReturnByte:     1,
+		ReturnU256:     64,
+		ReturnHash:     64,
+		ReturnAddress:  40,
+		Ecrecover:      EcrecoverGas,
+		SendContract:   CallStipend,
+		ContractPayout: ContractPayoutGas,
+		Log:            LogGas,
+		LogTopic:       LogTopicGas,
+		LogData:        LogDataGas,
+		SstoreSet:      SstoreSetGas,
+		SstoreReset:    SstoreResetGas,
+		SstoreClear:    SstoreClearGas,
+		SstoreRefund:   SstoreRefundGas,
+		CallNewAccount: CallNewAccountGas,
+		CallValue:      CallValueTransferGas,
+
+		Table: GasTableHubble,
+	}
+)
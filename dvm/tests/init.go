@@ -0,0 +1,349 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package tests implements execution of the Ethereum JSON tests against the
+// Darma EVM, so that we know which Ethereum semantics the fork actually matches.
+//
+// The fixtures are not shipped with the repository. Point DVM_ETHTESTS_DIR at a
+// checkout of github.com/ethereum/tests (or copy it to ./testdata) to run them.
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/darmaproject/darmasuite/dvm/params"
+)
+
+var (
+	baseDir      = ethTestsDir()
+	stateTestDir = filepath.Join(baseDir, "GeneralStateTests")
+	vmTestDir    = filepath.Join(baseDir, "VMTests")
+)
+
+func ethTestsDir() string {
+	if dir := os.Getenv("DVM_ETHTESTS_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(".", "testdata")
+}
+
+// never is a fork block no test reaches, for the rules Darma has had since
+// genesis (EIP150 and YoloV1) but an older Ethereum fork did not.
+var never = new(big.Int).SetUint64(math.MaxUint64)
+
+// Forks lists the Ethereum forks whose rules the Darma EVM can be configured
+// with. Transition forks and forks newer than YoloV1 are not supported.
+var Forks = map[string]*params.ChainConfig{
+	"Frontier": {
+		ChainID:     big.NewInt(1),
+		EIP150Block: never,
+		YoloV1Block: never,
+	},
+	"Homestead": {
+		ChainID:        big.NewInt(1),
+		HomesteadBlock: big.NewInt(0),
+		EIP150Block:    never,
+		YoloV1Block:    never,
+	},
+	"EIP150": {
+		ChainID:        big.NewInt(1),
+		HomesteadBlock: big.NewInt(0),
+		EIP150Block:    big.NewInt(0),
+		YoloV1Block:    never,
+	},
+	"EIP158": {
+		ChainID:        big.NewInt(1),
+		HomesteadBlock: big.NewInt(0),
+		EIP150Block:    big.NewInt(0),
+		EIP155Block:    big.NewInt(0),
+		EIP158Block:    big.NewInt(0),
+		YoloV1Block:    never,
+	},
+	"Byzantium": {
+		ChainID:        big.NewInt(1),
+		HomesteadBlock: big.NewInt(0),
+		EIP150Block:    big.NewInt(0),
+		EIP155Block:    big.NewInt(0),
+		EIP158Block:    big.NewInt(0),
+		ByzantiumBlock: big.NewInt(0),
+		YoloV1Block:    never,
+	},
+	"Constantinople": {
+		ChainID:             big.NewInt(1),
+		HomesteadBlock:      big.NewInt(0),
+		EIP150Block:         big.NewInt(0),
+		EIP155Block:         big.NewInt(0),
+		EIP158Block:         big.NewInt(0),
+		ByzantiumBlock:      big.NewInt(0),
+		ConstantinopleBlock: big.NewInt(0),
+		YoloV1Block:         never,
+	},
+	"ConstantinopleFix": {
+		ChainID:             big.NewInt(1),
+		HomesteadBlock:      big.NewInt(0),
+		EIP150Block:         big.NewInt(0),
+		EIP155Block:         big.NewInt(0),
+		EIP158Block:         big.NewInt(0),
+		ByzantiumBlock:      big.NewInt(0),
+		ConstantinopleBlock: big.NewInt(0),
+		PetersburgBlock:     big.NewInt(0),
+		YoloV1Block:         never,
+	},
+	"Istanbul": {
+		ChainID:             big.NewInt(1),
+		HomesteadBlock:      big.NewInt(0),
+		EIP150Block:         big.NewInt(0),
+		EIP155Block:         big.NewInt(0),
+		EIP158Block:         big.NewInt(0),
+		ByzantiumBlock:      big.NewInt(0),
+		ConstantinopleBlock: big.NewInt(0),
+		PetersburgBlock:     big.NewInt(0),
+		IstanbulBlock:       big.NewInt(0),
+		YoloV1Block:         never,
+	},
+	"YOLOv1": {
+		ChainID:             big.NewInt(1),
+		HomesteadBlock:      big.NewInt(0),
+		EIP150Block:         big.NewInt(0),
+		EIP155Block:         big.NewInt(0),
+		EIP158Block:         big.NewInt(0),
+		ByzantiumBlock:      big.NewInt(0),
+		ConstantinopleBlock: big.NewInt(0),
+		PetersburgBlock:     big.NewInt(0),
+		IstanbulBlock:       big.NewInt(0),
+		YoloV1Block:         big.NewInt(0),
+	},
+}
+
+// UnsupportedForkError is returned when a test requests a fork that isn't
+// implemented.
+type UnsupportedForkError struct {
+	Name string
+}
+
+func (e UnsupportedForkError) Error() string {
+	return fmt.Sprintf("unsupported fork %q", e.Name)
+}
+
+// GetChainConfig returns a chain config that makes the EVM follow the rules of
+// the named Ethereum fork.
+func GetChainConfig(forkString string) (*params.ChainConfig, error) {
+	config, ok := Forks[forkString]
+	if !ok {
+		return nil, UnsupportedForkError{forkString}
+	}
+	return config, nil
+}
+
+// AvailableForks returns the names of the supported forks, sorted.
+func AvailableForks() []string {
+	var availableForks []string
+	for k := range Forks {
+		availableForks = append(availableForks, k)
+	}
+	sort.Strings(availableForks)
+	return availableForks
+}
+
+func readJSON(reader []byte, value interface{}) error {
+	err := json.Unmarshal(reader, &value)
+	if err != nil {
+		if syntaxerr, ok := err.(*json.SyntaxError); ok {
+			line := findLine(reader, syntaxerr.Offset)
+			return fmt.Errorf("JSON syntax error at line %v: %v", line, err)
+		}
+		return err
+	}
+	return nil
+}
+
+func readJSONFile(fn string, value interface{}) error {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return err
+	}
+	if err = readJSON(data, value); err != nil {
+		return fmt.Errorf("%s in file %s", err.Error(), fn)
+	}
+	return nil
+}
+
+// findLine returns the line number for the given offset into data.
+func findLine(data []byte, offset int64) (line int) {
+	line = 1
+	for i, r := range string(data) {
+		if int64(i) >= offset {
+			return
+		}
+		if r == '\n' {
+			line++
+		}
+	}
+	return
+}
+
+// testMatcher controls skipping and expected failure behavior of tests.
+type testMatcher struct {
+	skiploadpat []*regexp.Regexp
+	failpat     []testFailure
+}
+
+type testFailure struct {
+	p      *regexp.Regexp
+	reason string
+}
+
+// skipLoad skips JSON loading of tests matching the pattern.
+func (tm *testMatcher) skipLoad(pattern string) {
+	tm.skiploadpat = append(tm.skiploadpat, regexp.MustCompile(pattern))
+}
+
+// fails adds an expected failure for tests matching the pattern.
+func (tm *testMatcher) fails(pattern string, reason string) {
+	if reason == "" {
+		panic("empty fail reason")
+	}
+	tm.failpat = append(tm.failpat, testFailure{regexp.MustCompile(pattern), reason})
+}
+
+func (tm *testMatcher) findSkip(name string) (reason string, skipload bool) {
+	for _, re := range tm.skiploadpat {
+		if re.MatchString(name) {
+			return "skipped by skipLoad", true
+		}
+	}
+	return "", false
+}
+
+func (tm *testMatcher) findFailure(name string) string {
+	for _, f := range tm.failpat {
+		if f.p.MatchString(name) {
+			return f.reason
+		}
+	}
+	return ""
+}
+
+// checkFailure checks whether a failure is expected.
+func (tm *testMatcher) checkFailure(t *testing.T, err error) error {
+	failReason := tm.findFailure(t.Name())
+	if failReason != "" {
+		t.Logf("expected failure: %s", failReason)
+		if err != nil {
+			t.Logf("error: %v", err)
+			return nil
+		}
+		return fmt.Errorf("test succeeded unexpectedly")
+	}
+	return err
+}
+
+// walk invokes its runTest argument for all subtests in the given directory.
+//
+// runTest should be a function of type func(t *testing.T, name string, x <TestType>),
+// where TestType is the type of the test contained in test files.
+func (tm *testMatcher) walk(t *testing.T, dir string, runTest interface{}) {
+	// Walk the directory.
+	dirinfo, err := os.Stat(dir)
+	if os.IsNotExist(err) || !dirinfo.IsDir() {
+		t.Skipf("missing test files in %s, set DVM_ETHTESTS_DIR to a checkout of the Ethereum tests", dir)
+	}
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		name := filepath.ToSlash(strings.TrimPrefix(path, dir+string(filepath.Separator)))
+		if info.IsDir() {
+			if _, skipload := tm.findSkip(name + "/"); skipload {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) == ".json" {
+			t.Run(name, func(t *testing.T) { tm.runTestFile(t, path, name, runTest) })
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (tm *testMatcher) runTestFile(t *testing.T, path, name string, runTest interface{}) {
+	if r, _ := tm.findSkip(name); r != "" {
+		t.Skip(r)
+	}
+	t.Parallel()
+
+	// Load the file as map[string]<testType>.
+	m := makeMapFromTestFunc(runTest)
+	if err := readJSONFile(path, m.Addr().Interface()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Run all tests from the map. Don't wrap in a subtest if there is only one test in the file.
+	keys := sortedMapKeys(m)
+	if len(keys) == 1 {
+		runTestFunc(runTest, t, name, m, keys[0])
+	} else {
+		for _, key := range keys {
+			name := name + "/" + key
+			t.Run(key, func(t *testing.T) {
+				if r, _ := tm.findSkip(name); r != "" {
+					t.Skip(r)
+				}
+				runTestFunc(runTest, t, name, m, key)
+			})
+		}
+	}
+}
+
+func makeMapFromTestFunc(f interface{}) reflect.Value {
+	stringT := reflect.TypeOf("")
+	testingT := reflect.TypeOf((*testing.T)(nil))
+	ftyp := reflect.TypeOf(f)
+	if ftyp.Kind() != reflect.Func || ftyp.NumIn() != 3 || ftyp.NumOut() != 0 || ftyp.In(0) != testingT || ftyp.In(1) != stringT {
+		panic(fmt.Sprintf("bad test function type: want func(*testing.T, string, <TestType>), have %s", ftyp))
+	}
+	testType := ftyp.In(2)
+	mp := reflect.New(reflect.MapOf(stringT, testType))
+	return mp.Elem()
+}
+
+func sortedMapKeys(m reflect.Value) []string {
+	keys := make([]string, m.Len())
+	for i, k := range m.MapKeys() {
+		keys[i] = k.String()
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func runTestFunc(runTest interface{}, t *testing.T, name string, m reflect.Value, key string) {
+	reflect.ValueOf(runTest).Call([]reflect.Value{
+		reflect.ValueOf(t),
+		reflect.ValueOf(name),
+		m.MapIndex(reflect.ValueOf(key)),
+	})
+}
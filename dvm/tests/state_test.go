@@ -0,0 +1,52 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tests
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/darmaproject/darmasuite/dvm/core/evm"
+)
+
+func TestState(t *testing.T) {
+	t.Parallel()
+
+	st := new(testMatcher)
+	// Long tests:
+	st.skipLoad(`^stQuadraticComplexityTest/`)
+	st.skipLoad(`^stTimeConsuming/`)
+	// Broken tests:
+	st.skipLoad(`^stTransactionTest/OverflowGasRequire\.json`) // gasLimit > 256 bits
+	st.skipLoad(`^stTransactionTest/zeroSigTransa[^/]*\.json`) // EIP-86 is not supported yet
+
+	st.walk(t, stateTestDir, func(t *testing.T, name string, test *StateTest) {
+		for _, subtest := range test.Subtests() {
+			subtest := subtest
+			key := fmt.Sprintf("%s/%d", subtest.Fork, subtest.Index)
+			t.Run(key, func(t *testing.T) {
+				if _, err := GetChainConfig(subtest.Fork); err != nil {
+					t.Skip(err)
+				}
+				_, err := test.Run(subtest, evm.Config{})
+				if err := st.checkFailure(t, err); err != nil {
+					t.Error(err)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,279 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tests
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/darmaproject/darmasuite/dvm/common"
+	"github.com/darmaproject/darmasuite/dvm/common/hexutil"
+	"github.com/darmaproject/darmasuite/dvm/common/math"
+	"github.com/darmaproject/darmasuite/dvm/core"
+	"github.com/darmaproject/darmasuite/dvm/core/evm"
+	"github.com/darmaproject/darmasuite/dvm/core/rawdb"
+	"github.com/darmaproject/darmasuite/dvm/core/state"
+	"github.com/darmaproject/darmasuite/dvm/core/types"
+	"github.com/darmaproject/darmasuite/dvm/crypto"
+	"github.com/darmaproject/darmasuite/dvm/crypto/sha3"
+	"github.com/darmaproject/darmasuite/dvm/ethdb"
+	"github.com/darmaproject/darmasuite/dvm/rlp"
+)
+
+// StateTest checks transaction processing without block context.
+// See https://github.com/ethereum/EIPs/issues/176 for the test format specification.
+type StateTest struct {
+	json stJSON
+}
+
+// StateSubtest selects a specific configuration of a General State Test.
+type StateSubtest struct {
+	Fork  string
+	Index int
+}
+
+func (t *StateTest) UnmarshalJSON(in []byte) error {
+	return json.Unmarshal(in, &t.json)
+}
+
+type stJSON struct {
+	Env  stEnv                    `json:"env"`
+	Pre  dvm.GenesisAlloc         `json:"pre"`
+	Tx   stTransaction            `json:"transaction"`
+	Out  hexutil.Bytes            `json:"out"`
+	Post map[string][]stPostState `json:"post"`
+}
+
+type stPostState struct {
+	Root    common.UnprefixedHash `json:"hash"`
+	Logs    common.UnprefixedHash `json:"logs"`
+	Indexes struct {
+		Data  int `json:"data"`
+		Gas   int `json:"gas"`
+		Value int `json:"value"`
+	}
+}
+
+type stEnv struct {
+	Coinbase   common.UnprefixedAddress `json:"currentCoinbase"`
+	Difficulty *math.HexOrDecimal256    `json:"currentDifficulty"`
+	GasLimit   math.HexOrDecimal64      `json:"currentGasLimit"`
+	Number     math.HexOrDecimal64      `json:"currentNumber"`
+	Timestamp  math.HexOrDecimal64      `json:"currentTimestamp"`
+}
+
+type stTransaction struct {
+	GasPrice   *math.HexOrDecimal256 `json:"gasPrice"`
+	Nonce      math.HexOrDecimal64   `json:"nonce"`
+	To         string                `json:"to"`
+	Data       []string              `json:"data"`
+	GasLimit   []math.HexOrDecimal64 `json:"gasLimit"`
+	Value      []string              `json:"value"`
+	PrivateKey hexutil.Bytes         `json:"secretKey"`
+}
+
+// Subtests returns all valid subtests of the test.
+func (t *StateTest) Subtests() []StateSubtest {
+	var sub []StateSubtest
+	for fork, pss := range t.json.Post {
+		for i := range pss {
+			sub = append(sub, StateSubtest{fork, i})
+		}
+	}
+	return sub
+}
+
+// Run executes a specific subtest and verifies the post-state and logs
+func (t *StateTest) Run(subtest StateSubtest, vmconfig evm.Config) (*state.StateDB, error) {
+	statedb, root, err := t.RunNoVerify(subtest, vmconfig)
+	if err != nil {
+		return statedb, err
+	}
+	post := t.json.Post[subtest.Fork][subtest.Index]
+	if root != common.Hash(post.Root) {
+		return statedb, fmt.Errorf("post state root mismatch: got %x, want %x", root, post.Root)
+	}
+	if logs := rlpHash(statedb.Logs()); logs != common.Hash(post.Logs) {
+		return statedb, fmt.Errorf("post state logs hash mismatch: got %x, want %x", logs, post.Logs)
+	}
+	return statedb, nil
+}
+
+// RunNoVerify runs a specific subtest and returns the statedb and post-state root
+func (t *StateTest) RunNoVerify(subtest StateSubtest, vmconfig evm.Config) (*state.StateDB, common.Hash, error) {
+	config, err := GetChainConfig(subtest.Fork)
+	if err != nil {
+		return nil, common.Hash{}, err
+	}
+	header := t.header()
+	statedb := MakePreState(rawdb.NewMemoryDatabase(), t.json.Pre)
+
+	post := t.json.Post[subtest.Fork][subtest.Index]
+	msg, err := t.json.Tx.toMessage(post)
+	if err != nil {
+		return nil, common.Hash{}, err
+	}
+	context := dvm.NewVMContext(msg, header, msg.From(), vmTestBlockHash, nil, nil, nil)
+	// NewVMContext credits the sender, the fixtures expect the miner.
+	context.Coinbase = common.Address(t.json.Env.Coinbase)
+	vmenv := evm.NewEVM(context, statedb, config, vmconfig)
+
+	gaspool := new(dvm.GasPool)
+	gaspool.AddGas(header.GasLimit)
+	snapshot := statedb.Snapshot()
+	if _, _, _, err := dvm.ApplyMessage(vmenv, msg, gaspool); err != nil {
+		statedb.RevertToSnapshot(snapshot)
+	}
+	// N.B: We need to do this in a two-step process, because the first Commit takes care
+	// of suicides, and we need to touch the coinbase _after_ it has potentially suicided.
+	statedb.Commit(config.IsEIP158(header.Number))
+	// Add 0-value mining reward. This only makes a difference in the cases
+	// where
+	// - the coinbase suicided, or
+	// - there are only 'bad' transactions, which aren't executed. In those cases,
+	//   the coinbase gets no txfee, so isn't created, and thus needs to be touched
+	statedb.AddBalance(context.Coinbase, new(big.Int))
+	// And _now_ get the state root
+	root := statedb.IntermediateRoot(config.IsEIP158(header.Number))
+	return statedb, root, nil
+}
+
+func (t *StateTest) header() *types.Header {
+	header := &types.Header{
+		Number:     new(big.Int).SetUint64(uint64(t.json.Env.Number)),
+		Time:       uint64(t.json.Env.Timestamp),
+		GasLimit:   uint64(t.json.Env.GasLimit),
+		Difficulty: new(big.Int),
+	}
+	if t.json.Env.Difficulty != nil {
+		header.Difficulty = (*big.Int)(t.json.Env.Difficulty)
+	}
+	return header
+}
+
+// MakePreState creates a state containing the given allocation.
+func MakePreState(db ethdb.Database, accounts dvm.GenesisAlloc) *state.StateDB {
+	sdb := state.NewDatabase(db)
+	statedb, _ := state.New(common.Hash{}, sdb, nil)
+	for addr, a := range accounts {
+		statedb.SetCode(addr, a.Code)
+		statedb.SetNonce(addr, a.Nonce)
+		statedb.SetBalance(addr, a.Balance)
+		for k, v := range a.Storage {
+			statedb.SetState(addr, k, v)
+		}
+	}
+	// Commit and re-open to start with a clean state.
+	root, _ := statedb.Commit(false)
+	statedb, _ = state.New(root, sdb, nil)
+	return statedb
+}
+
+func (tx *stTransaction) toMessage(ps stPostState) (*message, error) {
+	// Derive sender from private key if present.
+	var from common.Address
+	if len(tx.PrivateKey) > 0 {
+		key, err := crypto.ToECDSA(tx.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %v", err)
+		}
+		from = crypto.PubkeyToAddress(key.PublicKey)
+	}
+	// Parse recipient if present.
+	var to *common.Address
+	if tx.To != "" {
+		addr := common.HexToAddress(tx.To)
+		to = &addr
+	}
+
+	// Get values specific to this post state.
+	if ps.Indexes.Data >= len(tx.Data) {
+		return nil, fmt.Errorf("tx data index %d out of bounds", ps.Indexes.Data)
+	}
+	if ps.Indexes.Value >= len(tx.Value) {
+		return nil, fmt.Errorf("tx value index %d out of bounds", ps.Indexes.Value)
+	}
+	if ps.Indexes.Gas >= len(tx.GasLimit) {
+		return nil, fmt.Errorf("tx gas limit index %d out of bounds", ps.Indexes.Gas)
+	}
+	dataHex := tx.Data[ps.Indexes.Data]
+	valueHex := tx.Value[ps.Indexes.Value]
+	gasLimit := tx.GasLimit[ps.Indexes.Gas]
+	// Value, Data hex encoding is messy: https://github.com/ethereum/tests/issues/203
+	value := new(big.Int)
+	if valueHex != "0x" {
+		v, ok := math.ParseBig256(valueHex)
+		if !ok {
+			return nil, fmt.Errorf("invalid tx value %q", valueHex)
+		}
+		value = v
+	}
+	data, err := hex.DecodeString(strings.TrimPrefix(dataHex, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid tx data %q", dataHex)
+	}
+	gasPrice := new(big.Int)
+	if tx.GasPrice != nil {
+		gasPrice = (*big.Int)(tx.GasPrice)
+	}
+
+	msg := &message{
+		from:     from,
+		to:       to,
+		nonce:    uint64(tx.Nonce),
+		value:    value,
+		gas:      uint64(gasLimit),
+		gasPrice: gasPrice,
+		data:     data,
+	}
+	return msg, nil
+}
+
+// message implements dvm.Message for a fixture transaction.
+type message struct {
+	from     common.Address
+	to       *common.Address
+	nonce    uint64
+	value    *big.Int
+	gas      uint64
+	gasPrice *big.Int
+	data     []byte
+}
+
+func (m *message) From() common.Address { return m.from }
+func (m *message) To() *common.Address  { return m.to }
+func (m *message) GasPrice() *big.Int   { return m.gasPrice }
+func (m *message) Gas() uint64          { return m.gas }
+func (m *message) Value() *big.Int      { return m.value }
+func (m *message) Nonce() uint64        { return m.nonce }
+func (m *message) CheckNonce() bool     { return true }
+func (m *message) Data() []byte         { return m.data }
+func (m *message) ToIsEmpty() bool      { return m.to == nil }
+
+func vmTestBlockHash(n uint64) common.Hash {
+	return common.BytesToHash(crypto.Keccak256([]byte(big.NewInt(int64(n)).String())))
+}
+
+func rlpHash(x interface{}) (h common.Hash) {
+	hw := sha3.NewKeccak256()
+	rlp.Encode(hw, x)
+	hw.Sum(h[:0])
+	return h
+}
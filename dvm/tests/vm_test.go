@@ -0,0 +1,36 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/darmaproject/darmasuite/dvm/core/evm"
+)
+
+func TestVM(t *testing.T) {
+	t.Parallel()
+
+	vmt := new(testMatcher)
+	vmt.skipLoad(`^vmPerformance/`)
+
+	vmt.walk(t, vmTestDir, func(t *testing.T, name string, test *VMTest) {
+		if err := vmt.checkFailure(t, test.Run(evm.Config{})); err != nil {
+			t.Error(err)
+		}
+	})
+}
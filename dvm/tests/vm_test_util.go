@@ -0,0 +1,152 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/darmaproject/darmasuite/dvm/common"
+	"github.com/darmaproject/darmasuite/dvm/common/hexutil"
+	"github.com/darmaproject/darmasuite/dvm/common/math"
+	"github.com/darmaproject/darmasuite/dvm/core"
+	"github.com/darmaproject/darmasuite/dvm/core/evm"
+	"github.com/darmaproject/darmasuite/dvm/core/rawdb"
+	"github.com/darmaproject/darmasuite/dvm/core/state"
+	"github.com/darmaproject/darmasuite/dvm/core/vm"
+	inter "github.com/darmaproject/darmasuite/dvm/core/vm/interface"
+)
+
+// VMTest checks EVM execution without block or transaction context.
+// See https://github.com/ethereum/tests/wiki/VM-Tests for the test format specification.
+type VMTest struct {
+	json vmJSON
+}
+
+// vmTestFork is the fork the legacy VM tests were generated for.
+const vmTestFork = "Frontier"
+
+func (t *VMTest) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &t.json)
+}
+
+type vmJSON struct {
+	Env           stEnv                 `json:"env"`
+	Exec          vmExec                `json:"exec"`
+	Logs          common.UnprefixedHash `json:"logs"`
+	GasRemaining  *math.HexOrDecimal64  `json:"gas"`
+	Out           hexutil.Bytes         `json:"out"`
+	Pre           dvm.GenesisAlloc      `json:"pre"`
+	Post          dvm.GenesisAlloc      `json:"post"`
+	PostStateRoot common.Hash           `json:"postStateRoot"`
+}
+
+type vmExec struct {
+	Address  common.UnprefixedAddress `json:"address"`
+	Value    *math.HexOrDecimal256    `json:"value"`
+	GasLimit math.HexOrDecimal64      `json:"gas"`
+	Caller   common.UnprefixedAddress `json:"caller"`
+	Origin   common.UnprefixedAddress `json:"origin"`
+	Code     hexutil.Bytes            `json:"code"`
+	Data     hexutil.Bytes            `json:"data"`
+	GasPrice *math.HexOrDecimal256    `json:"gasPrice"`
+}
+
+// Run executes the test and compares the output, remaining gas, post storage
+// and logs with the fixture.
+func (t *VMTest) Run(vmconfig evm.Config) error {
+	statedb := MakePreState(rawdb.NewMemoryDatabase(), t.json.Pre)
+	ret, gasRemaining, err := t.exec(statedb, vmconfig)
+
+	if t.json.GasRemaining == nil {
+		if err == nil {
+			return fmt.Errorf("gas unspecified (indicating an error), but VM returned no error")
+		}
+		if gasRemaining > 0 {
+			return fmt.Errorf("gas unspecified (indicating an error), but VM returned gas remaining > 0")
+		}
+		return nil
+	}
+	// Test declares gas, expecting outputs to match.
+	if !bytes.Equal(ret, t.json.Out) {
+		return fmt.Errorf("return data mismatch: got %x, want %x", ret, t.json.Out)
+	}
+	if gasRemaining != uint64(*t.json.GasRemaining) {
+		return fmt.Errorf("remaining gas %v, want %v", gasRemaining, *t.json.GasRemaining)
+	}
+	for addr, account := range t.json.Post {
+		for k, wantV := range account.Storage {
+			if haveV := statedb.GetState(addr, k); haveV != wantV {
+				return fmt.Errorf("wrong storage value at %x:\n  got  %x\n  want %x", k, haveV, wantV)
+			}
+		}
+	}
+	// if root := statedb.IntermediateRoot(false); root != t.json.PostStateRoot {
+	// 	return fmt.Errorf("post state root mismatch, got %x, want %x", root, t.json.PostStateRoot)
+	// }
+	if logs := rlpHash(statedb.Logs()); logs != common.Hash(t.json.Logs) {
+		return fmt.Errorf("post state logs hash mismatch: got %x, want %x", logs, t.json.Logs)
+	}
+	return nil
+}
+
+func (t *VMTest) exec(statedb *state.StateDB, vmconfig evm.Config) ([]byte, uint64, error) {
+	vmenv, err := t.newEVM(statedb, vmconfig)
+	if err != nil {
+		return nil, 0, err
+	}
+	e := t.json.Exec
+	return vmenv.Call(evm.AccountRef(common.Address(e.Caller)), common.Address(e.Address), e.Data, uint64(e.GasLimit), (*big.Int)(e.Value))
+}
+
+func (t *VMTest) newEVM(statedb *state.StateDB, vmconfig evm.Config) (*evm.EVM, error) {
+	config, err := GetChainConfig(vmTestFork)
+	if err != nil {
+		return nil, err
+	}
+	initialCall := true
+	canTransfer := func(db inter.StateDB, address common.Address, amount *big.Int) bool {
+		if initialCall {
+			initialCall = false
+			return true
+		}
+		return dvm.CanTransfer(db, address, amount)
+	}
+	transfer := func(db inter.StateDB, sender, recipient common.Address, amount *big.Int) {}
+	context := vm.Context{
+		CanTransferFunc: canTransfer,
+		TransferFunc:    transfer,
+		GetHash:         vmTestBlockHash,
+		Origin:          common.Address(t.json.Exec.Origin),
+		Coinbase:        common.Address(t.json.Env.Coinbase),
+		BlockNumber:     new(big.Int).SetUint64(uint64(t.json.Env.Number)),
+		Time:            new(big.Int).SetUint64(uint64(t.json.Env.Timestamp)),
+		GasLimit:        uint64(t.json.Env.GasLimit),
+		Difficulty:      new(big.Int),
+		GasPrice:        new(big.Int),
+	}
+	if t.json.Env.Difficulty != nil {
+		context.Difficulty = (*big.Int)(t.json.Env.Difficulty)
+	}
+	if t.json.Exec.GasPrice != nil {
+		context.GasPrice = (*big.Int)(t.json.Exec.GasPrice)
+	}
+	vmconfig.NoRecursion = true
+	return evm.NewEVM(context, statedb, config, vmconfig), nil
+}
@@ -28,6 +28,13 @@ const (
 	SYNCING                       // 1
 )
 
+// the priorities the daemon estimates tx fees for
+const (
+	FEE_PRIORITY_SLOW   = "slow"
+	FEE_PRIORITY_NORMAL = "normal"
+	FEE_PRIORITY_FAST   = "fast"
+)
+
 // all the the global variables used by the program are stored here
 // since the entire logic is designed around a state machine driven by external events
 // once the core starts nothing changes until there is a network state change
@@ -0,0 +1,177 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+
+package globals
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RPCTLSConfig returns the TLS config of an RPC server listening on addr. The
+// certificate is given by --rpc-tls-cert and --rpc-tls-key, or generated once
+// if --rpc-tls-self-signed is set and kept as name.crt and name.key in dir, so
+// clients pinning its fingerprint keep working across restarts. It returns a
+// nil config if TLS is not enabled, and the SHA-256 fingerprint of the
+// certificate otherwise.
+func RPCTLSConfig(addr, dir, name string) (config *tls.Config, fingerprint string, err error) {
+	certFile, _ := Arguments["--rpc-tls-cert"].(string)
+	keyFile, _ := Arguments["--rpc-tls-key"].(string)
+	selfSigned, _ := Arguments["--rpc-tls-self-signed"].(bool)
+
+	switch {
+	case certFile != "" || keyFile != "":
+		if certFile == "" || keyFile == "" {
+			return nil, "", fmt.Errorf("--rpc-tls-cert and --rpc-tls-key must be given together")
+		}
+	case selfSigned:
+		certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+		if _, err = os.Stat(certFile); os.IsNotExist(err) {
+			if err = generateCertificate(addr, certFile, keyFile); err != nil {
+				return nil, "", err
+			}
+		}
+	default:
+		return nil, "", nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, "", err
+	}
+	config = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	return config, CertificateFingerprint(cert.Certificate[0]), nil
+}
+
+// CertificateFingerprint returns the SHA-256 fingerprint of a DER encoded
+// certificate the way browsers and openssl print it.
+func CertificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, ":")
+}
+
+// generateCertificate writes a self-signed certificate valid for the host of
+// addr, or for localhost and the hostname if addr listens on all interfaces.
+func generateCertificate(addr, certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Darma"}, CommonName: "darma rpc"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+		template.IPAddresses = append(template.IPAddresses, ip)
+	} else if ip == nil && host != "" {
+		template.DNSNames = append(template.DNSNames, host)
+	} else {
+		template.DNSNames = append(template.DNSNames, "localhost")
+		template.IPAddresses = append(template.IPAddresses, net.IPv4(127, 0, 0, 1), net.IPv6loopback)
+		if hostname, err := os.Hostname(); err == nil {
+			template.DNSNames = append(template.DNSNames, hostname)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(certFile), 0750); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// RPCCORSHandler lets browsers on the origins given by --rpc-cors, comma
+// separated or * for any, call next. Preflight requests are answered here,
+// before any authentication, since browsers send them without credentials.
+func RPCCORSHandler(next http.Handler) http.Handler {
+	s, _ := Arguments["--rpc-cors"].(string)
+	origins := make(map[string]bool)
+	for _, origin := range strings.Split(s, ",") {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			origins[origin] = true
+		}
+	}
+	if len(origins) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if origin != "" && (origins["*"] || origins[origin]) {
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			if origins[origin] {
+				h.Set("Access-Control-Allow-Origin", origin)
+				h.Set("Access-Control-Allow-Credentials", "true")
+			} else {
+				// any site may read, but not with the credentials of the user
+				h.Set("Access-Control-Allow-Origin", "*")
+			}
+			if req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" {
+				h.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+				h.Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+				h.Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// RPCShutdownTimeout returns how long the RPC servers let running requests
+// finish on shutdown before aborting them, given by --rpc-shutdown-timeout.
+func RPCShutdownTimeout() time.Duration {
+	if s, ok := Arguments["--rpc-shutdown-timeout"].(string); ok {
+		if timeout, err := time.ParseDuration(s); err == nil && timeout >= 0 {
+			return timeout
+		}
+		if Logger != nil {
+			Logger.Warnf("--rpc-shutdown-timeout %q is invalid, using 10s", s)
+		}
+	}
+	return 10 * time.Second
+}
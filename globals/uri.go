@@ -0,0 +1,163 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+
+package globals
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/darmaproject/darmasuite/address"
+)
+
+// URI_SCHEME is the scheme of payment request URIs, which look like
+//
+//	darma:<address>?amount=1.5&payment_id=<hex>&label=Order%2042&expiry=<unix time>
+//
+// the amount is in DMCH, or in units of the omni token or the ERC-20 contract
+// given by token=<symbol> or contract=<address>
+const URI_SCHEME = "darma"
+
+// PaymentRequest is what a payment request URI asks for.
+type PaymentRequest struct {
+	Address   string `json:"address"`              // may be an integrated address
+	Amount    string `json:"amount,omitempty"`     // decimal, empty to let the payer choose
+	PaymentID string `json:"payment_id,omitempty"` // hex, if not in an integrated address
+	Token     string `json:"token,omitempty"`      // symbol of an omni token
+	Contract  string `json:"contract,omitempty"`   // address of an ERC-20 contract
+	Label     string `json:"label,omitempty"`
+	Expiry    int64  `json:"expiry,omitempty"` // unix time, 0 if it does not expire
+}
+
+// ParsePaymentURI parses and validates a payment request URI, in reference to the
+// current main/test mode.
+func ParsePaymentURI(uri string) (*PaymentRequest, error) {
+	uri = strings.TrimSpace(uri)
+	i := strings.Index(uri, ":")
+	if i < 0 || !strings.EqualFold(uri[:i], URI_SCHEME) {
+		return nil, fmt.Errorf("URI scheme must be %s:", URI_SCHEME)
+	}
+	rest := strings.TrimPrefix(uri[i+1:], "//") // some QR readers add the slashes
+
+	var query string
+	if i = strings.Index(rest, "?"); i >= 0 {
+		rest, query = rest[:i], rest[i+1:]
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("URI query could not be parsed err: %s", err)
+	}
+
+	p := &PaymentRequest{
+		Address:   rest,
+		Amount:    values.Get("amount"),
+		PaymentID: values.Get("payment_id"),
+		Token:     values.Get("token"),
+		Contract:  values.Get("contract"),
+		Label:     values.Get("label"),
+	}
+	if expiry := values.Get("expiry"); expiry != "" {
+		if p.Expiry, err = strconv.ParseInt(expiry, 10, 64); err != nil || p.Expiry < 0 {
+			return nil, fmt.Errorf("URI expiry must be a unix time")
+		}
+	}
+	if err = p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate checks the fields of p, in reference to the current main/test mode.
+func (p *PaymentRequest) Validate() error {
+	addr, err := ParseValidateAddress(p.Address)
+	if err != nil {
+		return err
+	}
+
+	if p.PaymentID != "" {
+		if addr.IsIntegratedAddress() {
+			return fmt.Errorf("Payment ID provided in both integrated address and separately")
+		}
+		if len(p.PaymentID) != 16 && len(p.PaymentID) != 64 {
+			return fmt.Errorf("Payment ID must be 16 or 64 hex chars")
+		}
+		if _, err = hex.DecodeString(p.PaymentID); err != nil {
+			return fmt.Errorf("Payment ID must be hex")
+		}
+	}
+
+	if p.Token != "" && p.Contract != "" {
+		return fmt.Errorf("Only one of token and contract can be given")
+	}
+	if p.Token != "" {
+		if p.Token, err = ParseTokenSymbol(p.Token); err != nil {
+			return err
+		}
+	}
+	if p.Contract != "" {
+		if _, err = address.NewAddress(p.Contract); err != nil {
+			return fmt.Errorf("Contract address is invalid")
+		}
+	}
+
+	if p.Amount != "" {
+		if p.Token == "" && p.Contract == "" {
+			_, err = ParseAmount(p.Amount, false)
+			return err
+		}
+		// the decimals of tokens are not known here
+		amount, _, err := big.ParseFloat(p.Amount, 10, 0, big.ToZero)
+		if err != nil || amount.Sign() <= 0 {
+			return fmt.Errorf("Amount is invalid %s", p.Amount)
+		}
+	}
+	return nil
+}
+
+// Expired tells whether p expired at now.
+func (p *PaymentRequest) Expired(now time.Time) bool {
+	return p.Expiry != 0 && now.Unix() > p.Expiry
+}
+
+// URI returns the payment request URI of p, ready to be shown as a QR code.
+func (p *PaymentRequest) URI() string {
+	values := url.Values{}
+	if p.Amount != "" {
+		values.Set("amount", p.Amount)
+	}
+	if p.PaymentID != "" {
+		values.Set("payment_id", p.PaymentID)
+	}
+	if p.Token != "" {
+		values.Set("token", p.Token)
+	}
+	if p.Contract != "" {
+		values.Set("contract", p.Contract)
+	}
+	if p.Label != "" {
+		values.Set("label", p.Label)
+	}
+	if p.Expiry != 0 {
+		values.Set("expiry", strconv.FormatInt(p.Expiry, 10))
+	}
+
+	uri := URI_SCHEME + ":" + p.Address
+	if len(values) > 0 {
+		// spaces as %20, not all wallets read them as +
+		uri += "?" + strings.Replace(values.Encode(), "+", "%20", -1)
+	}
+	return uri
+}
+
+// FormatURIAmount formats amount in DMCH with no trailing zeroes, as URIs give it.
+func FormatURIAmount(amount uint64) string {
+	s := FormatMoney(amount)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
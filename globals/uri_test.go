@@ -0,0 +1,100 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+
+package globals
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/darmaproject/darmasuite/address"
+	"github.com/darmaproject/darmasuite/config"
+	"github.com/darmaproject/darmasuite/crypto"
+)
+
+// testAddresses returns a testnet address and an integrated address of it.
+func testAddresses(t *testing.T) (string, string) {
+	saved := Config
+	Config = config.TestNet
+	t.Cleanup(func() { Config = saved })
+
+	_, spend := crypto.NewKeyPair()
+	_, view := crypto.NewKeyPair()
+	addr := address.NewAddressFromKeys(config.TestNet.PublicAddressPrefix, *spend, *view)
+	integrated := *addr
+	integrated.PaymentID = []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	return addr.String(), integrated.String()
+}
+
+func TestPaymentURIRoundTrip(t *testing.T) {
+	addr, integrated := testAddresses(t)
+
+	for _, p := range []PaymentRequest{
+		{Address: addr},
+		{Address: addr, Amount: "1.5", PaymentID: strings.Repeat("ab", 32), Label: "Order 42", Expiry: 1600000000},
+		{Address: integrated, Amount: "0.25", Label: "café & co?=/"},
+		{Address: addr, Amount: "100", Contract: addr},
+	} {
+		uri := p.URI()
+		got, err := ParsePaymentURI(uri)
+		if err != nil {
+			t.Fatalf("%s: %s", uri, err)
+		}
+		if *got != p {
+			t.Fatalf("%s parsed as %+v, want %+v", uri, *got, p)
+		}
+	}
+}
+
+func TestPaymentURILabel(t *testing.T) {
+	addr, _ := testAddresses(t)
+
+	uri := (&PaymentRequest{Address: addr, Label: "Order 42"}).URI()
+	if !strings.HasSuffix(uri, "?label=Order%2042") {
+		t.Fatalf("label encoded as %s", uri)
+	}
+	for _, uri := range []string{
+		"darma:" + addr + "?label=Order%2042",
+		"darma:" + addr + "?label=Order+42",
+		"DARMA://" + addr + "?label=Order%2042",
+	} {
+		p, err := ParsePaymentURI(uri)
+		if err != nil {
+			t.Fatalf("%s: %s", uri, err)
+		}
+		if p.Label != "Order 42" {
+			t.Fatalf("%s has label %q", uri, p.Label)
+		}
+	}
+}
+
+func TestPaymentURIInvalid(t *testing.T) {
+	addr, integrated := testAddresses(t)
+
+	for _, test := range []struct {
+		uri string
+		err string
+	}{
+		{"monero:" + addr, "URI scheme must be darma:"},
+		{addr, "URI scheme must be darma:"},
+		{"darma:" + integrated + "?payment_id=" + strings.Repeat("ab", 8), "both integrated address and separately"},
+		{"darma:" + addr + "?payment_id=abc", "16 or 64 hex chars"},
+		{"darma:" + addr + "?amount=1&token=TKN&contract=" + addr, "Only one of token and contract"},
+		{"darma:" + addr + "?expiry=soon", "unix time"},
+		{"darma:" + addr + "?label=%zz", "could not be parsed"},
+	} {
+		if _, err := ParsePaymentURI(test.uri); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s parsed, err %v", test.uri, err)
+		}
+	}
+}
+
+func TestPaymentURIExpired(t *testing.T) {
+	p := PaymentRequest{Expiry: 1600000000}
+	if p.Expired(time.Unix(1600000000, 0)) || !p.Expired(time.Unix(1600000001, 0)) {
+		t.Fatalf("request expiring at %d", p.Expiry)
+	}
+	if (&PaymentRequest{}).Expired(time.Now()) {
+		t.Fatalf("request without expiry expired")
+	}
+}
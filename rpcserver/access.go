@@ -0,0 +1,544 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rpcserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/darmaproject/darmasuite/globals"
+)
+
+// the namespaces the RPC methods are grouped in
+const (
+	NamespacePublic = "public" // read only queries of the chain
+	NamespaceWallet = "wallet" // what wallets need to scan outputs and relay txs
+	NamespaceAdmin  = "admin"  // mining and node internals
+	NamespaceDebug  = "debug"  // profiling and dag dumps
+)
+
+var allNamespaces = []string{NamespacePublic, NamespaceWallet, NamespaceAdmin, NamespaceDebug}
+
+// methodNamespaces maps the JSON-RPC methods, and the plain http endpoints by
+// their path without the leading slash, to their namespace. Anything missing
+// here is treated as admin.
+var methodNamespaces = map[string]string{
+	"":                                NamespacePublic,
+	"Main.Echo":                       NamespacePublic,
+	"getheight":                       NamespacePublic,
+	"getblockcount":                   NamespacePublic,
+	"on_getblockhash":                 NamespacePublic,
+	"getlastblockheader":              NamespacePublic,
+	"getblockheaderbyhash":            NamespacePublic,
+	"getblockheaderbyheight":          NamespacePublic,
+	"getblockheaderbytopoheight":      NamespacePublic,
+	"getblockshashbytopoheight":       NamespacePublic,
+	"getblock":                        NamespacePublic,
+	"getblocklist":                    NamespacePublic,
+	"getblockinfo":                    NamespacePublic,
+	"gettxinfo":                       NamespacePublic,
+	"check_tx_key":                    NamespacePublic,
+	"get_info":                        NamespacePublic,
+	"get_difficulty":                  NamespacePublic,
+	"gettxpool":                       NamespacePublic,
+	"estimate_fee":                    NamespacePublic,
+	"get_poolsvotetats":               NamespacePublic,
+	"get_poolbonusstats":              NamespacePublic,
+	"get_stake_pool":                  NamespacePublic,
+	"get_stake_info":                  NamespacePublic,
+	"list_stake_pool":                 NamespacePublic,
+	"list_share":                      NamespacePublic,
+	"get_share":                       NamespacePublic,
+	"get_contract_address_by_txhash":  NamespacePublic,
+	"call_contract":                   NamespacePublic,
+	"get_contract_result":             NamespacePublic,
+	"get_balance_of_contract_account": NamespacePublic,
+	"get_contract_account_address":    NamespacePublic,
+	"eth_blockNumber":                 NamespacePublic,
+	"eth_call":                        NamespacePublic,
+	"eth_getTransactionReceipt":       NamespacePublic,
+
+	"get_token_output":        NamespaceWallet,
+	"getoutputs":              NamespaceWallet,
+	"getoutputs.bin":          NamespaceWallet,
+	"gettransactions":         NamespaceWallet,
+	"sendrawtransaction":      NamespaceWallet,
+	"eth_sendRawTransaction":  NamespaceWallet,
+	"is_key_image_spent":      NamespaceWallet,
+	"is_token_keyimage_spent": NamespaceWallet,
+	"is_token_id_spent":       NamespaceWallet,
+
+	"getblocktemplate": NamespaceAdmin,
+	"submitblock":      NamespaceAdmin,
+	"getp2psession":    NamespaceAdmin,
+
+	"debug_stable":  NamespaceDebug,
+	"gettreegraph":  NamespaceDebug,
+	"get_dot_graph": NamespaceDebug,
+	"metrics":       NamespaceDebug,
+}
+
+// methodNamespace returns the namespace of method.
+func methodNamespace(method string) string {
+	if strings.HasPrefix(method, "debug/") {
+		return NamespaceDebug
+	}
+	if ns, ok := methodNamespaces[method]; ok {
+		return ns
+	}
+	return NamespaceAdmin
+}
+
+// AccessConfig is read from the file given by --rpc-access-config, e.g.
+//
+//	{"keys": [{"key": "s3cret", "namespaces": ["public", "wallet", "admin"], "rate": 50}],
+//	 "anonymous": ["public"], "deny": ["getp2psession"],
+//	 "ip_rate": 5, "ip_burst": 20, "max_request_size": 1048576}
+//
+// With no keys every namespace is served without one, as before, unless
+// anonymous says otherwise. With keys, only public is served without one.
+type AccessConfig struct {
+	Keys           []APIKey `json:"keys"`
+	Anonymous      []string `json:"anonymous"`        // namespaces served without a key
+	Allow          []string `json:"allow"`            // if set, only these methods are served
+	Deny           []string `json:"deny"`             // methods never served
+	IPRate         float64  `json:"ip_rate"`          // requests per second of a client without a key, 0 for no limit
+	IPBurst        int      `json:"ip_burst"`         // defaults to twice the rate
+	KeyRate        float64  `json:"key_rate"`         // requests per second of a key, 0 for no limit
+	KeyBurst       int      `json:"key_burst"`        // defaults to twice the rate
+	MaxRequestSize int64    `json:"max_request_size"` // bytes, defaults to 10 MB
+}
+
+// APIKey is a key clients send as "Authorization: Bearer <key>" or as
+// "X-API-Key: <key>".
+type APIKey struct {
+	Key        string   `json:"key"`
+	Namespaces []string `json:"namespaces"` // defaults to all namespaces
+	Rate       float64  `json:"rate"`       // overrides key_rate
+	Burst      int      `json:"burst"`      // overrides key_burst
+}
+
+// loadAccessConfig returns the access config given by the --rpc-access-config
+// file and the --rpc-api-key, --rpc-anonymous, --rpc-allow-methods,
+// --rpc-deny-methods, --rpc-ip-rate, --rpc-key-rate and
+// --rpc-max-request-size flags, the flags taking precedence.
+func loadAccessConfig() (c AccessConfig, err error) {
+	if file, ok := globals.Arguments["--rpc-access-config"].(string); ok {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return c, err
+		}
+		if err = json.Unmarshal(data, &c); err != nil {
+			return c, fmt.Errorf("invalid rpc access config %s: %s", file, err)
+		}
+	}
+
+	keys, _ := globals.Arguments["--rpc-api-key"].([]string)
+	for _, key := range keys {
+		k := APIKey{Key: key}
+		if i := strings.Index(key, "="); i >= 0 {
+			k.Key, k.Namespaces = key[:i], splitList(key[i+1:])
+		}
+		c.Keys = append(c.Keys, k)
+	}
+	if s, ok := globals.Arguments["--rpc-anonymous"].(string); ok {
+		c.Anonymous = splitList(s)
+	}
+	if s, ok := globals.Arguments["--rpc-allow-methods"].(string); ok {
+		c.Allow = splitList(s)
+	}
+	if s, ok := globals.Arguments["--rpc-deny-methods"].(string); ok {
+		c.Deny = splitList(s)
+	}
+	if s, ok := globals.Arguments["--rpc-ip-rate"].(string); ok {
+		if c.IPRate, err = strconv.ParseFloat(s, 64); err != nil || c.IPRate < 0 {
+			return c, fmt.Errorf("--rpc-ip-rate %q is invalid", s)
+		}
+	}
+	if s, ok := globals.Arguments["--rpc-key-rate"].(string); ok {
+		if c.KeyRate, err = strconv.ParseFloat(s, 64); err != nil || c.KeyRate < 0 {
+			return c, fmt.Errorf("--rpc-key-rate %q is invalid", s)
+		}
+	}
+	if s, ok := globals.Arguments["--rpc-max-request-size"].(string); ok {
+		if c.MaxRequestSize, err = strconv.ParseInt(s, 10, 64); err != nil || c.MaxRequestSize < 0 {
+			return c, fmt.Errorf("--rpc-max-request-size %q is invalid", s)
+		}
+	}
+	return c, nil
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(s string) (list []string) {
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return
+}
+
+// accessKey is an APIKey ready to be checked.
+type accessKey struct {
+	hash       [sha256.Size]byte
+	namespaces map[string]bool
+	bucket     *tokenBucket
+}
+
+// accessControl authenticates, rate limits and filters the requests to the
+// RPC server according to an AccessConfig.
+type accessControl struct {
+	keys      []*accessKey
+	anonymous map[string]bool
+	allow     map[string]bool
+	deny      map[string]bool
+	maxSize   int64
+
+	ipRate  float64
+	ipBurst int
+	ips     *bucketMap
+}
+
+func newAccessControl(c AccessConfig) (*accessControl, error) {
+	a := &accessControl{
+		allow:   listSet(c.Allow),
+		deny:    listSet(c.Deny),
+		maxSize: c.MaxRequestSize,
+		ipRate:  c.IPRate,
+		ipBurst: burst(c.IPRate, c.IPBurst),
+		ips:     newBucketMap(),
+	}
+	if a.maxSize == 0 {
+		a.maxSize = 10 << 20
+	}
+
+	for _, k := range c.Keys {
+		if k.Key == "" {
+			return nil, fmt.Errorf("empty api key")
+		}
+		namespaces := k.Namespaces
+		if len(namespaces) == 0 {
+			namespaces = allNamespaces
+		}
+		if err := checkNamespaces(namespaces); err != nil {
+			return nil, err
+		}
+		rate, b := c.KeyRate, c.KeyBurst
+		if k.Rate > 0 {
+			rate, b = k.Rate, k.Burst
+		}
+		key := &accessKey{hash: sha256.Sum256([]byte(k.Key)), namespaces: listSet(namespaces)}
+		if rate > 0 {
+			key.bucket = newTokenBucket(rate, burst(rate, b))
+		}
+		a.keys = append(a.keys, key)
+	}
+
+	anonymous := c.Anonymous
+	if anonymous == nil {
+		anonymous = allNamespaces
+		if len(c.Keys) > 0 {
+			anonymous = []string{NamespacePublic}
+		}
+	}
+	if err := checkNamespaces(anonymous); err != nil {
+		return nil, err
+	}
+	a.anonymous = listSet(anonymous)
+	return a, nil
+}
+
+func checkNamespaces(namespaces []string) error {
+	for _, ns := range namespaces {
+		if !listSet(allNamespaces)[ns] {
+			return fmt.Errorf("unknown rpc namespace %q, must be one of %s", ns, strings.Join(allNamespaces, ", "))
+		}
+	}
+	return nil
+}
+
+func listSet(list []string) map[string]bool {
+	set := make(map[string]bool, len(list))
+	for _, item := range list {
+		set[item] = true
+	}
+	return set
+}
+
+// burst returns b, or twice the rate if b is not set.
+func burst(rate float64, b int) int {
+	if b > 0 {
+		return b
+	}
+	return int(math.Max(1, math.Ceil(2*rate)))
+}
+
+// lookupKey returns the key sent with req, nil if there is none. ok is false
+// if a key was sent but is unknown.
+func (a *accessControl) lookupKey(req *http.Request) (key *accessKey, ok bool) {
+	sent := req.Header.Get("X-API-Key")
+	if auth := req.Header.Get("Authorization"); sent == "" && auth != "" {
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return nil, false
+		}
+		sent = strings.TrimSpace(auth[len("Bearer "):])
+	}
+	if sent == "" {
+		return nil, true
+	}
+
+	// compare the hashes of all keys so the time taken tells nothing
+	hash := sha256.Sum256([]byte(sent))
+	for _, k := range a.keys {
+		if subtle.ConstantTimeCompare(hash[:], k.hash[:]) == 1 {
+			key = k
+		}
+	}
+	return key, key != nil
+}
+
+// Handler wraps next, the mux of the RPC server, with the access control.
+func (a *accessControl) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		jsonrpc := req.URL.Path == "/json_rpc"
+		fail := func(code int, msg string) {
+			if jsonrpc {
+				writeRPCError(w, code, msg)
+			} else {
+				http.Error(w, msg, code)
+			}
+		}
+
+		if req.ContentLength > a.maxSize {
+			fail(http.StatusRequestEntityTooLarge, "request too large")
+			return
+		}
+		req.Body = http.MaxBytesReader(w, req.Body, a.maxSize)
+
+		methods := []string{strings.TrimPrefix(req.URL.Path, "/")}
+		if jsonrpc {
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				fail(http.StatusRequestEntityTooLarge, "request too large")
+				return
+			}
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+			// a body whose methods are not known cannot be checked, so it
+			// never reaches the method repository
+			if methods, err = rpcMethods(body); err != nil {
+				fail(http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		key, ok := a.lookupKey(req)
+		bucket := key.tokenBucket()
+		if key == nil && a.ipRate > 0 {
+			bucket = a.ips.get(clientIP(req), a.ipRate, a.ipBurst)
+		}
+		if bucket != nil && !bucket.take(len(methods)) {
+			fail(http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		if !ok {
+			fail(http.StatusUnauthorized, "invalid api key")
+			return
+		}
+
+		for _, method := range methods {
+			if err := a.check(key, method); err != nil {
+				status := http.StatusForbidden
+				if key == nil {
+					status = http.StatusUnauthorized
+				}
+				fail(status, err.Error())
+				return
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// check returns an error if the client having key, nil if it has none, may
+// not call method.
+func (a *accessControl) check(key *accessKey, method string) error {
+	if a.deny[method] || (len(a.allow) > 0 && !a.allow[method]) {
+		return fmt.Errorf("method %q is not allowed", method)
+	}
+	ns := methodNamespace(method)
+	if key == nil && !a.anonymous[ns] {
+		return fmt.Errorf("method %q needs an api key", method)
+	}
+	if key != nil && !key.namespaces[ns] {
+		return fmt.Errorf("method %q is not allowed for this api key", method)
+	}
+	return nil
+}
+
+func (k *accessKey) tokenBucket() *tokenBucket {
+	if k == nil {
+		return nil
+	}
+	return k.bucket
+}
+
+// rpcMethods returns the methods called by a JSON-RPC request or batch. The
+// body is read the way the JSON-RPC library reads it, a streaming decode of
+// its first value, and anything after that value is refused, so the methods
+// checked are always the methods run.
+func rpcMethods(body []byte) ([]string, error) {
+	type call struct {
+		Method *string `json:"method"`
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid request: %s", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid request: data after the request")
+	}
+
+	var calls []call
+	if raw[0] == '[' {
+		if err := json.Unmarshal(raw, &calls); err != nil {
+			return nil, fmt.Errorf("invalid request: %s", err)
+		}
+		if len(calls) == 0 {
+			return nil, fmt.Errorf("invalid request: empty batch")
+		}
+	} else {
+		var c call
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return nil, fmt.Errorf("invalid request: %s", err)
+		}
+		calls = append(calls, c)
+	}
+
+	methods := make([]string, len(calls))
+	for i := range calls {
+		if calls[i].Method == nil || *calls[i].Method == "" {
+			return nil, fmt.Errorf("invalid request: missing method")
+		}
+		methods[i] = *calls[i].Method
+	}
+	return methods, nil
+}
+
+// writeRPCError answers a JSON-RPC request rejected by the access control.
+func writeRPCError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      nil,
+		"error":   map[string]interface{}{"code": -32000 - status, "message": msg},
+	})
+}
+
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// tokenBucket allows rate requests per second with bursts of up to burst
+// requests.
+type tokenBucket struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// take takes n tokens, and returns false if there are not enough of them.
+func (b *tokenBucket) take(n int) bool {
+	if n < 1 {
+		n = 1
+	}
+	b.Lock()
+	defer b.Unlock()
+
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// full returns whether the bucket refilled, i.e. it has not been used for a
+// while.
+func (b *tokenBucket) full(now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+// bucketMap holds the token buckets of the client ips.
+type bucketMap struct {
+	sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+func newBucketMap() *bucketMap {
+	return &bucketMap{buckets: make(map[string]*tokenBucket), swept: time.Now()}
+}
+
+// get returns the bucket of ip, dropping those that are full once a minute so
+// the map does not grow with every client ever seen.
+func (m *bucketMap) get(ip string, rate float64, burst int) *tokenBucket {
+	m.Lock()
+	defer m.Unlock()
+
+	if now := time.Now(); now.Sub(m.swept) > time.Minute {
+		for k, b := range m.buckets {
+			if b.full(now) {
+				delete(m.buckets, k)
+			}
+		}
+		m.swept = now
+	}
+	b, ok := m.buckets[ip]
+	if !ok {
+		b = newTokenBucket(rate, burst)
+		m.buckets[ip] = b
+	}
+	return b
+}
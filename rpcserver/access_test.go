@@ -0,0 +1,128 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rpcserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serveAccess(t *testing.T, a *accessControl, path, key, body string) int {
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// the body must still be readable after the access control read it
+		data, _ := ioutil.ReadAll(req.Body)
+		if string(data) != body {
+			t.Errorf("body %q, want %q", data, body)
+		}
+	})
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	req.RemoteAddr = "192.0.2.1:1234"
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	w := httptest.NewRecorder()
+	a.Handler(next).ServeHTTP(w, req)
+	return w.Code
+}
+
+func rpcBody(methods ...string) string {
+	calls := make([]string, len(methods))
+	for i, m := range methods {
+		calls[i] = `{"jsonrpc":"2.0","id":1,"method":"` + m + `"}`
+	}
+	if len(calls) == 1 {
+		return calls[0]
+	}
+	return "[" + strings.Join(calls, ",") + "]"
+}
+
+func TestAccessControl(t *testing.T) {
+	a, err := newAccessControl(AccessConfig{
+		Keys: []APIKey{
+			{Key: "admin", Namespaces: []string{"public", "wallet", "admin"}},
+			{Key: "wallet", Namespaces: []string{"wallet"}},
+		},
+		Deny:           []string{"getp2psession"},
+		MaxRequestSize: 1024,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, key, body string
+		code            int
+	}{
+		{"/json_rpc", "", rpcBody("getblockcount"), http.StatusOK},
+		{"/json_rpc", "", rpcBody("submitblock"), http.StatusUnauthorized},
+		{"/json_rpc", "", rpcBody("getblockcount", "submitblock"), http.StatusUnauthorized},
+		{"/json_rpc", "", rpcBody("some_future_method"), http.StatusUnauthorized},
+		{"/json_rpc", "admin", rpcBody("getblockcount", "submitblock"), http.StatusOK},
+		{"/json_rpc", "admin", rpcBody("getp2psession"), http.StatusForbidden},
+		{"/json_rpc", "admin", rpcBody("debug_stable"), http.StatusForbidden},
+		{"/json_rpc", "wallet", rpcBody("getblockcount"), http.StatusForbidden},
+		{"/json_rpc", "wallet", rpcBody("eth_sendRawTransaction"), http.StatusOK},
+		{"/json_rpc", "bogus", rpcBody("getblockcount"), http.StatusUnauthorized},
+		{"/json_rpc", "", strings.Repeat(" ", 1025), http.StatusRequestEntityTooLarge},
+		// what the access control cannot parse must not reach the methods
+		{"/json_rpc", "", rpcBody("submitblock") + "x", http.StatusBadRequest},
+		{"/json_rpc", "", rpcBody("getblockcount") + rpcBody("submitblock"), http.StatusBadRequest},
+		{"/json_rpc", "", rpcBody("getblockcount") + " \n", http.StatusOK},
+		{"/json_rpc", "", "", http.StatusBadRequest},
+		{"/json_rpc", "", "[]", http.StatusBadRequest},
+		{"/json_rpc", "", "null", http.StatusBadRequest},
+		{"/json_rpc", "", `{"jsonrpc":"2.0","id":1}`, http.StatusBadRequest},
+		{"/json_rpc", "", rpcBody(""), http.StatusBadRequest},
+		{"/json_rpc", "", `{"jsonrpc":"2.0","id":1,"method":7}`, http.StatusBadRequest},
+		{"/json_rpc", "", "[" + rpcBody("getblockcount") + `,{"id":2}]`, http.StatusBadRequest},
+		{"/json_rpc", "", `{"jsonrpc":"2.0","id":1,"method":"submitblock"`, http.StatusBadRequest},
+		{"/getheight", "", "", http.StatusOK},
+		{"/sendrawtransaction", "", "", http.StatusUnauthorized},
+		{"/sendrawtransaction", "wallet", "", http.StatusOK},
+		{"/debug/pprof/", "admin", "", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		if code := serveAccess(t, a, test.path, test.key, test.body); code != test.code {
+			t.Errorf("%s %q %s: code %d, want %d", test.path, test.key, test.body, code, test.code)
+		}
+	}
+}
+
+func TestAccessControlRateLimit(t *testing.T) {
+	a, err := newAccessControl(AccessConfig{
+		Keys:   []APIKey{{Key: "key", Rate: 0.001, Burst: 3}},
+		IPRate: 0.001, IPBurst: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if code := serveAccess(t, a, "/json_rpc", "", rpcBody("getblockcount")); code != want {
+			t.Errorf("anonymous request %d: code %d, want %d", i, code, want)
+		}
+	}
+	// a batch takes a token per call, and keys have their own bucket
+	if code := serveAccess(t, a, "/json_rpc", "key", rpcBody("getblockcount", "getblockcount")); code != http.StatusOK {
+		t.Errorf("batch: code %d, want %d", code, http.StatusOK)
+	}
+	if code := serveAccess(t, a, "/json_rpc", "key", rpcBody("getblockcount", "getblockcount")); code != http.StatusTooManyRequests {
+		t.Errorf("batch: code %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
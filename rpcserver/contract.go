@@ -21,6 +21,7 @@ package rpcserver
 import (
 	"context"
 	"fmt"
+	"time"
 	"github.com/darmaproject/darmasuite/address"
 	"github.com/darmaproject/darmasuite/config"
 	"github.com/darmaproject/darmasuite/dvm/common"
@@ -35,9 +36,44 @@ import (
 import "github.com/intel-go/fastjson"
 import "github.com/osamingo/jsonrpc"
 
+import "github.com/darmaproject/darmasuite/blockchain"
 import "github.com/darmaproject/darmasuite/crypto"
 import "github.com/darmaproject/darmasuite/structures"
 
+// RPCGasCap caps the gas of contract calls made through the RPC, 0 means no
+// cap. It is set by --rpc-gas-cap.
+var RPCGasCap uint64 = 25000000
+
+// RPCCallTimeout bounds how long a contract call made through the RPC may
+// run, 0 means no limit. It is set by --rpc-call-timeout.
+var RPCCallTimeout = 5 * time.Second
+
+// callContract runs scdata with the gas cap and the timeout of the RPC. It is
+// aborted when c is done, i.e. when the client goes away.
+func callContract(c context.Context, scdata *transaction.SCData, topoHeight int64) ([]byte, *jsonrpc.Error) {
+	if RPCGasCap > 0 && scdata.GasLimit > RPCGasCap {
+		rlog.Warnf("Request param 'gas' %d capped to %d", scdata.GasLimit, RPCGasCap)
+		scdata.GasLimit = RPCGasCap
+	}
+	if c == nil {
+		c = context.Background()
+	}
+	if RPCCallTimeout > 0 {
+		var cancel context.CancelFunc
+		c, cancel = context.WithTimeout(c, RPCCallTimeout)
+		defer cancel()
+	}
+
+	res, err := chain.CallContactContext(c, scdata, topoHeight)
+	if err == blockchain.ErrExecutionTimeout {
+		return nil, &jsonrpc.Error{Code: -3, Message: err.Error()}
+	}
+	if err != nil {
+		return res, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	}
+	return res, nil
+}
+
 type GetContractHandler struct{}
 
 func (h GetContractHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
@@ -112,9 +148,9 @@ func (h CallContractHandler) ServeJSONRPC(c context.Context, params *fastjson.Ra
 		Payload:      payload,
 	}
 
-	res, err := chain.CallContact(scdata, p.TopoHeight)
-	if err != nil {
-		return nil, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	res, jerr := callContract(c, scdata, p.TopoHeight)
+	if jerr != nil {
+		return nil, jerr
 	}
 
 	return structures.CallContractResult{
@@ -185,6 +221,16 @@ func (h GetContractAccountAddressHandler) ServeJSONRPC(c context.Context, rawMes
 		return nil, &jsonrpc.Error{Code: -1, Message: fmt.Sprintf("params too few")}
 	}
 
+	// a contract account, in full or in the 20 byte form returned below, is
+	// looked up in the registry of Darma addresses
+	if account, err := hexutil.Decode(params[0]); err == nil && (len(account) == common.AddressLength || len(account) == 20) {
+		owner, err := chain.LookupContractAccountOwner(common.BytesToAddress(account))
+		if err != nil {
+			return nil, &jsonrpc.Error{Code: -2, Message: err.Error()}
+		}
+		return owner, nil
+	}
+
 	darmaAddress, err := address.NewAddress(params[0])
 	if err != nil {
 		return nil, &jsonrpc.Error{Code: -1, Message: fmt.Sprintf("internal error: address is invalid")}
@@ -0,0 +1,50 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rpcserver
+
+import (
+	"context"
+
+	"github.com/intel-go/fastjson"
+	"github.com/osamingo/jsonrpc"
+
+	"github.com/darmaproject/darmasuite/structures"
+)
+
+// EstimateFeeHandler suggests the fee per KB and the gas price of txs, for each
+// priority
+type EstimateFeeHandler struct{}
+
+func (h EstimateFeeHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	e := chain.EstimateFee()
+
+	result := structures.EstimateFeeResult{
+		Min_fee_per_kb: e.MinFeePerKb,
+		Tx_pool_count:  e.PoolTxs,
+		Tx_pool_size:   e.PoolSize,
+		Block_fullness: e.Fullness,
+		Status:         "OK",
+	}
+	for _, estimate := range e.Estimates {
+		result.Estimates = append(result.Estimates, structures.EstimateFee{
+			Priority:   estimate.Priority,
+			Blocks:     estimate.Blocks,
+			Fee_per_kb: estimate.FeePerKb,
+			Gas_price:  estimate.GasPrice,
+		})
+	}
+	return result, nil
+}
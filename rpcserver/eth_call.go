@@ -23,11 +23,13 @@ import (
 	"github.com/darmaproject/darmasuite/dvm/common"
 	"github.com/darmaproject/darmasuite/dvm/common/hexutil"
 	"github.com/darmaproject/darmasuite/dvm/common/math"
+	dvmmetrics "github.com/darmaproject/darmasuite/dvm/metrics"
 	"github.com/darmaproject/darmasuite/structures"
 	"github.com/darmaproject/darmasuite/transaction"
 	"github.com/romana/rlog"
 	"reflect"
 	"strings"
+	"time"
 )
 
 import "github.com/intel-go/fastjson"
@@ -56,6 +58,8 @@ var (
 	addressT = reflect.TypeOf(Web3Address{})
 )
 
+var ethCallTimer = dvmmetrics.NewRegisteredTimer("rpc/eth_call", nil)
+
 
 func (bn *EthWeb3JsRpcParams_eth_call) UnmarshalJSON(data []byte) error {
 	var params []interface{}
@@ -214,6 +218,7 @@ func (a *Web3Address) UnmarshalJSON(input []byte) error {
 }
 
 func (h EthWeb3JsRpcHandler_eth_call) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	defer ethCallTimer.UpdateSince(time.Now())
 	rlog.Infof("eth_call: params=%s\n",string(*params))
 
 	var callParams EthWeb3JsRpcParams_eth_call
@@ -251,6 +256,9 @@ func (h EthWeb3JsRpcHandler_eth_call) ServeJSONRPC(c context.Context, params *fa
 		p.TopoHeight = blockNumber.Int64()
 	}
 
+	if callParams.args.Gas != nil {
+		p.Gas = uint64(*callParams.args.Gas)
+	}
 	if p.Gas > 0 && p.Gas < config.MIN_GASLIMIT {
 		rlog.Warnf("Request param 'gas' is not enough")
 		return nil, &jsonrpc.Error{Code: -2, Message: fmt.Sprintf("Gas is not enough")}
@@ -288,9 +296,12 @@ func (h EthWeb3JsRpcHandler_eth_call) ServeJSONRPC(c context.Context, params *fa
 	}
 
 	rlog.Debugf("eth_call scdata: {sender:%x, nonce:%d, price:%d, gaslimit:%d, amount:%d, recipient:%x, payload:%x}",scdata.Sender,scdata.AccountNonce,scdata.Price,scdata.GasLimit,scdata.Amount,scdata.Recipient,scdata.Payload)
-	res, err := chain.CallContact(scdata, p.TopoHeight)
-	if err != nil {
-		return nil, &jsonrpc.Error{Code: -2, Message: fmt.Sprintf("call failed: %s",err.Error())}
+	res, jerr := callContract(c, scdata, p.TopoHeight)
+	if jerr != nil {
+		if jerr.Code == -2 {
+			jerr.Message = fmt.Sprintf("call failed: %s", jerr.Message)
+		}
+		return nil, jerr
 	}
 
 	return fmt.Sprintf("0x%x", res), nil
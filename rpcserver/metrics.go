@@ -0,0 +1,338 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rpcserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+
+	dvmmetrics "github.com/darmaproject/darmasuite/dvm/metrics"
+	"github.com/darmaproject/darmasuite/dvm/metrics/influxdb"
+	"github.com/darmaproject/darmasuite/dvm/metrics/librato"
+	dvmprometheus "github.com/darmaproject/darmasuite/dvm/metrics/prometheus"
+	"github.com/darmaproject/darmasuite/globals"
+	"github.com/darmaproject/darmasuite/metrics"
+)
+
+// RunMetrics serves the metrics on the address given by --metrics-bind, apart
+// from the RPC server so they can be scraped without DEBUG_MODE. /metrics has
+// the contract execution metrics, /metrics/node those of the node.
+func (r *RPCServer) RunMetrics() {
+	if _, ok := globals.Arguments["--metrics-bind"]; !ok || globals.Arguments["--metrics-bind"] == nil {
+		return
+	}
+	addr, err := net.ResolveTCPAddr("tcp", globals.Arguments["--metrics-bind"].(string))
+	if err != nil {
+		logger.Warnf("--metrics-bind address is invalid, err = %s", err)
+		return
+	}
+	if addr.Port == 0 {
+		logger.Infof("Metrics server is disabled")
+		return
+	}
+
+	go dvmmetrics.CollectProcessMetrics(3 * time.Second)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", dvmprometheus.Handler(dvmmetrics.DefaultRegistry))
+	mux.Handle("/metrics/node", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+
+	logger.Infof("Metrics will be served on http://%s/metrics", addr.String())
+	r.Lock()
+	if ExitInProgress {
+		r.Unlock()
+		return
+	}
+	r.metricsSrv = &http.Server{Addr: addr.String(), Handler: r.track(mux), BaseContext: func(net.Listener) context.Context { return r.ctx }}
+	srv := r.metricsSrv
+	r.Unlock()
+
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		logger.Warnf("ERR listening to metrics address err %s", err)
+	}
+}
+
+// MetricsConfig is read from the file given by --metrics-config, e.g.
+//
+//	{"exporters": [
+//		{"type": "influxdb", "endpoint": "http://127.0.0.1:8086", "database": "darma",
+//		 "username": "darma", "password": "secret", "interval": "10s"},
+//		{"type": "opentsdb", "endpoint": "127.0.0.1:4242", "tags": {"region": "eu"}}
+//	]}
+type MetricsConfig struct {
+	Exporters []ExporterConfig `json:"exporters"`
+}
+
+// ExporterConfig configures a push exporter of the contract execution
+// metrics.
+type ExporterConfig struct {
+	Type     string            `json:"type"`     // influxdb, librato, graphite or opentsdb
+	Endpoint string            `json:"endpoint"` // URL for influxdb, ip:port for graphite and opentsdb, unused for librato
+	Interval string            `json:"interval"` // how often metrics are pushed, defaults to 10s
+	Tags     map[string]string `json:"tags"`     // defaults to the --node-tag as tag "node"
+	Username string            `json:"username"` // influxdb user or librato email
+	Password string            `json:"password"` // influxdb password or librato token
+	Database string            `json:"database"` // influxdb database
+	Prefix   string            `json:"prefix"`   // prepended to metric names, defaults to "darma"
+}
+
+// metricsExporters returns the exporters given by the --metrics-config file
+// and the --metrics-push flags.
+func metricsExporters() (exporters []ExporterConfig, err error) {
+	if file, ok := globals.Arguments["--metrics-config"].(string); ok {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var c MetricsConfig
+		if err = json.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("invalid metrics config %s: %s", file, err)
+		}
+		exporters = append(exporters, c.Exporters...)
+	}
+
+	pushes, _ := globals.Arguments["--metrics-push"].([]string)
+	if len(pushes) == 0 {
+		return
+	}
+	var base ExporterConfig
+	base.Interval, _ = globals.Arguments["--metrics-push-interval"].(string)
+	base.Database, _ = globals.Arguments["--metrics-push-database"].(string)
+	if auth, ok := globals.Arguments["--metrics-push-auth"].(string); ok {
+		i := strings.Index(auth, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("--metrics-push-auth must be <user:password>")
+		}
+		base.Username, base.Password = auth[:i], auth[i+1:]
+	}
+	if tags, ok := globals.Arguments["--metrics-push-tags"].(string); ok {
+		if base.Tags, err = parseMetricsTags(tags); err != nil {
+			return nil, err
+		}
+	}
+	for _, push := range pushes {
+		e := base
+		if i := strings.Index(push, "="); i >= 0 {
+			e.Type, e.Endpoint = push[:i], push[i+1:]
+		} else {
+			e.Type = push
+		}
+		exporters = append(exporters, e)
+	}
+	return
+}
+
+// parseMetricsTags parses the --metrics-push-tags value, e.g.
+// "region=eu,node=seed1".
+func parseMetricsTags(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(tag), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid --metrics-push-tags tag %q", tag)
+		}
+		if _, ok := tags[kv[0]]; ok {
+			return nil, fmt.Errorf("duplicate --metrics-push-tags tag %q", kv[0])
+		}
+		tags[kv[0]] = kv[1]
+	}
+	return tags, nil
+}
+
+// startMetricsExporters starts pushing the contract execution and the node
+// metrics to the exporters configured by --metrics-config and --metrics-push.
+// They stop when the server is stopped.
+func (r *RPCServer) startMetricsExporters() {
+	exporters, err := metricsExporters()
+	if err != nil {
+		logger.Warnf("Metrics exporters are disabled, err = %s", err)
+		return
+	}
+	if len(exporters) == 0 {
+		return
+	}
+
+	// the node metrics are prometheus collectors, they are mirrored into a
+	// registry the exporters understand
+	node := dvmmetrics.NewRegistry()
+	go mirrorNodeMetrics(r.ctx, node, 5*time.Second)
+
+	for _, e := range exporters {
+		if err := startMetricsExporter(r.ctx, e, dvmmetrics.DefaultRegistry, ""); err != nil {
+			logger.Warnf("Could not start %s metrics exporter, err = %s", e.Type, err)
+			continue
+		}
+		if err := startMetricsExporter(r.ctx, e, node, ".node"); err != nil {
+			logger.Warnf("Could not start %s node metrics exporter, err = %s", e.Type, err)
+		}
+	}
+}
+
+// startMetricsExporter pushes registry to e until ctx is done, suffix is
+// appended to the prefix of the metric names.
+func startMetricsExporter(ctx context.Context, e ExporterConfig, registry dvmmetrics.Registry, suffix string) error {
+	interval := 10 * time.Second
+	if e.Interval != "" {
+		d, err := time.ParseDuration(e.Interval)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid interval %q", e.Interval)
+		}
+		interval = d
+	}
+	if e.Prefix == "" {
+		e.Prefix = "darma"
+	}
+	e.Prefix += suffix
+	if len(e.Tags) == 0 {
+		if tag, ok := globals.Arguments["--node-tag"].(string); ok && tag != "" {
+			e.Tags = map[string]string{"node": tag}
+		}
+	}
+
+	switch strings.ToLower(e.Type) {
+	case "influxdb":
+		if e.Endpoint == "" || e.Database == "" {
+			return fmt.Errorf("influxdb needs an endpoint and a database")
+		}
+		go influxdb.InfluxDBWithTagsContext(ctx, registry, interval, e.Endpoint, e.Database, e.Username, e.Password, e.Prefix+".", e.Tags)
+
+	case "librato":
+		if e.Username == "" || e.Password == "" {
+			return fmt.Errorf("librato needs an email and a token")
+		}
+		rep := librato.NewReporter(registry, interval, e.Username, e.Password, tagValues(e.Tags, "."), []float64{0.5, 0.75, 0.95, 0.99, 0.999}, time.Millisecond)
+		rep.Namespace = e.Prefix
+		go rep.RunContext(ctx)
+
+	case "graphite":
+		addr, err := net.ResolveTCPAddr("tcp", e.Endpoint)
+		if err != nil {
+			return err
+		}
+		// graphite has no tags, they become part of the metric names
+		prefix := e.Prefix
+		if values := tagValues(e.Tags, "."); values != "" {
+			prefix += "." + values
+		}
+		go dvmmetrics.GraphiteWithContext(ctx, dvmmetrics.GraphiteConfig{
+			Addr:          addr,
+			Registry:      registry,
+			FlushInterval: interval,
+			DurationUnit:  time.Nanosecond,
+			Prefix:        prefix,
+			Percentiles:   []float64{0.5, 0.75, 0.95, 0.99, 0.999},
+		})
+
+	case "opentsdb":
+		addr, err := net.ResolveTCPAddr("tcp", e.Endpoint)
+		if err != nil {
+			return err
+		}
+		go dvmmetrics.OpenTSDBWithContext(ctx, dvmmetrics.OpenTSDBConfig{
+			Addr:          addr,
+			Registry:      registry,
+			FlushInterval: interval,
+			DurationUnit:  time.Nanosecond,
+			Prefix:        e.Prefix,
+			Tags:          e.Tags,
+		})
+
+	default:
+		return fmt.Errorf("unknown exporter type %q", e.Type)
+	}
+
+	logger.Infof("Pushing %s metrics to %s %s every %s", e.Prefix, e.Type, e.Endpoint, interval)
+	return nil
+}
+
+// tagValues joins the values of tags ordered by their keys with sep.
+func tagValues(tags map[string]string, sep string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	values := make([]string, len(keys))
+	for i, k := range keys {
+		values[i] = tags[k]
+	}
+	return strings.Join(values, sep)
+}
+
+// mirrorNodeMetrics copies the node metrics into registry every interval
+// until ctx is done. Counters and gauges become gauges, summaries and
+// histograms their sum and count. Label values are appended to the names.
+func mirrorNodeMetrics(ctx context.Context, registry dvmmetrics.Registry, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		families, err := metrics.Registry.Gather()
+		if err != nil {
+			logger.Warnf("Could not gather the node metrics, err = %s", err)
+		}
+		for _, family := range families {
+			for _, m := range family.GetMetric() {
+				name := nodeMetricName(family.GetName(), m.GetLabel())
+				switch family.GetType() {
+				case dto.MetricType_COUNTER:
+					setGauge(registry, name, m.GetCounter().GetValue())
+				case dto.MetricType_GAUGE:
+					setGauge(registry, name, m.GetGauge().GetValue())
+				case dto.MetricType_UNTYPED:
+					setGauge(registry, name, m.GetUntyped().GetValue())
+				case dto.MetricType_SUMMARY:
+					setGauge(registry, name+".sum", m.GetSummary().GetSampleSum())
+					setGauge(registry, name+".count", float64(m.GetSummary().GetSampleCount()))
+				case dto.MetricType_HISTOGRAM:
+					setGauge(registry, name+".sum", m.GetHistogram().GetSampleSum())
+					setGauge(registry, name+".count", float64(m.GetHistogram().GetSampleCount()))
+				}
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// nodeMetricName appends the label values, ordered by label name, to name.
+func nodeMetricName(name string, labels []*dto.LabelPair) string {
+	tags := make(map[string]string, len(labels))
+	for _, l := range labels {
+		tags[l.GetName()] = l.GetValue()
+	}
+	if values := tagValues(tags, "."); values != "" {
+		name += "." + values
+	}
+	return name
+}
+
+func setGauge(registry dvmmetrics.Registry, name string, value float64) {
+	dvmmetrics.GetOrRegisterGaugeFloat64(name, registry).Update(value)
+}
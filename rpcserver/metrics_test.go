@@ -0,0 +1,116 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rpcserver
+
+import (
+	"reflect"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/darmaproject/darmasuite/globals"
+)
+
+func TestParseMetricsTags(t *testing.T) {
+	tests := []struct {
+		in   string
+		tags map[string]string
+		err  bool
+	}{
+		{in: "node=seed1", tags: map[string]string{"node": "seed1"}},
+		{in: "region=eu,node=seed1", tags: map[string]string{"region": "eu", "node": "seed1"}},
+		{in: "region=eu, node=seed1", tags: map[string]string{"region": "eu", "node": "seed1"}},
+		{in: "url=a=b", tags: map[string]string{"url": "a=b"}},
+		{in: "empty=", tags: map[string]string{"empty": ""}},
+		{in: "", err: true},
+		{in: "node", err: true},
+		{in: "=seed1", err: true},
+		{in: "node=seed1,", err: true},
+		{in: "node=seed1,node=seed2", err: true},
+	}
+	for _, test := range tests {
+		tags, err := parseMetricsTags(test.in)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", test.in, tags)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(tags, test.tags) {
+			t.Errorf("%q: got %v, want %v", test.in, tags, test.tags)
+		}
+	}
+}
+
+func TestMetricsExportersTags(t *testing.T) {
+	saved := globals.Arguments
+	defer func() { globals.Arguments = saved }()
+
+	globals.Arguments = map[string]interface{}{
+		"--metrics-push":      []string{"graphite=127.0.0.1:2003", "librato"},
+		"--metrics-push-tags": "region=eu,node=seed1",
+		"--metrics-push-auth": "user:pass:word",
+	}
+	exporters, err := metricsExporters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exporters) != 2 {
+		t.Fatalf("got %d exporters, want 2", len(exporters))
+	}
+	if e := exporters[0]; e.Type != "graphite" || e.Endpoint != "127.0.0.1:2003" {
+		t.Fatalf("got exporter %s %s", e.Type, e.Endpoint)
+	}
+	for _, e := range exporters {
+		if !reflect.DeepEqual(e.Tags, map[string]string{"region": "eu", "node": "seed1"}) {
+			t.Fatalf("%s got tags %v", e.Type, e.Tags)
+		}
+		if e.Username != "user" || e.Password != "pass:word" {
+			t.Fatalf("%s got auth %s %s", e.Type, e.Username, e.Password)
+		}
+	}
+
+	globals.Arguments["--metrics-push-tags"] = "region"
+	if _, err := metricsExporters(); err == nil {
+		t.Fatal("invalid tags are accepted")
+	}
+}
+
+func TestTagValues(t *testing.T) {
+	if v := tagValues(map[string]string{"region": "eu", "node": "seed1"}, "."); v != "seed1.eu" {
+		t.Fatalf("got %q", v)
+	}
+	if v := tagValues(nil, "."); v != "" {
+		t.Fatalf("got %q", v)
+	}
+}
+
+func TestNodeMetricName(t *testing.T) {
+	label := func(name, value string) *dto.LabelPair {
+		return &dto.LabelPair{Name: &name, Value: &value}
+	}
+	if name := nodeMetricName("peers", nil); name != "peers" {
+		t.Fatalf("got %q", name)
+	}
+	labels := []*dto.LabelPair{label("type", "in"), label("state", "ok")}
+	if name := nodeMetricName("peers", labels); name != "peers.ok.in" {
+		t.Fatalf("got %q", name)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -47,8 +48,13 @@ var DEBUG_MODE bool
 // this structure must be update while mutex
 type RPCServer struct {
 	srv        *http.Server
+	metricsSrv *http.Server // serves --metrics-bind
 	mux        *http.ServeMux
 	ExitEvents chan bool // blockchain is shutting down and we must quit ASAP
+
+	ctx      context.Context    // requests are served with contexts derived from it
+	cancel   context.CancelFunc // aborts the requests, and the VMs they run, still going on shutdown
+	handlers sync.RWMutex       // read locked by every running handler
 	sync.RWMutex
 }
 
@@ -63,33 +69,82 @@ func RpcServerStart(params map[string]interface{}) (*RPCServer, error) {
 	_ = err
 
 	r.ExitEvents = make(chan bool)
+	r.ctx, r.cancel = context.WithCancel(context.Background())
 
 	logger = globals.Logger.WithFields(log.Fields{"source": "RPC"}) // all components must use this logger
 	chain = params["chain"].(*blockchain.Blockchain)
 
+	if s, ok := globals.Arguments["--rpc-gas-cap"].(string); ok {
+		if gas, err := strconv.ParseUint(s, 10, 64); err != nil {
+			logger.Warnf("--rpc-gas-cap is invalid, err = %s", err)
+		} else {
+			RPCGasCap = gas
+		}
+	}
+	if s, ok := globals.Arguments["--rpc-call-timeout"].(string); ok {
+		if timeout, err := time.ParseDuration(s); err != nil || timeout < 0 {
+			logger.Warnf("--rpc-call-timeout %q is invalid", s)
+		} else {
+			RPCCallTimeout = timeout
+		}
+	}
+
 	go r.Run()
+	go r.RunMetrics()
+	r.startMetricsExporters()
 	logger.Infof("RPC server started")
 	atomic.AddUint32(&globals.SubsystemActive, 1) // increment subsystem
 
 	return &r, nil
 }
 
-// shutdown the rpc server component
+// shutdown the rpc server component, running requests are given
+// --rpc-shutdown-timeout to finish before they are aborted
 func (r *RPCServer) RpcServerStop() {
 	r.Lock()
-	defer r.Unlock()
 	ExitInProgress = true
 	close(r.ExitEvents) // send signal to all connections to exit
+	srv, metricsSrv := r.srv, r.metricsSrv
+	r.Unlock()
 
-	if r.srv != nil {
-		r.srv.Shutdown(context.Background()) // shutdown the server
+	timeout := globals.RPCShutdownTimeout()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, s := range []*http.Server{srv, metricsSrv} {
+		if s == nil {
+			continue
+		}
+		// stop listening and wait for the connections to go idle
+		if err := s.Shutdown(ctx); err != nil {
+			logger.Warnf("RPC requests still running after %s are aborted", timeout)
+			s.Close()
+		}
 	}
-	// TODO we  must wait for connections to kill themselves
-	time.Sleep(1 * time.Second)
+
+	// abort what is left, contract calls cancel their VM, and wait for every
+	// handler to return
+	r.cancel()
+	r.handlers.Lock()
+	r.handlers.Unlock()
+
 	logger.Infof("RPC Shutdown")
 	atomic.AddUint32(&globals.SubsystemActive, ^uint32(0)) // this decrement 1 fom subsystem
 }
 
+// track serves next unless the server is shutting down, so that RpcServerStop
+// can wait for the running handlers.
+func (r *RPCServer) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.handlers.RLock()
+		defer r.handlers.RUnlock()
+		if r.ctx.Err() != nil {
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
 // setup handlers
 func (r *RPCServer) Run() {
 	mr := jsonrpc.NewMethodRepository()
@@ -186,6 +241,10 @@ func (r *RPCServer) Run() {
 		log.Fatalln(err)
 	}
 
+	if err := mr.RegisterMethod("estimate_fee", EstimateFeeHandler{}, structures.EstimateFeeParams{}, structures.EstimateFeeResult{}); err != nil {
+		log.Fatalln(err)
+	}
+
 	if err := mr.RegisterMethod("get_poolsvotetats", GetPoolVoteStats_Handler{}, structures.GetPoolVoteStatsParams{}, structures.GetPoolVoteStatsResult{}); err != nil {
 		log.Fatalln(err)
 	}
@@ -282,9 +341,38 @@ func (r *RPCServer) Run() {
 		}
 	}
 
+	accessConfig, err := loadAccessConfig()
+	if err != nil {
+		logger.Warnf("RPC server is disabled, err = %s", err)
+		return
+	}
+	access, err := newAccessControl(accessConfig)
+	if err != nil {
+		logger.Warnf("RPC server is disabled, err = %s", err)
+		return
+	}
+
+	tlsConfig, fingerprint, err := globals.RPCTLSConfig(defaultAddress, globals.GetDataDirectory(), "rpc")
+	if err != nil {
+		logger.Warnf("RPC server is disabled, TLS err = %s", err)
+		return
+	}
+
 	logger.Infof("RPC  will listen on %s", defaultAddress)
+	if tlsConfig != nil {
+		logger.Infof("RPC uses TLS, certificate SHA-256 fingerprint %s", fingerprint)
+	}
 	r.Lock()
-	r.srv = &http.Server{Addr: defaultAddress, Handler: r.mux}
+	if ExitInProgress {
+		r.Unlock()
+		return
+	}
+	r.srv = &http.Server{
+		Addr:        defaultAddress,
+		Handler:     r.track(globals.RPCCORSHandler(access.Handler(r.mux))),
+		TLSConfig:   tlsConfig,
+		BaseContext: func(net.Listener) context.Context { return r.ctx },
+	}
 	r.Unlock()
 
 	r.mux.HandleFunc("/", hello)
@@ -312,7 +400,12 @@ func (r *RPCServer) Run() {
 	}
 
 	//r.mux.HandleFunc("/json_rpc/debug", mr.ServeDebug)
-	if err := r.srv.ListenAndServe(); err != http.ErrServerClosed {
+	if tlsConfig != nil {
+		err = r.srv.ListenAndServeTLS("", "")
+	} else {
+		err = r.srv.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
 		logger.Warnf("ERR listening to address err %s", err)
 	}
 }
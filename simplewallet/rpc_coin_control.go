@@ -0,0 +1,116 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+
+package simplewallet
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/intel-go/fastjson"
+	"github.com/osamingo/jsonrpc"
+
+	"github.com/darmaproject/darmasuite/structures"
+)
+
+type ListCoinsHandler struct {
+	r *RPCServer
+}
+
+func (h ListCoinsHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p structures.ListCoinsParams
+	if err := jsonrpc.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+
+	result := structures.ListCoinsResult{Coins: []structures.Coin{}}
+	for _, coin := range h.r.w.ListCoins() {
+		if p.Frozen_only && !coin.Frozen {
+			continue
+		}
+		result.Coins = append(result.Coins, structures.Coin{
+			Index:    coin.Index,
+			Amount:   coin.Amount,
+			Txid:     coin.TXID,
+			Height:   coin.Height,
+			Unlocked: coin.Unlocked,
+			Frozen:   coin.Frozen,
+			Label:    coin.Label,
+		})
+	}
+	return result, nil
+}
+
+type FreezeOutputsHandler struct {
+	r *RPCServer
+}
+
+func (h FreezeOutputsHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p structures.FreezeOutputsParams
+	if err := jsonrpc.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+
+	if err := h.r.w.FreezeOutputs(p.Outputs); err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	}
+	return structures.FreezeOutputsResult{}, nil
+}
+
+type ThawOutputsHandler struct {
+	r *RPCServer
+}
+
+func (h ThawOutputsHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p structures.ThawOutputsParams
+	if err := jsonrpc.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+
+	if err := h.r.w.ThawOutputs(p.Outputs); err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	}
+	return structures.ThawOutputsResult{}, nil
+}
+
+type LabelOutputHandler struct {
+	r *RPCServer
+}
+
+func (h LabelOutputHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p structures.LabelOutputParams
+	if err := jsonrpc.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+
+	if err := h.r.w.LabelOutput(p.Output, p.Label); err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	}
+	return structures.LabelOutputResult{}, nil
+}
+
+type ConsolidateDustHandler struct {
+	r *RPCServer
+}
+
+func (h ConsolidateDustHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p structures.ConsolidateDustParams
+	if err := jsonrpc.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+
+	tx, inputs, inputs_sum, err := h.r.w.ConsolidateDust(p.Threshold, p.Mixin, p.Priority)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: fmt.Sprintf("Error while building Transaction: %s", err)}
+	}
+	if err = h.r.w.SendTransaction(tx); err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: fmt.Sprintf("Error while sending transaction: %s", err)}
+	}
+
+	fee := tx.RctSignature.GetTXFee()
+	return structures.ConsolidateDustResult{
+		Tx_hash: tx.GetHash().String(),
+		Fee:     fee,
+		Inputs:  len(inputs),
+		Amount:  inputs_sum - fee,
+	}, nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+
+package simplewallet
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/intel-go/fastjson"
+	"github.com/osamingo/jsonrpc"
+
+	"github.com/darmaproject/darmasuite/structures"
+	"github.com/darmaproject/darmasuite/walletapi"
+)
+
+// contractMethodCall resolves the abi of p and returns the call it describes.
+func (r *RPCServer) contractMethodCall(p *structures.ContractMethodParams) (*walletapi.ContractMethodCall, *jsonrpc.Error) {
+	call := &walletapi.ContractMethodCall{
+		Contract: p.Address,
+		Method:   p.Method,
+		Args:     p.Args,
+		Amount:   p.Amount,
+		Gas:      p.Gas,
+		GasPrice: p.GasPrice,
+	}
+	if p.Code != "" {
+		code, err := hex.DecodeString(strings.TrimPrefix(p.Code, "0x"))
+		if err != nil {
+			return nil, &jsonrpc.Error{Code: -2, Message: fmt.Sprintf("Code is invalid hex: %s", err)}
+		}
+		call.Code = code
+	}
+
+	a, err := r.abis.Resolve(p.Address, p.Abi)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	}
+	call.ABI = a
+	return call, nil
+}
+
+type RegisterContractAbiHandler struct {
+	r *RPCServer
+}
+
+func (h RegisterContractAbiHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p structures.RegisterContractAbiParams
+	if err := jsonrpc.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+
+	if err := h.r.abis.Register(p.Address, p.Abi); err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	}
+	return structures.RegisterContractAbiResult{}, nil
+}
+
+type SendContractMethodHandler struct {
+	r *RPCServer
+}
+
+func (h SendContractMethodHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p structures.SendContractMethodParams
+	if err := jsonrpc.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+
+	mp := structures.ContractMethodParams(p)
+	call, jerr := h.r.contractMethodCall(&mp)
+	if jerr != nil {
+		return nil, jerr
+	}
+	tx, err := h.r.w.SendContractMethod(call)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: fmt.Sprintf("Error while sending transaction: %s", err)}
+	}
+
+	return structures.SendContractMethodResult{
+		TXHash: tx.GetHash().String(),
+		Fee:    tx.RctSignature.GetTXFee(),
+	}, nil
+}
+
+type CallContractMethodHandler struct {
+	r *RPCServer
+}
+
+func (h CallContractMethodHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p structures.CallContractMethodParams
+	if err := jsonrpc.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+
+	call, jerr := h.r.contractMethodCall(&p.ContractMethodParams)
+	if jerr != nil {
+		return nil, jerr
+	}
+	outputs, err := h.r.w.CallContractMethod(call, p.TopoHeight)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: fmt.Sprintf("Call failed: %s", err)}
+	}
+
+	return structures.CallContractMethodResult{Outputs: outputs}, nil
+}
+
+type GetContractMethodResultHandler struct {
+	r *RPCServer
+}
+
+func (h GetContractMethodResultHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p structures.GetContractMethodResultParams
+	if err := jsonrpc.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+
+	a, err := h.r.abis.Resolve(p.Address, p.Abi)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	}
+	result, err := h.r.w.GetContractMethodResult(a, p.Method, p.TXHash)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	}
+
+	return structures.GetContractMethodResultResult{
+		Status:  result.Status,
+		Outputs: result.Outputs,
+		Events:  result.Events,
+	}, nil
+}
@@ -0,0 +1,24 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+
+package simplewallet
+
+import (
+	"context"
+
+	"github.com/intel-go/fastjson"
+	"github.com/osamingo/jsonrpc"
+)
+
+// EstimateFeeHandler relays the fee estimates of the daemon, the priorities of
+// which transfers can ask for
+type EstimateFeeHandler struct {
+	r *RPCServer
+}
+
+func (h EstimateFeeHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	result, err := h.r.w.EstimateFees()
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	}
+	return *result, nil
+}
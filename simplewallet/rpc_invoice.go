@@ -0,0 +1,156 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+
+package simplewallet
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/intel-go/fastjson"
+	"github.com/osamingo/jsonrpc"
+
+	"github.com/darmaproject/darmasuite/globals"
+	"github.com/darmaproject/darmasuite/structures"
+	"github.com/darmaproject/darmasuite/walletapi"
+)
+
+func invoiceResult(i *walletapi.Invoice) structures.Invoice {
+	return structures.Invoice{
+		Payment_ID:  i.PaymentID,
+		Address:     i.Address,
+		Amount:      i.Amount,
+		Label:       i.Label,
+		Height:      i.Height,
+		Created:     i.Created,
+		Expiry:      i.Expiry,
+		Uri:         i.URI,
+		Status:      i.Status,
+		Received:    i.Received,
+		Paid_height: i.PaidAt,
+		Txids:       i.TXIDs,
+
+		Expiry_height: i.ExpiryHeight,
+		Late:          i.Late,
+		Late_txids:    i.LateTXIDs,
+	}
+}
+
+type MakePaymentUriHandler struct {
+	r *RPCServer
+}
+
+func (h MakePaymentUriHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p structures.MakePaymentUriParams
+	if err := jsonrpc.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+
+	request := globals.PaymentRequest{
+		Address:   p.Address,
+		Amount:    p.Amount,
+		PaymentID: p.Payment_ID,
+		Token:     p.Token,
+		Contract:  p.Contract,
+		Label:     p.Label,
+		Expiry:    p.Expiry,
+	}
+	if request.Address == "" {
+		request.Address = h.r.w.GetAddress().String()
+	}
+	if err := request.Validate(); err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	}
+	return structures.MakePaymentUriResult{Uri: request.URI()}, nil
+}
+
+type ParsePaymentUriHandler struct {
+	r *RPCServer
+}
+
+func (h ParsePaymentUriHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p structures.ParsePaymentUriParams
+	if err := jsonrpc.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+
+	request, err := globals.ParsePaymentURI(p.Uri)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	}
+	return structures.ParsePaymentUriResult{
+		Uri: structures.PaymentRequest{
+			Address:    request.Address,
+			Amount:     request.Amount,
+			Payment_ID: request.PaymentID,
+			Token:      request.Token,
+			Contract:   request.Contract,
+			Label:      request.Label,
+			Expiry:     request.Expiry,
+		},
+		Expired: request.Expired(time.Now()),
+	}, nil
+}
+
+type CreateInvoiceHandler struct {
+	r *RPCServer
+}
+
+func (h CreateInvoiceHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p structures.CreateInvoiceParams
+	if err := jsonrpc.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Expires_in < 0 {
+		return nil, &jsonrpc.Error{Code: -2, Message: "expires_in cannot be negative"}
+	}
+
+	invoice, err := h.r.w.CreateInvoice(p.Amount, p.Label, time.Duration(p.Expires_in)*time.Second)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	}
+	return structures.CreateInvoiceResult{Invoice: invoiceResult(invoice)}, nil
+}
+
+type GetInvoiceHandler struct {
+	r *RPCServer
+}
+
+func (h GetInvoiceHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p structures.GetInvoiceParams
+	if err := jsonrpc.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+
+	invoice, err := h.r.w.GetInvoice(p.Payment_ID)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	}
+	return structures.GetInvoiceResult{Invoice: invoiceResult(invoice)}, nil
+}
+
+type GetInvoicesHandler struct {
+	r *RPCServer
+}
+
+func (h GetInvoicesHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p structures.GetInvoicesParams
+	if params != nil {
+		if err := jsonrpc.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+	}
+	switch p.Status {
+	case "", walletapi.INVOICE_UNPAID, walletapi.INVOICE_PARTIAL, walletapi.INVOICE_PAID, walletapi.INVOICE_EXPIRED:
+	default:
+		return nil, &jsonrpc.Error{Code: -2, Message: fmt.Sprintf("Invalid invoice status %s", p.Status)}
+	}
+
+	result := structures.GetInvoicesResult{Invoices: []structures.Invoice{}}
+	for _, invoice := range h.r.w.GetInvoices() {
+		if p.Status == "" || invoice.Status == p.Status {
+			result.Invoices = append(result.Invoices, invoiceResult(&invoice))
+		}
+	}
+	return result, nil
+}
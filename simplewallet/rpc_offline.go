@@ -0,0 +1,168 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+
+package simplewallet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/intel-go/fastjson"
+	"github.com/osamingo/jsonrpc"
+
+	"github.com/darmaproject/darmasuite/address"
+	"github.com/darmaproject/darmasuite/globals"
+	"github.com/darmaproject/darmasuite/structures"
+	"github.com/darmaproject/darmasuite/walletapi"
+)
+
+// unsignedTxResult marshals u to be returned as the unsigned_tx of a result
+func unsignedTxResult(u *walletapi.UnsignedTx) (structures.BuildUnsignedTransferResult, *jsonrpc.Error) {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return structures.BuildUnsignedTransferResult{}, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	}
+	return structures.BuildUnsignedTransferResult{UnsignedTx: data}, nil
+}
+
+type BuildUnsignedTransferHandler struct {
+	r *RPCServer
+}
+
+func (h BuildUnsignedTransferHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p structures.BuildUnsignedTransferParams
+	if err := jsonrpc.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+
+	var addr []address.Address
+	var amount []uint64
+	for _, d := range p.Destinations {
+		a, err := globals.ParseValidateAddress(d.Address)
+		if err != nil {
+			return nil, &jsonrpc.Error{Code: -2, Message: fmt.Sprintf("Invalid address %s: %s", d.Address, err)}
+		}
+		addr = append(addr, *a)
+		amount = append(amount, d.Amount)
+	}
+
+	u, err := h.r.w.BuildUnsignedTransfer(addr, amount, p.Unlock_time, p.Payment_ID, p.Mixin)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: fmt.Sprintf("Error while building Transaction: %s", err)}
+	}
+	return unsignedTxResult(u)
+}
+
+type BuildUnsignedContractHandler struct {
+	r *RPCServer
+}
+
+func (h BuildUnsignedContractHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p structures.BuildUnsignedContractParams
+	if err := jsonrpc.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+
+	mp := structures.ContractMethodParams(p)
+	call, jerr := h.r.contractMethodCall(&mp)
+	if jerr != nil {
+		return nil, jerr
+	}
+	u, err := h.r.w.BuildUnsignedContractMethod(call)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: fmt.Sprintf("Error while building Transaction: %s", err)}
+	}
+	result, jerr := unsignedTxResult(u)
+	if jerr != nil {
+		return nil, jerr
+	}
+	return structures.BuildUnsignedContractResult(result), nil
+}
+
+type DescribeUnsignedTxHandler struct {
+	r *RPCServer
+}
+
+func (h DescribeUnsignedTxHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p structures.DescribeUnsignedTxParams
+	if err := jsonrpc.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+
+	u, err := walletapi.ParseUnsignedTx(p.UnsignedTx)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	}
+	summary, err := h.r.w.DescribeUnsignedTx(u)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	}
+
+	result := structures.DescribeUnsignedTxResult{
+		PaymentID:      summary.PaymentID,
+		UnlockTime:     summary.UnlockTime,
+		InputsSum:      summary.InputsSum,
+		Fee:            summary.Fees,
+		FeePerKb:       summary.FeesPerKb,
+		MaxFeePerKb:    summary.MaxFeesPerKb,
+		Change:         summary.Change,
+		Contract:       summary.Contract,
+		ContractAmount: summary.ContractAmount,
+		MaxGasFee:      summary.MaxGasFees,
+	}
+	for _, d := range summary.Destinations {
+		result.Destinations = append(result.Destinations, structures.Destination{Address: d.Address, Amount: d.Amount})
+	}
+	return result, nil
+}
+
+type SignUnsignedTxHandler struct {
+	r *RPCServer
+}
+
+func (h SignUnsignedTxHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p structures.SignUnsignedTxParams
+	if err := jsonrpc.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+
+	u, err := walletapi.ParseUnsignedTx(p.UnsignedTx)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	}
+	s, err := h.r.w.SignUnsignedTx(u, p.MaxFeePerKb)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: fmt.Sprintf("Error while signing Transaction: %s", err)}
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	}
+
+	return structures.SignUnsignedTxResult{
+		SignedTx: data,
+		TXHash:   s.Details.TXID,
+		Fee:      s.Details.Fees,
+	}, nil
+}
+
+type BroadcastSignedTxHandler struct {
+	r *RPCServer
+}
+
+func (h BroadcastSignedTxHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p structures.BroadcastSignedTxParams
+	if err := jsonrpc.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+
+	s, err := walletapi.ParseSignedTx(p.SignedTx)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	}
+	tx, err := h.r.w.BroadcastSignedTx(s)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: fmt.Sprintf("Error while sending transaction: %s", err)}
+	}
+	return structures.BroadcastSignedTxResult{TXHash: tx.GetHash().String()}, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+
+package simplewallet
+
+import (
+	"context"
+
+	"github.com/intel-go/fastjson"
+	"github.com/osamingo/jsonrpc"
+
+	"github.com/darmaproject/darmasuite/structures"
+	"github.com/darmaproject/darmasuite/walletapi"
+)
+
+type AddWebhookHandler struct {
+	r *RPCServer
+}
+
+func (h AddWebhookHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p structures.AddWebhookParams
+	if err := jsonrpc.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+
+	hook, err := h.r.webhooks.Add(p.Url, p.Secret, p.Events, p.Confirmations)
+	if err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	}
+	return structures.AddWebhookResult{Id: hook.ID, Secret: hook.Secret}, nil
+}
+
+type RemoveWebhookHandler struct {
+	r *RPCServer
+}
+
+func (h RemoveWebhookHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p structures.RemoveWebhookParams
+	if err := jsonrpc.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+
+	if err := h.r.webhooks.Remove(p.Id); err != nil {
+		return nil, &jsonrpc.Error{Code: -2, Message: err.Error()}
+	}
+	return structures.RemoveWebhookResult{}, nil
+}
+
+type ListWebhooksHandler struct {
+	r *RPCServer
+}
+
+func (h ListWebhooksHandler) ServeJSONRPC(c context.Context, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	hooks, queued := h.r.webhooks.List()
+
+	result := structures.ListWebhooksResult{Webhooks: []structures.Webhook{}}
+	for i, hook := range hooks {
+		events := hook.Events
+		if len(events) == 0 {
+			events = walletapi.WEBHOOK_EVENTS
+		}
+		result.Webhooks = append(result.Webhooks, structures.Webhook{
+			Id:            hook.ID,
+			Url:           hook.URL,
+			Events:        events,
+			Confirmations: hook.Confirmations,
+			Created:       hook.Created,
+			Queued:        queued[i],
+		})
+	}
+	return result, nil
+}
@@ -11,11 +11,14 @@ import (
 )
 
 //import "fmt"
-import "time"
+import "net"
 import "sync"
+import "context"
 import "log"
 import "strings"
 import "net/http"
+import "crypto/sha256"
+import "crypto/subtle"
 
 //import "github.com/intel-go/fastjson"
 import "github.com/osamingo/jsonrpc"
@@ -35,7 +38,13 @@ type RPCServer struct {
 	Exit_Event       chan bool // wallet is shutting down and we must quit ASAP
 	Exit_In_Progress bool
 
-	w *walletapi.Wallet // reference to the wallet which is open
+	ctx      context.Context    // requests are served with contexts derived from it
+	cancel   context.CancelFunc // aborts the requests still going on shutdown
+	handlers sync.RWMutex       // read locked by every running handler
+
+	w        *walletapi.Wallet       // reference to the wallet which is open
+	abis     *walletapi.ContractABIs // abis registered for contracts, kept next to the wallet file
+	webhooks *walletapi.Webhooks     // POST the events of the wallet
 	sync.RWMutex
 }
 
@@ -47,26 +56,75 @@ func RPCServer_Start(w *walletapi.Wallet, address string) (*RPCServer, error) {
 	//_ = err
 
 	r.Exit_Event = make(chan bool)
+	r.ctx, r.cancel = context.WithCancel(context.Background())
 	r.w = w
 	r.address = address
 
+	abiFile := ""
+	if globals.Arguments["--wallet-file"] != nil {
+		abiFile = globals.Arguments["--wallet-file"].(string) + ".abi.json"
+	}
+	abis, err := walletapi.NewContractABIs(abiFile)
+	if err != nil {
+		return nil, err
+	}
+	r.abis = abis
+
+	if r.webhooks, err = walletapi.NewWebhooks(w); err != nil {
+		return nil, err
+	}
+	r.webhooks.Start()
+
 	go r.Run()
 	//logger.Infof("RPC server started")
 
 	return &r, nil
 }
 
-// shutdown the rpc server component
+// shutdown the rpc server component, running requests are given
+// --rpc-shutdown-timeout to finish before they are aborted
 func (r *RPCServer) RPCServer_Stop() {
-	r.srv.Shutdown(nil) // shutdown the server
+	r.Lock()
 	r.Exit_In_Progress = true
 	close(r.Exit_Event) // send signal to all connections to exit
-	// TODO we  must wait for connections to kill themselves
-	time.Sleep(1 * time.Second)
+	srv := r.srv
+	r.Unlock()
+
+	if srv != nil {
+		timeout := globals.RPCShutdownTimeout()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Wallet RPC requests still running after %s are aborted", timeout)
+			srv.Close()
+		}
+	}
+
+	// abort what is left and wait for every handler to return
+	r.cancel()
+	r.handlers.Lock()
+	r.handlers.Unlock()
+
+	// queued deliveries are sent when the wallet opens again
+	r.webhooks.Stop()
 	//logger.Infof("RPC Shutdown")
 
 }
 
+// track serves next unless the server is shutting down, so that
+// RPCServer_Stop can wait for the running handlers.
+func (r *RPCServer) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.handlers.RLock()
+		defer r.handlers.RUnlock()
+		if r.ctx.Err() != nil {
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
 func (r *RPCServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	basic_auth_enabled := false
@@ -90,7 +148,11 @@ func (r *RPCServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
-		if u != parts[0] || p != parts[1] {
+		// compare hashes in constant time, so neither the time taken nor the
+		// lengths give away how much of the credentials were right
+		uh, ph := sha256.Sum256([]byte(u)), sha256.Sum256([]byte(p))
+		wantu, wantp := sha256.Sum256([]byte(parts[0])), sha256.Sum256([]byte(parts[1]))
+		if subtle.ConstantTimeCompare(uh[:], wantu[:])&subtle.ConstantTimeCompare(ph[:], wantp[:]) != 1 {
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
@@ -215,6 +277,81 @@ func (r *RPCServer) Run() {
 		log.Fatalln(err)
 	}
 
+	if err := mr.RegisterMethod("register_contract_abi", RegisterContractAbiHandler{r: r}, structures.RegisterContractAbiParams{}, structures.RegisterContractAbiResult{}); err != nil {
+		log.Fatalln(err)
+	}
+	if err := mr.RegisterMethod("send_contract_method", SendContractMethodHandler{r: r}, structures.SendContractMethodParams{}, structures.SendContractMethodResult{}); err != nil {
+		log.Fatalln(err)
+	}
+	if err := mr.RegisterMethod("call_contract_method", CallContractMethodHandler{r: r}, structures.CallContractMethodParams{}, structures.CallContractMethodResult{}); err != nil {
+		log.Fatalln(err)
+	}
+	if err := mr.RegisterMethod("get_contract_method_result", GetContractMethodResultHandler{r: r}, structures.GetContractMethodResultParams{}, structures.GetContractMethodResultResult{}); err != nil {
+		log.Fatalln(err)
+	}
+
+	if err := mr.RegisterMethod("build_unsigned_transfer", BuildUnsignedTransferHandler{r: r}, structures.BuildUnsignedTransferParams{}, structures.BuildUnsignedTransferResult{}); err != nil {
+		log.Fatalln(err)
+	}
+	if err := mr.RegisterMethod("build_unsigned_contract", BuildUnsignedContractHandler{r: r}, structures.BuildUnsignedContractParams{}, structures.BuildUnsignedContractResult{}); err != nil {
+		log.Fatalln(err)
+	}
+	if err := mr.RegisterMethod("describe_unsigned_tx", DescribeUnsignedTxHandler{r: r}, structures.DescribeUnsignedTxParams{}, structures.DescribeUnsignedTxResult{}); err != nil {
+		log.Fatalln(err)
+	}
+	if err := mr.RegisterMethod("sign_unsigned_tx", SignUnsignedTxHandler{r: r}, structures.SignUnsignedTxParams{}, structures.SignUnsignedTxResult{}); err != nil {
+		log.Fatalln(err)
+	}
+	if err := mr.RegisterMethod("broadcast_signed_tx", BroadcastSignedTxHandler{r: r}, structures.BroadcastSignedTxParams{}, structures.BroadcastSignedTxResult{}); err != nil {
+		log.Fatalln(err)
+	}
+
+	if err := mr.RegisterMethod("make_payment_uri", MakePaymentUriHandler{r: r}, structures.MakePaymentUriParams{}, structures.MakePaymentUriResult{}); err != nil {
+		log.Fatalln(err)
+	}
+	if err := mr.RegisterMethod("parse_payment_uri", ParsePaymentUriHandler{r: r}, structures.ParsePaymentUriParams{}, structures.ParsePaymentUriResult{}); err != nil {
+		log.Fatalln(err)
+	}
+	if err := mr.RegisterMethod("create_invoice", CreateInvoiceHandler{r: r}, structures.CreateInvoiceParams{}, structures.CreateInvoiceResult{}); err != nil {
+		log.Fatalln(err)
+	}
+	if err := mr.RegisterMethod("get_invoice", GetInvoiceHandler{r: r}, structures.GetInvoiceParams{}, structures.GetInvoiceResult{}); err != nil {
+		log.Fatalln(err)
+	}
+	if err := mr.RegisterMethod("get_invoices", GetInvoicesHandler{r: r}, structures.GetInvoicesParams{}, structures.GetInvoicesResult{}); err != nil {
+		log.Fatalln(err)
+	}
+
+	if err := mr.RegisterMethod("add_webhook", AddWebhookHandler{r: r}, structures.AddWebhookParams{}, structures.AddWebhookResult{}); err != nil {
+		log.Fatalln(err)
+	}
+	if err := mr.RegisterMethod("remove_webhook", RemoveWebhookHandler{r: r}, structures.RemoveWebhookParams{}, structures.RemoveWebhookResult{}); err != nil {
+		log.Fatalln(err)
+	}
+	if err := mr.RegisterMethod("list_webhooks", ListWebhooksHandler{r: r}, structures.ListWebhooksParams{}, structures.ListWebhooksResult{}); err != nil {
+		log.Fatalln(err)
+	}
+
+	if err := mr.RegisterMethod("estimate_fee", EstimateFeeHandler{r: r}, structures.EstimateFeeParams{}, structures.EstimateFeeResult{}); err != nil {
+		log.Fatalln(err)
+	}
+
+	if err := mr.RegisterMethod("list_coins", ListCoinsHandler{r: r}, structures.ListCoinsParams{}, structures.ListCoinsResult{}); err != nil {
+		log.Fatalln(err)
+	}
+	if err := mr.RegisterMethod("freeze_outputs", FreezeOutputsHandler{r: r}, structures.FreezeOutputsParams{}, structures.FreezeOutputsResult{}); err != nil {
+		log.Fatalln(err)
+	}
+	if err := mr.RegisterMethod("thaw_outputs", ThawOutputsHandler{r: r}, structures.ThawOutputsParams{}, structures.ThawOutputsResult{}); err != nil {
+		log.Fatalln(err)
+	}
+	if err := mr.RegisterMethod("label_output", LabelOutputHandler{r: r}, structures.LabelOutputParams{}, structures.LabelOutputResult{}); err != nil {
+		log.Fatalln(err)
+	}
+	if err := mr.RegisterMethod("consolidate_dust", ConsolidateDustHandler{r: r}, structures.ConsolidateDustParams{}, structures.ConsolidateDustResult{}); err != nil {
+		log.Fatalln(err)
+	}
+
 	if err := mr.RegisterMethod("create_address", CreateSubaddressHandler{r: r}, structures.CreateAddressParams{}, structures.CreateAddressResult{}); err != nil {
 		log.Fatalln(err)
 	}
@@ -231,9 +368,29 @@ func (r *RPCServer) Run() {
 		log.Fatalln(err)
 	}
 
+	// the certificate is kept in the working directory, the wallet has no data directory
+	tlsConfig, fingerprint, err := globals.RPCTLSConfig(r.address, "", "wallet_rpc")
+	if err != nil {
+		log.Fatalf("ERR wallet RPC TLS err %s", err)
+	}
+	if tlsConfig != nil {
+		log.Printf("Wallet RPC uses TLS, certificate SHA-256 fingerprint %s", fingerprint)
+	}
+
 	// create a new mux
 	r.mux = http.NewServeMux()
-	r.srv = &http.Server{Addr: r.address, Handler: r.mux}
+	r.Lock()
+	if r.Exit_In_Progress {
+		r.Unlock()
+		return
+	}
+	r.srv = &http.Server{
+		Addr:        r.address,
+		Handler:     r.track(globals.RPCCORSHandler(r.mux)),
+		TLSConfig:   tlsConfig,
+		BaseContext: func(net.Listener) context.Context { return r.ctx },
+	}
+	r.Unlock()
 
 	r.mux.HandleFunc("/", hello)
 	r.mux.Handle("/json_rpc", r)
@@ -246,7 +403,12 @@ func (r *RPCServer) Run() {
 		r.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
 	}
 
-	if err := r.srv.ListenAndServe(); err != http.ErrServerClosed {
+	if tlsConfig != nil {
+		err = r.srv.ListenAndServeTLS("", "")
+	} else {
+		err = r.srv.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
 		log.Fatalf("ERR listening to address err %s", err)
 	}
 }
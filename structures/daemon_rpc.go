@@ -663,3 +663,21 @@ type (
 		Balance string `json:"balance"`
 	}
 )
+
+type (
+	EstimateFeeParams struct{} // no params
+	EstimateFee       struct {
+		Priority   string `json:"priority"` // slow, normal or fast
+		Blocks     uint64 `json:"blocks"`   // the tx should be mined within
+		Fee_per_kb uint64 `json:"fee_per_kb"`
+		Gas_price  uint64 `json:"gas_price"`
+	}
+	EstimateFeeResult struct {
+		Min_fee_per_kb uint64        `json:"min_fee_per_kb"` // the protocol minimum
+		Tx_pool_count  int           `json:"tx_pool_count"`
+		Tx_pool_size   uint64        `json:"tx_pool_size"`   // bytes
+		Block_fullness float64       `json:"block_fullness"` // of the recent blocks, 0 to 1
+		Estimates      []EstimateFee `json:"estimates"`
+		Status         string        `json:"status"`
+	}
+)
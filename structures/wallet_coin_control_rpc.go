@@ -0,0 +1,64 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+
+package structures
+
+// the wallet rpc methods of coin control, listing, freezing and labelling the
+// outputs, and consolidating the dust
+
+type Coin struct {
+	Index    uint64 `json:"index"`
+	Amount   uint64 `json:"amount"`
+	Txid     string `json:"txid"`
+	Height   uint64 `json:"height"`
+	Unlocked bool   `json:"unlocked"`
+	Frozen   bool   `json:"frozen"`
+	Label    string `json:"label,omitempty"`
+}
+
+type (
+	ListCoinsParams struct {
+		Frozen_only bool `json:"frozen_only"`
+	}
+	ListCoinsResult struct {
+		Coins []Coin `json:"coins"`
+	}
+)
+
+type (
+	FreezeOutputsParams struct {
+		Outputs []uint64 `json:"outputs"`
+	}
+	FreezeOutputsResult struct {
+	}
+)
+
+type (
+	ThawOutputsParams struct {
+		Outputs []uint64 `json:"outputs"`
+	}
+	ThawOutputsResult struct {
+	}
+)
+
+type (
+	LabelOutputParams struct {
+		Output uint64 `json:"output"`
+		Label  string `json:"label"` // empty to remove it
+	}
+	LabelOutputResult struct {
+	}
+)
+
+type (
+	ConsolidateDustParams struct {
+		Threshold uint64 `json:"threshold"` // outputs up to this amount are dust
+		Mixin     uint64 `json:"mixin"`
+		Priority  string `json:"priority"` // of the fee, the minimum fee if empty
+	}
+	ConsolidateDustResult struct {
+		Tx_hash string `json:"tx_hash"`
+		Fee     uint64 `json:"fee"`
+		Inputs  int    `json:"inputs"`
+		Amount  uint64 `json:"amount"` // sent back to the wallet, after the fee
+	}
+)
@@ -0,0 +1,64 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+
+package structures
+
+import (
+	"encoding/json"
+
+	"github.com/darmaproject/darmasuite/dvm/accounts/abi"
+)
+
+// the wallet rpc methods calling contracts by method name, with the abi given
+// inline or registered before with register_contract_abi
+
+type (
+	RegisterContractAbiParams struct {
+		Address string          `json:"address"`
+		Abi     json.RawMessage `json:"abi"`
+	}
+	RegisterContractAbiResult struct {
+	}
+)
+
+type ContractMethodParams struct {
+	Address  string          `json:"address"`        // contract, empty to create one from code
+	Code     string          `json:"code,omitempty"` // hex code of the contract to create
+	Abi      json.RawMessage `json:"abi,omitempty"`  // defaults to the abi registered for address
+	Method   string          `json:"method"`         // empty for the constructor
+	Args     json.RawMessage `json:"args,omitempty"` // JSON array of the arguments
+	Amount   uint64          `json:"amount"`
+	Gas      uint64          `json:"gas"`
+	GasPrice uint64          `json:"gas_price"`
+}
+
+type (
+	SendContractMethodParams ContractMethodParams
+	SendContractMethodResult struct {
+		TXHash string `json:"tx_hash"`
+		Fee    uint64 `json:"fee"`
+	}
+)
+
+type (
+	CallContractMethodParams struct {
+		ContractMethodParams
+		TopoHeight int64 `json:"topoheight"` // 0 for the latest
+	}
+	CallContractMethodResult struct {
+		Outputs []abi.Value `json:"outputs"`
+	}
+)
+
+type (
+	GetContractMethodResultParams struct {
+		TXHash  string          `json:"tx_hash"`
+		Address string          `json:"address"`          // contract whose registered abi is used
+		Abi     json.RawMessage `json:"abi,omitempty"`    // overrides the registered abi
+		Method  string          `json:"method,omitempty"` // decodes the return data of this method
+	}
+	GetContractMethodResultResult struct {
+		Status  uint64           `json:"status"`
+		Outputs []abi.Value      `json:"outputs"`
+		Events  []abi.DecodedLog `json:"events"`
+	}
+)
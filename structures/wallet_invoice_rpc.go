@@ -0,0 +1,80 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+
+package structures
+
+// the wallet rpc methods of payment request URIs and invoices
+
+type PaymentRequest struct {
+	Address    string `json:"address"`
+	Amount     string `json:"amount,omitempty"` // decimal, in DMCH or in units of token or contract
+	Payment_ID string `json:"payment_id,omitempty"`
+	Token      string `json:"token,omitempty"`
+	Contract   string `json:"contract,omitempty"`
+	Label      string `json:"label,omitempty"`
+	Expiry     int64  `json:"expiry,omitempty"` // unix time
+}
+
+type (
+	MakePaymentUriParams PaymentRequest
+	MakePaymentUriResult struct {
+		Uri string `json:"uri"`
+	}
+)
+
+type (
+	ParsePaymentUriParams struct {
+		Uri string `json:"uri"`
+	}
+	ParsePaymentUriResult struct {
+		Uri     PaymentRequest `json:"uri"`
+		Expired bool           `json:"expired"`
+	}
+)
+
+type Invoice struct {
+	Payment_ID  string   `json:"payment_id"`
+	Address     string   `json:"address"`
+	Amount      uint64   `json:"amount"`
+	Label       string   `json:"label,omitempty"`
+	Height      uint64   `json:"height"`
+	Created     int64    `json:"created"`
+	Expiry      int64    `json:"expiry,omitempty"`
+	Uri         string   `json:"uri"`
+	Status      string   `json:"status"` // unpaid, partial, paid or expired
+	Received    uint64   `json:"received"`
+	Paid_height uint64   `json:"paid_height,omitempty"`
+	Txids       []string `json:"txids,omitempty"`
+
+	Expiry_height uint64   `json:"expiry_height,omitempty"`
+	Late          uint64   `json:"late,omitempty"` // received after the invoice expired
+	Late_txids    []string `json:"late_txids,omitempty"`
+}
+
+type (
+	CreateInvoiceParams struct {
+		Amount     uint64 `json:"amount"`
+		Label      string `json:"label"`
+		Expires_in int64  `json:"expires_in"` // seconds, 0 if it does not expire
+	}
+	CreateInvoiceResult struct {
+		Invoice Invoice `json:"invoice"`
+	}
+)
+
+type (
+	GetInvoiceParams struct {
+		Payment_ID string `json:"payment_id"`
+	}
+	GetInvoiceResult struct {
+		Invoice Invoice `json:"invoice"`
+	}
+)
+
+type (
+	GetInvoicesParams struct {
+		Status string `json:"status"` // only the invoices of this status if not empty
+	}
+	GetInvoicesResult struct {
+		Invoices []Invoice `json:"invoices"`
+	}
+)
@@ -0,0 +1,66 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+
+package structures
+
+import "encoding/json"
+
+// the wallet rpc methods of the offline signing workflow. The unsigned and signed
+// txs are JSON objects, to be carried as they are between the online wallet and
+// the wallet holding the spend key
+
+type (
+	BuildUnsignedTransferParams struct {
+		Destinations []Destination `json:"destinations"`
+		Mixin        uint64        `json:"mixin"`
+		Unlock_time  uint64        `json:"unlock_time"`
+		Payment_ID   string        `json:"payment_id"`
+	}
+	BuildUnsignedTransferResult struct {
+		UnsignedTx json.RawMessage `json:"unsigned_tx"`
+	}
+)
+
+type (
+	BuildUnsignedContractParams ContractMethodParams
+	BuildUnsignedContractResult BuildUnsignedTransferResult
+)
+
+type (
+	DescribeUnsignedTxParams struct {
+		UnsignedTx json.RawMessage `json:"unsigned_tx"`
+	}
+	DescribeUnsignedTxResult struct {
+		Destinations   []Destination `json:"destinations"`
+		PaymentID      string        `json:"payment_id,omitempty"`
+		UnlockTime     uint64        `json:"unlock_time"`
+		InputsSum      uint64        `json:"inputs_sum"`
+		Fee            uint64        `json:"fee"`
+		FeePerKb       uint64        `json:"fee_per_kb"`
+		MaxFeePerKb    uint64        `json:"max_fee_per_kb"`
+		Change         uint64        `json:"change"`
+		Contract       string        `json:"contract,omitempty"`
+		ContractAmount uint64        `json:"contract_amount,omitempty"`
+		MaxGasFee      uint64        `json:"max_gas_fee,omitempty"`
+	}
+)
+
+type (
+	SignUnsignedTxParams struct {
+		UnsignedTx  json.RawMessage `json:"unsigned_tx"`
+		MaxFeePerKb uint64          `json:"max_fee_per_kb"` // the wallet's limit if 0
+	}
+	SignUnsignedTxResult struct {
+		SignedTx json.RawMessage `json:"signed_tx"`
+		TXHash   string          `json:"tx_hash"`
+		Fee      uint64          `json:"fee"`
+	}
+)
+
+type (
+	BroadcastSignedTxParams struct {
+		SignedTx json.RawMessage `json:"signed_tx"`
+	}
+	BroadcastSignedTxResult struct {
+		TXHash string `json:"tx_hash"`
+	}
+)
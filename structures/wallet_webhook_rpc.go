@@ -0,0 +1,42 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+
+package structures
+
+// the wallet rpc methods of webhooks, which POST the events of the wallet
+
+type Webhook struct {
+	Id            string   `json:"id"`
+	Url           string   `json:"url"`
+	Events        []string `json:"events"`
+	Confirmations uint64   `json:"confirmations"`
+	Created       int64    `json:"created"`
+	Queued        int      `json:"queued"` // deliveries waiting to be sent or retried
+}
+
+type (
+	AddWebhookParams struct {
+		Url           string   `json:"url"`
+		Secret        string   `json:"secret"`        // generated if empty
+		Events        []string `json:"events"`        // all of them if empty
+		Confirmations uint64   `json:"confirmations"` // transfer_confirmed is sent at this many if not 0
+	}
+	AddWebhookResult struct {
+		Id     string `json:"id"`
+		Secret string `json:"secret"` // only returned here
+	}
+)
+
+type (
+	RemoveWebhookParams struct {
+		Id string `json:"id"`
+	}
+	RemoveWebhookResult struct {
+	}
+)
+
+type (
+	ListWebhooksParams struct{}
+	ListWebhooksResult struct {
+		Webhooks []Webhook `json:"webhooks"`
+	}
+)
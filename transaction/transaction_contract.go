@@ -21,6 +21,11 @@ type SCData struct {
 	Payload      []byte         `json:"input"`
 	Sig          [64]byte       `json:"sig"`
 	Type         uint8          `json:"type"`
+
+	// optional, the public view key of the sender signed with its spend key.
+	// It registers the sender's Darma address for its contract account.
+	SenderViewKey crypto.Key `json:"fromViewKey"`
+	SenderViewSig [64]byte   `json:"fromViewSig"`
 }
 
 // enum SCData.Type
@@ -61,6 +66,12 @@ func (scdata *SCData) Serialize(buf *bytes.Buffer) {
 	n = binary.PutUvarint(buffer, uint64(scdata.Type))
 	scDataBuf.Write(buffer[:n])
 
+	// the view key is a trailer, parsers not knowing it skip it
+	if scdata.HasSenderViewKey() {
+		scDataBuf.Write(scdata.SenderViewKey[:])
+		scDataBuf.Write(scdata.SenderViewSig[:])
+	}
+
 	// serialize fields END
 
 	scDataBytes := scDataBuf.Bytes()
@@ -152,9 +163,56 @@ func (scdata *SCData) Deserialize(buf *bytes.Reader) ([]byte, error) {
 	scdata.Type = uint8(typeU64)
 	contract = contract[done:]
 
+	if len(contract) >= len(scdata.SenderViewKey)+len(scdata.SenderViewSig) {
+		done = copy(scdata.SenderViewKey[:], contract)
+		contract = contract[done:]
+		copy(scdata.SenderViewSig[:], contract)
+	}
+
 	return nil, nil
 }
 
+// HasSenderViewKey returns whether the sender's view key is included.
+func (scdata *SCData) HasSenderViewKey() bool {
+	return scdata.SenderViewKey != crypto.Key{}
+}
+
+// VerifySenderViewKey checks that the sender's view key is signed with the
+// sender's spend key.
+func (scdata *SCData) VerifySenderViewKey() bool {
+	return crypto.VerifySign(scdata.SenderViewKey[:], crypto.Key(scdata.Sender), scdata.SenderViewSig)
+}
+
+// DWHash returns the hash the sender of the deposit or withdraw tx signs:
+// the type, the sender, the contract, the nonce and the amount of scdata, and
+// the prefix of tx without its extra, which holds the signature. The key
+// images in the prefix tie the signature to tx.
+func (scdata *SCData) DWHash(tx *Transaction) crypto.Hash {
+	var buf bytes.Buffer
+	buffer := make([]byte, binary.MaxVarintLen64)
+
+	buf.WriteString("contractdw")
+	buf.WriteByte(scdata.Type)
+	buf.Write(scdata.Sender[:])
+	buf.Write(scdata.Recipient[:])
+
+	n := binary.PutUvarint(buffer, scdata.AccountNonce)
+	buf.Write(buffer[:n])
+
+	n = binary.PutUvarint(buffer, scdata.Amount)
+	buf.Write(buffer[:n])
+
+	n = binary.PutUvarint(buffer, uint64(len(scdata.Payload)))
+	buf.Write(buffer[:n])
+	buf.Write(scdata.Payload)
+
+	prefix := *tx
+	prefix.Extra = nil
+	buf.Write(prefix.SerializeHeader())
+
+	return crypto.Keccak256(buf.Bytes())
+}
+
 func contrackBase58Addr(sender string, salt []byte) (string, error) {
 	addr, err := address.NewAddress(sender)
 	if err != nil {
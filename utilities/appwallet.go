@@ -114,6 +114,9 @@ type MobileWallet struct {
 	quitAutomaticTransfer chan int
 	automaticWG           sync.WaitGroup
 	AppAutomaticTransferStates
+
+	abisLock sync.Mutex
+	abis     *walletapi.ContractABIs // abis registered for contracts, kept next to the wallet file
 }
 
 type AutomaticTransferState struct {
@@ -821,6 +824,110 @@ func (w *MobileWallet) Generate_Intergrated_Address(payment_id_len int) string {
 	return address
 }
 
+// Make_Payment_Uri returns the darma: URI requesting a payment to address, the wallet
+// address if empty. amount is in DMCH, or in units of token or contract if given
+func (w *MobileWallet) Make_Payment_Uri(address, amount, payment_id, token, contract, label string, expiry int64) string {
+	request := globals.PaymentRequest{
+		Address:   address,
+		Amount:    amount,
+		PaymentID: payment_id,
+		Token:     token,
+		Contract:  contract,
+		Label:     label,
+		Expiry:    expiry,
+	}
+	if request.Address == "" {
+		wallet := w.GetWallet()
+		if wallet == nil {
+			setLastError(ErrInvalidWalletObject, "Wallet is not open.")
+			return ""
+		}
+		request.Address = wallet.GetAddress().String()
+	}
+	if err := request.Validate(); err != nil {
+		setLastError(ErrDecodeData, "Invalid payment request: ", err)
+		return ""
+	}
+	return request.URI()
+}
+
+// Parse_Payment_Uri parses a darma: URI, and returns what it requests as JSON
+func (w *MobileWallet) Parse_Payment_Uri(uri string) string {
+	request, err := globals.ParsePaymentURI(uri)
+	if err != nil {
+		setLastError(ErrDecodeData, "Invalid payment URI: ", err)
+		return ""
+	}
+
+	buffer, err := json.Marshal(struct {
+		*globals.PaymentRequest
+		Expired bool `json:"expired"`
+	}{request, request.Expired(time.Now())})
+	if err != nil {
+		setLastError(ErrSystemInternal, err.Error())
+		return ""
+	}
+	return string(buffer)
+}
+
+// Create_Invoice creates an invoice of amountstr DMCH, which expires after expires_in
+// seconds if not 0, and returns it as JSON
+func (w *MobileWallet) Create_Invoice(amountstr string, label string, expires_in int64) string {
+	wallet := w.GetWallet()
+	if wallet == nil {
+		setLastError(ErrInvalidWalletObject, "Wallet is not open.")
+		return ""
+	}
+
+	amount, err := globals.ParseAmount(amountstr, false)
+	if err != nil {
+		setLastError(ErrInvalidAmount, "Invalid amount: ", err)
+		return ""
+	}
+	invoice, err := wallet.CreateInvoice(amount, label, time.Duration(expires_in)*time.Second)
+	if err != nil {
+		setLastError(ErrSystemInternal, err.Error())
+		return ""
+	}
+
+	buffer, _ := json.Marshal(invoice)
+	return string(buffer)
+}
+
+// Get_Invoice returns the invoice of payment_id as JSON, with the payments received so far
+func (w *MobileWallet) Get_Invoice(payment_id string) string {
+	wallet := w.GetWallet()
+	if wallet == nil {
+		setLastError(ErrInvalidWalletObject, "Wallet is not open.")
+		return ""
+	}
+
+	invoice, err := wallet.GetInvoice(payment_id)
+	if err != nil {
+		setLastError(ErrInvalidPaymentID, err.Error())
+		return ""
+	}
+
+	buffer, _ := json.Marshal(invoice)
+	return string(buffer)
+}
+
+// Get_Invoices returns the invoices of the wallet as JSON, newest first
+func (w *MobileWallet) Get_Invoices() string {
+	wallet := w.GetWallet()
+	if wallet == nil {
+		setLastError(ErrInvalidWalletObject, "Wallet is not open.")
+		return ""
+	}
+
+	invoices := wallet.GetInvoices()
+	if invoices == nil {
+		invoices = []walletapi.Invoice{}
+	}
+	buffer, _ := json.Marshal(invoices)
+	return string(buffer)
+}
+
 func (w *MobileWallet) Get_Transfers(in bool, out bool, max_height_str, limit_str string) string {
 	wallet := w.GetWallet()
 	if wallet == nil {
@@ -896,7 +1003,7 @@ func (w *MobileWallet) Get_TotalOutput(limit_str string, limitType string, amoun
 	}
 	var amount uint64
 	if amount_str == "0" {
-		banlance, _ := wallet.GetBalance()
+		banlance, _ := wallet.GetSpendableBalance()
 		amount = banlance
 	} else {
 		amount, err = globals.ParseAmount(amount_str, false)
@@ -920,6 +1027,25 @@ func (w *MobileWallet) Get_TotalOutput(limit_str string, limitType string, amoun
 	return string(buffer)
 }
 
+// Estimate_Fee returns the fee per KB and the gas price the daemon suggests for
+// the slow, normal and fast priorities as JSON
+func (w *MobileWallet) Estimate_Fee() string {
+	wallet := w.GetWallet()
+	if wallet == nil {
+		setLastError(ErrInvalidWalletObject, "Wallet is not open.")
+		return ""
+	}
+
+	result, err := wallet.EstimateFees()
+	if err != nil {
+		setLastError(ErrSystemInternal, "Estimate fee failed: ", err)
+		return ""
+	}
+
+	buffer, _ := json.Marshal(result)
+	return string(buffer)
+}
+
 func (w *MobileWallet) Get_Automatic_Status() string {
 	buffer, _ := json.Marshal(w.automaticTS)
 
@@ -974,7 +1100,7 @@ func (w *MobileWallet) Start_Automatic_Transfer(toaddr string, amountstr string,
 	}
 	var amount uint64
 	if amountstr == "0" {
-		banlance, _ := wallet.GetBalance()
+		banlance, _ := wallet.GetSpendableBalance()
 		amount = banlance
 	} else {
 		amount, err = globals.ParseAmount(amountstr, false)
@@ -1037,7 +1163,7 @@ func (w *MobileWallet) automatic_Transfer(toaddr string, amount uint64, unlock_t
 			return
 		default:
 			if wallet != nil {
-				tx := w.TransferV2(toaddr, globals.FormatMoney8(amount), "0", payment_id, 0, true, password, limit, "max")
+				tx := w.TransferV2(toaddr, globals.FormatMoney8(amount), "0", payment_id, 0, true, password, limit, "max", "", "", "")
 				if tx == "" {
 					w.automaticTS.ErrorTransfer++
 					time.Sleep(time.Second * 3)
@@ -1074,10 +1200,13 @@ func (w *MobileWallet) automatic_Transfer(toaddr string, amount uint64, unlock_t
 }
 
 func (w *MobileWallet) Transfer(toaddr string, amountstr string, unlock_time_str string, payment_id string, mixin int, sendtx bool, password string) string {
-	return w.TransferV2(toaddr, amountstr, unlock_time_str, payment_id, mixin, sendtx, password, 0, "")
+	return w.TransferV2(toaddr, amountstr, unlock_time_str, payment_id, mixin, sendtx, password, 0, "", "", "", "")
 }
 
-func (w *MobileWallet) TransferV2(toaddr string, amountstr string, unlock_time_str string, payment_id string, mixin int, sendtx bool, password string, limit uint64, limitType string) string {
+// TransferV2 spends the outputs of the comma separated global indices in outputs if
+// not empty, or else selects them by strategy: random (default), fee or consolidate.
+// priority is that of the fee, slow, normal or fast, or empty for the minimum fee
+func (w *MobileWallet) TransferV2(toaddr string, amountstr string, unlock_time_str string, payment_id string, mixin int, sendtx bool, password string, limit uint64, limitType string, outputs string, strategy string, priority string) string {
 	var addr_list []address.Address
 	var amount_list []uint64
 
@@ -1128,7 +1257,20 @@ func (w *MobileWallet) TransferV2(toaddr string, amountstr string, unlock_time_s
 		rlog.Infof("Payment ID is integreted in address ID:%x", addr.PaymentID)
 	}
 
-	tx, inputs, input_sum, change, err := wallet.Transfer(addr_list, amount_list, unlock_time, payment_id, 0, 0, limit, limitType)
+	indices, err := parseOutputIndices(outputs)
+	if err != nil {
+		setLastError(ErrDecodeData, "Invalid outputs: ", err)
+		return ""
+	}
+	cc := &walletapi.CoinControl{
+		Outputs:   indices,
+		Strategy:  strategy,
+		Limit:     limit,
+		LimitType: limitType,
+		Priority:  priority,
+	}
+
+	tx, inputs, input_sum, change, err := wallet.TransferCoinControl(addr_list, amount_list, unlock_time, payment_id, 0, 0, nil, cc)
 
 	_ = inputs
 	if err != nil {
@@ -1170,6 +1312,139 @@ func (w *MobileWallet) TransferV2(toaddr string, amountstr string, unlock_time_s
 	return string(buffer)
 }
 
+// parseOutputIndices parses comma separated global indices of outputs
+func parseOutputIndices(outputs string) (indices []uint64, err error) {
+	for _, s := range strings.Split(outputs, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		index, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		indices = append(indices, index)
+	}
+	return
+}
+
+// List_Coins returns the unspent outputs of the wallet as JSON, with their frozen
+// state and labels
+func (w *MobileWallet) List_Coins() string {
+	wallet := w.GetWallet()
+	if wallet == nil {
+		setLastError(ErrInvalidWalletObject, "Wallet is not open.")
+		return ""
+	}
+
+	coins := wallet.ListCoins()
+	if coins == nil {
+		coins = []walletapi.Coin{}
+	}
+	buffer, _ := json.Marshal(coins)
+	return string(buffer)
+}
+
+// Freeze_Outputs keeps the outputs of the comma separated global indices from being spent
+func (w *MobileWallet) Freeze_Outputs(outputs string) bool {
+	return w.updateOutputs(outputs, true)
+}
+
+// Thaw_Outputs lets frozen outputs be spent again
+func (w *MobileWallet) Thaw_Outputs(outputs string) bool {
+	return w.updateOutputs(outputs, false)
+}
+
+func (w *MobileWallet) updateOutputs(outputs string, freeze bool) bool {
+	wallet := w.GetWallet()
+	if wallet == nil {
+		setLastError(ErrInvalidWalletObject, "Wallet is not open.")
+		return false
+	}
+
+	indices, err := parseOutputIndices(outputs)
+	if err != nil || len(indices) == 0 {
+		setLastError(ErrDecodeData, "Invalid outputs: ", err)
+		return false
+	}
+	if freeze {
+		err = wallet.FreezeOutputs(indices)
+	} else {
+		err = wallet.ThawOutputs(indices)
+	}
+	if err != nil {
+		setLastError(ErrSystemInternal, err.Error())
+		return false
+	}
+	return true
+}
+
+// Label_Output labels the output of global index output, an empty label removes it
+func (w *MobileWallet) Label_Output(output string, label string) bool {
+	wallet := w.GetWallet()
+	if wallet == nil {
+		setLastError(ErrInvalidWalletObject, "Wallet is not open.")
+		return false
+	}
+
+	index, err := strconv.ParseUint(output, 10, 64)
+	if err != nil {
+		setLastError(ErrDecodeData, "Invalid output: ", err)
+		return false
+	}
+	if err = wallet.LabelOutput(index, label); err != nil {
+		setLastError(ErrSystemInternal, err.Error())
+		return false
+	}
+	return true
+}
+
+// Consolidate_Dust sends the unlocked outputs up to threshold DMCH back to the wallet
+// as one output, which may need to be repeated while there is more dust. priority is
+// that of the fee, slow, normal or fast, or empty for the minimum fee
+func (w *MobileWallet) Consolidate_Dust(threshold_str string, priority string, sendtx bool, password string) string {
+	wallet := w.GetWallet()
+	if wallet == nil {
+		setLastError(ErrInvalidWalletObject, "Wallet is not open.")
+		return ""
+	}
+	if w.Check_Password(password) == false {
+		setLastError(ErrInvalidPassword, "Invalid password")
+		return ""
+	}
+
+	threshold, err := globals.ParseAmount(threshold_str, false)
+	if err != nil {
+		setLastError(ErrInvalidAmount, "Invalid amount: ", err)
+		return ""
+	}
+
+	tx, _, input_sum, err := wallet.ConsolidateDust(threshold, 0, priority)
+	if err != nil {
+		setLastError(ErrSystemInternal, "Error while building Transaction: ", err)
+		return ""
+	}
+
+	var txResult AppWalletTransferTX
+	fees := tx.RctSignature.GetTXFee()
+	txResult.Transfer_fee = fees
+	txResult.Transfer_amount = input_sum - fees
+	txResult.Transfer_inputs_sum = input_sum
+	txResult.Transfer_txid = tx.GetHash().String()
+	txResult.Transfer_txhex = hex.EncodeToString(tx.Serialize())
+	txResult.Transfer_address = wallet.GetAddress().String()
+	txResult.Transfer_txsize = len([]byte(txResult.Transfer_txhex))/1024/2 + 1
+
+	if sendtx == true {
+		if err = wallet.SendTransaction(tx); err != nil {
+			setLastError(ErrSystemInternal, "Send transfer failed: ", err)
+			return ""
+		}
+	}
+
+	buffer, _ := json.Marshal(txResult)
+	return string(buffer)
+}
+
 func (w *MobileWallet) Transfer_Everything(address string, unlock_time_str string, payment_id_hex string, mixin int, sendtx bool, password string) string {
 	if w.Check_Password(password) == false {
 		return ""
@@ -1406,6 +1681,160 @@ func (w *MobileWallet) Send_Raw_Transaction(txraw string) string {
 	return tx.GetHash().String()
 }
 
+// Build_Unsigned_Transfer builds the transfer of amountstr to toaddr without signing it, and
+// returns it as JSON to be signed by Sign_Unsigned_Tx on the wallet holding the spend key
+func (w *MobileWallet) Build_Unsigned_Transfer(toaddr string, amountstr string, unlock_time_str string, payment_id string, mixin int) string {
+	wallet := w.GetWallet()
+	if wallet == nil {
+		setLastError(ErrInvalidWalletObject, "Wallet is not open.")
+		return ""
+	}
+
+	unlock_time, _ := strconv.ParseUint(unlock_time_str, 10, 64)
+
+	addr, err := globals.ParseValidateAddress(toaddr)
+	if err != nil {
+		setLastError(ErrInvalidAddress, "Invalid address: ", err)
+		return ""
+	}
+	amount, err := globals.ParseAmount(amountstr, false)
+	if err != nil {
+		setLastError(ErrInvalidAmount, "Invalid amount: ", err)
+		return ""
+	}
+
+	u, err := wallet.BuildUnsignedTransfer([]address.Address{*addr}, []uint64{amount}, unlock_time, payment_id, uint64(mixin))
+	if err != nil {
+		setLastError(ErrSystemInternal, "Error while building Transaction: ", err)
+		return ""
+	}
+
+	buffer, err := json.Marshal(u)
+	if err != nil {
+		setLastError(ErrSystemInternal, err.Error())
+		return ""
+	}
+	return string(buffer)
+}
+
+// Build_Unsigned_Contract_Method builds the tx calling method of the contract at addr without
+// signing it, see Contract_Send_Method
+func (w *MobileWallet) Build_Unsigned_Contract_Method(addr, abi_json, method, args, amount, gas, gas_price string) string {
+	wallet := w.GetWallet()
+	if wallet == nil {
+		setLastError(ErrInvalidWalletObject, "Wallet is not open.")
+		return ""
+	}
+
+	call := w.contractMethodCall(addr, abi_json, method, args, amount, gas, gas_price)
+	if call == nil {
+		return ""
+	}
+	u, err := wallet.BuildUnsignedContractMethod(call)
+	if err != nil {
+		setLastError(ErrSystemInternal, "Error while building Transaction: ", err)
+		return ""
+	}
+
+	buffer, err := json.Marshal(u)
+	if err != nil {
+		setLastError(ErrSystemInternal, err.Error())
+		return ""
+	}
+	return string(buffer)
+}
+
+// Describe_Unsigned_Tx returns as JSON the destinations, amounts and fee of a tx built by
+// Build_Unsigned_*, to be shown to users before Sign_Unsigned_Tx signs it
+func (w *MobileWallet) Describe_Unsigned_Tx(unsigned_tx string) string {
+	wallet := w.GetWallet()
+	if wallet == nil {
+		setLastError(ErrInvalidWalletObject, "Wallet is not open.")
+		return ""
+	}
+
+	u, err := walletapi.ParseUnsignedTx([]byte(unsigned_tx))
+	if err != nil {
+		setLastError(ErrDecodeData, err.Error())
+		return ""
+	}
+	summary, err := wallet.DescribeUnsignedTx(u)
+	if err != nil {
+		setLastError(ErrSystemInternal, err.Error())
+		return ""
+	}
+
+	buffer, err := json.Marshal(summary)
+	if err != nil {
+		setLastError(ErrSystemInternal, err.Error())
+		return ""
+	}
+	return string(buffer)
+}
+
+// Sign_Unsigned_Tx signs a tx built by Build_Unsigned_*, the wallet need not be online.
+// Txs paying more fees per KB than max_fee_per_kb, or than the wallet's limit if it is
+// empty, are refused. It returns the signed tx as JSON, to be sent by Broadcast_Signed_Tx
+func (w *MobileWallet) Sign_Unsigned_Tx(unsigned_tx string, max_fee_per_kb string, password string) string {
+	wallet := w.GetWallet()
+	if wallet == nil {
+		setLastError(ErrInvalidWalletObject, "Wallet is not open.")
+		return ""
+	}
+	if w.Check_Password(password) == false {
+		setLastError(ErrInvalidPassword, "Invalid password")
+		return ""
+	}
+
+	var max_fees_per_kb uint64
+	if max_fee_per_kb != "" {
+		var err error
+		if max_fees_per_kb, err = globals.ParseAmount(max_fee_per_kb, false); err != nil {
+			setLastError(ErrInvalidAmount, "Invalid fee per KB: ", err)
+			return ""
+		}
+	}
+
+	u, err := walletapi.ParseUnsignedTx([]byte(unsigned_tx))
+	if err != nil {
+		setLastError(ErrDecodeData, err.Error())
+		return ""
+	}
+	signed, err := wallet.SignUnsignedTx(u, max_fees_per_kb)
+	if err != nil {
+		setLastError(ErrSystemInternal, "Error while signing Transaction: ", err)
+		return ""
+	}
+
+	buffer, err := json.Marshal(signed)
+	if err != nil {
+		setLastError(ErrSystemInternal, err.Error())
+		return ""
+	}
+	return string(buffer)
+}
+
+// Broadcast_Signed_Tx sends a tx signed by Sign_Unsigned_Tx and returns its hash
+func (w *MobileWallet) Broadcast_Signed_Tx(signed_tx string) string {
+	wallet := w.GetWallet()
+	if wallet == nil {
+		setLastError(ErrInvalidWalletObject, "Wallet is not open.")
+		return ""
+	}
+
+	signed, err := walletapi.ParseSignedTx([]byte(signed_tx))
+	if err != nil {
+		setLastError(ErrDecodeData, err.Error())
+		return ""
+	}
+	tx, err := wallet.BroadcastSignedTx(signed)
+	if err != nil {
+		setLastError(ErrSystemInternal, "Transaction sending failed: ", err)
+		return ""
+	}
+	return tx.GetHash().String()
+}
+
 func (w *MobileWallet) Rescan_From_Height() {
 	wallet := w.GetWallet()
 
@@ -1896,7 +2325,7 @@ func (w *MobileWallet) Stake_Buy_Share(pool_id string, amount_str string, reward
 		return ""
 	}
 
-	balance, _ := wallet.GetBalance()
+	balance, _ := wallet.GetSpendableBalance()
 	fmt.Println("balance:", balance, "  amount:", amount)
 	if balance <= amount {
 		setLastError(ErrInvalidAmount, "Insufficient unlocked balance.")
@@ -2809,6 +3238,137 @@ func (w *MobileWallet) Contract_Result(abi_file, func_name, tx_hash string) stri
 	return val
 }
 
+// contractABIs returns the abis registered for contracts, loading them on first use
+func (w *MobileWallet) contractABIs() *walletapi.ContractABIs {
+	w.abisLock.Lock()
+	defer w.abisLock.Unlock()
+
+	if w.abis == nil {
+		abis, err := walletapi.NewContractABIs(w.FileName + ".abi.json")
+		if err != nil {
+			setLastError(ErrDecodeData, "Load contract abis failed: ", err)
+			return nil
+		}
+		w.abis = abis
+	}
+	return w.abis
+}
+
+// contractMethodCall returns the call of method of the contract at addr, decoded with abi_json
+// or the abi registered for addr, and args given as a JSON array
+func (w *MobileWallet) contractMethodCall(addr, abi_json, method, args, amount, gas, gas_price string) *walletapi.ContractMethodCall {
+	abis := w.contractABIs()
+	if abis == nil {
+		return nil
+	}
+	a, err := abis.Resolve(addr, []byte(abi_json))
+	if err != nil {
+		setLastError(ErrDecodeData, err.Error())
+		return nil
+	}
+
+	call := &walletapi.ContractMethodCall{ABI: a, Contract: addr, Method: method, Args: json.RawMessage(args)}
+	call.Amount, _ = strconv.ParseUint(amount, 10, 64)
+	call.Gas, _ = strconv.ParseUint(gas, 10, 64)
+	call.GasPrice, _ = strconv.ParseUint(gas_price, 10, 64)
+	return call
+}
+
+// Contract_Register_Abi registers abi_json for the contract at addr, so the Contract_*_Method
+// functions can be called with an empty abi
+func (w *MobileWallet) Contract_Register_Abi(addr, abi_json string) bool {
+	abis := w.contractABIs()
+	if abis == nil {
+		return false
+	}
+	if err := abis.Register(addr, []byte(abi_json)); err != nil {
+		setLastError(ErrDecodeData, err.Error())
+		return false
+	}
+	return true
+}
+
+// Contract_Send_Method sends the tx calling method of the contract at addr and returns its hash
+func (w *MobileWallet) Contract_Send_Method(addr, abi_json, method, args, amount, gas, gas_price string) string {
+	wallet := w.GetWallet()
+	if wallet == nil {
+		setLastError(ErrInvalidWalletObject, "Wallet is not open.")
+		return ""
+	}
+
+	call := w.contractMethodCall(addr, abi_json, method, args, amount, gas, gas_price)
+	if call == nil {
+		return ""
+	}
+	tx, err := wallet.SendContractMethod(call)
+	if err != nil {
+		setLastError(ErrSystemInternal, "Transaction sending failed: ", err)
+		return ""
+	}
+	rlog.Infof("Transaction sent successfully. txid = %s", tx.GetHash())
+	return tx.GetHash().String()
+}
+
+// Contract_Call_Method runs method of the contract at addr without sending a tx and returns
+// its outputs as JSON
+func (w *MobileWallet) Contract_Call_Method(addr, abi_json, method, args, amount, gas, gas_price, height string) string {
+	wallet := w.GetWallet()
+	if wallet == nil {
+		setLastError(ErrInvalidWalletObject, "Wallet is not open.")
+		return ""
+	}
+
+	call := w.contractMethodCall(addr, abi_json, method, args, amount, gas, gas_price)
+	if call == nil {
+		return ""
+	}
+	topoHeight, _ := strconv.ParseInt(height, 10, 64)
+	outputs, err := wallet.CallContractMethod(call, topoHeight)
+	if err != nil {
+		setLastError(ErrSystemInternal, err.Error())
+		return ""
+	}
+
+	data, err := json.Marshal(outputs)
+	if err != nil {
+		setLastError(ErrSystemInternal, err.Error())
+		return ""
+	}
+	return string(data)
+}
+
+// Contract_Method_Result returns the status, outputs of method and events of the tx tx_hash
+// as JSON
+func (w *MobileWallet) Contract_Method_Result(tx_hash, addr, abi_json, method string) string {
+	wallet := w.GetWallet()
+	if wallet == nil {
+		setLastError(ErrInvalidWalletObject, "Wallet is not open.")
+		return ""
+	}
+
+	abis := w.contractABIs()
+	if abis == nil {
+		return ""
+	}
+	a, err := abis.Resolve(addr, []byte(abi_json))
+	if err != nil {
+		setLastError(ErrDecodeData, err.Error())
+		return ""
+	}
+	result, err := wallet.GetContractMethodResult(a, method, tx_hash)
+	if err != nil {
+		setLastError(ErrSystemInternal, err.Error())
+		return ""
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		setLastError(ErrSystemInternal, err.Error())
+		return ""
+	}
+	return string(data)
+}
+
 func (w *MobileWallet) Set_Rlog_Env() {
 	if os.Getenv("RLOG_LOG_LEVEL") == "" {
 		os.Setenv("RLOG_LOG_LEVEL", "INFO") // default logging in debug mode
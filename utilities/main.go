@@ -47,7 +47,7 @@ const commandLine = `darmad
 Darma: A secure, private blockchain with smart-contracts 
 
 Usage:
-  darmad [--help] [--version] [--testNet] [--sync-node] [--boltdb | --badgerdb] [--disable-checkpoints] [--netEnv=<netEnv>] [--socks-proxy=<socks_ip:port>] [--data-dir=<directory>] [--p2p-bind=<0.0.0.0:53803>] [--add-exclusive-node=<ip:port>]... [--add-priority-node=<ip:port>]... 	[--min-peers=<11>] [--rpc-bind=<127.0.0.1:53804>] [--lowcpuram] [--mining-address=<wallet_address>] [--mining-threads=<cpu_num>] [--node-tag=<unique name>] [--vote-rpc-address=<127.0.0.1:53805>] [--pool-id=<xxxx>] [--log-level=<info>]
+  darmad [--help] [--version] [--testNet] [--sync-node] [--boltdb | --badgerdb] [--disable-checkpoints] [--netEnv=<netEnv>] [--socks-proxy=<socks_ip:port>] [--data-dir=<directory>] [--p2p-bind=<0.0.0.0:53803>] [--add-exclusive-node=<ip:port>]... [--add-priority-node=<ip:port>]... 	[--min-peers=<11>] [--rpc-bind=<127.0.0.1:53804>] [--rpc-gas-cap=<25000000>] [--rpc-call-timeout=<5s>] [--rpc-access-config=<file>] [--rpc-api-key=<key[=namespaces]>]... [--rpc-anonymous=<namespaces>] [--rpc-allow-methods=<methods>] [--rpc-deny-methods=<methods>] [--rpc-ip-rate=<req/s>] [--rpc-key-rate=<req/s>] [--rpc-max-request-size=<10485760>] [--rpc-tls-cert=<file>] [--rpc-tls-key=<file>] [--rpc-tls-self-signed] [--rpc-cors=<origins>] [--rpc-shutdown-timeout=<10s>] [--metrics-bind=<127.0.0.1:53806>] [--metrics-config=<file>] [--metrics-push=<type=endpoint>]... [--metrics-push-interval=<10s>] [--metrics-push-tags=<key=value,...>] [--metrics-push-auth=<user:password>] [--metrics-push-database=<darma>] [--contract-workers=<1>] [--lowcpuram] [--mining-address=<wallet_address>] [--mining-threads=<cpu_num>] [--node-tag=<unique name>] [--vote-rpc-address=<127.0.0.1:53805>] [--pool-id=<xxxx>] [--log-level=<info>]
   darmad -h | --help
   darmad -v | --version
 
@@ -61,6 +61,29 @@ Options:
   --socks-proxy=<socks_ip:port>        Use a proxy to connect to network.
   --data-dir=<directory>               Store blockchain data at this location
   --rpc-bind=<127.0.0.1:53804>         RPC listens on this ip:port
+  --rpc-gas-cap=<25000000>             Gas cap of contract calls made through the RPC, 0 for no cap
+  --rpc-call-timeout=<5s>              Timeout of contract calls made through the RPC, 0 for no timeout
+  --rpc-access-config=<file>           Read the RPC keys, allow-lists and rate limits from this JSON file
+  --rpc-api-key=<key[=namespaces]>     RPC key sent as Bearer token or X-API-Key, for the comma separated namespaces (public, wallet, admin, debug), all if none
+  --rpc-anonymous=<namespaces>         RPC namespaces served without a key, defaults to public if keys are set, all otherwise
+  --rpc-allow-methods=<methods>        Serve only these comma separated RPC methods
+  --rpc-deny-methods=<methods>         Never serve these comma separated RPC methods
+  --rpc-ip-rate=<req/s>                RPC requests per second of each ip without a key, 0 for no limit
+  --rpc-key-rate=<req/s>               RPC requests per second of each key, 0 for no limit
+  --rpc-max-request-size=<10485760>    Maximum size of an RPC request in bytes
+  --rpc-tls-cert=<file>                Serve the RPC over TLS with this PEM certificate
+  --rpc-tls-key=<file>                 PEM private key of --rpc-tls-cert
+  --rpc-tls-self-signed                Serve the RPC over TLS with a self-signed certificate kept in the data directory
+  --rpc-cors=<origins>                 Comma separated origins browsers may call the RPC from, * for any
+  --rpc-shutdown-timeout=<10s>         How long running RPC requests may finish on shutdown before they are aborted
+  --metrics-bind=<127.0.0.1:53806>     Serve metrics for Prometheus on http://ip:port/metrics
+  --metrics-config=<file>              Push metrics to the exporters listed in this JSON file
+  --metrics-push=<type=endpoint>       Push metrics to influxdb=<url>, graphite=<ip:port>, opentsdb=<ip:port> or librato
+  --metrics-push-interval=<10s>        How often --metrics-push exporters are sent the metrics
+  --metrics-push-tags=<key=value,...>  Tags of the pushed metrics, defaults to node=<node-tag>
+  --metrics-push-auth=<user:password>  Credentials of the --metrics-push exporters (librato: email:token)
+  --metrics-push-database=<darma>      InfluxDB database of the pushed metrics
+  --contract-workers=<1>               Run the contract txs of a block in parallel on this many goroutines, 0 for one per CPU
   --p2p-bind=<0.0.0.0:53803>           P2P server listens on this ip:port, specify port 0 to disable listening server
   --add-exclusive-node=<ip:port>       Connect to specific peer only 
   --add-priority-node=<ip:port>	       Maintain persistant connection to specified peer
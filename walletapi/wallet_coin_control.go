@@ -0,0 +1,312 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+package walletapi
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/romana/rlog"
+
+	"github.com/darmaproject/darmasuite/address"
+	"github.com/darmaproject/darmasuite/config"
+	"github.com/darmaproject/darmasuite/crypto"
+	"github.com/darmaproject/darmasuite/globals"
+	"github.com/darmaproject/darmasuite/inputmaturity"
+	"github.com/darmaproject/darmasuite/transaction"
+)
+
+// the frozen state and label of outputs, by global index
+const COIN_CONTROL_BUCKET = "COIN_CONTROL"
+
+// strategies to select the outputs of a transfer
+const (
+	COIN_SELECT_RANDOM      = "random"      // default, outputs in random order, revealing the least
+	COIN_SELECT_FEE         = "fee"         // largest outputs first, the fewest inputs and the lowest fee
+	COIN_SELECT_CONSOLIDATE = "consolidate" // smallest outputs first, folding dust into the change
+)
+
+// CoinControl chooses the outputs a transfer spends.
+type CoinControl struct {
+	Outputs     []uint64 // global indices of the outputs to spend, all of them, if not empty
+	Strategy    string   // order in which the outputs are selected otherwise
+	Limit       uint64   // leave out outputs above (LimitType "max") or below ("min") Limit
+	LimitType   string
+	SubtractFee bool   // take the fee from the amounts, if the outputs cannot pay it on top
+	Priority    string // of the fee the daemon estimates, the minimum fee if empty
+}
+
+type coinInfo struct {
+	Frozen bool   `json:"frozen,omitempty"`
+	Label  string `json:"label,omitempty"`
+}
+
+// Coin is an unspent output of the wallet.
+type Coin struct {
+	Index    uint64 `json:"index"` // global index, which the coin control calls refer to
+	Amount   uint64 `json:"amount"`
+	TXID     string `json:"txid"`
+	Height   uint64 `json:"height"`
+	Unlocked bool   `json:"unlocked"` // mature and not in a pending transfer
+	Frozen   bool   `json:"frozen"`
+	Label    string `json:"label,omitempty"`
+}
+
+func checkStrategy(strategy string) error {
+	switch strategy {
+	case "", COIN_SELECT_RANDOM, COIN_SELECT_FEE, COIN_SELECT_CONSOLIDATE:
+		return nil
+	}
+	return fmt.Errorf("Unknown output selection strategy %s", strategy)
+}
+
+// Check checks cc before a transfer is built with it.
+func (cc *CoinControl) Check() error {
+	if len(cc.Outputs) > config.MAX_INPUT_SIZE {
+		return fmt.Errorf("At most %d outputs can be spent at once", config.MAX_INPUT_SIZE)
+	}
+	seen := make(map[uint64]bool, len(cc.Outputs))
+	for _, index := range cc.Outputs {
+		if seen[index] {
+			return fmt.Errorf("Output %d given twice", index)
+		}
+		seen[index] = true
+	}
+	if err := checkPriority(cc.Priority); err != nil {
+		return err
+	}
+	return checkStrategy(cc.Strategy)
+}
+
+// sortOutputs orders the shuffled txs by strategy.
+func sortOutputs(txs []*TXWalletData, strategy string) {
+	switch strategy {
+	case COIN_SELECT_FEE:
+		sort.SliceStable(txs, func(i, j int) bool { return txs[i].WAmount > txs[j].WAmount })
+	case COIN_SELECT_CONSOLIDATE:
+		sort.SliceStable(txs, func(i, j int) bool { return txs[i].WAmount < txs[j].WAmount })
+	}
+}
+
+// selectExplicitOutputs checks that the outputs of indices can all be spent now.
+func (w *Wallet) selectExplicitOutputs(indices []uint64) (selectedOutputIndex []uint64, sum uint64, err error) {
+	pendingKeyImage := w.getAllPendingKeyImage()
+	frozen := w.frozenOutputs()
+
+	txs := make([]*TXWalletData, 0, len(indices))
+	keyImages := make([]crypto.Key, 0, len(indices))
+	for _, index := range indices {
+		if frozen[index] {
+			return nil, 0, fmt.Errorf("Output %d is frozen", index)
+		}
+		tx, err := w.loadFundsData(index, FUNDS_BUCKET)
+		if err != nil {
+			return nil, 0, fmt.Errorf("Output %d is not in the wallet", index)
+		}
+		txs = append(txs, tx)
+		keyImages = append(keyImages, tx.WKimage)
+	}
+
+	keyImageSpent := w.IsKeyImageSpentBatch(keyImages)
+	for _, tx := range txs {
+		if keyImageSpent[tx.WKimage] || pendingKeyImage[tx.WKimage] {
+			return nil, 0, fmt.Errorf("Output %d is already spent", tx.TXdata.Index_Global)
+		}
+		if !inputmaturity.IsInputMature(w.Get_Height(), tx.TXdata.Height, tx.TXdata.Unlock_Height, tx.TXdata.SigType) {
+			return nil, 0, fmt.Errorf("Output %d is still locked", tx.TXdata.Index_Global)
+		}
+		selectedOutputIndex = append(selectedOutputIndex, tx.TXdata.Index_Global)
+		sum += tx.WAmount
+	}
+	return
+}
+
+// ListCoins returns the unspent outputs of the wallet, with their frozen state
+// and labels.
+func (w *Wallet) ListCoins() (coins []Coin) {
+	indexList := w.loadAllValuesFromBucket(BLOCKCHAIN_UNIVERSE, []byte(FUNDS_AVAILABLE))
+	pendingKeyImage := w.getAllPendingKeyImage()
+
+	txs := make([]*TXWalletData, 0, len(indexList))
+	keyImages := make([]crypto.Key, 0, len(indexList))
+	for i := range indexList {
+		currentIndex := binary.BigEndian.Uint64(indexList[i])
+		tx, err := w.loadFundsData(currentIndex, FUNDS_BUCKET)
+		if err != nil {
+			rlog.Warnf("Error while reading available funds index index %d err %s", currentIndex, err)
+			continue
+		}
+		txs = append(txs, tx)
+		keyImages = append(keyImages, tx.WKimage)
+	}
+
+	keyImageSpent := w.IsKeyImageSpentBatch(keyImages)
+	for _, tx := range txs {
+		if keyImageSpent[tx.WKimage] {
+			continue
+		}
+		info := w.coinInfo(tx.TXdata.Index_Global)
+		coins = append(coins, Coin{
+			Index:    tx.TXdata.Index_Global,
+			Amount:   tx.WAmount,
+			TXID:     tx.TXdata.TXID.String(),
+			Height:   tx.TXdata.Height,
+			Unlocked: inputmaturity.IsInputMature(w.Get_Height(), tx.TXdata.Height, tx.TXdata.Unlock_Height, tx.TXdata.SigType) && !pendingKeyImage[tx.WKimage],
+			Frozen:   info.Frozen,
+			Label:    info.Label,
+		})
+	}
+	sort.Slice(coins, func(i, j int) bool { return coins[i].Index < coins[j].Index })
+	return
+}
+
+// FreezeOutputs keeps the outputs of indices from being spent, until they are thawed.
+func (w *Wallet) FreezeOutputs(indices []uint64) error {
+	return w.updateCoinInfo(indices, func(info *coinInfo) { info.Frozen = true })
+}
+
+// ThawOutputs lets frozen outputs be spent again.
+func (w *Wallet) ThawOutputs(indices []uint64) error {
+	return w.updateCoinInfo(indices, func(info *coinInfo) { info.Frozen = false })
+}
+
+// LabelOutput labels the output of index, an empty label removes it.
+func (w *Wallet) LabelOutput(index uint64, label string) error {
+	return w.updateCoinInfo([]uint64{index}, func(info *coinInfo) { info.Label = label })
+}
+
+// ConsolidateDust sends the unlocked outputs below threshold back to the wallet,
+// as one output, the fee taken from it. At most config.MAX_INPUT_SIZE outputs,
+// the smallest first, are spent by a transfer, so it may need to run again.
+func (w *Wallet) ConsolidateDust(threshold uint64, mixin uint64, priority string) (tx *transaction.Transaction, inputs_selected []uint64, inputs_sum uint64, err error) {
+	dust, sum, err := w.selectDust(threshold)
+	if err != nil {
+		return
+	}
+
+	cc := &CoinControl{Outputs: dust, SubtractFee: true, Priority: priority}
+	tx, inputs_selected, inputs_sum, _, err = w.TransferCoinControl([]address.Address{w.GetAddress()}, []uint64{sum}, 0, "", 0, mixin, nil, cc)
+	return
+}
+
+// selectDust selects the outputs ConsolidateDust spends at once.
+func (w *Wallet) selectDust(threshold uint64) (dust []uint64, sum uint64, err error) {
+	if threshold == 0 {
+		err = fmt.Errorf("Dust threshold cannot be 0")
+		return
+	}
+	dust, sum = w.selectOutputsByStrategy(0, 0, true, threshold, "max", false, COIN_SELECT_CONSOLIDATE)
+	if len(dust) > config.MAX_INPUT_SIZE {
+		dust = dust[:config.MAX_INPUT_SIZE]
+		sum = 0
+		for _, index := range dust {
+			txw, err := w.loadFundsData(index, FUNDS_BUCKET)
+			if err != nil {
+				return nil, 0, err
+			}
+			sum += txw.WAmount
+		}
+	}
+	if len(dust) < 2 {
+		err = fmt.Errorf("No dust to consolidate below %s", globals.FormatMoney(threshold))
+	}
+	return
+}
+
+// GetSpendableBalance returns the unlocked balance less the frozen outputs, which
+// GetBalance counts as unlocked, and the sum of the frozen outputs left out.
+func (w *Wallet) GetSpendableBalance() (spendable uint64, frozen uint64) {
+	unlocked, _ := w.GetBalance()
+	frozen = w.frozenBalance()
+	if frozen > unlocked {
+		frozen = unlocked
+	}
+	return unlocked - frozen, frozen
+}
+
+// frozenBalance sums the frozen outputs which could be spent now if thawed.
+func (w *Wallet) frozenBalance() (sum uint64) {
+	frozen := w.frozenOutputs()
+	if len(frozen) == 0 {
+		return 0
+	}
+	pendingKeyImage := w.getAllPendingKeyImage()
+
+	txs := make([]*TXWalletData, 0, len(frozen))
+	keyImages := make([]crypto.Key, 0, len(frozen))
+	for index := range frozen {
+		tx, err := w.loadFundsData(index, FUNDS_BUCKET)
+		if err != nil {
+			continue
+		}
+		txs = append(txs, tx)
+		keyImages = append(keyImages, tx.WKimage)
+	}
+
+	keyImageSpent := w.IsKeyImageSpentBatch(keyImages)
+	for _, tx := range txs {
+		if keyImageSpent[tx.WKimage] || pendingKeyImage[tx.WKimage] {
+			continue
+		}
+		if inputmaturity.IsInputMature(w.Get_Height(), tx.TXdata.Height, tx.TXdata.Unlock_Height, tx.TXdata.SigType) {
+			sum += tx.WAmount
+		}
+	}
+	return
+}
+
+// insufficientBalance is the error of a transfer needing more than spendable,
+// telling whether thawing the frozen outputs would cover it.
+func insufficientBalance(spendable, frozen uint64) error {
+	if frozen > 0 {
+		return fmt.Errorf("Insufficient unlocked balance: %s, funds frozen: %s", globals.FormatMoney(spendable), globals.FormatMoney(frozen))
+	}
+	return fmt.Errorf("Insufficient unlocked balance: %s", globals.FormatMoney(spendable))
+}
+
+// frozenOutputs returns the global indices of the frozen outputs.
+func (w *Wallet) frozenOutputs() map[uint64]bool {
+	frozen := make(map[uint64]bool)
+	for _, data := range w.loadAllValuesFromBucket(BLOCKCHAIN_UNIVERSE, []byte(COIN_CONTROL_BUCKET)) {
+		var info struct {
+			coinInfo
+			Index uint64 `json:"index"`
+		}
+		if err := json.Unmarshal(data, &info); err == nil && info.Frozen {
+			frozen[info.Index] = true
+		}
+	}
+	return frozen
+}
+
+func (w *Wallet) coinInfo(index uint64) (info coinInfo) {
+	data, err := w.loadKeyValue(BLOCKCHAIN_UNIVERSE, []byte(COIN_CONTROL_BUCKET), itob(index))
+	if err == nil && len(data) > 0 {
+		json.Unmarshal(data, &info)
+	}
+	return
+}
+
+func (w *Wallet) updateCoinInfo(indices []uint64, update func(*coinInfo)) error {
+	for _, index := range indices {
+		if _, err := w.loadFundsData(index, FUNDS_BUCKET); err != nil {
+			return fmt.Errorf("Output %d is not in the wallet", index)
+		}
+	}
+	for _, index := range indices {
+		info := w.coinInfo(index)
+		update(&info)
+
+		// the index is stored along, as only the values of the bucket are listed
+		data, err := json.Marshal(struct {
+			coinInfo
+			Index uint64 `json:"index"`
+		}{info, index})
+		if err != nil {
+			return err
+		}
+		w.storeKeyValue(BLOCKCHAIN_UNIVERSE, []byte(COIN_CONTROL_BUCKET), itob(index), data)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+package walletapi
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/vmihailenco/msgpack"
+
+	"github.com/darmaproject/darmasuite/config"
+	"github.com/darmaproject/darmasuite/crypto"
+)
+
+const testCoinHeight = 1000 // of the wallet, the outputs below it are unlocked
+
+// newTestCoinWallet returns a wallet at testCoinHeight owning unlocked outputs
+// of amounts, at global indices 1, 2...
+func newTestCoinWallet(t *testing.T, amounts ...uint64) *Wallet {
+	w := newTestWallet(t)
+	w.account.Height = testCoinHeight
+	for i, amount := range amounts {
+		addTestOutput(t, w, uint64(i+1), amount, 1)
+	}
+	return w
+}
+
+// addTestOutput stores an unspent output of amount received at height.
+func addTestOutput(t *testing.T, w *Wallet, index, amount, height uint64) {
+	var txw TXWalletData
+	txw.TXdata.Index_Global = index
+	txw.TXdata.Height = height
+	txw.WAmount = amount
+	keyImage, _ := crypto.NewKeyPair()
+	txw.WKimage = *keyImage
+
+	data, err := msgpack.Marshal(&txw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.storeKeyValue(BLOCKCHAIN_UNIVERSE, []byte(FUNDS_BUCKET), itob(index), data)
+	w.storeKeyValue(BLOCKCHAIN_UNIVERSE, []byte(FUNDS_AVAILABLE), itob(index), itob(index))
+}
+
+func sortedIndices(indices []uint64) []uint64 {
+	indices = append([]uint64(nil), indices...)
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	return indices
+}
+
+func TestFreezeOutputs(t *testing.T) {
+	w := newTestCoinWallet(t, 10, 20, 30, 40)
+
+	if err := w.FreezeOutputs([]uint64{2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.LabelOutput(2, "savings"); err != nil {
+		t.Fatal(err)
+	}
+	if frozen := w.frozenBalance(); frozen != 50 {
+		t.Fatalf("frozen balance %d, want 50", frozen)
+	}
+	for _, coin := range w.ListCoins() {
+		if coin.Frozen != (coin.Index == 2 || coin.Index == 3) || !coin.Unlocked {
+			t.Fatalf("coin %+v", coin)
+		}
+		if (coin.Label == "savings") != (coin.Index == 2) {
+			t.Fatalf("coin %d labelled %q", coin.Index, coin.Label)
+		}
+	}
+	if selected, sum := w.selectOutputsByStrategy(0, 0, true, 0, "", false, COIN_SELECT_RANDOM); sum != 50 || !reflect.DeepEqual(sortedIndices(selected), []uint64{1, 4}) {
+		t.Fatalf("outputs %v of %d selected with 2 and 3 frozen", selected, sum)
+	}
+
+	// thawing keeps the label
+	if err := w.ThawOutputs([]uint64{2}); err != nil {
+		t.Fatal(err)
+	}
+	if frozen := w.frozenBalance(); frozen != 30 {
+		t.Fatalf("frozen balance %d after thawing, want 30", frozen)
+	}
+	if info := w.coinInfo(2); info.Frozen || info.Label != "savings" {
+		t.Fatalf("thawed output %+v", info)
+	}
+
+	if err := w.FreezeOutputs([]uint64{4, 99}); err == nil || !strings.Contains(err.Error(), "not in the wallet") {
+		t.Fatalf("unknown output frozen, err %v", err)
+	}
+	if w.coinInfo(4).Frozen {
+		t.Fatalf("output frozen along with an unknown output")
+	}
+
+	// frozen outputs still locked are not spendable anyway
+	addTestOutput(t, w, 5, 50, testCoinHeight)
+	if err := w.FreezeOutputs([]uint64{5}); err != nil {
+		t.Fatal(err)
+	}
+	if frozen := w.frozenBalance(); frozen != 30 {
+		t.Fatalf("frozen balance %d with a locked output frozen, want 30", frozen)
+	}
+}
+
+func TestInsufficientBalance(t *testing.T) {
+	if err := insufficientBalance(1, 0); strings.Contains(err.Error(), "frozen") {
+		t.Fatalf("nothing frozen, err %v", err)
+	}
+	if err := insufficientBalance(1, 2); !strings.Contains(err.Error(), "funds frozen") {
+		t.Fatalf("funds frozen, err %v", err)
+	}
+}
+
+func TestSelectExplicitOutputs(t *testing.T) {
+	w := newTestCoinWallet(t, 10, 20, 30)
+	addTestOutput(t, w, 4, 40, testCoinHeight)
+	if err := w.FreezeOutputs([]uint64{3}); err != nil {
+		t.Fatal(err)
+	}
+
+	selected, sum, err := w.selectExplicitOutputs([]uint64{2, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != 30 || !reflect.DeepEqual(selected, []uint64{2, 1}) {
+		t.Fatalf("outputs %v of %d selected", selected, sum)
+	}
+
+	for _, test := range []struct {
+		outputs []uint64
+		err     string
+	}{
+		{[]uint64{1, 3}, "frozen"},
+		{[]uint64{1, 99}, "not in the wallet"},
+		{[]uint64{4}, "still locked"},
+	} {
+		if _, _, err := w.selectExplicitOutputs(test.outputs); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("outputs %v selected, err %v", test.outputs, err)
+		}
+	}
+
+	if err := (&CoinControl{Outputs: []uint64{1, 2, 1}}).Check(); err == nil || !strings.Contains(err.Error(), "given twice") {
+		t.Errorf("output given twice, err %v", err)
+	}
+	if err := (&CoinControl{Strategy: "oldest"}).Check(); err == nil {
+		t.Errorf("unknown strategy checked")
+	}
+}
+
+func TestSelectOutputsByStrategy(t *testing.T) {
+	w := newTestCoinWallet(t, 30, 10, 40, 20)
+
+	for _, test := range []struct {
+		strategy string
+		want     []uint64
+	}{
+		{COIN_SELECT_FEE, []uint64{3, 1}},            // 40 + 30
+		{COIN_SELECT_CONSOLIDATE, []uint64{2, 4, 1}}, // 10 + 20 + 30
+	} {
+		selected, sum := w.selectOutputsByStrategy(45, 0, false, 0, "", false, test.strategy)
+		if !reflect.DeepEqual(selected, test.want) || sum <= 45 {
+			t.Errorf("%s selects outputs %v of %d, want %v", test.strategy, selected, sum, test.want)
+		}
+	}
+
+	selected, sum := w.selectOutputsByStrategy(45, 0, false, 0, "", false, COIN_SELECT_RANDOM)
+	if sum <= 45 || len(selected) < 2 {
+		t.Errorf("random selects outputs %v of %d", selected, sum)
+	}
+	// limits leave out the outputs above or below them
+	if selected, sum = w.selectOutputsByStrategy(0, 0, true, 20, "max", false, COIN_SELECT_CONSOLIDATE); sum != 30 || !reflect.DeepEqual(selected, []uint64{2, 4}) {
+		t.Errorf("outputs %v of %d up to 20 selected", selected, sum)
+	}
+}
+
+func TestSelectDust(t *testing.T) {
+	w := newTestCoinWallet(t)
+	n := config.MAX_INPUT_SIZE + 5
+	for i := 1; i <= n; i++ {
+		addTestOutput(t, w, uint64(i), uint64(n+1-i), 1) // the last outputs are the smallest
+	}
+	addTestOutput(t, w, uint64(n+1), 1000, 1)
+
+	dust, sum, err := w.selectDust(uint64(n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dust) != config.MAX_INPUT_SIZE {
+		t.Fatalf("%d outputs of dust selected, at most %d can be spent", len(dust), config.MAX_INPUT_SIZE)
+	}
+	var want uint64
+	for i, index := range dust {
+		if index != uint64(n-i) {
+			t.Fatalf("output %d selected as dust, not the smallest", index)
+		}
+		want += uint64(i + 1)
+	}
+	if sum != want {
+		t.Fatalf("dust sums to %d, want %d", sum, want)
+	}
+
+	if _, _, err = w.selectDust(0); err == nil {
+		t.Fatalf("dust below 0 selected")
+	}
+	if _, _, err = w.selectDust(1); err == nil || !strings.Contains(err.Error(), "No dust") {
+		t.Fatalf("single output of 1 consolidated, err %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+package walletapi
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+	"github.com/ybbus/jsonrpc"
+
+	"github.com/darmaproject/darmasuite/address"
+	"github.com/darmaproject/darmasuite/config"
+	"github.com/darmaproject/darmasuite/dvm/accounts/abi"
+	"github.com/darmaproject/darmasuite/dvm/common"
+	"github.com/darmaproject/darmasuite/dvm/common/hexutil"
+	"github.com/darmaproject/darmasuite/structures"
+	"github.com/darmaproject/darmasuite/transaction"
+)
+
+// ContractABIs holds the ABIs registered for contracts, so they can be called
+// by method name without passing the ABI every time. They are kept in a JSON
+// file if one is given.
+type ContractABIs struct {
+	sync.RWMutex
+	file string
+	abis map[string]json.RawMessage // by contract address
+}
+
+// NewContractABIs loads the ABIs registered in file, which may not exist yet.
+// An empty file keeps them in memory only.
+func NewContractABIs(file string) (*ContractABIs, error) {
+	c := &ContractABIs{file: file, abis: make(map[string]json.RawMessage)}
+	if file == "" {
+		return c, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &c.abis); err != nil {
+		return nil, fmt.Errorf("invalid contract abi file %s: %s", file, err)
+	}
+	return c, nil
+}
+
+// Register registers abiJSON for the contract at contractAddr, replacing the
+// one registered before.
+func (c *ContractABIs) Register(contractAddr string, abiJSON []byte) error {
+	addr, err := address.NewAddress(contractAddr)
+	if err != nil {
+		return fmt.Errorf("Contract address is invalid")
+	}
+	if _, err = parseABI(abiJSON); err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.abis[addr.String()] = append(json.RawMessage(nil), abiJSON...)
+	if c.file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(c.abis, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.file, data, 0600)
+}
+
+// Resolve returns abiJSON if given, or else the ABI registered for the
+// contract at contractAddr.
+func (c *ContractABIs) Resolve(contractAddr string, abiJSON []byte) (*abi.ABI, error) {
+	if len(abiJSON) > 0 && string(abiJSON) != "null" {
+		return parseABI(abiJSON)
+	}
+	addr, err := address.NewAddress(contractAddr)
+	if err != nil {
+		return nil, fmt.Errorf("No abi given and contract address is invalid")
+	}
+
+	c.RLock()
+	registered, ok := c.abis[addr.String()]
+	c.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("No abi registered for contract %s", contractAddr)
+	}
+	return parseABI(registered)
+}
+
+// parseABI parses an ABI given as a JSON array, or as a JSON string holding
+// one as some tools output it.
+func parseABI(abiJSON []byte) (*abi.ABI, error) {
+	var s string
+	if json.Unmarshal(abiJSON, &s) == nil {
+		abiJSON = []byte(s)
+	}
+	a, err := abi.JSON(strings.NewReader(string(abiJSON)))
+	if err != nil {
+		return nil, fmt.Errorf("Abi is invalid: %s", err)
+	}
+	return &a, nil
+}
+
+// ContractMethodCall is the call of a contract method by its name.
+type ContractMethodCall struct {
+	ABI      *abi.ABI
+	Contract string          // address of the contract, empty to create it
+	Code     []byte          // code of the contract to create
+	Method   string          // empty for the constructor
+	Args     json.RawMessage // JSON array of the arguments
+	Amount   uint64
+	Gas      uint64 // defaults to config.DEFAULT_GASLIMIT
+	GasPrice uint64 // defaults to config.DEFAULT_GASPRICE
+}
+
+// payload returns the code, if the contract is created, followed by the
+// packed arguments.
+func (call *ContractMethodCall) payload() ([]byte, error) {
+	if call.Contract != "" && call.Method == "" {
+		return nil, fmt.Errorf("Need method name")
+	}
+	if call.Contract == "" && len(call.Code) == 0 {
+		return nil, fmt.Errorf("Need contract code")
+	}
+	data, err := call.ABI.PackJSONArgs(call.Method, call.Args)
+	if err != nil {
+		return nil, err
+	}
+	if call.Contract != "" {
+		return data, nil
+	}
+	return append(append([]byte(nil), call.Code...), data...), nil
+}
+
+func (call *ContractMethodCall) gas() (gas, gasPrice uint64) {
+	gas, gasPrice = call.Gas, call.GasPrice
+	if gas == 0 {
+		gas = config.DEFAULT_GASLIMIT
+	}
+	if gasPrice == 0 {
+		gasPrice = config.DEFAULT_GASPRICE
+	}
+	return
+}
+
+// SendContractMethod builds the tx calling a contract method, or creating
+// the contract if call.Contract is empty, and sends it.
+func (w *Wallet) SendContractMethod(call *ContractMethodCall) (tx *transaction.Transaction, err error) {
+	code, err := call.payload()
+	if err != nil {
+		return nil, err
+	}
+	gas, gasPrice := call.gas()
+
+	tx, _, _, _, err = w.BuildContractTx(code, call.Amount, gas, gasPrice, call.Contract, call.Contract == "")
+	if err != nil {
+		return nil, err
+	}
+	if err = w.SendTransaction(tx); err != nil {
+		rlog.Warnf("Transaction sending failed txid = %s, err %s", tx.GetHash(), err)
+		return nil, err
+	}
+	return tx, nil
+}
+
+// CallContractMethod runs a contract method at topoHeight, the latest if 0,
+// without sending a tx, and returns its decoded outputs.
+func (w *Wallet) CallContractMethod(call *ContractMethodCall, topoHeight int64) ([]abi.Value, error) {
+	code, err := call.payload()
+	if err != nil {
+		return nil, err
+	}
+	if call.Contract == "" {
+		return nil, fmt.Errorf("Need contract address")
+	}
+
+	p := structures.CallContractParams{}
+	p.Data = fmt.Sprintf("0x%x", code)
+	p.Amount = call.Amount
+	p.Gas, p.GasPrice = call.gas()
+	p.From = w.GetAddress().String()
+	p.TopoHeight = topoHeight
+	p.To = call.Contract
+
+	result := w.CallContract(p)
+	if result == "" {
+		return nil, fmt.Errorf("No result")
+	}
+	output, err := hex.DecodeString(result)
+	if err != nil {
+		return nil, err
+	}
+	return call.ABI.DecodeOutputs(call.Method, output)
+}
+
+// ContractMethodResult is the outcome of a tx calling a contract method.
+type ContractMethodResult struct {
+	Status  uint64           `json:"status"`  // 1 if the call succeeded
+	Outputs []abi.Value      `json:"outputs"` // empty if the call failed or no method is given
+	Events  []abi.DecodedLog `json:"events"`  // the logs matching an event of the ABI
+}
+
+// GetContractMethodResult returns the outputs of method, and the events the
+// tx txid logged, decoded with a.
+func (w *Wallet) GetContractMethodResult(a *abi.ABI, method, txid string) (*ContractMethodResult, error) {
+	receipt, err := w.getContractReceipt(txid)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ContractMethodResult{Status: uint64(receipt.Status), Outputs: []abi.Value{}, Events: []abi.DecodedLog{}}
+	for _, log := range receipt.Logs {
+		decoded, err := a.DecodeLog(log.Topics, log.Data)
+		if err != nil {
+			// logs of the contracts it called may not be in this abi
+			rlog.Debugf("Skipping log of tx %s, err %s", txid, err)
+			continue
+		}
+		result.Events = append(result.Events, *decoded)
+	}
+
+	if method == "" || result.Status != 1 {
+		return result, nil
+	}
+	ret := w.GetContractResult(structures.GetContractResultParams{TXHash: txid})
+	output, err := hex.DecodeString(ret)
+	if err != nil {
+		return nil, err
+	}
+	if len(output) > 0 {
+		if result.Outputs, err = a.DecodeOutputs(method, output); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+type contractReceipt struct {
+	Status hexutil.Uint `json:"status"`
+	Logs   []struct {
+		Topics []common.Hash `json:"topics"`
+		Data   hexutil.Bytes `json:"data"`
+	} `json:"logs"`
+}
+
+// daemonClient returns a client of the json rpc of the daemon.
+func (w *Wallet) daemonClient() (jsonrpc.RPCClient, error) {
+	endpoint := w.DaemonEndpoint
+	if endpoint == "" {
+		return nil, fmt.Errorf("Daemon address is not specified")
+	}
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		endpoint = "http://" + endpoint
+	}
+
+	return jsonrpc.NewClientWithOpts(endpoint+"/json_rpc", &jsonrpc.RPCClientOpts{
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}), nil
+}
+
+// getContractReceipt asks the daemon for the receipt of the contract tx txid.
+func (w *Wallet) getContractReceipt(txid string) (*contractReceipt, error) {
+	client, err := w.daemonClient()
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Call("eth_getTransactionReceipt", "0x"+strings.TrimPrefix(txid, "0x"))
+	if err != nil {
+		return nil, err
+	}
+	if response.Error != nil {
+		return nil, fmt.Errorf("No receipt of tx %s: %s", txid, response.Error.Message)
+	}
+
+	var receipt contractReceipt
+	if err = response.GetObject(&receipt); err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
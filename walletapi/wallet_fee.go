@@ -0,0 +1,81 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+package walletapi
+
+import (
+	"fmt"
+
+	"github.com/romana/rlog"
+
+	"github.com/darmaproject/darmasuite/globals"
+	"github.com/darmaproject/darmasuite/structures"
+)
+
+func checkPriority(priority string) error {
+	switch priority {
+	case "", globals.FEE_PRIORITY_SLOW, globals.FEE_PRIORITY_NORMAL, globals.FEE_PRIORITY_FAST:
+		return nil
+	}
+	return fmt.Errorf("Unknown fee priority %s, it should be slow, normal or fast", priority)
+}
+
+// the estimates of the daemon are trusted up to this many times the minimum
+// fees per KB, which the offline signer accepts too
+const MAX_ESTIMATE_FEES_MULTIPLIER = MAX_OFFLINE_FEES_MULTIPLIER
+
+// checkEstimatedFeesPerKb returns the fees per KB the daemon estimated, within
+// minimum and MAX_ESTIMATE_FEES_MULTIPLIER times it. An estimate above the cap,
+// as under congestion or from a lying daemon, pays the cap, so a fast transfer
+// still pays more than the minimum.
+func checkEstimatedFeesPerKb(estimated, minimum uint64) uint64 {
+	if estimated < minimum {
+		rlog.Warnf("Estimated fees per KB %d are below the minimum %d, using the minimum", estimated, minimum)
+		return minimum
+	}
+	if max := MAX_ESTIMATE_FEES_MULTIPLIER * minimum; estimated > max {
+		rlog.Warnf("Estimated fees per KB %d are more than %d times the minimum %d, using %d", estimated, MAX_ESTIMATE_FEES_MULTIPLIER, minimum, max)
+		return max
+	}
+	return estimated
+}
+
+// EstimateFees asks the daemon for the fee per KB and the gas price of each priority.
+func (w *Wallet) EstimateFees() (*structures.EstimateFeeResult, error) {
+	client, err := w.daemonClient()
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Call("estimate_fee")
+	if err != nil {
+		return nil, err
+	}
+	if response.Error != nil {
+		return nil, fmt.Errorf("Daemon could not estimate fees: %s", response.Error.Message)
+	}
+
+	var result structures.EstimateFeeResult
+	if err = response.GetObject(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// FeeEstimate returns the estimate of the daemon for priority.
+func (w *Wallet) FeeEstimate(priority string) (*structures.EstimateFee, error) {
+	if priority == "" {
+		return nil, fmt.Errorf("Fee priority is not given")
+	}
+	if err := checkPriority(priority); err != nil {
+		return nil, err
+	}
+
+	result, err := w.EstimateFees()
+	if err != nil {
+		return nil, err
+	}
+	for i := range result.Estimates {
+		if result.Estimates[i].Priority == priority {
+			return &result.Estimates[i], nil
+		}
+	}
+	return nil, fmt.Errorf("Daemon has no estimate for %s priority", priority)
+}
@@ -0,0 +1,25 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+package walletapi
+
+import "testing"
+
+// TestCheckEstimatedFeesPerKb checks the estimates of a daemon are bound to the
+// minimum and MAX_ESTIMATE_FEES_MULTIPLIER times it.
+func TestCheckEstimatedFeesPerKb(t *testing.T) {
+	const minimum = 1000
+	for _, test := range []struct {
+		estimated, want uint64
+	}{
+		{0, minimum},
+		{minimum - 1, minimum},
+		{minimum, minimum},
+		{3 * minimum, 3 * minimum},
+		{MAX_ESTIMATE_FEES_MULTIPLIER * minimum, MAX_ESTIMATE_FEES_MULTIPLIER * minimum},
+		{MAX_ESTIMATE_FEES_MULTIPLIER*minimum + 1, MAX_ESTIMATE_FEES_MULTIPLIER * minimum},
+		{1 << 62, MAX_ESTIMATE_FEES_MULTIPLIER * minimum},
+	} {
+		if got := checkEstimatedFeesPerKb(test.estimated, minimum); got != test.want {
+			t.Errorf("estimate %d gives %d fees per KB, want %d", test.estimated, got, test.want)
+		}
+	}
+}
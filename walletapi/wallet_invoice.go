@@ -0,0 +1,185 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+package walletapi
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/darmaproject/darmasuite/globals"
+)
+
+// invoices are payment requests to integrated addresses of the wallet, each with
+// its own payment id, so their payments are found the way get_bulk_payments finds them
+const INVOICES_BUCKET = "INVOICES"
+
+const (
+	INVOICE_UNPAID  = "unpaid"
+	INVOICE_PARTIAL = "partial" // received less than the amount
+	INVOICE_PAID    = "paid"
+	INVOICE_EXPIRED = "expired" // expired before it was paid
+)
+
+type Invoice struct {
+	PaymentID string   `json:"payment_id"`
+	Address   string   `json:"address"` // integrated address to pay to
+	Amount    uint64   `json:"amount"`
+	Label     string   `json:"label,omitempty"`
+	Height    uint64   `json:"height"`  // of the wallet when the invoice was created
+	Created   int64    `json:"created"` // unix time
+	Expiry    int64    `json:"expiry,omitempty"`
+	URI       string   `json:"uri"`
+	Status    string   `json:"status"`
+	Received  uint64   `json:"received"`
+	PaidAt    uint64   `json:"paid_height,omitempty"` // height of the payment which completed the amount
+	TXIDs     []string `json:"txids,omitempty"`
+
+	// payments are only known by height, so the invoice expires at the height of the
+	// chain when the wallet first finds it expired, and payments after it are late
+	ExpiryHeight uint64   `json:"expiry_height,omitempty"`
+	Late         uint64   `json:"late,omitempty"` // received after the invoice expired
+	LateTXIDs    []string `json:"late_txids,omitempty"`
+}
+
+// CreateInvoice creates an invoice of amount, which expires after expiresIn if not 0.
+func (w *Wallet) CreateInvoice(amount uint64, label string, expiresIn time.Duration) (*Invoice, error) {
+	if amount == 0 {
+		return nil, fmt.Errorf("Invoice amount cannot be 0")
+	}
+
+	addr := w.GetRandomIAddress8()
+	now := time.Now()
+	invoice := &Invoice{
+		PaymentID: hex.EncodeToString(addr.PaymentID),
+		Address:   addr.String(),
+		Amount:    amount,
+		Label:     label,
+		Height:    w.Get_Height(),
+		Created:   now.Unix(),
+		Status:    INVOICE_UNPAID,
+	}
+	if expiresIn > 0 {
+		invoice.Expiry = now.Add(expiresIn).Unix()
+	}
+	request := globals.PaymentRequest{
+		Address: invoice.Address,
+		Amount:  globals.FormatURIAmount(amount),
+		Label:   label,
+		Expiry:  invoice.Expiry,
+	}
+	invoice.URI = request.URI()
+
+	if err := w.storeInvoice(invoice); err != nil {
+		return nil, err
+	}
+	rlog.Infof("Created invoice %s of %s", invoice.PaymentID, globals.FormatMoney(amount))
+	return invoice, nil
+}
+
+// GetInvoice returns the invoice of payment_id, with the payments received so far.
+func (w *Wallet) GetInvoice(payment_id string) (*Invoice, error) {
+	payid, err := hex.DecodeString(payment_id)
+	if err != nil {
+		return nil, fmt.Errorf("Payment ID must be hex")
+	}
+	data, err := w.loadKeyValue(BLOCKCHAIN_UNIVERSE, []byte(INVOICES_BUCKET), payid)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("Invoice %s not found", payment_id)
+	}
+
+	var invoice Invoice
+	if err = json.Unmarshal(data, &invoice); err != nil {
+		return nil, fmt.Errorf("Error while decoding invoice %s err %s", payment_id, err)
+	}
+	w.updateInvoice(&invoice)
+	return &invoice, nil
+}
+
+// GetInvoices returns the invoices of the wallet, newest first, with the payments
+// received so far.
+func (w *Wallet) GetInvoices() (invoices []Invoice) {
+	for _, data := range w.loadAllValuesFromBucket(BLOCKCHAIN_UNIVERSE, []byte(INVOICES_BUCKET)) {
+		var invoice Invoice
+		if err := json.Unmarshal(data, &invoice); err != nil {
+			rlog.Warnf("Error while decoding invoice err %s", err)
+			continue
+		}
+		w.updateInvoice(&invoice)
+		invoices = append(invoices, invoice)
+	}
+	sort.SliceStable(invoices, func(i, j int) bool {
+		return invoices[i].Created > invoices[j].Created
+	})
+	return
+}
+
+// updateInvoice sums the payments received for invoice before it expired, and
+// those received after it separately. Once paid, the invoice is stored as such,
+// so it stays paid when it expires.
+func (w *Wallet) updateInvoice(invoice *Invoice) {
+	if invoice.Status == INVOICE_PAID {
+		return
+	}
+	expired := invoice.Expiry != 0 && time.Now().Unix() > invoice.Expiry
+	if expired && invoice.ExpiryHeight == 0 {
+		height := w.Get_Daemon_Height()
+		if height == 0 { // without the height of the chain, no payment can be told on time
+			invoice.Status = INVOICE_EXPIRED
+			return
+		}
+		invoice.ExpiryHeight = height
+		if err := w.storeInvoice(invoice); err != nil {
+			rlog.Warnf("Error while storing invoice %s err %s", invoice.PaymentID, err)
+		}
+	}
+	payid, _ := hex.DecodeString(invoice.PaymentID)
+
+	invoice.Received, invoice.Late = 0, 0
+	invoice.TXIDs, invoice.LateTXIDs = invoice.TXIDs[:0], invoice.LateTXIDs[:0]
+	for _, entry := range w.Get_Payments_Payment_ID(payid, invoice.Height) {
+		if expired && entry.Height > invoice.ExpiryHeight {
+			invoice.Late += entry.Amount
+			invoice.LateTXIDs = append(invoice.LateTXIDs, entry.TXID.String())
+			continue
+		}
+		if invoice.Received >= invoice.Amount {
+			continue
+		}
+		invoice.Received += entry.Amount
+		invoice.TXIDs = append(invoice.TXIDs, entry.TXID.String())
+		if invoice.Received >= invoice.Amount {
+			invoice.PaidAt = entry.Height
+		}
+	}
+
+	switch {
+	case invoice.Received >= invoice.Amount:
+		invoice.Status = INVOICE_PAID
+		if err := w.storeInvoice(invoice); err != nil {
+			rlog.Warnf("Error while storing invoice %s err %s", invoice.PaymentID, err)
+		}
+	case expired:
+		invoice.Status = INVOICE_EXPIRED
+	case invoice.Received > 0:
+		invoice.Status = INVOICE_PARTIAL
+	default:
+		invoice.Status = INVOICE_UNPAID
+	}
+}
+
+func (w *Wallet) storeInvoice(invoice *Invoice) error {
+	payid, err := hex.DecodeString(invoice.PaymentID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(invoice)
+	if err != nil {
+		return err
+	}
+	w.storeKeyValue(BLOCKCHAIN_UNIVERSE, []byte(INVOICES_BUCKET), payid, data)
+	return nil
+}
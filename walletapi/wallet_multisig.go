@@ -0,0 +1,297 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+package walletapi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/romana/rlog"
+
+	"github.com/darmaproject/darmasuite/address"
+	"github.com/darmaproject/darmasuite/crypto"
+)
+
+// multisig wallets share an address with cosigners, each of them holding a share
+// of its spend key. The cosigners exchange their multisig info once: the shared
+// view key is the sum of their view keys, and the shared spend key the sum of
+// their spend keys, each weighted by a coefficient over all of them so that no
+// cosigner can choose its key to cancel the others. The key image of an output
+// is the sum of the weighted partial key images of all the cosigners and of the
+// part of its derivation, which any of them can add. Each partial key image
+// carries a proof that it uses the spend key of its cosigner.
+//
+// ringct cannot sign in rounds yet, so the outputs of a shared address could not
+// be spent: neither the wallet RPC nor the app wallet make multisig wallets until
+// it can, and only N-of-N wallets are made, as M-of-N needs more rounds.
+const MULTISIG_BUCKET = "MULTISIG"
+
+const MULTISIG_INFO_PREFIX = "MultisigV1"
+const MULTISIG_KEY_IMAGE_PREFIX = "MultisigKeyImageV1"
+
+var multisigStateKey = []byte("state")
+
+// Multisig is what a wallet keeps of the multisig wallet it is a cosigner of
+type Multisig struct {
+	Threshold   int          `json:"threshold"`
+	Signers     []crypto.Key `json:"signers"` // spend public keys of the cosigners, sorted
+	ViewSecret  crypto.Key   `json:"view_secret"`
+	SpendPublic crypto.Key   `json:"spend_public"`
+	Address     string       `json:"address"`
+}
+
+// MultisigOutput is an output received on the multisig address, as hex keys
+type MultisigOutput struct {
+	TxPublicKey   string `json:"tx_public_key"`
+	IndexWithinTx uint64 `json:"index_within_tx"`
+	OutputKey     string `json:"output_key"`
+}
+
+// multisigShares returns the view key and the spend key the wallet uses as a
+// cosigner, derived from its own keys so they need no backup of their own.
+func (w *Wallet) multisigShares() (view, spend crypto.Key) {
+	keys := w.Get_Keys()
+	view = *crypto.HashToScalar(append([]byte("multisig_view"), keys.Viewkey_Secret[:]...))
+	spend = *crypto.HashToScalar(append([]byte("multisig_spend"), keys.Spendkey_Secret[:]...))
+	return
+}
+
+// PrepareMultisig returns the multisig info of the wallet, to be given to the
+// other cosigners for MakeMultisig. It holds the view key of the wallet as a
+// cosigner, so it should only be shared with them.
+func (w *Wallet) PrepareMultisig() (string, error) {
+	if w.IsMultisig() {
+		return "", fmt.Errorf("Wallet is already multisig")
+	}
+	view, spend := w.multisigShares()
+	return MULTISIG_INFO_PREFIX + hex.EncodeToString(append(view[:], spend.PublicKey()[:]...)), nil
+}
+
+func parseMultisigInfo(info string) (view, spendPublic crypto.Key, err error) {
+	info = strings.TrimSpace(info)
+	if !strings.HasPrefix(info, MULTISIG_INFO_PREFIX) {
+		return view, spendPublic, fmt.Errorf("Multisig info should start with %s", MULTISIG_INFO_PREFIX)
+	}
+	data, err := hex.DecodeString(info[len(MULTISIG_INFO_PREFIX):])
+	if err != nil || len(data) != 64 {
+		return view, spendPublic, fmt.Errorf("Invalid multisig info %s", info)
+	}
+	copy(view[:], data[:32])
+	copy(spendPublic[:], data[32:])
+	return
+}
+
+// multisigCoefficient weights the spend key of signer in the shared spend key.
+func multisigCoefficient(signers []crypto.Key, signer crypto.Key) *crypto.Key {
+	data := []byte("multisig")
+	for i := range signers {
+		data = append(data, signers[i][:]...)
+	}
+	return crypto.HashToScalar(append(data, signer[:]...))
+}
+
+// MakeMultisig makes the wallet a cosigner of the threshold-of-N multisig wallet
+// of itself and of the cosigners whose multisig infos are given, and returns its
+// address. Every cosigner gets the same address from the infos of the others.
+func (w *Wallet) MakeMultisig(threshold int, infos []string) (string, error) {
+	if w.IsMultisig() {
+		return "", fmt.Errorf("Wallet is already multisig")
+	}
+	if len(infos) == 0 {
+		return "", fmt.Errorf("Multisig infos of the other cosigners are not given")
+	}
+	if threshold != len(infos)+1 {
+		return "", fmt.Errorf("Only N-of-N multisig wallets are supported, threshold should be %d", len(infos)+1)
+	}
+
+	view, spend := w.multisigShares()
+	ms := &Multisig{Threshold: threshold, ViewSecret: view, Signers: []crypto.Key{*spend.PublicKey()}}
+	for _, info := range infos {
+		v, k, err := parseMultisigInfo(info)
+		if err != nil {
+			return "", err
+		}
+		for i := range ms.Signers {
+			if ms.Signers[i] == k {
+				return "", fmt.Errorf("Multisig info of a cosigner is given twice, or is the info of this wallet")
+			}
+		}
+		ms.Signers = append(ms.Signers, k)
+		crypto.ScAdd(&ms.ViewSecret, &ms.ViewSecret, &v)
+	}
+	sort.Slice(ms.Signers, func(i, j int) bool { return bytes.Compare(ms.Signers[i][:], ms.Signers[j][:]) < 0 })
+
+	for i := range ms.Signers {
+		weighted := crypto.NewKeyByPoint(multisigCoefficient(ms.Signers, ms.Signers[i]), &ms.Signers[i])
+		if i == 0 {
+			ms.SpendPublic = *weighted
+		} else {
+			crypto.AddKeys(&ms.SpendPublic, &ms.SpendPublic, weighted)
+		}
+	}
+	ms.Address = address.NewAddressFromKeys(w.GetAddress().Network, ms.SpendPublic, *ms.ViewSecret.PublicKey()).String()
+
+	data, err := json.Marshal(ms)
+	if err != nil {
+		return "", err
+	}
+	w.storeKeyValue(BLOCKCHAIN_UNIVERSE, []byte(MULTISIG_BUCKET), multisigStateKey, data)
+	rlog.Infof("Wallet is a cosigner of %d-of-%d multisig address %s", ms.Threshold, len(ms.Signers), ms.Address)
+	return ms.Address, nil
+}
+
+// IsMultisig returns whether the wallet is a cosigner of a multisig wallet.
+func (w *Wallet) IsMultisig() bool {
+	_, err := w.GetMultisig()
+	return err == nil
+}
+
+// GetMultisig returns the multisig wallet the wallet is a cosigner of.
+func (w *Wallet) GetMultisig() (*Multisig, error) {
+	data, err := w.loadKeyValue(BLOCKCHAIN_UNIVERSE, []byte(MULTISIG_BUCKET), multisigStateKey)
+	if err != nil {
+		return nil, fmt.Errorf("Wallet is not multisig")
+	}
+	var ms Multisig
+	if err = json.Unmarshal(data, &ms); err != nil {
+		return nil, err
+	}
+	return &ms, nil
+}
+
+func parseHexKey(name, s string) (key crypto.Key, err error) {
+	data, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(data) != len(key) {
+		return key, fmt.Errorf("Invalid %s %s", name, s)
+	}
+	copy(key[:], data)
+	return key, nil
+}
+
+// multisigOutput checks o was received on the multisig address and returns its
+// key and the scalar of its derivation.
+func (ms *Multisig) multisigOutput(o MultisigOutput) (outputKey crypto.Key, derivationScalar crypto.Key, err error) {
+	txPublicKey, err := parseHexKey("tx public key", o.TxPublicKey)
+	if err != nil {
+		return
+	}
+	if outputKey, err = parseHexKey("output key", o.OutputKey); err != nil {
+		return
+	}
+	derivation := crypto.KeyDerivation(&txPublicKey, &ms.ViewSecret)
+	if derivation.KeyDerivationToPublicKey(o.IndexWithinTx, ms.SpendPublic) != outputKey {
+		err = fmt.Errorf("Output %s is not owned by the multisig address", o.OutputKey)
+		return
+	}
+	derivationScalar = *derivation.KeyDerivationToScalar(o.IndexWithinTx)
+	return
+}
+
+// partialKeyImageChallenge is the challenge of the proof that partial key image
+// ki of output key o and spend public key signer share their secret key, given
+// the commitments r1 (by G) and r2 (by the hash of o).
+func partialKeyImageChallenge(signer, o, ki, r1, r2 *crypto.Key) *crypto.Key {
+	data := []byte("multisig_key_image")
+	for _, k := range []*crypto.Key{signer, o, ki, r1, r2} {
+		data = append(data, k[:]...)
+	}
+	return crypto.HashToScalar(data)
+}
+
+// checkPartialKeyImage checks proof (c, s) that ki is the partial key image of
+// output key o by the secret key of signer.
+func checkPartialKeyImage(signer, o, ki, c, s crypto.Key) bool {
+	var r1, r2 crypto.Key
+	crypto.AddKeys2(&r1, &s, &c, &signer) // s*G + c*signer
+	sHash := crypto.GenerateKeyImage(o, s)
+	crypto.AddKeys(&r2, &sHash, crypto.NewKeyByPoint(&c, &ki)) // s*Hp(o) + c*ki
+	return *partialKeyImageChallenge(&signer, &o, &ki, &r1, &r2) == c
+}
+
+// ExportPartialKeyImage returns the partial key image of the wallet for output o
+// of the multisig address, with the proof that it uses the spend key of the
+// wallet, to be given to the cosigner combining the key image.
+func (w *Wallet) ExportPartialKeyImage(o MultisigOutput) (string, error) {
+	ms, err := w.GetMultisig()
+	if err != nil {
+		return "", err
+	}
+	outputKey, _, err := ms.multisigOutput(o)
+	if err != nil {
+		return "", err
+	}
+
+	_, spend := w.multisigShares()
+	signer := *spend.PublicKey()
+	ki := crypto.GenerateKeyImage(outputKey, spend)
+
+	nonce, r1 := crypto.NewKeyPair()
+	r2 := crypto.GenerateKeyImage(outputKey, *nonce)
+	c := partialKeyImageChallenge(&signer, &outputKey, &ki, r1, &r2)
+	var s crypto.Key
+	crypto.ScMulSub(&s, c, &spend, nonce) // nonce - c*spend
+
+	var data []byte
+	for _, k := range []*crypto.Key{&signer, &outputKey, &ki, c, &s} {
+		data = append(data, k[:]...)
+	}
+	return MULTISIG_KEY_IMAGE_PREFIX + hex.EncodeToString(data), nil
+}
+
+// CombineKeyImage returns the key image of output o of the multisig address from
+// the partial key images of all the cosigners, this wallet included.
+func (w *Wallet) CombineKeyImage(o MultisigOutput, partials []string) (string, error) {
+	ms, err := w.GetMultisig()
+	if err != nil {
+		return "", err
+	}
+	outputKey, derivationScalar, err := ms.multisigOutput(o)
+	if err != nil {
+		return "", err
+	}
+
+	keyImage := crypto.GenerateKeyImage(outputKey, derivationScalar)
+	seen := map[crypto.Key]bool{}
+	for _, p := range partials {
+		p = strings.TrimSpace(p)
+		if !strings.HasPrefix(p, MULTISIG_KEY_IMAGE_PREFIX) {
+			return "", fmt.Errorf("Partial key image should start with %s", MULTISIG_KEY_IMAGE_PREFIX)
+		}
+		data, err := hex.DecodeString(p[len(MULTISIG_KEY_IMAGE_PREFIX):])
+		if err != nil || len(data) != 160 {
+			return "", fmt.Errorf("Invalid partial key image %s", p)
+		}
+		var signer, key, partial, c, s crypto.Key
+		copy(signer[:], data[:32])
+		copy(key[:], data[32:64])
+		copy(partial[:], data[64:96])
+		copy(c[:], data[96:128])
+		copy(s[:], data[128:])
+
+		if key != outputKey {
+			return "", fmt.Errorf("Partial key image of cosigner %x is for another output", signer)
+		}
+		cosigner := false
+		for i := range ms.Signers {
+			cosigner = cosigner || ms.Signers[i] == signer
+		}
+		if !cosigner {
+			return "", fmt.Errorf("Partial key image of %x is not from a cosigner", signer)
+		}
+		if seen[signer] {
+			return "", fmt.Errorf("Partial key image of cosigner %x is given twice", signer)
+		}
+		if !checkPartialKeyImage(signer, key, partial, c, s) {
+			return "", fmt.Errorf("Partial key image of cosigner %x does not prove its spend key", signer)
+		}
+		seen[signer] = true
+		crypto.AddKeys(&keyImage, &keyImage, crypto.NewKeyByPoint(multisigCoefficient(ms.Signers, signer), &partial))
+	}
+	if len(seen) != len(ms.Signers) {
+		return "", fmt.Errorf("Partial key images of %d cosigners are given, all %d are needed", len(seen), len(ms.Signers))
+	}
+	return hex.EncodeToString(keyImage[:]), nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+package walletapi
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/darmaproject/darmasuite/crypto"
+)
+
+// newTestMultisig makes n wallets the cosigners of an n-of-n multisig wallet.
+func newTestMultisig(t *testing.T, n int) []*Wallet {
+	var wallets []*Wallet
+	var infos []string
+	for i := 0; i < n; i++ {
+		w := newTestWallet(t)
+		info, err := w.PrepareMultisig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		wallets = append(wallets, w)
+		infos = append(infos, info)
+	}
+
+	var shared string
+	for i, w := range wallets {
+		others := append(append([]string(nil), infos[:i]...), infos[i+1:]...)
+		addr, err := w.MakeMultisig(n, others)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 && addr != shared {
+			t.Fatalf("cosigner %d has address %s, cosigner 0 %s", i, addr, shared)
+		}
+		shared = addr
+	}
+	return wallets
+}
+
+// testMultisigOutput returns an output sent to the multisig address of ms.
+func testMultisigOutput(ms *Multisig) MultisigOutput {
+	txSecretKey, txPublicKey := crypto.NewKeyPair()
+	derivation := crypto.KeyDerivation(ms.ViewSecret.PublicKey(), txSecretKey)
+	outputKey := derivation.KeyDerivationToPublicKey(1, ms.SpendPublic)
+	return MultisigOutput{
+		TxPublicKey:   hex.EncodeToString(txPublicKey[:]),
+		IndexWithinTx: 1,
+		OutputKey:     hex.EncodeToString(outputKey[:]),
+	}
+}
+
+func TestMultisigKeyExchange(t *testing.T) {
+	wallets := newTestMultisig(t, 3)
+	ms, err := wallets[0].GetMultisig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms.Threshold != 3 || len(ms.Signers) != 3 || ms.Address == wallets[0].GetAddress().String() {
+		t.Fatalf("multisig wallet %+v", ms)
+	}
+	if _, err := wallets[0].PrepareMultisig(); err == nil {
+		t.Fatalf("multisig wallet prepared again")
+	}
+
+	// only N-of-N, with the infos of other cosigners, once each
+	w, other := newTestWallet(t), newTestWallet(t)
+	own, _ := w.PrepareMultisig()
+	info, _ := other.PrepareMultisig()
+	for _, test := range []struct {
+		threshold int
+		infos     []string
+		err       string
+	}{
+		{1, []string{info}, "N-of-N"},
+		{2, nil, "not given"},
+		{3, []string{info, info}, "given twice"},
+		{2, []string{own}, "given twice"},
+		{2, []string{"MultisigV1" + strings.Repeat("00", 63)}, "Invalid multisig info"},
+		{2, []string{strings.Repeat("00", 64)}, "should start with"},
+	} {
+		if _, err := w.MakeMultisig(test.threshold, test.infos); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%d-of-%d multisig made, err %v", test.threshold, len(test.infos)+1, err)
+		}
+	}
+	if w.IsMultisig() {
+		t.Fatalf("wallet is multisig after failing to make it")
+	}
+}
+
+// forgePartialKeyImage returns partial with the key image of other in it.
+func forgePartialKeyImage(t *testing.T, partial, other string) string {
+	data, err := hex.DecodeString(strings.TrimPrefix(partial, MULTISIG_KEY_IMAGE_PREFIX))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherData, err := hex.DecodeString(strings.TrimPrefix(other, MULTISIG_KEY_IMAGE_PREFIX))
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(data[64:96], otherData[64:96])
+	return MULTISIG_KEY_IMAGE_PREFIX + hex.EncodeToString(data)
+}
+
+func TestMultisigKeyImage(t *testing.T) {
+	wallets := newTestMultisig(t, 3)
+	ms, _ := wallets[0].GetMultisig()
+	o := testMultisigOutput(ms)
+
+	var partials []string
+	for _, w := range wallets {
+		partial, err := w.ExportPartialKeyImage(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		partials = append(partials, partial)
+	}
+
+	// any cosigner combines the same key image, whatever the order of the partials
+	keyImage, err := wallets[0].CombineKeyImage(o, partials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reversed := []string{partials[2], partials[1], partials[0]}
+	if other, err := wallets[2].CombineKeyImage(o, reversed); err != nil || other != keyImage {
+		t.Fatalf("cosigner 2 combines key image %s, cosigner 0 %s, err %v", other, keyImage, err)
+	}
+
+	// a cosigner giving the partial key image of another spend key under its own
+	forged := forgePartialKeyImage(t, partials[1], partials[2])
+
+	for _, test := range []struct {
+		partials []string
+		err      string
+	}{
+		{partials[:2], "all 3 are needed"},
+		{[]string{partials[0], partials[1], partials[1]}, "given twice"},
+		{[]string{partials[0], partials[1], "00"}, "should start with"},
+		{[]string{partials[0], forged, partials[2]}, "does not prove"},
+	} {
+		if _, err := wallets[0].CombineKeyImage(o, test.partials); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("key image combined, err %v", err)
+		}
+	}
+
+	// partials of another output, and outputs not sent to the multisig address
+	another := testMultisigOutput(ms)
+	partial, err := wallets[1].ExportPartialKeyImage(another)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wallets[0].CombineKeyImage(o, []string{partials[0], partial, partials[2]}); err == nil || !strings.Contains(err.Error(), "another output") {
+		t.Errorf("partial key image of another output combined, err %v", err)
+	}
+	foreign := o
+	foreign.IndexWithinTx = 2
+	if _, err := wallets[0].ExportPartialKeyImage(foreign); err == nil || !strings.Contains(err.Error(), "not owned") {
+		t.Errorf("partial key image of a foreign output exported, err %v", err)
+	}
+	if _, err := newTestWallet(t).ExportPartialKeyImage(o); err == nil {
+		t.Errorf("wallet which is not multisig exported a partial key image")
+	}
+}
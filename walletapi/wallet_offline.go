@@ -0,0 +1,524 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+package walletapi
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/romana/rlog"
+	"github.com/vmihailenco/msgpack"
+
+	"github.com/darmaproject/darmasuite/address"
+	"github.com/darmaproject/darmasuite/crypto"
+	"github.com/darmaproject/darmasuite/dvm/common"
+	"github.com/darmaproject/darmasuite/globals"
+	"github.com/darmaproject/darmasuite/ringct"
+	"github.com/darmaproject/darmasuite/structures"
+	"github.com/darmaproject/darmasuite/transaction"
+)
+
+// the offline signing workflow keeps the spend key on a machine which is never online.
+// A wallet holding the view key only (or a copy of the wallet) selects the inputs and
+// their ring members and exports them as an UnsignedTx. The wallet holding the spend key
+// signs it without talking to a daemon and exports a SignedTx, which the online wallet
+// broadcasts.
+
+const UNSIGNED_TX_VERSION = 1
+
+// the wallet signing offline refuses txs paying more than this many times the minimum
+// fees per KB, unless users give a higher limit, as the online wallet chose the fees
+const MAX_OFFLINE_FEES_MULTIPLIER = 4
+
+// UnsignedTx is a tx with its inputs, ring members and outputs chosen, which only
+// needs the spend key to be signed.
+type UnsignedTx struct {
+	Version      int                 `json:"version"`
+	Mainnet      bool                `json:"mainnet"`
+	Height       uint64              `json:"height"` // of the chain, selects the ring signature type
+	Inputs       []UnsignedInput     `json:"inputs"`
+	Destinations []Destination       `json:"destinations"`
+	PaymentID    string              `json:"payment_id,omitempty"` // hex, if not in an integrated address
+	UnlockTime   uint64              `json:"unlock_time"`
+	FeesPerKb    uint64              `json:"fees_per_kb"`
+	Fees         uint64              `json:"fees"`                    // estimated, the signer settles the exact fees
+	ContractData *transaction.SCData `json:"contract_data,omitempty"` // not signed yet
+}
+
+// UnsignedInput is an output of the wallet spent by an UnsignedTx, with its ring.
+type UnsignedInput struct {
+	IndexGlobal   uint64         `json:"index_global"`
+	Amount        uint64         `json:"amount"`
+	Mask          crypto.Key     `json:"mask"`          // secret of the amount commitment
+	TxPublicKey   crypto.Key     `json:"tx_public_key"` // of the tx which created the output
+	IndexWithinTx uint64         `json:"index_within_tx"`
+	RingMembers   []uint64       `json:"ring_members"` // sorted, the output is at Index
+	Pubs          []ringct.CtKey `json:"pubs"`
+	Index         int            `json:"index"`
+}
+
+type Destination struct {
+	Address string `json:"address"`
+	Amount  uint64 `json:"amount"`
+}
+
+// SignedTx is an UnsignedTx once signed, ready to be broadcast.
+type SignedTx struct {
+	TXHex   string                               `json:"tx"`
+	Inputs  []uint64                             `json:"inputs"` // index_global of the inputs, in the order of the tx
+	Details structures.Outgoing_Transfer_Details `json:"details"`
+}
+
+// UnsignedTxSummary is what an UnsignedTx pays, shown to users before they sign it.
+type UnsignedTxSummary struct {
+	Destinations []Destination `json:"destinations"`
+	PaymentID    string        `json:"payment_id,omitempty"`
+	UnlockTime   uint64        `json:"unlock_time"`
+	InputsSum    uint64        `json:"inputs_sum"`
+	Fees         uint64        `json:"fees"` // estimated, the exact fees are settled when signing
+	FeesPerKb    uint64        `json:"fees_per_kb"`
+	MaxFeesPerKb uint64        `json:"max_fees_per_kb"` // signed without a higher limit given up to this
+	Change       uint64        `json:"change"`
+
+	// of contract txs
+	Contract       string `json:"contract,omitempty"` // account called, empty if the contract is created
+	ContractAmount uint64 `json:"contract_amount,omitempty"`
+	MaxGasFees     uint64 `json:"max_gas_fees,omitempty"`
+}
+
+// ParseUnsignedTx parses an UnsignedTx as exported by BuildUnsignedTransfer.
+func ParseUnsignedTx(data []byte) (*UnsignedTx, error) {
+	var u UnsignedTx
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, fmt.Errorf("Unsigned tx could not be parsed: %s", err)
+	}
+	if u.Version != UNSIGNED_TX_VERSION {
+		return nil, fmt.Errorf("Unsigned tx version %d is not supported", u.Version)
+	}
+	return &u, nil
+}
+
+// ParseSignedTx parses a SignedTx as exported by SignUnsignedTx.
+func ParseSignedTx(data []byte) (*SignedTx, error) {
+	var s SignedTx
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("Signed tx could not be parsed: %s", err)
+	}
+	return &s, nil
+}
+
+// BuildUnsignedTransfer selects the inputs and ring members to send amount to addr,
+// like TransferV2, and returns them to be signed offline.
+func (w *Wallet) BuildUnsignedTransfer(addr []address.Address, amount []uint64, unlock_time uint64, payment_id_hex string, mixin uint64) (*UnsignedTx, error) {
+	return w.buildUnsignedTx(addr, amount, unlock_time, payment_id_hex, mixin, nil)
+}
+
+// BuildUnsignedContractTx is BuildContractTx with the tx returned to be signed offline.
+func (w *Wallet) BuildUnsignedContractTx(code []byte, amount, gas, gasPrice uint64, contractAddr string, isCreate bool) (*UnsignedTx, error) {
+	scdata, err := w.contractData(code, amount, gas, gasPrice, contractAddr, isCreate)
+	if err != nil {
+		return nil, err
+	}
+	return w.buildUnsignedTx(nil, nil, 0, "", 0, scdata)
+}
+
+// BuildUnsignedContractMethod is SendContractMethod with the tx returned to be signed
+// offline.
+func (w *Wallet) BuildUnsignedContractMethod(call *ContractMethodCall) (*UnsignedTx, error) {
+	code, err := call.payload()
+	if err != nil {
+		return nil, err
+	}
+	gas, gasPrice := call.gas()
+	return w.BuildUnsignedContractTx(code, call.Amount, gas, gasPrice, call.Contract, call.Contract == "")
+}
+
+func (w *Wallet) buildUnsignedTx(addr []address.Address, amount []uint64, unlock_time uint64, payment_id_hex string, mixin uint64, scdata *transaction.SCData) (*UnsignedTx, error) {
+	w.transferMutex.Lock()
+	defer w.transferMutex.Unlock()
+	if mixin == 0 {
+		mixin = uint64(w.account.Mixin) // use wallet mixin, if mixin not provided
+	}
+	if mixin < 5 { // enforce minimum mixin
+		mixin = 5
+	}
+
+	_, totalAmountRequired, err := checkDestinations(addr, amount, payment_id_hex, scdata != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	unlocked, frozen := w.GetSpendableBalance()
+	if totalAmountRequired > unlocked {
+		return nil, insufficientBalance(unlocked, frozen)
+	}
+
+	payload := 0
+	if scdata != nil {
+		payload = len(scdata.Payload)
+	}
+
+	// the fees are estimated from the size the tx will have, as it cannot be signed here
+	u := &UnsignedTx{
+		Version:      UNSIGNED_TX_VERSION,
+		Mainnet:      globals.IsMainnet(),
+		Height:       w.Get_Height(),
+		PaymentID:    payment_id_hex,
+		UnlockTime:   unlock_time,
+		FeesPerKb:    w.feesPerKb(""),
+		ContractData: scdata,
+	}
+	var inputs_selected []uint64
+	var inputs_sum uint64
+	for {
+		// the wallet signing offline holds the keys of the main address only
+		inputs_selected, inputs_sum = w.selectOutputs(totalAmountRequired, u.Fees, false, 0, "", false, COIN_SELECT_RANDOM, w.receivedOnSubAddress)
+		if inputs_sum == 0 {
+			return nil, fmt.Errorf("Reading available funds failed, please check your wallet or network")
+		}
+		if inputs_sum < totalAmountRequired+u.Fees {
+			return nil, fmt.Errorf("Insufficient unlocked balance(fee %s)", globals.FormatMoney(u.Fees))
+		}
+
+		size_in_kb := (estimateTxSize(len(inputs_selected), int(mixin), len(addr)+1, payload) + 1023) / 1024
+		needed_fee := w.getfees(size_in_kb * u.FeesPerKb)
+		if needed_fee <= u.Fees {
+			break
+		}
+		u.Fees = needed_fee
+	}
+
+	for i := range inputs_selected {
+		txw, err := w.loadFundsData(inputs_selected[i], FUNDS_BUCKET)
+		if err != nil {
+			return nil, fmt.Errorf("Error while reading available funds index( it was just selected ) index %d err %s", inputs_selected[i], err)
+		}
+
+		input := w.ringInput(txw, mixin)
+		u.Inputs = append(u.Inputs, UnsignedInput{
+			IndexGlobal:   input.Index_Global,
+			Amount:        input.Amount,
+			Mask:          input.Sk.Mask,
+			TxPublicKey:   txw.TXdata.Tx_Public_Key,
+			IndexWithinTx: txw.TXdata.Index_within_tx,
+			RingMembers:   input.RingMembers,
+			Pubs:          input.Pubs,
+			Index:         input.Index,
+		})
+	}
+	for i := range addr {
+		u.Destinations = append(u.Destinations, Destination{Address: addr[i].String(), Amount: amount[i]})
+	}
+
+	rlog.Infof("Built unsigned tx of %d inputs %s DMCH, estimated fees %s", len(u.Inputs), globals.FormatMoney(inputs_sum), globals.FormatMoney(u.Fees))
+	return u, nil
+}
+
+// receivedOnSubAddress tells whether txw was received on a sub address of the wallet,
+// rather than on its main address.
+func (w *Wallet) receivedOnSubAddress(txw *TXWalletData) bool {
+	keys := w.Get_Keys()
+	derivation := crypto.KeyDerivation(&txw.TXdata.Tx_Public_Key, &keys.Viewkey_Secret)
+	return derivation.KeyDerivationToPublicKey(txw.TXdata.Index_within_tx, keys.Spendkey_Public) != txw.TXdata.InKey.Destination
+}
+
+// estimateTxSize estimates, a bit above, the size of a tx with bulletproofs and clsag
+// ring signatures.
+func estimateTxSize(inputs, mixin, outputs, payload int) uint64 {
+	size := 1 + 6                              // version, unlock time
+	size += inputs * (1 + 6 + mixin*4 + 32)    // key offsets, key image
+	size += outputs * (6 + 1 + 64)             // amount, target, key and sub address key
+	size += 3 + 33 + 34 + 250 + payload        // extra: tx public key, payment id, contract data
+	size += 1 + 6 + outputs*(8+32)             // rct type, fees, encrypted amounts, commitments
+	size += inputs * (mixin*32 + 32 + 32 + 32) // clsag, pseudo output
+	rounds := 6                                // log2 of 64 bits
+	for n := 1; n < outputs; n <<= 1 {
+		rounds++
+	}
+	size += 32 * (2*rounds + 9) // the aggregated bulletproof
+	return uint64(size)
+}
+
+// maxOfflineFeesPerKb returns the highest fees per KB the wallet signs for, max_fees_per_kb
+// if users give it.
+func (w *Wallet) maxOfflineFeesPerKb(max_fees_per_kb uint64) uint64 {
+	if max_fees_per_kb != 0 {
+		return max_fees_per_kb
+	}
+	return MAX_OFFLINE_FEES_MULTIPLIER * w.feesPerKb("")
+}
+
+// checkUnsignedTx checks u can be signed by the wallet, and returns its destinations
+// and inputs, with the secret keys and key images derived, the online wallet may not
+// know them.
+func (w *Wallet) checkUnsignedTx(u *UnsignedTx) (addr []address.Address, amount []uint64, inputs []ringct.InputInfo, err error) {
+	if u.Version != UNSIGNED_TX_VERSION {
+		return nil, nil, nil, fmt.Errorf("Unsigned tx version %d is not supported", u.Version)
+	}
+	if u.Mainnet != globals.IsMainnet() {
+		return nil, nil, nil, fmt.Errorf("Unsigned tx has invalid DMCH network mainnet/testnet")
+	}
+	keys := w.Get_Keys()
+	if keys.Spendkey_Secret == (crypto.Key{}) {
+		return nil, nil, nil, fmt.Errorf("Wallet is view only, it cannot sign")
+	}
+
+	for _, d := range u.Destinations {
+		a, err := address.NewAddress(d.Address)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("Destination address %s is invalid", d.Address)
+		}
+		addr = append(addr, *a)
+		amount = append(amount, d.Amount)
+	}
+	if u.ContractData != nil && u.ContractData.Sender != w.GetAddress().ToContractAddress() {
+		return nil, nil, nil, fmt.Errorf("Contract data is not sent by this wallet")
+	}
+
+	for i, in := range u.Inputs {
+		if len(in.RingMembers) != len(in.Pubs) || in.Index < 0 || in.Index >= len(in.Pubs) || in.RingMembers[in.Index] != in.IndexGlobal {
+			return nil, nil, nil, fmt.Errorf("Ring of input %d is invalid", i)
+		}
+
+		derivation := crypto.KeyDerivation(&in.TxPublicKey, &keys.Viewkey_Secret)
+		var secret crypto.Key
+		crypto.ScAdd(&secret, derivation.KeyDerivationToScalar(in.IndexWithinTx), &keys.Spendkey_Secret)
+		if *secret.PublicKey() != in.Pubs[in.Index].Destination {
+			// outputs received on sub addresses are left out by BuildUnsigned*
+			return nil, nil, nil, fmt.Errorf("Input %d is not owned by the main address of this wallet", i)
+		}
+		// the amount and the mask come from the online wallet, what is described to
+		// users and signed must be what the output commits to
+		if inputCommitment(in.Amount, in.Mask) != in.Pubs[in.Index].Mask {
+			return nil, nil, nil, fmt.Errorf("Amount of input %d does not match its commitment", i)
+		}
+		keyImage := crypto.GenerateKeyImage(in.Pubs[in.Index].Destination, secret)
+
+		inputs = append(inputs, ringct.InputInfo{
+			Amount:       in.Amount,
+			Key_image:    crypto.Hash(keyImage),
+			Sk:           ringct.CtKey{Destination: secret, Mask: in.Mask},
+			Pubs:         in.Pubs,
+			Index:        in.Index,
+			Index_Global: in.IndexGlobal,
+			RingMembers:  in.RingMembers,
+		})
+	}
+	return
+}
+
+// inputCommitment returns the commitment to amount with mask, mask*G + amount*H.
+func inputCommitment(amount uint64, mask crypto.Key) (commitment crypto.Key) {
+	var amountKey crypto.Key
+	binary.LittleEndian.PutUint64(amountKey[:], amount)
+	crypto.AddKeys2(&commitment, &mask, &amountKey, &crypto.H)
+	return
+}
+
+// DescribeUnsignedTx returns what u pays, for users to check before they sign it.
+func (w *Wallet) DescribeUnsignedTx(u *UnsignedTx) (*UnsignedTxSummary, error) {
+	_, _, inputs, err := w.checkUnsignedTx(u)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &UnsignedTxSummary{
+		Destinations: u.Destinations,
+		PaymentID:    u.PaymentID,
+		UnlockTime:   u.UnlockTime,
+		Fees:         u.Fees,
+		FeesPerKb:    u.FeesPerKb,
+		MaxFeesPerKb: w.maxOfflineFeesPerKb(0),
+	}
+	for i := range inputs {
+		summary.InputsSum += inputs[i].Amount
+	}
+	spent := u.Fees
+	for _, d := range u.Destinations {
+		spent += d.Amount
+	}
+	if summary.InputsSum > spent {
+		summary.Change = summary.InputsSum - spent
+	}
+	if scdata := u.ContractData; scdata != nil {
+		if scdata.Recipient != (common.Address{}) {
+			summary.Contract = scdata.Recipient.Hex()
+		}
+		summary.ContractAmount = scdata.Amount
+		summary.MaxGasFees = scdata.GasLimit * scdata.Price
+	}
+	return summary, nil
+}
+
+// SignUnsignedTx signs u with the spend key of the wallet, which need not be online.
+// The exact fees are settled here, the change gets what the estimate left over.
+// Txs paying more than max_fees_per_kb are refused, if it is 0 the limit is
+// MAX_OFFLINE_FEES_MULTIPLIER times the minimum fees per KB.
+func (w *Wallet) SignUnsignedTx(u *UnsignedTx, max_fees_per_kb uint64) (*SignedTx, error) {
+	w.transferMutex.Lock()
+	defer w.transferMutex.Unlock()
+
+	addr, amount, inputs, err := w.checkUnsignedTx(u)
+	if err != nil {
+		return nil, err
+	}
+	paymentId, totalAmountRequired, err := checkDestinations(addr, amount, u.PaymentID, u.ContractData != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// the online wallet chose the inputs, the fees must not take what they leave over
+	if limit := w.maxOfflineFeesPerKb(max_fees_per_kb); u.FeesPerKb > limit {
+		return nil, fmt.Errorf("Unsigned tx pays %s fees per KB, above the limit of %s", globals.FormatMoney(u.FeesPerKb), globals.FormatMoney(limit))
+	}
+
+	var tx_extra *transaction.TxCreateExtra
+	isContract := false
+	if u.ContractData != nil {
+		scdata := *u.ContractData
+		w.signContractData(&scdata, u.Height)
+		tx_extra = &transaction.TxCreateExtra{ContractData: &scdata}
+		isContract = true
+	}
+
+	keys := w.Get_Keys()
+	var inputs_selected []uint64
+	inputs_sum := uint64(0)
+	for i := range inputs {
+		inputs_selected = append(inputs_selected, inputs[i].Index_Global)
+		inputs_sum += inputs[i].Amount
+	}
+
+	var tx *transaction.Transaction
+	var transfer_details structures.Outgoing_Transfer_Details
+	fees := u.Fees
+	for {
+		if inputs_sum < totalAmountRequired+fees {
+			return nil, fmt.Errorf("Inputs do not cover the fees %s, build the tx again", globals.FormatMoney(fees))
+		}
+
+		transfer_details.Fees = fees
+		transfer_details.Amount = transfer_details.Amount[:0]
+		transfer_details.Daddress = transfer_details.Daddress[:0]
+
+		var outputs []ringct.Output_info
+		for i := range addr {
+			var output ringct.Output_info
+			output.Amount = amount[i]
+			output.Public_Spend_Key = addr[i].SpendKey
+			output.Public_View_Key = addr[i].ViewKey
+			output.ExtraPublicKey = addr[i].IsSubAddress()
+
+			transfer_details.Amount = append(transfer_details.Amount, output.Amount)
+			transfer_details.Daddress = append(transfer_details.Daddress, addr[i].String())
+			outputs = append(outputs, output)
+		}
+
+		var change ringct.Output_info
+		change.Amount = inputs_sum - totalAmountRequired - fees
+		change.Public_Spend_Key = keys.Spendkey_Public
+		change.Public_View_Key = keys.Viewkey_Public
+		if change.Amount > 0 { // include change only if required
+			transfer_details.Amount = append(transfer_details.Amount, change.Amount)
+			transfer_details.Daddress = append(transfer_details.Daddress, w.GetAddress().String())
+			outputs = append(outputs, change)
+		}
+
+		// encrypted payment ids are encrypted against first output, do not shuffle them
+		if u.UnlockTime == 0 && len(paymentId) != 8 && !isContract {
+			globals.Global_Random.Shuffle(len(outputs), func(i, j int) {
+				outputs[i], outputs[j] = outputs[j], outputs[i]
+			})
+		}
+
+		tx = w.createTXv2(inputs, outputs, fees, u.UnlockTime, paymentId, true, tx_extra, u.Height)
+
+		tx_size := uint64(len(tx.Serialize()))
+		size_in_kb := tx_size / 1024
+		if (tx_size % 1024) != 0 { // for any part there of, use a full KB fee
+			size_in_kb += 1
+		}
+		needed_fee := w.getfees(size_in_kb * u.FeesPerKb)
+
+		rlog.Infof("required fees %s provided fee %s size %d fee/kb %s\n", globals.FormatMoney(needed_fee), globals.FormatMoney(fees), size_in_kb, globals.FormatMoney(u.FeesPerKb))
+		if fees == needed_fee {
+			break
+		}
+		fees = needed_fee
+	}
+
+	txhash := tx.GetHash()
+	transfer_details.SendAmount = totalAmountRequired
+	transfer_details.PaymentID = hex.EncodeToString(paymentId)
+	transfer_details.TXsecretkey = w.GetTXKey(txhash)
+	transfer_details.TXID = txhash.String()
+
+	// the offline wallet keeps the details as well, to display them again to users
+	details_serialized, err := json.Marshal(transfer_details)
+	if err != nil {
+		rlog.Warnf("Err marshalling details err %s", err)
+	}
+	w.storeKeyValue(BLOCKCHAIN_UNIVERSE, []byte(TX_OUT_DETAILS_BUCKET), txhash[:], details_serialized[:])
+
+	rlog.Infof("Signed tx %s of %d inputs, fees %s", txhash, len(inputs), globals.FormatMoney(fees))
+	return &SignedTx{
+		TXHex:   hex.EncodeToString(tx.Serialize()),
+		Inputs:  inputs_selected,
+		Details: transfer_details,
+	}, nil
+}
+
+// BroadcastSignedTx sends a tx signed offline to the daemon. The key images of its
+// inputs are kept, so a wallet holding the view key only sees them spent.
+func (w *Wallet) BroadcastSignedTx(s *SignedTx) (*transaction.Transaction, error) {
+	txBytes, err := hex.DecodeString(strings.TrimSpace(s.TXHex))
+	if err != nil {
+		return nil, fmt.Errorf("Transaction Could NOT be hex decoded: %s", err)
+	}
+	var tx transaction.Transaction
+	if err = tx.DeserializeHeader(txBytes); err != nil {
+		return nil, fmt.Errorf("Transaction Could NOT be deserialized: %s", err)
+	}
+	txhash := tx.GetHash()
+	if s.Details.TXID != "" && s.Details.TXID != txhash.String() {
+		return nil, fmt.Errorf("Transaction details are of tx %s, not %s", s.Details.TXID, txhash)
+	}
+	if len(s.Inputs) != len(tx.Vin) {
+		return nil, fmt.Errorf("Transaction has %d inputs, %d given", len(tx.Vin), len(s.Inputs))
+	}
+
+	if err = w.SendTransaction(&tx); err != nil {
+		rlog.Warnf("Transaction sending failed txid = %s, err %s", txhash, err)
+		return nil, err
+	}
+	rlog.Infof("Transaction sent successfully. txid = %s", txhash)
+
+	for i := range tx.Vin {
+		in, ok := tx.Vin[i].(transaction.TxinToKey)
+		if !ok {
+			continue
+		}
+		txw, err := w.loadFundsData(s.Inputs[i], FUNDS_BUCKET)
+		if err != nil {
+			rlog.Warnf("Input %d of tx %s is not in the wallet err %s", s.Inputs[i], txhash, err)
+			continue
+		}
+		txw.WKimage = crypto.Key(in.K_image)
+		serialized, err := msgpack.Marshal(txw)
+		if err != nil {
+			rlog.Warnf("Err marshalling funds index %d err %s", s.Inputs[i], err)
+			continue
+		}
+		w.storeKeyValue(BLOCKCHAIN_UNIVERSE, []byte(FUNDS_BUCKET), itob(s.Inputs[i]), serialized)
+	}
+
+	details_serialized, err := json.Marshal(s.Details)
+	if err != nil {
+		rlog.Warnf("Err marshalling details err %s", err)
+	}
+	w.storeKeyValue(BLOCKCHAIN_UNIVERSE, []byte(TX_OUT_DETAILS_BUCKET), txhash[:], details_serialized[:])
+
+	return &tx, nil
+}
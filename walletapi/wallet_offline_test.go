@@ -0,0 +1,239 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+package walletapi
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/darmaproject/darmasuite/crypto"
+	"github.com/darmaproject/darmasuite/globals"
+	"github.com/darmaproject/darmasuite/ringct"
+	"github.com/darmaproject/darmasuite/transaction"
+)
+
+func newTestWallet(t *testing.T) *Wallet {
+	dir, err := ioutil.TempDir("", "walletapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := CreateEncryptedWalletRandom(filepath.Join(dir, "test.db"), "")
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		w.Close_Encrypted_Wallet()
+		os.RemoveAll(dir)
+	})
+	return w
+}
+
+// testCommitment returns a random mask and the commitment to amount with it.
+func testCommitment(amount uint64) (mask, commitment crypto.Key) {
+	secret, _ := crypto.NewKeyPair()
+	mask = *secret
+	var amountKey crypto.Key
+	binary.LittleEndian.PutUint64(amountKey[:], amount)
+	crypto.AddKeys2(&commitment, &mask, &amountKey, &crypto.H)
+	return
+}
+
+// testInput returns an output of amount received by spendPublic, as an online
+// wallet knowing viewSecret would export it, with mixin-1 random ring members.
+func testInput(viewSecret, spendPublic crypto.Key, amount, indexGlobal uint64, mixin int) UnsignedInput {
+	_, txPublicKey := crypto.NewKeyPair()
+	derivation := crypto.KeyDerivation(txPublicKey, &viewSecret)
+	mask, commitment := testCommitment(amount)
+
+	in := UnsignedInput{
+		IndexGlobal:   indexGlobal,
+		Amount:        amount,
+		Mask:          mask,
+		TxPublicKey:   *txPublicKey,
+		IndexWithinTx: 1,
+		Index:         mixin / 2,
+	}
+	for i := 0; i < mixin; i++ {
+		member := indexGlobal - uint64(in.Index) + uint64(i)
+		if i == in.Index {
+			in.RingMembers = append(in.RingMembers, indexGlobal)
+			in.Pubs = append(in.Pubs, ringct.CtKey{Destination: derivation.KeyDerivationToPublicKey(in.IndexWithinTx, spendPublic), Mask: commitment})
+			continue
+		}
+		_, key := crypto.NewKeyPair()
+		_, other := testCommitment(amount)
+		in.RingMembers = append(in.RingMembers, member)
+		in.Pubs = append(in.Pubs, ringct.CtKey{Destination: *key, Mask: other})
+	}
+	return in
+}
+
+// testUnsignedTx returns a tx sending send to a random address, spending inputs of
+// amounts, all in the minimum fees per KB.
+func testUnsignedTx(t *testing.T, w *Wallet, send uint64, amounts ...uint64) *UnsignedTx {
+	to, err := Generate_Keys_From_Random()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := w.Get_Keys()
+	u := &UnsignedTx{
+		Version:      UNSIGNED_TX_VERSION,
+		Mainnet:      globals.IsMainnet(),
+		Height:       w.Get_Height(),
+		Destinations: []Destination{{Address: to.GetAddress().String(), Amount: send * w.feesPerKb("")}},
+		FeesPerKb:    w.feesPerKb(""),
+	}
+	for i, amount := range amounts {
+		u.Inputs = append(u.Inputs, testInput(keys.Viewkey_Secret, keys.Spendkey_Public, amount*u.FeesPerKb, uint64(100*(i+1)), 5))
+	}
+	return u
+}
+
+// TestOfflineSigningRoundTrip carries an unsigned tx to the signer and the signed
+// tx back as JSON, and checks the tx pays what was described.
+func TestOfflineSigningRoundTrip(t *testing.T) {
+	w := newTestWallet(t)
+	unit := w.feesPerKb("")
+	data, err := json.Marshal(testUnsignedTx(t, w, 100, 200, 300))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := ParseUnsignedTx(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary, err := w.DescribeUnsignedTx(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.InputsSum != 500*unit || len(summary.Destinations) != 1 || summary.Destinations[0].Amount != 100*unit {
+		t.Fatalf("summary %+v does not describe the tx", summary)
+	}
+	if summary.MaxFeesPerKb != MAX_OFFLINE_FEES_MULTIPLIER*w.feesPerKb("") {
+		t.Fatalf("summary limits the fees per KB to %d", summary.MaxFeesPerKb)
+	}
+
+	signed, err := w.SignUnsignedTx(u, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = json.Marshal(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ParseSignedTx(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txBytes, err := hex.DecodeString(s.TXHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tx transaction.Transaction
+	if err = tx.DeserializeHeader(txBytes); err != nil {
+		t.Fatal(err)
+	}
+	if tx.GetHash().String() != s.Details.TXID {
+		t.Fatalf("details are of tx %s, not %s", s.Details.TXID, tx.GetHash())
+	}
+	if len(tx.Vin) != 2 || len(s.Inputs) != 2 || s.Inputs[0] != 100 || s.Inputs[1] != 200 {
+		t.Fatalf("tx spends %d inputs %v", len(tx.Vin), s.Inputs)
+	}
+	for i := range tx.Vin {
+		in := tx.Vin[i].(transaction.TxinToKey)
+		if len(in.Key_offsets) != 5 {
+			t.Fatalf("input %d has a ring of %d", i, len(in.Key_offsets))
+		}
+	}
+
+	fees := tx.RctSignature.GetTXFee()
+	if fees != s.Details.Fees || fees == 0 {
+		t.Fatalf("tx pays fees %d, details say %d", fees, s.Details.Fees)
+	}
+	size_in_kb := (uint64(len(txBytes)) + 1023) / 1024
+	if fees != w.getfees(size_in_kb*u.FeesPerKb) {
+		t.Fatalf("tx of %d KB pays fees %d at %d per KB", size_in_kb, fees, u.FeesPerKb)
+	}
+	if len(tx.Vout) != 2 || s.Details.SendAmount != 100*unit {
+		t.Fatalf("tx has %d outputs sending %d", len(tx.Vout), s.Details.SendAmount)
+	}
+	var paid uint64
+	for _, amount := range s.Details.Amount {
+		paid += amount
+	}
+	if paid+fees != 500*unit {
+		t.Fatalf("outputs %d and fees %d do not spend the inputs", paid, fees)
+	}
+}
+
+// TestOfflineSigningFeeLimit checks the signer refuses the fees an online wallet
+// would take the inputs away with.
+func TestOfflineSigningFeeLimit(t *testing.T) {
+	w := newTestWallet(t)
+	u := testUnsignedTx(t, w, 100, 1000)
+	u.FeesPerKb = MAX_OFFLINE_FEES_MULTIPLIER*w.feesPerKb("") + 1
+
+	if _, err := w.SignUnsignedTx(u, 0); err == nil || !strings.Contains(err.Error(), "above the limit") {
+		t.Fatalf("tx paying %d fees per KB signed, err %v", u.FeesPerKb, err)
+	}
+	// unless users allow them
+	if _, err := w.SignUnsignedTx(u, u.FeesPerKb); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestOfflineSigningInputAmounts checks the signer refuses inputs whose amount
+// or mask do not match their commitment, as an online wallet could misreport.
+func TestOfflineSigningInputAmounts(t *testing.T) {
+	w := newTestWallet(t)
+	for _, tamper := range []func(in *UnsignedInput){
+		func(in *UnsignedInput) { in.Amount *= 2 },
+		func(in *UnsignedInput) { in.Mask[0] ^= 1 },
+	} {
+		u := testUnsignedTx(t, w, 100, 200, 300)
+		tamper(&u.Inputs[1])
+		if _, err := w.DescribeUnsignedTx(u); err == nil || !strings.Contains(err.Error(), "does not match its commitment") {
+			t.Fatalf("tampered input described, err %v", err)
+		}
+		if _, err := w.SignUnsignedTx(u, 0); err == nil {
+			t.Fatalf("tampered input signed")
+		}
+	}
+}
+
+// TestOfflineSigningForeignInputs checks inputs the main address does not own,
+// as outputs received on sub addresses, are refused by the signer and left
+// out when an unsigned tx is built.
+func TestOfflineSigningForeignInputs(t *testing.T) {
+	w := newTestWallet(t)
+	keys := w.Get_Keys()
+	_, otherSpend := crypto.NewKeyPair()
+
+	u := testUnsignedTx(t, w, 100, 300)
+	u.Inputs[0] = testInput(keys.Viewkey_Secret, *otherSpend, u.Inputs[0].Amount, 100, 5)
+	if _, err := w.SignUnsignedTx(u, 0); err == nil || !strings.Contains(err.Error(), "not owned") {
+		t.Fatalf("foreign input signed, err %v", err)
+	}
+
+	for _, test := range []struct {
+		spend crypto.Key
+		sub   bool
+	}{{keys.Spendkey_Public, false}, {*otherSpend, true}} {
+		in := testInput(keys.Viewkey_Secret, test.spend, 1000, 100, 1)
+		var txw TXWalletData
+		txw.TXdata.Tx_Public_Key = in.TxPublicKey
+		txw.TXdata.Index_within_tx = in.IndexWithinTx
+		txw.TXdata.InKey = in.Pubs[in.Index]
+		if sub := w.receivedOnSubAddress(&txw); sub != test.sub {
+			t.Fatalf("output received on sub address %t, want %t", sub, test.sub)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"math/rand"
 	"sort"
 
@@ -17,6 +18,7 @@ import (
 	"github.com/darmaproject/darmasuite/address"
 	"github.com/darmaproject/darmasuite/config"
 	"github.com/darmaproject/darmasuite/crypto"
+	"github.com/darmaproject/darmasuite/dvm/core"
 	"github.com/darmaproject/darmasuite/globals"
 	"github.com/darmaproject/darmasuite/inputmaturity"
 	"github.com/darmaproject/darmasuite/ringct"
@@ -95,6 +97,34 @@ func (w *Wallet) isRingPoS(r *RingMember) bool {
 	return false
 }
 
+// feesPerKb returns the fees per KB the daemon estimates for priority if one is
+// given, or else the fees per KB the network asks for, or the compiled in fees
+// if the wallet has not learnt them
+func (w *Wallet) feesPerKb(priority string) (fees_per_kb uint64) {
+	// if wallet is online,take the fees from the network itself
+	// otherwise use whatever user has provided
+	fees_per_kb = w.dynamicFeesPerKb
+
+	if fees_per_kb == 0 { // hard coded at compile time
+		if w.account.Height < uint64(globals.GetVotingStartHeight()) {
+			fees_per_kb = config.BEFORE_DPOS_FEE_PER_KB
+		} else {
+			fees_per_kb = config.FEE_PER_KB
+		}
+	}
+
+	if priority != "" {
+		estimate, err := w.FeeEstimate(priority)
+		if err == nil {
+			rlog.Infof("Fees per KB %d for %s priority\n", estimate.Fee_per_kb, priority)
+			return checkEstimatedFeesPerKb(estimate.Fee_per_kb, fees_per_kb)
+		}
+		rlog.Warnf("Fee estimate for %s priority failed, using the minimum err %s", priority, err)
+	}
+	rlog.Infof("Fees per KB %d\n", fees_per_kb)
+	return
+}
+
 func (w *Wallet) TotalOutput(limit uint64, limitType string, amount uint64) (selectedOutputIndex []uint64, sum uint64) {
 	return w.selectOutputsForTransfer(amount, 0, true, limit, limitType, false)
 }
@@ -122,8 +152,27 @@ func (w *Wallet) TransferInternal(
 	tx_extra *transaction.TxCreateExtra,
 	limit uint64,
 	limitType string) (tx *transaction.Transaction, inputs_selected []uint64, inputs_sum uint64, changeAmount uint64, err error) {
+	return w.TransferCoinControl(addr, amount, unlock_time, payment_id_hex, fees_per_kb, mixin, tx_extra, &CoinControl{Limit: limit, LimitType: limitType})
+}
+
+// send amount to specific addresses, spending the outputs chosen by cc
+func (w *Wallet) TransferCoinControl(
+	addr []address.Address,
+	amount []uint64,
+	unlock_time uint64,
+	payment_id_hex string,
+	fees_per_kb uint64,
+	mixin uint64,
+	tx_extra *transaction.TxCreateExtra,
+	cc *CoinControl) (tx *transaction.Transaction, inputs_selected []uint64, inputs_sum uint64, changeAmount uint64, err error) {
 	var transfer_details structures.Outgoing_Transfer_Details
 	isContract := false
+	if cc == nil {
+		cc = &CoinControl{}
+	}
+	if err = cc.Check(); err != nil {
+		return
+	}
 
 	w.transferMutex.Lock()
 	defer w.transferMutex.Unlock()
@@ -134,99 +183,31 @@ func (w *Wallet) TransferInternal(
 		mixin = 5
 	}
 
-	// if wallet is online,take the fees from the network itself
-	// otherwise use whatever user has provided
-	//if w.GetMode()  {
-	fees_per_kb = w.dynamicFeesPerKb // TODO disabled as protection while lots more testing is going on
-	rlog.Infof("Fees per KB %d\n", fees_per_kb)
-	//}
-
-	if fees_per_kb == 0 {
-		if w.account.Height < uint64(globals.GetVotingStartHeight()) {
-			fees_per_kb = config.BEFORE_DPOS_FEE_PER_KB
-		} else {
-			fees_per_kb = config.FEE_PER_KB
-		}
-	}
+	fees_per_kb = w.feesPerKb(cc.Priority)
 
 	var txw *TXWalletData
-	if len(addr) != len(amount) {
-		err = fmt.Errorf("Count of address and amounts mismatch")
-		return
-	}
-
 	if tx_extra != nil && tx_extra.ContractData != nil {
 		isContract = true
 	}
-	if isContract == false && len(addr) < 1 {
-		err = fmt.Errorf("Destination address missing")
-		return
-	}
 
 	var paymentId []byte // we later on find WHETHER to include it, encrypt it depending on length
-
-	// if payment  ID is provided explicity, use it
-	if payment_id_hex != "" {
-		paymentId, err = hex.DecodeString(payment_id_hex) // payment_id in hex
-		if err != nil {
-			return
-		}
-
-		if len(paymentId) == 32 || len(paymentId) == 8 {
-		} else {
-			err = fmt.Errorf("Payment ID must be atleast 64 hex chars (32 bytes) or 16 hex chars 8 byte")
-			return
-		}
-	}
-
-	// only only single payment id
-	for i := range addr {
-		if addr[i].IsIntegratedAddress() && payment_id_hex != "" {
-			err = fmt.Errorf("Payment ID provided in both integrated address and separately")
-			return
-		}
-	}
-
-	// if integrated address payment id present , normal payment id must not be provided
-	for i := range addr {
-		if !addr[i].IsDARMANetwork() {
-			err = fmt.Errorf("address provided is not a valid DMCH network address")
-			return
-		}
-		if addr[i].IsMainnet() != globals.IsMainnet() {
-			err = fmt.Errorf("address provided has invalid DMCH network mainnet/testnet")
-			return
-		}
-
-		if addr[i].IsIntegratedAddress() {
-			if len(paymentId) > 0 { // a transaction can have only single encrypted payment ID
-				err = fmt.Errorf("More than 1 integrated address provided")
-				return
-			}
-			paymentId = addr[i].PaymentID
-		}
+	var totalAmountRequired uint64
+	paymentId, totalAmountRequired, err = checkDestinations(addr, amount, payment_id_hex, isContract)
+	if err != nil {
+		return
 	}
 
 	fees := uint64(0) // start with zero fees
 	expectedFee := uint64(0)
-	totalAmountRequired := uint64(0)
 	diff := uint64(0)
 
-	for i := range amount {
-		if amount[i] == 0 { // cannot send 0  amount
-			err = fmt.Errorf("Sending 0 amount to destination NOT possible")
-			return
-		}
-		totalAmountRequired += amount[i]
-	}
-
 	// infinite tries to build a transaction
 	for {
 		// we need to make sure that account has sufficient unlocked balance ( to send amount ) + required amount of fees
-		unlocked, _ := w.GetBalance()
+		unlocked, frozen := w.GetSpendableBalance()
 
 		if totalAmountRequired > unlocked {
-			err = fmt.Errorf("Insufficient unlocked balance: %s", globals.FormatMoney(unlocked))
+			err = insufficientBalance(unlocked, frozen)
 			return
 		}
 
@@ -234,13 +215,21 @@ func (w *Wallet) TransferInternal(
 		//total_amount_required += fees
 		// select few outputs randomly
 
-		inputs_selected, inputs_sum = w.selectOutputsForTransfer(totalAmountRequired, fees+expectedFee, false, limit, limitType, w.Lightweight_mode)
+		if len(cc.Outputs) > 0 {
+			inputs_selected, inputs_sum, err = w.selectExplicitOutputs(cc.Outputs)
+			if err != nil {
+				return
+			}
+		} else {
+			inputs_selected, inputs_sum = w.selectOutputsByStrategy(totalAmountRequired, fees+expectedFee, false, cc.Limit, cc.LimitType, w.Lightweight_mode, cc.Strategy)
+		}
 		if inputs_sum == 0 {
 			err = fmt.Errorf("Reading available funds failed, please check your wallet or network")
 			return
 		}
 
-		if inputs_sum < (totalAmountRequired+fees) && (!w.Lightweight_mode || isContract) {
+		// the lightweight wallet, and consolidation, take what is missing from the amounts
+		if inputs_sum < (totalAmountRequired+fees) && (!(w.Lightweight_mode || cc.SubtractFee) || isContract) {
 			err = fmt.Errorf("Insufficient unlocked balance(fee %s)", globals.FormatMoney(fees))
 			return
 		}
@@ -257,29 +246,7 @@ func (w *Wallet) TransferInternal(
 			}
 
 			rlog.Infof("current input  %d %d \n", i, inputs_selected[i])
-			var currentInput ringct.InputInfo
-			currentInput.Amount = txw.WAmount
-			currentInput.Key_image = crypto.Hash(txw.WKimage)
-			currentInput.Sk = txw.WKey
-
-			currentInput.Index_Global = txw.TXdata.Index_Global
-
-			// add ring members here
-			// TODO force random ring members
-
-			//  mandatory add ourselves as ring member, otherwise there is no point in building the tx
-			currentInput.RingMembers = append(currentInput.RingMembers, currentInput.Index_Global)
-			currentInput.Pubs = append(currentInput.Pubs, txw.TXdata.InKey)
-
-			// add necessary amount  of random ring members
-			// TODO we need to make sure ring members are mature, otherwise tx will fail because o immature inputs
-			// This can cause certain TX to fail
-			w.selectRingMembers(&currentInput, mixin)
-
-			rlog.Infof(" current input before sorting %+v \n", currentInput.RingMembers)
-			currentInput = sortRingMembers(currentInput)
-			rlog.Infof(" current input after sorting  %+v \n", currentInput.RingMembers)
-			inputs = append(inputs, currentInput)
+			inputs = append(inputs, w.ringInput(txw, mixin))
 		}
 
 		// fill in the outputs
@@ -288,6 +255,10 @@ func (w *Wallet) TransferInternal(
 	rebuild_tx_with_correct_fee:
 		if inputs_sum < (totalAmountRequired + fees) {
 			diff = totalAmountRequired + fees - inputs_sum
+			if diff >= totalAmountRequired {
+				err = fmt.Errorf("Amount %s does not cover the fee %s", globals.FormatMoney(totalAmountRequired), globals.FormatMoney(fees))
+				return
+			}
 		}
 		outputs = outputs[:0]
 
@@ -402,6 +373,71 @@ func (w *Wallet) TransferInternal(
 	return
 }
 
+// checkDestinations checks the destinations of a transfer, and returns the
+// payment id to include and the total amount to send
+func checkDestinations(addr []address.Address, amount []uint64, payment_id_hex string, isContract bool) (paymentId []byte, totalAmountRequired uint64, err error) {
+	if len(addr) != len(amount) {
+		err = fmt.Errorf("Count of address and amounts mismatch")
+		return
+	}
+
+	if isContract == false && len(addr) < 1 {
+		err = fmt.Errorf("Destination address missing")
+		return
+	}
+
+	// if payment  ID is provided explicity, use it
+	if payment_id_hex != "" {
+		paymentId, err = hex.DecodeString(payment_id_hex) // payment_id in hex
+		if err != nil {
+			return
+		}
+
+		if len(paymentId) == 32 || len(paymentId) == 8 {
+		} else {
+			err = fmt.Errorf("Payment ID must be atleast 64 hex chars (32 bytes) or 16 hex chars 8 byte")
+			return
+		}
+	}
+
+	// only only single payment id
+	for i := range addr {
+		if addr[i].IsIntegratedAddress() && payment_id_hex != "" {
+			err = fmt.Errorf("Payment ID provided in both integrated address and separately")
+			return
+		}
+	}
+
+	// if integrated address payment id present , normal payment id must not be provided
+	for i := range addr {
+		if !addr[i].IsDARMANetwork() {
+			err = fmt.Errorf("address provided is not a valid DMCH network address")
+			return
+		}
+		if addr[i].IsMainnet() != globals.IsMainnet() {
+			err = fmt.Errorf("address provided has invalid DMCH network mainnet/testnet")
+			return
+		}
+
+		if addr[i].IsIntegratedAddress() {
+			if len(paymentId) > 0 { // a transaction can have only single encrypted payment ID
+				err = fmt.Errorf("More than 1 integrated address provided")
+				return
+			}
+			paymentId = addr[i].PaymentID
+		}
+	}
+
+	for i := range amount {
+		if amount[i] == 0 { // cannot send 0  amount
+			err = fmt.Errorf("Sending 0 amount to destination NOT possible")
+			return
+		}
+		totalAmountRequired += amount[i]
+	}
+	return
+}
+
 // send all unlocked balance amount to specific address
 func (w *Wallet) TransferEverything(addr address.Address, payment_id_hex string, unlock_time uint64, fees_per_kb uint64, mixin uint64) (tx *transaction.Transaction, inputs_selected []uint64, inputsSum uint64, err error) {
 	var transferDetails structures.Outgoing_Transfer_Details
@@ -413,18 +449,7 @@ func (w *Wallet) TransferEverything(addr address.Address, payment_id_hex string,
 		mixin = 5
 	}
 
-	// if wallet is online,take the fees from the network itself
-	// otherwise use whatever user has provided
-	fees_per_kb = w.dynamicFeesPerKb // TODO disabled as protection while lots more testing is going on
-	rlog.Infof("Fees per KB %d\n", fees_per_kb)
-
-	if fees_per_kb == 0 { // hard coded at compile time
-		if w.account.Height < uint64(globals.GetVotingStartHeight()) {
-			fees_per_kb = config.BEFORE_DPOS_FEE_PER_KB
-		} else {
-			fees_per_kb = config.FEE_PER_KB
-		}
-	}
+	fees_per_kb = w.feesPerKb("")
 
 	var txw *TXWalletData
 
@@ -591,20 +616,7 @@ func (w *Wallet) TransferLocked(addr address.Address, amount uint64, payment_id_
 		mixin = 5
 	}
 
-	// if wallet is online,take the fees from the network itself
-	// otherwise use whatever user has provided
-	//if w.GetMode()  {
-	fees_per_kb = w.dynamicFeesPerKb // TODO disabled as protection while lots more testing is going on
-	rlog.Infof("Fees per KB %d\n", fees_per_kb)
-	//}
-
-	if fees_per_kb == 0 {
-		if w.account.Height < uint64(globals.GetVotingStartHeight()) {
-			fees_per_kb = config.BEFORE_DPOS_FEE_PER_KB
-		} else {
-			fees_per_kb = config.FEE_PER_KB
-		}
-	}
+	fees_per_kb = w.feesPerKb("")
 
 	if tx_extra == nil {
 		err = fmt.Errorf("Lock type must be specified.")
@@ -666,9 +678,9 @@ func (w *Wallet) TransferLocked(addr address.Address, amount uint64, payment_id_
 	for {
 		if tx_extra.LockedType == transaction.LOCKEDTYPE_LOCKED {
 			// we need to make sure that account has sufficient unlocked balance ( to send amount ) + required amount of fees
-			unlocked, _ := w.GetBalance()
+			unlocked, frozen := w.GetSpendableBalance()
 			if totalAmountRequired >= unlocked {
-				err = fmt.Errorf("Insufficient unlocked balance %s", globals.FormatMoney(unlocked))
+				err = insufficientBalance(unlocked, frozen)
 				return
 			}
 
@@ -708,29 +720,7 @@ func (w *Wallet) TransferLocked(addr address.Address, amount uint64, payment_id_
 			}
 
 			rlog.Infof("current input  %d %d \n", i, inputs_selected[i])
-			var currentInput ringct.InputInfo
-			currentInput.Amount = txw.WAmount
-			currentInput.Key_image = crypto.Hash(txw.WKimage)
-			currentInput.Sk = txw.WKey
-
-			currentInput.Index_Global = txw.TXdata.Index_Global
-
-			// add ring members here
-			// TODO force random ring members
-
-			//  mandatory add ourselves as ring member, otherwise there is no point in building the tx
-			currentInput.RingMembers = append(currentInput.RingMembers, currentInput.Index_Global)
-			currentInput.Pubs = append(currentInput.Pubs, txw.TXdata.InKey)
-
-			// add necessary amount  of random ring members
-			// TODO we need to make sure ring members are mature, otherwise tx will fail because o immature inputs
-			// This can cause certain TX to fail
-			w.selectRingMembers(&currentInput, mixin)
-
-			rlog.Infof(" current input before sorting %+v \n", currentInput.RingMembers)
-			currentInput = sortRingMembers(currentInput)
-			rlog.Infof(" current input after sorting  %+v \n", currentInput.RingMembers)
-			inputs = append(inputs, currentInput)
+			inputs = append(inputs, w.ringInput(txw, mixin))
 		}
 
 		// fill in the outputs
@@ -871,6 +861,33 @@ func (s members) Less(i, j int) bool {
 	return s[i].index < s[j].index
 }
 
+// ringInput prepares the funds txw as input of a tx, with mixin ring members
+func (w *Wallet) ringInput(txw *TXWalletData, mixin uint64) ringct.InputInfo {
+	var currentInput ringct.InputInfo
+	currentInput.Amount = txw.WAmount
+	currentInput.Key_image = crypto.Hash(txw.WKimage)
+	currentInput.Sk = txw.WKey
+
+	currentInput.Index_Global = txw.TXdata.Index_Global
+
+	// add ring members here
+	// TODO force random ring members
+
+	//  mandatory add ourselves as ring member, otherwise there is no point in building the tx
+	currentInput.RingMembers = append(currentInput.RingMembers, currentInput.Index_Global)
+	currentInput.Pubs = append(currentInput.Pubs, txw.TXdata.InKey)
+
+	// add necessary amount  of random ring members
+	// TODO we need to make sure ring members are mature, otherwise tx will fail because o immature inputs
+	// This can cause certain TX to fail
+	w.selectRingMembers(&currentInput, mixin)
+
+	rlog.Infof(" current input before sorting %+v \n", currentInput.RingMembers)
+	currentInput = sortRingMembers(currentInput)
+	rlog.Infof(" current input after sorting  %+v \n", currentInput.RingMembers)
+	return currentInput
+}
+
 // sort ring members
 func sortRingMembers(input ringct.InputInfo) ringct.InputInfo {
 	if len(input.RingMembers) != len(input.Pubs) {
@@ -895,6 +912,18 @@ func sortRingMembers(input ringct.InputInfo) ringct.InputInfo {
 }
 
 func (w *Wallet) selectOutputsForTransfer(neededAmount uint64, fees uint64, all bool, limit uint64, limitType string, maxinput bool) (selectedOutputIndex []uint64, sum uint64) {
+	return w.selectOutputsByStrategy(neededAmount, fees, all, limit, limitType, maxinput, COIN_SELECT_RANDOM)
+}
+
+// selectOutputsByStrategy selects the outputs in the order of strategy, leaving out
+// the frozen ones
+func (w *Wallet) selectOutputsByStrategy(neededAmount uint64, fees uint64, all bool, limit uint64, limitType string, maxinput bool, strategy string) (selectedOutputIndex []uint64, sum uint64) {
+	return w.selectOutputs(neededAmount, fees, all, limit, limitType, maxinput, strategy, nil)
+}
+
+// selectOutputs is selectOutputsByStrategy leaving out the outputs skip returns true
+// for as well, if it is not nil
+func (w *Wallet) selectOutputs(neededAmount uint64, fees uint64, all bool, limit uint64, limitType string, maxinput bool, strategy string, skip func(*TXWalletData) bool) (selectedOutputIndex []uint64, sum uint64) {
 	indexList := w.loadAllValuesFromBucket(BLOCKCHAIN_UNIVERSE, []byte(FUNDS_AVAILABLE))
 
 	// shuffle the index_list
@@ -904,12 +933,16 @@ func (w *Wallet) selectOutputsForTransfer(neededAmount uint64, fees uint64, all
 	}
 
 	pendingKeyImage := w.getAllPendingKeyImage()
+	frozen := w.frozenOutputs()
 
 	txs := make([]*TXWalletData, 0)
 	keyImages := make([]crypto.Key, 0)
 
 	for i := range indexList { // load index
 		currentIndex := binary.BigEndian.Uint64(indexList[i])
+		if frozen[currentIndex] {
+			continue
+		}
 
 		tx, err := w.loadFundsData(currentIndex, FUNDS_BUCKET)
 		if err != nil {
@@ -920,10 +953,14 @@ func (w *Wallet) selectOutputsForTransfer(neededAmount uint64, fees uint64, all
 		if limit > 0 && (limitType == "max" && tx.WAmount > limit) || (limitType == "min" && tx.WAmount < limit) {
 			continue
 		}
+		if skip != nil && skip(tx) {
+			continue
+		}
 
 		txs = append(txs, tx)
 		keyImages = append(keyImages, tx.WKimage)
 	}
+	sortOutputs(txs, strategy)
 
 	keyImageSpent := w.IsKeyImageSpentBatch(keyImages)
 	count := 1
@@ -1130,6 +1167,11 @@ func (w *Wallet) loadTokenWalletData(index uint64, bucket string) (token_wallet_
 
 // this will create ringct simple 2 transaction to transfer x amount
 func (w *Wallet) CreateTXv2(inputs []ringct.InputInfo, outputs []ringct.Output_info, fees uint64, unlock_time uint64, paymentId []byte, bulletproof bool, tx_extra *transaction.TxCreateExtra) (txout *transaction.Transaction) {
+	return w.createTXv2(inputs, outputs, fees, unlock_time, paymentId, bulletproof, tx_extra, w.Get_Height())
+}
+
+// createTXv2 creates the tx with the ring signature valid at height
+func (w *Wallet) createTXv2(inputs []ringct.InputInfo, outputs []ringct.Output_info, fees uint64, unlock_time uint64, paymentId []byte, bulletproof bool, tx_extra *transaction.TxCreateExtra, height uint64) (txout *transaction.Transaction) {
 	var tx transaction.Transaction
 	tx.Version = config.TX_VERSION_NORMAL
 	tx.UnlockTime = unlock_time // for the first input
@@ -1233,6 +1275,14 @@ func (w *Wallet) CreateTXv2(inputs []ringct.InputInfo, outputs []ringct.Output_i
 		// setup key so as output amount can be encrypted, this will be passed later on to ringct package to encrypt amount
 		outputs[i].Scalar_Key = *(derivation.KeyDerivationToScalar(uint64(indexWithinTx)))
 	}
+
+	// deposits and withdraws are signed over the tx carrying them, so the
+	// signature can't be replayed in another tx
+	if tx.IsContractDW() {
+		scdata := tx.ExtraMap[transaction.TX_EXTRA_CONTRACT].(*transaction.SCData)
+		hash := scdata.DWHash(&tx)
+		scdata.Sig = crypto.Sign(hash[:], w.Get_Keys().Spendkey_Secret)
+	}
 	tx.Extra = tx.SerializeExtra() // serialize the extra
 
 	// now comes the ringct part, we always generate rinct simple, they are a bit larger (~1KB) if only single input is used
@@ -1240,7 +1290,7 @@ func (w *Wallet) CreateTXv2(inputs []ringct.InputInfo, outputs []ringct.Output_i
 	tx.RctSignature = &ringct.RctSig{} // we always generate ringct simple
 
 	if bulletproof {
-		if !globals.IsMainnet() || w.Get_Height() >= config.FIX_CLSAG {
+		if !globals.IsMainnet() || height >= config.FIX_CLSAG {
 			tx.RctSignature.Gen_RingCT_Simple_BulletProof(tx.GetPrefixHash(), inputs, outputs, fees, lockedAmount, ringct.RCTTypeCLSAG)
 		} else {
 			tx.RctSignature.Gen_RingCT_Simple_BulletProof(tx.GetPrefixHash(), inputs, outputs, fees, lockedAmount, ringct.RCTTypeSimpleBulletproof)
@@ -1397,9 +1447,22 @@ func (w *Wallet) CreateTXv3(inputs []ringct.InputInfo, outputs []ringct.Output_i
 }
 
 func (w *Wallet) BuildContractTx(code []byte, amount, gas, gasPrice uint64, contractAddr string, isCreate bool) (tx *transaction.Transaction, inputs_selected []uint64, inputs_sum uint64, changeAmount uint64, err error) {
+	txExtra := new(transaction.TxCreateExtra)
+	txExtra.ContractData, err = w.contractData(code, amount, gas, gasPrice, contractAddr, isCreate)
+	if err != nil {
+		return nil, nil, 0, 0, err
+	}
+	w.signContractData(txExtra.ContractData, w.Get_Height())
+
+	return w.TransferV2(nil, nil, 0, "", 0, 0, txExtra)
+}
+
+// contractData returns the contract data, not signed yet, of the tx calling
+// the contract at contractAddr or creating one
+func (w *Wallet) contractData(code []byte, amount, gas, gasPrice uint64, contractAddr string, isCreate bool) (*transaction.SCData, error) {
 	if gasPrice < config.MIN_GASPRICE {
 		rlog.Warnf("Invalid gas price %s", globals.FormatMoney(gasPrice))
-		return nil, nil, 0, 0, fmt.Errorf("GasPrice is not enough")
+		return nil, fmt.Errorf("GasPrice is not enough")
 	}
 	if gasPrice == 0 {
 		gasPrice = config.DEFAULT_GASPRICE
@@ -1407,52 +1470,61 @@ func (w *Wallet) BuildContractTx(code []byte, amount, gas, gasPrice uint64, cont
 
 	if gas < config.MIN_GASLIMIT {
 		rlog.Warnf("Invalid gas price %s", globals.FormatMoney(gasPrice))
-		return nil, nil, 0, 0, fmt.Errorf("Gas is not enough")
+		return nil, fmt.Errorf("Gas is not enough")
 	}
 	if gas == 0 {
 		gas = config.DEFAULT_GASLIMIT
 	}
 
-	keys := w.Get_Keys()
 	addr := w.GetAddress()
 
-	txExtra := new(transaction.TxCreateExtra)
-	txExtra.ContractData = &transaction.SCData{
+	scdata := &transaction.SCData{
 		Sender:       addr.ToContractAddress(),
 		AccountNonce: 0,
 		Price:        gasPrice,
 		GasLimit:     gas,
 		Amount:       amount,
 		Payload:      code,
-		Sig:          crypto.Sign(code, keys.Spendkey_Secret),
 	}
 
 	if !isCreate {
 		if contractAddr == "" {
 			rlog.Warnf("Request param 'to' is empty")
-			return nil, nil, 0, 0, fmt.Errorf("Need contract address")
+			return nil, fmt.Errorf("Need contract address")
 		}
 		to, err := address.NewAddress(contractAddr)
 		if err != nil {
 			rlog.Warnf("Request param 'to' is invalid")
-			return nil, nil, 0, 0, fmt.Errorf("Contract address is invalid")
+			return nil, fmt.Errorf("Contract address is invalid")
 		}
-		txExtra.ContractData.Recipient = to.ToContractAddress()
+		scdata.Recipient = to.ToContractAddress()
 	}
 
-	return w.TransferV2(nil, nil, 0, "", 0, 0, txExtra)
+	return scdata, nil
 }
 
-// build DEPOSIT and WITHDRAW transaction
+// signContractData signs the payload of scdata and its sender with the
+// wallet's spend key, for a tx mined on top of height
+func (w *Wallet) signContractData(scdata *transaction.SCData, height uint64) {
+	keys := w.Get_Keys()
+	scdata.Sig = crypto.Sign(scdata.Payload, keys.Spendkey_Secret)
+	w.signContractSender(scdata, height)
+}
+
+// build DEPOSIT and WITHDRAW transaction, createTXv2 signs them once their
+// inputs and outputs are known
 
 func (w *Wallet) BuildDepositTx(amount uint64) (tx *transaction.Transaction, err error) {
 	addr := w.GetAddress()
+	bytesAmount := uintAmountToBytesAmount(amount)
 	txExtra := new(transaction.TxCreateExtra)
 	txExtra.ContractData = &transaction.SCData{
 		Sender:       addr.ToContractAddress(),
 		Amount:       amount,
 		Type:         transaction.SCDATA_DEPOSIT_TYPE,
+		Payload:      bytesAmount,
 	}
+	w.signContractSender(txExtra.ContractData, w.Get_Height())
 
 	var dests []address.Address
 	var amounts []uint64
@@ -1473,12 +1545,26 @@ func (w *Wallet) BuildWithdrawTx(uintAmount uint64) (tx *transaction.Transaction
 		Type:         transaction.SCDATA_WITHDRAW_TYPE,
 		Payload:      bytesAmount,
 	}
+	w.signContractSender(txExtra.ContractData, w.Get_Height())
 
 	tx,_,_,_,err = w.TransferV2(nil, nil, 0, "", 0, 0, txExtra)
 
 	return tx,err
 }
 
+// signContractSender adds the wallet's public view key to scdata, so the
+// chain can register the wallet's address for its contract account. The key
+// is only added once the account registry fork is active for the block on
+// top of height.
+func (w *Wallet) signContractSender(scdata *transaction.SCData, height uint64) {
+	if !dvm.GetChainCOnfig().IsAccountRegistry(new(big.Int).SetUint64(height + 1)) {
+		return
+	}
+	keys := w.Get_Keys()
+	scdata.SenderViewKey = keys.Viewkey_Public
+	scdata.SenderViewSig = crypto.Sign(scdata.SenderViewKey[:], keys.Spendkey_Secret)
+}
+
 func uintAmountToBytesAmount(amount uint64) []byte {
 	buf := make([]byte,8) // sizeof(uint64) == 8 bytes
 	binary.BigEndian.PutUint64(buf,amount)
@@ -0,0 +1,587 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+package walletapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/darmaproject/darmasuite/crypto"
+	"github.com/darmaproject/darmasuite/globals"
+)
+
+// webhooks POST the events of the wallet as JSON to the URLs registered for them.
+// Deliveries are queued in the wallet database, so those failing, or pending when
+// the wallet closes, are retried with backoff until they succeed or
+// WEBHOOK_MAX_ATTEMPTS is reached.
+//
+// Every POST carries the headers
+//
+//	X-Darma-Event: <event type>
+//	X-Darma-Delivery: <event id>, the same across retries
+//	X-Darma-Timestamp: <unix time of the attempt>
+//	X-Darma-Signature: hex(HMAC-SHA256(secret, timestamp + "." + body))
+//
+// receivers should check the signature and drop timestamps too far in the past.
+const WEBHOOKS_BUCKET = "WEBHOOKS"
+
+const (
+	EVENT_INCOMING_TRANSFER = "incoming_transfer"
+	EVENT_OUTGOING_TRANSFER = "outgoing_transfer"
+	EVENT_CONFIRMED         = "transfer_confirmed" // a transfer reached the confirmations of the webhook
+	EVENT_ERC20_TRANSFER    = "erc20_transfer"
+	EVENT_STAKE_REWARD      = "stake_reward" // share profits, which are incoming transfers as well
+)
+
+var WEBHOOK_EVENTS = []string{
+	EVENT_INCOMING_TRANSFER,
+	EVENT_OUTGOING_TRANSFER,
+	EVENT_CONFIRMED,
+	EVENT_ERC20_TRANSFER,
+	EVENT_STAKE_REWARD,
+}
+
+const (
+	WEBHOOK_MAX_ATTEMPTS  = 12
+	WEBHOOK_MAX_QUEUE     = 10000 // the oldest deliveries are dropped beyond it
+	WEBHOOK_TIMEOUT       = 10 * time.Second
+	WEBHOOK_SCAN_INTERVAL = 5 * time.Second
+	WEBHOOK_SCAN_BLOCKS   = 100   // blocks scanned at most at once, while the wallet catches up
+	WEBHOOK_SCAN_LIMIT    = 10000 // transfers of each kind listed by a scan, fewer blocks are scanned when it is reached
+	WEBHOOK_ORPHAN_BLOCKS = 10    // a transfer the wallet lost for as many blocks was orphaned
+)
+
+// the state of the webhooks, in WEBHOOKS_BUCKET
+var (
+	webhooksKey             = []byte("hooks")
+	webhookQueueKey         = []byte("queue")
+	webhookScannedKey       = []byte("scanned")
+	webhookConfirmationsKey = []byte("confirmations")
+)
+
+type Webhook struct {
+	ID            string   `json:"id"`
+	URL           string   `json:"url"`
+	Secret        string   `json:"secret"`                  // key of the signatures
+	Events        []string `json:"events,omitempty"`        // all of them if empty
+	Confirmations uint64   `json:"confirmations,omitempty"` // of transfer_confirmed, which is not sent if 0
+	Created       int64    `json:"created"`
+}
+
+func (h *Webhook) wants(event string) bool {
+	if event == EVENT_CONFIRMED && h.Confirmations == 0 {
+		return false
+	}
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookEvent is the body POSTed to webhooks.
+type WebhookEvent struct {
+	ID      string      `json:"id"`
+	Type    string      `json:"type"`
+	Created int64       `json:"created"`
+	Height  uint64      `json:"height"` // of the wallet when the event was found
+	Data    interface{} `json:"data"`
+}
+
+// WebhookConfirmation is the data of transfer_confirmed.
+type WebhookConfirmation struct {
+	TXID          string `json:"txid"`
+	Incoming      bool   `json:"incoming"`
+	Height        uint64 `json:"height"`
+	Confirmations uint64 `json:"confirmations"`
+}
+
+type webhookDelivery struct {
+	Webhook   string          `json:"webhook"`
+	Event     string          `json:"event"`
+	EventID   string          `json:"event_id"`
+	Body      json.RawMessage `json:"body"` // kept as sent, so every retry has the same signature base
+	Attempts  int             `json:"attempts"`
+	Next      int64           `json:"next"` // unix time of the next attempt
+	LastError string          `json:"last_error,omitempty"`
+}
+
+// a transfer waiting for the confirmations of a webhook
+type webhookPending struct {
+	Webhook  string `json:"webhook"`
+	TXID     string `json:"txid"`
+	Incoming bool   `json:"incoming"`
+	Missing  uint64 `json:"missing,omitempty"` // height of the wallet when the transfer was first not found
+}
+
+// Webhooks delivers the events of a wallet to the webhooks registered in it.
+type Webhooks struct {
+	sync.Mutex
+	w       *Wallet
+	client  *http.Client
+	hooks   []Webhook
+	queue   []webhookDelivery
+	pending []webhookPending
+	scanned uint64 // height up to which the transfers were turned into events
+	dirty   bool   // the state changed since it was saved
+
+	ctx    context.Context // cancels the deliveries going on when stopped
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewWebhooks loads the webhooks registered in w and their queued deliveries.
+func NewWebhooks(w *Wallet) (*Webhooks, error) {
+	wh := &Webhooks{w: w, client: &http.Client{Timeout: WEBHOOK_TIMEOUT}}
+	for key, v := range map[string]interface{}{
+		string(webhooksKey):             &wh.hooks,
+		string(webhookQueueKey):         &wh.queue,
+		string(webhookConfirmationsKey): &wh.pending,
+	} {
+		data, err := w.loadKeyValue(BLOCKCHAIN_UNIVERSE, []byte(WEBHOOKS_BUCKET), []byte(key))
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		if err = json.Unmarshal(data, v); err != nil {
+			return nil, fmt.Errorf("Error while decoding webhooks %s err %s", key, err)
+		}
+	}
+	if data, err := w.loadKeyValue(BLOCKCHAIN_UNIVERSE, []byte(WEBHOOKS_BUCKET), webhookScannedKey); err == nil && len(data) == 8 {
+		wh.scanned = binary.BigEndian.Uint64(data)
+	}
+	return wh, nil
+}
+
+// Start delivers the events in the background, until Stop is called.
+func (wh *Webhooks) Start() {
+	wh.ctx, wh.cancel = context.WithCancel(context.Background())
+	wh.done = make(chan struct{})
+	go wh.run()
+}
+
+// Stop waits for the background delivery to quit, aborting the POST going on.
+// What is left in the queue is delivered after the next Start.
+func (wh *Webhooks) Stop() {
+	if wh.cancel == nil {
+		return
+	}
+	wh.cancel()
+	<-wh.done
+}
+
+func (wh *Webhooks) run() {
+	defer close(wh.done)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var lastScan time.Time
+	for {
+		select {
+		case <-wh.ctx.Done():
+			return
+		case now := <-ticker.C:
+			if now.Sub(lastScan) >= WEBHOOK_SCAN_INTERVAL {
+				wh.scan()
+				lastScan = now
+			}
+			wh.deliver()
+		}
+	}
+}
+
+// Add registers a webhook POSTing events to rawurl, all of them if events is
+// empty. A random secret is generated if none is given.
+func (wh *Webhooks) Add(rawurl, secret string, events []string, confirmations uint64) (*Webhook, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("Webhook URL must be an http or https URL")
+	}
+	for _, e := range events {
+		known := false
+		for _, k := range WEBHOOK_EVENTS {
+			known = known || e == k
+		}
+		if !known {
+			return nil, fmt.Errorf("Unknown webhook event %s", e)
+		}
+	}
+	if secret == "" {
+		secret = webhookToken(32)
+	}
+
+	hook := Webhook{
+		ID:            webhookToken(8),
+		URL:           u.String(),
+		Secret:        secret,
+		Events:        events,
+		Confirmations: confirmations,
+		Created:       time.Now().Unix(),
+	}
+
+	wh.Lock()
+	defer wh.Unlock()
+	if len(wh.hooks) == 0 {
+		// nothing was watching, so the events start from now
+		wh.scanned = wh.w.Get_Height()
+	}
+	wh.hooks = append(wh.hooks, hook)
+	wh.dirty = true
+	wh.save()
+	rlog.Infof("Registered webhook %s to %s", hook.ID, hook.URL)
+	return &hook, nil
+}
+
+// Remove unregisters the webhook id, and drops its queued deliveries.
+func (wh *Webhooks) Remove(id string) error {
+	wh.Lock()
+	defer wh.Unlock()
+
+	i := wh.find(id)
+	if i < 0 {
+		return fmt.Errorf("Webhook %s not found", id)
+	}
+	wh.hooks = append(wh.hooks[:i], wh.hooks[i+1:]...)
+
+	queue := wh.queue[:0]
+	for _, d := range wh.queue {
+		if d.Webhook != id {
+			queue = append(queue, d)
+		}
+	}
+	wh.queue = queue
+	pending := wh.pending[:0]
+	for _, p := range wh.pending {
+		if p.Webhook != id {
+			pending = append(pending, p)
+		}
+	}
+	wh.pending = pending
+
+	wh.dirty = true
+	wh.save()
+	rlog.Infof("Removed webhook %s", id)
+	return nil
+}
+
+// List returns the registered webhooks, and the number of deliveries queued
+// for each of them.
+func (wh *Webhooks) List() (hooks []Webhook, queued []int) {
+	wh.Lock()
+	defer wh.Unlock()
+
+	hooks = append(hooks, wh.hooks...)
+	queued = make([]int, len(hooks))
+	for _, d := range wh.queue {
+		if i := wh.find(d.Webhook); i >= 0 {
+			queued[i]++
+		}
+	}
+	return
+}
+
+func (wh *Webhooks) find(id string) int {
+	for i := range wh.hooks {
+		if wh.hooks[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// scan turns the transfers of the blocks synced since the last scan into events,
+// and sends transfer_confirmed for those which reached the confirmations.
+func (wh *Webhooks) scan() {
+	height := wh.w.Get_Height()
+
+	wh.Lock()
+	defer wh.Unlock()
+
+	if len(wh.hooks) == 0 || height < wh.scanned {
+		// nothing is watching, or the wallet is rescanning
+		wh.scanned = height
+		return
+	}
+
+	if height > wh.scanned {
+		min, max := wh.scanned+1, height
+		if max-min >= WEBHOOK_SCAN_BLOCKS {
+			max = min + WEBHOOK_SCAN_BLOCKS - 1
+		}
+
+		for {
+			incoming := wh.w.ShowMergedTransfers(true, false, true, false, min, max, WEBHOOK_SCAN_LIMIT)
+			outgoing := wh.w.ShowMergedTransfers(false, true, true, false, min, max, WEBHOOK_SCAN_LIMIT)
+			rewards := wh.w.ShowTransfersV3(true, false, true, false, false, false, min, max, WEBHOOK_SCAN_LIMIT, globals.TX_TYPE_SHARE_PROFIT)
+			full := len(incoming) >= WEBHOOK_SCAN_LIMIT || len(outgoing) >= WEBHOOK_SCAN_LIMIT || len(rewards) >= WEBHOOK_SCAN_LIMIT
+			if full && max > min {
+				// a full listing may have left transfers out, the blocks are
+				// scanned fewer at a time until every listing fits
+				max = min + (max-min)/2
+				continue
+			}
+			if full {
+				rlog.Warnf("Webhook scan of block %d listed %d transfers, the limit, some may be left out", min, WEBHOOK_SCAN_LIMIT)
+			}
+
+			for _, e := range incoming {
+				wh.enqueue(EVENT_INCOMING_TRANSFER, height, e)
+				wh.watch(e.TXID.String(), true)
+			}
+			for _, e := range outgoing {
+				wh.enqueue(EVENT_OUTGOING_TRANSFER, height, e)
+				wh.watch(e.TXID.String(), false)
+			}
+			for _, e := range rewards {
+				wh.enqueue(EVENT_STAKE_REWARD, height, e)
+			}
+			break
+		}
+		for _, e := range wh.w.ShowERC20Transfers(true, true, min, max) {
+			wh.enqueue(EVENT_ERC20_TRANSFER, height, e)
+		}
+		wh.scanned = max
+		wh.dirty = true
+	}
+
+	pending := wh.pending[:0]
+	for _, p := range wh.pending {
+		i := wh.find(p.Webhook)
+		if i < 0 {
+			wh.dirty = true
+			continue
+		}
+		txid := crypto.HashHexToHash(p.TXID)
+		entry, err := wh.w.GetTransferByTXID(txid)
+		if err != nil {
+			// the wallet may be syncing the block of the transfer again, so it is only
+			// given up once it stayed lost for WEBHOOK_ORPHAN_BLOCKS and is not pending
+			if p.Missing == 0 || height < p.Missing {
+				p.Missing = height
+				wh.dirty = true
+			}
+			if _, err := wh.w.GetPendingTransferByTXID(txid); err == nil || height-p.Missing < WEBHOOK_ORPHAN_BLOCKS {
+				pending = append(pending, p)
+				continue
+			}
+			rlog.Warnf("Webhook %s transfer %s was orphaned", p.Webhook, p.TXID)
+			wh.dirty = true
+			continue
+		}
+		if p.Missing != 0 {
+			p.Missing = 0
+			wh.dirty = true
+		}
+		if height < entry.Height || height-entry.Height+1 < wh.hooks[i].Confirmations {
+			pending = append(pending, p)
+			continue
+		}
+		wh.enqueueTo(&wh.hooks[i], EVENT_CONFIRMED, height, WebhookConfirmation{
+			TXID:          p.TXID,
+			Incoming:      p.Incoming,
+			Height:        entry.Height,
+			Confirmations: height - entry.Height + 1,
+		})
+	}
+	wh.pending = pending
+
+	wh.save()
+}
+
+// watch waits for the confirmations of txid, for the webhooks asking for them.
+func (wh *Webhooks) watch(txid string, incoming bool) {
+	for i := range wh.hooks {
+		if wh.hooks[i].wants(EVENT_CONFIRMED) {
+			wh.pending = append(wh.pending, webhookPending{Webhook: wh.hooks[i].ID, TXID: txid, Incoming: incoming})
+			wh.dirty = true
+		}
+	}
+}
+
+// enqueue queues event for every webhook asking for it.
+func (wh *Webhooks) enqueue(event string, height uint64, data interface{}) {
+	for i := range wh.hooks {
+		if wh.hooks[i].wants(event) {
+			wh.enqueueTo(&wh.hooks[i], event, height, data)
+		}
+	}
+}
+
+func (wh *Webhooks) enqueueTo(hook *Webhook, event string, height uint64, data interface{}) {
+	e := WebhookEvent{
+		ID:      webhookToken(16),
+		Type:    event,
+		Created: time.Now().Unix(),
+		Height:  height,
+		Data:    data,
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		rlog.Warnf("Error while encoding webhook event %s err %s", event, err)
+		return
+	}
+
+	if len(wh.queue) >= WEBHOOK_MAX_QUEUE {
+		rlog.Warnf("Webhook queue is full, dropping %s event %s", wh.queue[0].Event, wh.queue[0].EventID)
+		wh.queue = wh.queue[1:]
+	}
+	wh.queue = append(wh.queue, webhookDelivery{
+		Webhook: hook.ID,
+		Event:   event,
+		EventID: e.ID,
+		Body:    body,
+		Next:    e.Created,
+	})
+	wh.dirty = true
+}
+
+// deliver POSTs the deliveries which are due, without holding the lock meanwhile.
+func (wh *Webhooks) deliver() {
+	type attempt struct {
+		delivery webhookDelivery
+		hook     Webhook
+		err      error
+	}
+
+	now := time.Now().Unix()
+	var due []attempt
+	wh.Lock()
+	for _, d := range wh.queue {
+		if i := wh.find(d.Webhook); i >= 0 && d.Next <= now {
+			due = append(due, attempt{delivery: d, hook: wh.hooks[i]})
+		}
+	}
+	wh.Unlock()
+	if len(due) == 0 {
+		return
+	}
+
+	for i := range due {
+		if wh.ctx.Err() != nil {
+			due = due[:i]
+			break
+		}
+		due[i].err = wh.post(&due[i].hook, &due[i].delivery)
+	}
+
+	wh.Lock()
+	defer wh.Unlock()
+	for _, a := range due {
+		for i := range wh.queue {
+			d := &wh.queue[i]
+			if d.Webhook != a.delivery.Webhook || d.EventID != a.delivery.EventID {
+				continue
+			}
+			if a.err == nil {
+				wh.queue = append(wh.queue[:i], wh.queue[i+1:]...)
+				wh.dirty = true
+				break
+			}
+			if wh.ctx.Err() != nil {
+				// aborted by Stop, which is not the fault of the receiver
+				break
+			}
+
+			wh.dirty = true
+			d.Attempts++
+			d.LastError = a.err.Error()
+			if d.Attempts >= WEBHOOK_MAX_ATTEMPTS {
+				rlog.Warnf("Webhook %s dropping %s event %s after %d attempts, last err %s", d.Webhook, d.Event, d.EventID, d.Attempts, a.err)
+				wh.queue = append(wh.queue[:i], wh.queue[i+1:]...)
+				break
+			}
+			d.Next = time.Now().Add(webhookBackoff(d.Attempts)).Unix()
+			rlog.Debugf("Webhook %s %s event %s failed attempt %d err %s", d.Webhook, d.Event, d.EventID, d.Attempts, a.err)
+			break
+		}
+	}
+	wh.save()
+}
+
+// post POSTs d to hook, signed by its secret.
+func (wh *Webhooks) post(hook *Webhook, d *webhookDelivery) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(hook.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(d.Body)
+
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(wh.ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Darma-Event", d.Event)
+	req.Header.Set("X-Darma-Delivery", d.EventID)
+	req.Header.Set("X-Darma-Timestamp", timestamp)
+	req.Header.Set("X-Darma-Signature", hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// save stores the state of the webhooks if it changed, the lock must be held.
+func (wh *Webhooks) save() {
+	if !wh.dirty {
+		return
+	}
+	wh.dirty = false
+
+	for key, v := range map[string]interface{}{
+		string(webhooksKey):             wh.hooks,
+		string(webhookQueueKey):         wh.queue,
+		string(webhookConfirmationsKey): wh.pending,
+	} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			rlog.Warnf("Error while encoding webhooks %s err %s", key, err)
+			continue
+		}
+		wh.w.storeKeyValue(BLOCKCHAIN_UNIVERSE, []byte(WEBHOOKS_BUCKET), []byte(key), data)
+	}
+	wh.w.storeKeyValue(BLOCKCHAIN_UNIVERSE, []byte(WEBHOOKS_BUCKET), webhookScannedKey, itob(wh.scanned))
+}
+
+// webhookBackoff returns the wait after the failed attempt, from 10s up to an hour.
+func webhookBackoff(attempt int) time.Duration {
+	if attempt > 9 {
+		return time.Hour
+	}
+	if d := 5 * time.Second << uint(attempt); d < time.Hour {
+		return d
+	}
+	return time.Hour
+}
+
+func webhookToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,189 @@
+// Copyright 2018-2020 Darma Project. All rights reserved.
+package walletapi
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testReceiver records the webhook POSTs whose signature checks with secret, and
+// answers them with status.
+type testReceiver struct {
+	sync.Mutex
+	secret string
+	status int
+	events []WebhookEvent
+	header []http.Header
+	errors []string
+}
+
+func (r *testReceiver) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	r.Lock()
+	defer r.Unlock()
+
+	body, _ := ioutil.ReadAll(req.Body)
+	mac := hmac.New(sha256.New, []byte(r.secret))
+	mac.Write([]byte(req.Header.Get("X-Darma-Timestamp") + "." + string(body)))
+	signature, _ := hex.DecodeString(req.Header.Get("X-Darma-Signature"))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		r.errors = append(r.errors, "bad signature")
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var e WebhookEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		r.errors = append(r.errors, err.Error())
+	}
+	r.events = append(r.events, e)
+	r.header = append(r.header, req.Header)
+	rw.WriteHeader(r.status)
+}
+
+// got returns what the receiver recorded so far.
+func (r *testReceiver) got() ([]WebhookEvent, []http.Header, []string) {
+	r.Lock()
+	defer r.Unlock()
+	return append([]WebhookEvent(nil), r.events...), append([]http.Header(nil), r.header...), append([]string(nil), r.errors...)
+}
+
+func (r *testReceiver) answer(status int) {
+	r.Lock()
+	defer r.Unlock()
+	r.status = status
+}
+
+// newTestWebhooks returns the webhooks of a new wallet, delivering as if started.
+func newTestWebhooks(t *testing.T) *Webhooks {
+	wh, err := NewWebhooks(newTestWallet(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wh.ctx, wh.cancel = context.WithCancel(context.Background())
+	t.Cleanup(wh.cancel)
+	return wh
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	r := &testReceiver{secret: "s3cret", status: http.StatusOK}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	wh := newTestWebhooks(t)
+	hook, err := wh.Add(server.URL, r.secret, []string{EVENT_INCOMING_TRANSFER}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := wh.Add(server.URL, "other", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wh.Lock()
+	wh.enqueue(EVENT_INCOMING_TRANSFER, 7, map[string]uint64{"amount": 42})
+	wh.enqueue(EVENT_OUTGOING_TRANSFER, 7, map[string]uint64{"amount": 1})
+	wh.Unlock()
+	if _, queued := wh.List(); queued[0] != 1 || queued[1] != 2 {
+		t.Fatalf("queued deliveries %v, want [1 2]", queued)
+	}
+	wh.Remove(other.ID) // its deliveries would fail the signature of the receiver
+	wh.deliver()
+
+	events, headers, errors := r.got()
+	if len(errors) != 0 || len(events) != 1 {
+		t.Fatalf("receiver got %d events, errors %v", len(events), errors)
+	}
+	e, header := events[0], headers[0]
+	if e.Type != EVENT_INCOMING_TRANSFER || e.Height != 7 || e.Data.(map[string]interface{})["amount"] != float64(42) {
+		t.Fatalf("event %+v", e)
+	}
+	if header.Get("X-Darma-Event") != e.Type || header.Get("X-Darma-Delivery") != e.ID || header.Get("Content-Type") != "application/json" {
+		t.Fatalf("headers %v of event %s", header, e.ID)
+	}
+	if len(wh.queue) != 0 || wh.dirty {
+		t.Fatalf("%d deliveries left after delivering, unsaved %t", len(wh.queue), wh.dirty)
+	}
+
+	// the state loads back as saved
+	loaded, err := NewWebhooks(wh.w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.hooks) != 1 || loaded.hooks[0].ID != hook.ID || len(loaded.queue) != 0 {
+		t.Fatalf("loaded webhooks %+v, queue %d", loaded.hooks, len(loaded.queue))
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	r := &testReceiver{secret: "s3cret", status: http.StatusInternalServerError}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	wh := newTestWebhooks(t)
+	if _, err := wh.Add(server.URL, r.secret, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	wh.Lock()
+	wh.enqueue(EVENT_STAKE_REWARD, 7, nil)
+	wh.Unlock()
+
+	wh.deliver()
+	if len(wh.queue) != 1 || wh.queue[0].Attempts != 1 || !strings.Contains(wh.queue[0].LastError, "500") {
+		t.Fatalf("failed delivery %+v", wh.queue)
+	}
+	if next := time.Unix(wh.queue[0].Next, 0); time.Until(next) < webhookBackoff(1)-time.Second {
+		t.Fatalf("failed delivery retried at %s", next)
+	}
+
+	// not due, nothing is posted nor saved
+	wh.deliver()
+	if events, _, _ := r.got(); len(events) != 1 || wh.dirty {
+		t.Fatalf("delivery retried before its backoff, %d posts", len(events))
+	}
+
+	// every retry has the same signature base, so it still checks
+	r.answer(http.StatusNoContent)
+	wh.queue[0].Next = 0
+	wh.deliver()
+	events, _, errors := r.got()
+	if len(errors) != 0 || len(events) != 2 || events[0].ID != events[1].ID || len(wh.queue) != 0 {
+		t.Fatalf("retry posted %d events, errors %v, %d left", len(events), errors, len(wh.queue))
+	}
+}
+
+// TestWebhookLostTransfer checks a transfer waiting for its confirmations is only
+// given up once the wallet lost it for WEBHOOK_ORPHAN_BLOCKS.
+func TestWebhookLostTransfer(t *testing.T) {
+	wh := newTestWebhooks(t)
+	wh.w.account.Height = 100
+	hook, err := wh.Add("http://127.0.0.1:1/hook", "", nil, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txid := strings.Repeat("ab", 32)
+	wh.pending = []webhookPending{{Webhook: hook.ID, TXID: txid, Incoming: true}}
+
+	for _, height := range []uint64{100, 101, 100 + WEBHOOK_ORPHAN_BLOCKS - 1} {
+		wh.scanned, wh.w.account.Height = height, height
+		wh.scan()
+		if len(wh.pending) != 1 || wh.pending[0].Missing != 100 {
+			t.Fatalf("at height %d, pending %+v", height, wh.pending)
+		}
+	}
+
+	wh.scanned, wh.w.account.Height = 100+WEBHOOK_ORPHAN_BLOCKS, 100+WEBHOOK_ORPHAN_BLOCKS
+	wh.scan()
+	if len(wh.pending) != 0 || len(wh.queue) != 0 {
+		t.Fatalf("orphaned transfer still pending %+v, queue %d", wh.pending, len(wh.queue))
+	}
+}